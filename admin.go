@@ -0,0 +1,199 @@
+package irc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// AdminConsole exposes a simple line based interface for inspecting and
+// controlling a running Client. Each line sent to the console is a command,
+// and each command gets a single line JSON response. The supported commands
+// are:
+//
+//	state                 returns the nick, caps, ISupport, and channels
+//	raw <line>            sends a raw line to the server
+//	handler enable        starts passing messages to the Handler
+//	handler disable       stops passing messages to the Handler
+//	reconnect             calls the Reconnect function
+//
+// The console does not do any authentication, so it should only be served on
+// a unix socket or a localhost address.
+type AdminConsole struct {
+	// Reconnect is called when the reconnect command is used. If it is nil,
+	// the command will return an error.
+	Reconnect func() error
+
+	client *Client
+
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+}
+
+// AdminResponse is the JSON response to a single AdminConsole command.
+type AdminResponse struct {
+	OK     bool        `json:"ok"`
+	Error  string      `json:"error,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// AdminState is the result of the state command.
+type AdminState struct {
+	Nick           string            `json:"nick"`
+	Network        string            `json:"network,omitempty"`
+	Connected      bool              `json:"connected"`
+	HandlerEnabled bool              `json:"handler_enabled"`
+	Caps           []string          `json:"caps"`
+	ISupport       map[string]string `json:"isupport,omitempty"`
+	Channels       []string          `json:"channels,omitempty"`
+}
+
+// NewAdminConsole creates an AdminConsole for the given Client.
+func NewAdminConsole(c *Client) *AdminConsole {
+	return &AdminConsole{
+		client:    c,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on the given listener until it is closed or Close
+// is called. Each connection is handled in its own goroutine.
+func (a *AdminConsole) Serve(l net.Listener) error {
+	a.lock.Lock()
+	a.listeners[l] = struct{}{}
+	a.lock.Unlock()
+
+	defer func() {
+		a.lock.Lock()
+		delete(a.listeners, l)
+		a.lock.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go a.serveConn(conn)
+	}
+}
+
+// Close stops all listeners and closes all open console connections.
+func (a *AdminConsole) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for l := range a.listeners {
+		l.Close()
+	}
+
+	for conn := range a.conns {
+		conn.Close()
+	}
+
+	return nil
+}
+
+func (a *AdminConsole) serveConn(conn net.Conn) {
+	a.lock.Lock()
+	a.conns[conn] = struct{}{}
+	a.lock.Unlock()
+
+	defer func() {
+		a.lock.Lock()
+		delete(a.conns, conn)
+		a.lock.Unlock()
+
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		result, err := a.Exec(line)
+
+		resp := AdminResponse{OK: err == nil, Result: result}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+
+		if encoder.Encode(resp) != nil {
+			return
+		}
+	}
+}
+
+// Exec runs a single console command and returns its result. This is what is
+// used for each line sent to the console, but it can also be called directly.
+func (a *AdminConsole) Exec(line string) (interface{}, error) {
+	cmd, args := line, ""
+	if i := strings.IndexByte(line, ' '); i != -1 {
+		cmd, args = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch strings.ToLower(cmd) {
+	case "state":
+		return a.state(), nil
+	case "raw":
+		if args == "" {
+			return nil, errors.New("irc: raw requires a line to send")
+		}
+
+		return nil, a.client.Write(args)
+	case "handler":
+		switch args {
+		case "enable":
+			a.client.SetHandlerEnabled(true)
+		case "disable":
+			a.client.SetHandlerEnabled(false)
+		default:
+			return nil, errors.New("irc: handler requires enable or disable")
+		}
+
+		return nil, nil
+	case "reconnect":
+		if a.Reconnect == nil {
+			return nil, errors.New("irc: reconnect is not supported")
+		}
+
+		return nil, a.Reconnect()
+	}
+
+	return nil, errors.New("irc: unknown command " + cmd)
+}
+
+func (a *AdminConsole) state() *AdminState {
+	c := a.client
+
+	ret := &AdminState{
+		Nick:           c.CurrentNick(),
+		Network:        c.NetworkName(),
+		Connected:      c.isConnected(),
+		HandlerEnabled: c.HandlerEnabled(),
+		Caps:           c.enabledCaps(),
+	}
+
+	if c.ISupport != nil {
+		ret.ISupport = c.ISupport.Snapshot()
+	}
+
+	if c.Tracker != nil {
+		ret.Channels = c.Tracker.ListChannels()
+		sort.Strings(ret.Channels)
+	}
+
+	return ret
+}
@@ -0,0 +1,85 @@
+package irc_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestAdminConsole(t *testing.T) {
+	t.Parallel()
+
+	c, buf := newCommandTestClient(t, irc.ClientConfig{
+		Nick:          "test_nick",
+		EnableTracker: true,
+	}, "005 test_nick NETWORK=Example :are supported by this server")
+
+	require.NoError(t, c.Tracker.Handle(irc.MustParseMessage("001 test_nick :Welcome")))
+	require.NoError(t, c.Tracker.Handle(irc.MustParseMessage(":test_nick!user@host JOIN #chan")))
+
+	console := irc.NewAdminConsole(c)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- console.Serve(l)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	exec := func(line string) map[string]interface{} {
+		t.Helper()
+
+		_, err := conn.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+
+		data, err := reader.ReadBytes('\n')
+		require.NoError(t, err)
+
+		var ret map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &ret))
+		return ret
+	}
+
+	state := exec("state")
+	assert.Equal(t, true, state["ok"])
+	result := state["result"].(map[string]interface{})
+	assert.Equal(t, "test_nick", result["nick"])
+	assert.Equal(t, "Example", result["network"])
+	assert.Equal(t, true, result["handler_enabled"])
+	assert.Equal(t, []interface{}{"#chan"}, result["channels"])
+
+	assert.Equal(t, map[string]interface{}{"ok": true}, exec("raw PRIVMSG #chan :hello"))
+	assert.Equal(t, []string{"PRIVMSG #chan :hello"}, bufferLines(buf))
+
+	assert.Equal(t, map[string]interface{}{"ok": true}, exec("handler disable"))
+	assert.False(t, c.HandlerEnabled())
+	assert.Equal(t, map[string]interface{}{"ok": true}, exec("handler enable"))
+	assert.True(t, c.HandlerEnabled())
+
+	assert.Equal(t, map[string]interface{}{
+		"ok":    false,
+		"error": "irc: reconnect is not supported",
+	}, exec("reconnect"))
+
+	assert.Equal(t, false, exec("bogus")["ok"])
+
+	// Reconnect can be provided by the caller.
+	console.Reconnect = func() error { return errors.New("nope") }
+	assert.Equal(t, "nope", exec("reconnect")["error"])
+
+	require.NoError(t, console.Close())
+	assert.Error(t, <-done)
+}
@@ -0,0 +1,241 @@
+package irc
+
+import (
+	"context"
+	"strings"
+)
+
+// JoinError is returned from Client.JoinWithBackfill if the server refused to
+// let us join a channel, such as with ERR_BANNEDFROMCHAN (474).
+type JoinError struct {
+	// Channel is the channel we tried to join.
+	Channel string
+
+	// Numeric is the numeric the server sent.
+	Numeric string
+
+	// Text is the text the server sent along with it.
+	Text string
+}
+
+// Error implements the error interface.
+func (e *JoinError) Error() string {
+	return "irc: cannot join " + e.Channel + " (" + e.Numeric + "): " + e.Text
+}
+
+// BackfillOptions configures Client.JoinWithBackfill.
+type BackfillOptions struct {
+	// Key is the channel key, if the channel needs one.
+	Key string
+
+	// Limit is how many of the most recent messages to request with
+	// CHATHISTORY. If it is 0, or the chathistory cap isn't enabled, no
+	// history is requested.
+	Limit int
+
+	// Buffer, if set, is checked for messages we have already seen. Any
+	// history messages with a msgid which was in the Buffer before history
+	// was requested are dropped.
+	Buffer *HistoryBuffer
+}
+
+// Backfill is everything JoinWithBackfill gathered about a channel after
+// joining it.
+type Backfill struct {
+	// Channel is the channel which was joined.
+	Channel string
+
+	// Names are the nicks in the channel from NAMES, without any prefixes,
+	// in the order the server sent them.
+	Names []string
+
+	// Messages are the recent messages in the channel, oldest first. This is
+	// empty if no history was requested.
+	Messages []*Message
+}
+
+// joinRequest is a pending call to JoinWithBackfill.
+type joinRequest struct {
+	channel string
+	names   []string
+	result  chan error
+}
+
+// JoinWithBackfill joins a channel, waits for the server to finish sending
+// NAMES, and then requests recent history with CHATHISTORY if it's supported.
+// The results are returned together, so a client can draw a channel in one
+// go rather than piecing it together from separate replies.
+//
+// As with History, the batch and draft/chathistory caps must be requested
+// before connecting to get history, and this must not be called from the
+// Handler, as the replies are read by the same goroutine. The JOIN, NAMES,
+// and any buffered messages are still passed to the Handler as usual.
+func (c *Client) JoinWithBackfill(ctx context.Context, channel string, opts BackfillOptions) (*Backfill, error) {
+	if !c.config.IgnoreChanLimit && !c.withinChanLimit([]string{channel}) {
+		return nil, ErrChannelLimitReached
+	}
+
+	req := &joinRequest{
+		channel: channel,
+		result:  make(chan error, 1),
+	}
+
+	c.joinLock.Lock()
+	c.joins = append(c.joins, req)
+	c.joinLock.Unlock()
+
+	params := []string{channel}
+	if opts.Key != "" {
+		params = append(params, opts.Key)
+	}
+
+	err := c.WriteMessage(&Message{Command: "JOIN", Params: params})
+	if err != nil {
+		c.popJoinRequest(channel)
+		return nil, err
+	}
+
+	select {
+	case err = <-req.result:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		c.popJoinRequest(channel)
+		return nil, ctx.Err()
+	}
+
+	ret := &Backfill{
+		Channel: channel,
+		Names:   req.names,
+	}
+
+	if opts.Limit <= 0 {
+		return ret, nil
+	}
+
+	// The Buffer is checked before requesting history because it will also
+	// see the history messages as they arrive.
+	seen := make(map[string]struct{})
+	if opts.Buffer != nil {
+		for _, m := range opts.Buffer.Messages(channel) {
+			seen[m.Tags["msgid"]] = struct{}{}
+		}
+	}
+
+	messages, err := c.History(ctx, channel, HistoryLatest, "*", opts.Limit)
+	if err == ErrHistoryNotSupported {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, m := range messages {
+		if _, ok := seen[m.Tags["msgid"]]; ok {
+			continue
+		}
+
+		ret.Messages = append(ret.Messages, m)
+	}
+
+	return ret, nil
+}
+
+// findJoinRequest returns the pending request for a channel, if there is one.
+// The joinLock must be held when calling this.
+func (c *Client) findJoinRequest(channel string) *joinRequest {
+	for _, req := range c.joins {
+		if c.foldNick(req.channel) == c.foldNick(channel) {
+			return req
+		}
+	}
+
+	return nil
+}
+
+// popJoinRequest removes and returns the pending request for a channel, if
+// there is one.
+func (c *Client) popJoinRequest(channel string) *joinRequest {
+	c.joinLock.Lock()
+	defer c.joinLock.Unlock()
+
+	req := c.findJoinRequest(channel)
+	if req == nil {
+		return nil
+	}
+
+	for i, pending := range c.joins {
+		if pending == req {
+			c.joins = append(c.joins[:i], c.joins[i+1:]...)
+			break
+		}
+	}
+
+	return req
+}
+
+// From rfc2812 section 5.1 (Command responses)
+//
+//	353    RPL_NAMREPLY
+//	"( "=" / "*" / "@" ) <channel>
+//	:[ "@" / "+" ] <nick> *( " " [ "@" / "+" ] <nick> )
+func handle353(c *Client, m *Message) {
+	if len(m.Params) != 4 {
+		return
+	}
+
+	c.joinLock.Lock()
+	defer c.joinLock.Unlock()
+
+	req := c.findJoinRequest(m.Params[2])
+	if req == nil {
+		return
+	}
+
+	prefixes := "~&@%+"
+	if c.ISupport != nil {
+		if prefixMap, ok := c.ISupport.GetPrefixMap(); ok {
+			prefixes = ""
+			for symbol := range prefixMap {
+				prefixes += string(symbol)
+			}
+		}
+	}
+
+	for _, name := range strings.Fields(m.Trailing()) {
+		// With userhost-in-names, the nick is followed by the rest of the
+		// hostmask.
+		name = strings.TrimLeft(name, prefixes)
+		req.names = append(req.names, ParsePrefix(name).Name)
+	}
+}
+
+// From rfc2812 section 5.1 (Command responses)
+//
+//	366    RPL_ENDOFNAMES
+//	"<channel> :End of NAMES list"
+func handle366(c *Client, m *Message) {
+	if len(m.Params) < 2 {
+		return
+	}
+
+	if req := c.popJoinRequest(m.Params[1]); req != nil {
+		req.result <- nil
+	}
+}
+
+// handleJoinError is called for numerics which mean we couldn't join a
+// channel. Any pending JoinWithBackfill call for it will return a JoinError.
+func handleJoinError(c *Client, m *Message) {
+	if len(m.Params) < 2 {
+		return
+	}
+
+	if req := c.popJoinRequest(m.Params[1]); req != nil {
+		req.result <- &JoinError{
+			Channel: m.Params[1],
+			Numeric: m.Command,
+			Text:    m.Trailing(),
+		}
+	}
+}
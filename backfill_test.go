@@ -0,0 +1,125 @@
+package irc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestJoinWithBackfill(t *testing.T) {
+	t.Parallel()
+
+	buffer := irc.NewHistoryBuffer(10)
+	config := irc.ClientConfig{
+		Nick:           "test_nick",
+		User:           "test_user",
+		Name:           "test_name",
+		EnableISupport: true,
+		StateTrackers:  []irc.StateTracker{buffer},
+	}
+
+	type result struct {
+		backfill *irc.Backfill
+		err      error
+	}
+	results := make(chan result, 1)
+
+	var c *irc.Client
+	join := func(channel string, opts irc.BackfillOptions) TestAction {
+		return func(t *testing.T, rw *testReadWriter) {
+			go func() {
+				backfill, err := c.JoinWithBackfill(context.Background(), channel, opts)
+				results <- result{backfill, err}
+			}()
+		}
+	}
+
+	rw := newTestReadWriter()
+	c = irc.NewClient(rw, config)
+	c.CapRequest("batch", true)
+	c.CapRequest("draft/chathistory", true)
+
+	go func() {
+		assert.Equal(t, io.EOF, c.Run())
+		close(rw.clientDone)
+	}()
+
+	runTest(t, rw, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		LineFunc(func(m *irc.Message) { assert.Equal(t, "CAP", m.Command) }),
+		LineFunc(func(m *irc.Message) { assert.Equal(t, "CAP", m.Command) }),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :batch draft/chathistory\r\n"),
+		SendLine("CAP * ACK :batch\r\n"),
+		SendLine("CAP * ACK :draft/chathistory\r\n"),
+		ExpectLine("CAP END\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		SendLine("005 test_nick PREFIX=(ov)@+ :are supported by this server\r\n"),
+
+		// Messages we've already seen shouldn't be returned again.
+		SendLine("@msgid=2 :b!u@h PRIVMSG #chan :two\r\n"),
+
+		join("#chan", irc.BackfillOptions{Key: "secret", Limit: 10, Buffer: buffer}),
+		ExpectLine("JOIN #chan secret\r\n"),
+		SendLine(":test_nick!u@h JOIN #chan\r\n"),
+		SendLine("353 test_nick = #chan :@test_nick +a\r\n"),
+		SendLine("353 test_nick = #other :c\r\n"),
+		SendLine("353 test_nick = #chan :b!u@h\r\n"),
+		SendLine("366 test_nick #chan :End of /NAMES list\r\n"),
+		ExpectLine("CHATHISTORY LATEST #chan * 10\r\n"),
+		SendLine("BATCH +ref chathistory #chan\r\n"),
+		SendLine("@batch=ref;msgid=1 :a!u@h PRIVMSG #chan :one\r\n"),
+		SendLine("@batch=ref;msgid=2 :b!u@h PRIVMSG #chan :two\r\n"),
+		SendLine("@batch=ref;msgid=3 :a!u@h PRIVMSG #chan :three\r\n"),
+		SendLine("BATCH -ref\r\n"),
+		func(t *testing.T, rw *testReadWriter) {
+			res := <-results
+			if assert.NoError(t, res.err) {
+				assert.Equal(t, "#chan", res.backfill.Channel)
+				assert.Equal(t, []string{"test_nick", "a", "b"}, res.backfill.Names)
+
+				var text []string
+				for _, m := range res.backfill.Messages {
+					text = append(text, m.Trailing())
+				}
+				assert.Equal(t, []string{"one", "three"}, text)
+			}
+		},
+
+		// Without a limit, no history is requested.
+		join("#quiet", irc.BackfillOptions{}),
+		ExpectLine("JOIN #quiet\r\n"),
+		SendLine("366 test_nick #Quiet :End of /NAMES list\r\n"),
+		func(t *testing.T, rw *testReadWriter) {
+			res := <-results
+			if assert.NoError(t, res.err) {
+				assert.Empty(t, res.backfill.Names)
+				assert.Empty(t, res.backfill.Messages)
+			}
+		},
+
+		// Being refused should be returned as an error.
+		join("#banned", irc.BackfillOptions{Limit: 10}),
+		ExpectLine("JOIN #banned\r\n"),
+		SendLine("474 test_nick #banned :Cannot join channel (+b)\r\n"),
+		func(t *testing.T, rw *testReadWriter) {
+			res := <-results
+
+			var joinErr *irc.JoinError
+			if assert.True(t, errors.As(res.err, &joinErr)) {
+				assert.Equal(t, &irc.JoinError{
+					Channel: "#banned",
+					Numeric: "474",
+					Text:    "Cannot join channel (+b)",
+				}, joinErr)
+			}
+			assert.EqualError(t, res.err, "irc: cannot join #banned (474): Cannot join channel (+b)")
+		},
+	})
+}
@@ -0,0 +1,114 @@
+package irc
+
+import (
+	"strings"
+)
+
+// Batch is a group of messages sent by the server between BATCH +ref and
+// BATCH -ref. See https://ircv3.net/specs/extensions/batch for details.
+type Batch struct {
+	// Ref is the reference tag the server used for this batch.
+	Ref string
+
+	// Type is the type of the batch, such as netsplit or chathistory.
+	Type string
+
+	// Params are any additional parameters after the batch type.
+	Params []string
+
+	// Start is the BATCH message which opened this batch.
+	Start *Message
+
+	// Messages are all the messages in this batch, in the order they were
+	// received. Messages which are part of a nested batch are in that
+	// batch instead.
+	Messages []*Message
+
+	// Batches are any batches nested inside this one.
+	Batches []*Batch
+
+	parent *Batch
+
+	// claimed is set when a batch is being collected for an internal request,
+	// such as Client.History, rather than for the Handler.
+	claimed bool
+	request *historyRequest
+}
+
+// BatchHandler can be implemented by a Handler to receive batches as a single
+// unit. If any of a Client's handlers implement BatchHandler, messages which
+// are part of a batch will not be passed to their Handle; instead HandleBatch
+// will be called with the whole batch once it ends. Other handlers still get
+// each message. Nested batches are delivered as part of their outermost
+// batch. Note that the batch cap needs to be requested for servers to send
+// batches.
+type BatchHandler interface {
+	HandleBatch(*Client, *Batch)
+}
+
+// batchCollector keeps track of batches which haven't ended yet.
+type batchCollector struct {
+	open map[string]*Batch
+}
+
+func newBatchCollector() *batchCollector {
+	return &batchCollector{
+		open: make(map[string]*Batch),
+	}
+}
+
+// handle looks at a single message. If the message was part of a batch, the
+// outermost batch it belongs to will be returned. done will be true if this
+// message ended that outermost batch.
+func (bc *batchCollector) handle(m *Message) (root *Batch, done bool) {
+	parent := bc.open[m.Tags["batch"]]
+
+	if m.Command == "BATCH" && len(m.Params) > 0 {
+		ref := m.Params[0]
+
+		switch {
+		case strings.HasPrefix(ref, "+") && len(m.Params) > 1:
+			batch := &Batch{
+				Ref:    ref[1:],
+				Type:   m.Params[1],
+				Params: m.Params[2:],
+				Start:  m,
+				parent: parent,
+			}
+
+			if parent != nil {
+				parent.Batches = append(parent.Batches, batch)
+			}
+
+			bc.open[batch.Ref] = batch
+
+			return batch.root(), false
+		case strings.HasPrefix(ref, "-"):
+			batch, ok := bc.open[ref[1:]]
+			if !ok {
+				return nil, false
+			}
+
+			delete(bc.open, batch.Ref)
+
+			return batch.root(), batch.parent == nil
+		}
+	}
+
+	if parent == nil {
+		return nil, false
+	}
+
+	parent.Messages = append(parent.Messages, m)
+
+	return parent.root(), false
+}
+
+// root returns the outermost batch this batch is nested in.
+func (b *Batch) root() *Batch {
+	for b.parent != nil {
+		b = b.parent
+	}
+
+	return b
+}
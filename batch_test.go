@@ -0,0 +1,104 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+type testBatchHandler struct {
+	TestHandler
+
+	batches []*irc.Batch
+}
+
+func (h *testBatchHandler) HandleBatch(c *irc.Client, b *irc.Batch) {
+	h.batches = append(h.batches, b)
+}
+
+func TestBatchHandler(t *testing.T) {
+	t.Parallel()
+
+	handler := &testBatchHandler{}
+	ht := irc.NewHandlerTester(handler, irc.ClientConfig{})
+
+	require.NoError(t, ht.Feed(
+		"PRIVMSG #chan :before",
+		"BATCH +outer chathistory #chan",
+		"@batch=outer :a!u@h PRIVMSG #chan :one",
+		"@batch=outer BATCH +inner netsplit irc.a irc.b",
+		"@batch=inner :b!u@h QUIT :irc.a irc.b",
+		"BATCH -inner",
+		"@batch=outer :a!u@h PRIVMSG #chan :two",
+		"@batch=unknown PRIVMSG #chan :not in a batch",
+	))
+
+	// Nothing should be delivered until the outer batch ends.
+	assert.Empty(t, handler.batches)
+	assert.Len(t, handler.Messages(), 2)
+
+	require.NoError(t, ht.Feed("BATCH -outer"))
+	require.Len(t, handler.batches, 1)
+
+	outer := handler.batches[0]
+	assert.Equal(t, "outer", outer.Ref)
+	assert.Equal(t, "chathistory", outer.Type)
+	assert.Equal(t, []string{"#chan"}, outer.Params)
+	if assert.Len(t, outer.Messages, 2) {
+		assert.Equal(t, "one", outer.Messages[0].Trailing())
+		assert.Equal(t, "two", outer.Messages[1].Trailing())
+	}
+
+	require.Len(t, outer.Batches, 1)
+	inner := outer.Batches[0]
+	assert.Equal(t, "netsplit", inner.Type)
+	assert.Equal(t, []string{"irc.a", "irc.b"}, inner.Params)
+	if assert.Len(t, inner.Messages, 1) {
+		assert.Equal(t, "QUIT", inner.Messages[0].Command)
+	}
+
+	// Handlers which don't implement BatchHandler see every message.
+	plain := &TestHandler{}
+	ht = irc.NewHandlerTester(plain, irc.ClientConfig{})
+	require.NoError(t, ht.Feed(
+		"BATCH +ref netsplit irc.a irc.b",
+		"@batch=ref :b!u@h QUIT :irc.a irc.b",
+		"BATCH -ref",
+	))
+	assert.Len(t, plain.Messages(), 3)
+}
+
+func TestBatchHandlerWithHandlers(t *testing.T) {
+	t.Parallel()
+
+	first := &testBatchHandler{}
+	second := &testBatchHandler{}
+	plain := &TestHandler{}
+	added := &TestHandler{}
+
+	ht := irc.NewHandlerTester(first, irc.ClientConfig{
+		Handlers: []irc.Handler{plain, second},
+	})
+	ht.Client.AddHandler(added)
+
+	require.NoError(t, ht.Feed(
+		"BATCH +ref netsplit irc.a irc.b",
+		"@batch=ref :b!u@h QUIT :irc.a irc.b",
+		"BATCH -ref",
+	))
+
+	// Each BatchHandler gets the batch, and everything else gets every
+	// message.
+	for _, h := range []*testBatchHandler{first, second} {
+		assert.Empty(t, h.Messages())
+		if assert.Len(t, h.batches, 1) {
+			assert.Equal(t, "ref", h.batches[0].Ref)
+		}
+	}
+
+	assert.Len(t, plain.Messages(), 3)
+	assert.Len(t, added.Messages(), 3)
+}
@@ -0,0 +1,58 @@
+package irc
+
+import "strings"
+
+// CasefoldASCII lowercases the ASCII letters in s. This matches the ascii
+// CASEMAPPING.
+func CasefoldASCII(s string) string {
+	return casefold(s, 'Z')
+}
+
+// CasefoldRFC1459 lowercases s using the rfc1459 CASEMAPPING, where []\^ are
+// the uppercase versions of {}|~. This is what most servers use.
+func CasefoldRFC1459(s string) string {
+	return casefold(s, '^')
+}
+
+// CasefoldRFC1459Strict lowercases s using the rfc1459-strict CASEMAPPING,
+// which is the same as rfc1459 except that ~ and ^ are treated as different
+// characters.
+func CasefoldRFC1459Strict(s string) string {
+	return casefold(s, ']')
+}
+
+// casefold lowercases every byte from A up to and including upper. Bytes
+// outside of ASCII are left alone.
+func casefold(s string, upper byte) string {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return r >= 'A' && r <= rune(upper)
+	})
+	if i == -1 {
+		return s
+	}
+
+	ret := []byte(s)
+	for ; i < len(ret); i++ {
+		if ret[i] >= 'A' && ret[i] <= upper {
+			ret[i] += 'a' - 'A'
+		}
+	}
+
+	return string(ret)
+}
+
+// Fold lowercases s based on the CASEMAPPING the server sent, so nicks and
+// channels can be compared. If the server didn't send CASEMAPPING, or sent
+// one which isn't supported, rfc1459 will be used.
+func (t *ISupportTracker) Fold(s string) string {
+	casemapping, _ := t.GetEnum("CASEMAPPING")
+
+	switch casemapping {
+	case "ascii":
+		return CasefoldASCII(s)
+	case "rfc1459-strict":
+		return CasefoldRFC1459Strict(s)
+	}
+
+	return CasefoldRFC1459(s)
+}
@@ -0,0 +1,47 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestCasefold(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "nick[]\\^", irc.CasefoldASCII("NiCK[]\\^"))
+	assert.Equal(t, "nick{}|~", irc.CasefoldRFC1459("NiCK[]\\^"))
+	assert.Equal(t, "nick{}|^", irc.CasefoldRFC1459Strict("NiCK[]\\^"))
+
+	// Anything outside of ASCII should be left alone.
+	assert.Equal(t, "ÄÖ#chan", irc.CasefoldRFC1459("ÄÖ#CHAN"))
+
+	// The returned string should be the same if nothing needs to change.
+	assert.Equal(t, "already_lower", irc.CasefoldASCII("already_lower"))
+}
+
+func TestISupportFold(t *testing.T) {
+	t.Parallel()
+
+	isupport := irc.NewISupportTracker()
+	assert.Equal(t, "nick{}", isupport.Fold("Nick[]"))
+
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick CASEMAPPING=ascii :are supported by this server",
+	)))
+	assert.Equal(t, "nick[]", isupport.Fold("Nick[]"))
+
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick CASEMAPPING=rfc1459-strict :are supported by this server",
+	)))
+	assert.Equal(t, "nick{}^", isupport.Fold("Nick[]^"))
+
+	// Unknown casemappings should fall back to rfc1459.
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick CASEMAPPING=rfc7613 :are supported by this server",
+	)))
+	assert.Equal(t, "nick{}", isupport.Fold("Nick[]"))
+}
@@ -5,7 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -19,6 +24,22 @@ type ClientConfig struct {
 	User string
 	Name string
 
+	// SASLLogin and SASLPassword are used to authenticate with SASL PLAIN
+	// during the CAP handshake if SASLLogin is set. If SASL fails, Run will
+	// return ErrSASLFailed.
+	SASLLogin    string
+	SASLPassword string
+
+	// SASLExternal should be set when the connection uses a TLS client
+	// certificate. The client will authenticate with SASL EXTERNAL if the
+	// server supports it, falling back to PLAIN if SASLLogin is also set.
+	SASLExternal bool
+
+	// NetworkName is an optional name used to identify which network this
+	// client is connected to. If it is empty, the NETWORK ISupport token will
+	// be used instead.
+	NetworkName string
+
 	// If this is set to true, the ISupport value on the client struct will be
 	// non-nil.
 	EnableISupport bool
@@ -27,10 +48,74 @@ type ClientConfig struct {
 	// non-nil.
 	EnableTracker bool
 
+	// If this is set to true along with EnableTracker, a MODE query will be
+	// sent for every channel the client joins so the Tracker knows the
+	// channel's modes.
+	TrackChannelModes bool
+
+	// If this is set to true along with EnableTracker, a WHO query will be
+	// sent for every channel the client joins so the Tracker knows the user,
+	// host, realname, and away status of everyone in it. If the server
+	// supports WHOX, accounts will be requested as well.
+	WhoOnJoin bool
+
+	// If this is set to true, the Monitor value on the client struct will be
+	// non-nil. This also enables ISupport so the MONITOR limit is known.
+	EnableMonitor bool
+
+	// StateTrackers are additional state trackers which will be given every
+	// incoming message after the ISupport and Tracker, but before the
+	// Handler.
+	StateTrackers []StateTracker
+
+	// If this is set to true and the server advertises the BOT ISupport token,
+	// the client will mark itself as a bot after registration.
+	Bot bool
+
+	// AltNicks are tried in order if Nick is in use or unavailable during
+	// registration.
+	AltNicks []string
+
+	// NickTransform is used to come up with a new nick when the last one we
+	// tried during registration was rejected, once all the AltNicks have been
+	// tried. If it is nil, an underscore will be appended.
+	NickTransform func(nick string) string
+
+	// RegainInterval enables trying to change back to Nick this often if we
+	// had to register with a different one. An attempt is also made right
+	// away if we see whoever has Nick quit or change nicks.
+	RegainInterval time.Duration
+
+	// RegainFunc, if set, is called instead of sending a NICK when trying to
+	// regain Nick, such as to ask services to REGAIN it.
+	RegainFunc func(c *Client, nick string) error
+
+	// ServicesAuth, if set, identifies to NickServ or a similar services bot
+	// once registration completes, for networks which don't support SASL.
+	ServicesAuth *ServicesAuth
+
+	// Channels are joined automatically once the server has finished
+	// sending the welcome burst (001 through 005), so any ISUPPORT limits are
+	// known. A key can be given after the channel name, separated by a space,
+	// such as "#secret hunter2". If ISupport is enabled, the channels will be
+	// split over as many JOIN messages as the server's TARGMAX requires.
+	Channels []string
+
+	// Servers is an ordered list of servers for a ReconnectingClient to
+	// connect to. If connecting to one fails, the next one is tried. It is
+	// ignored by a Client on its own.
+	Servers []Server
+
 	// Connection settings
 	PingFrequency time.Duration
 	PingTimeout   time.Duration
 
+	// OnPong is called with the round-trip time whenever a PONG is received
+	// for one of the PINGs sent because of PingFrequency. It is called from
+	// the goroutine which sent the PING, so it must be safe for concurrent
+	// use with the Handler.
+	OnPong func(lag time.Duration)
+
 	// SendLimit is how frequent messages can be sent. If this is zero,
 	// there will be no limit.
 	SendLimit time.Duration
@@ -38,8 +123,185 @@ type ClientConfig struct {
 	// SendBurst is the number of messages which can be sent in a burst.
 	SendBurst int
 
+	// MaxSendQueue is the number of lines which may be waiting to be sent
+	// before Client.TrySend starts returning ErrSendQueueFull. If it is zero,
+	// TrySend will only send messages which can go out right away.
+	MaxSendQueue int
+
+	// Limiter, if set, replaces the rate limiting configured by SendLimit,
+	// SendBurst, and RegistrationBurst, which will all be ignored.
+	Limiter Limiter
+
+	// RegistrationBurst is the number of messages which can be sent in a
+	// burst right after registration completes, to allow for things like
+	// joining channels and identifying without being throttled. Once
+	// RegistrationGrace has passed, SendBurst applies again. If this is not
+	// larger than SendBurst, it has no effect.
+	RegistrationBurst int
+
+	// RegistrationGrace is how long the RegistrationBurst allowance lasts. If
+	// it is zero, 10 seconds will be used.
+	RegistrationGrace time.Duration
+
+	// QuitTimeout is how long to wait for the server to close the connection
+	// after Quit is called before closing it ourselves. If it is zero, 5
+	// seconds will be used.
+	QuitTimeout time.Duration
+
+	// MaxParseErrors is the number of consecutive malformed lines which will
+	// be skipped before giving up on the connection. If this is zero, any
+	// malformed line will cause Run to return an error.
+	MaxParseErrors int
+
+	// ParseErrorHandler is called with the error for every malformed line
+	// which is skipped because of MaxParseErrors.
+	ParseErrorHandler func(error)
+
+	// StateErrorHandler is called with a *StateError whenever the ISupport,
+	// Tracker, Monitor, or any of the StateTrackers fail to handle a
+	// message. This usually means the tracked state no longer matches the
+	// server's, so applications may want to log it or reconnect.
+	StateErrorHandler func(error)
+
+	// RecoverPanics stops a panic in the Handler, or anything else given
+	// incoming messages, from crashing the program. The rest of the message's
+	// handling is skipped and the panic is passed to PanicHandler as a
+	// *PanicError.
+	RecoverPanics bool
+
+	// PanicHandler is called with a *PanicError for every panic recovered
+	// because of RecoverPanics.
+	PanicHandler func(error)
+
+	// DisconnectOnPanic makes Run return the *PanicError after a panic is
+	// recovered, rather than carrying on with the next message.
+	DisconnectOnPanic bool
+
+	// IgnoreChanLimit disables checking the CHANLIMIT ISupport token before
+	// joining channels with Client.Join.
+	IgnoreChanLimit bool
+
+	// RejectLongReasons controls what happens when an away, quit, or kick
+	// reason is longer than the AWAYLEN, QUITLEN, or KICKLEN the server
+	// advertised. By default the reason will be truncated, but if this is set
+	// ErrReasonTooLong will be returned instead. ISupport must be enabled for
+	// limits to be known.
+	RejectLongReasons bool
+
+	// NoticeGates are checked against every NOTICE received before
+	// registration completes. DefaultNoticeGates covers common ircds.
+	NoticeGates []NoticeGate
+
+	// OnNoticeGate is called whenever one of the NoticeGates matches, along
+	// with how long the server asked us to wait, if it said.
+	OnNoticeGate func(gate NoticeGate, wait time.Duration, m *Message)
+
+	// OnConnectionEvent is called when the server accepts our registration
+	// and when Run returns, with ConnectionRegistered and
+	// ConnectionDisconnected events. It is called from the goroutine reading
+	// messages, before the Handler sees the 001.
+	OnConnectionEvent func(ConnectionEvent)
+
+	// OnCapChange is called when the server adds or removes caps after
+	// registration using CAP NEW and CAP DEL. The cap-notify cap is
+	// implicitly enabled whenever caps are requested.
+	OnCapChange func(added, removed []string)
+
+	// IgnoreEchoes stops PRIVMSG, NOTICE, and TAGMSG messages sent by this
+	// client from being passed to the Handler when the echo-message cap is
+	// enabled. State tracking still sees them.
+	IgnoreEchoes bool
+
+	// ExperimentalResume enables support for the draft/resume-0.5 extension.
+	// Resume tokens and the time of the last message seen are kept in the
+	// ResumeStore, keyed by Nick, and used to resume the session on the next
+	// connection. Messages replayed by the server which we've already seen
+	// are not passed to the Handler. This is experimental and may change.
+	ExperimentalResume bool
+
+	// ResumeStore is where resume state is persisted. It is required for
+	// ExperimentalResume.
+	ResumeStore Store
+
+	// OnRedact is called whenever the server sends a REDACT message to let
+	// us know a message was deleted.
+	OnRedact func(*Redaction)
+
+	// SplitLongMessages makes the Client split any outgoing PRIVMSG or NOTICE
+	// which is too long to be relayed by the server without being truncated
+	// into multiple messages, in the same way as Client.SendSplit.
+	SplitLongMessages bool
+
+	// CoalesceWindow enables dropping PRIVMSG and NOTICE lines which are
+	// identical to the previous line sent to the same target within this
+	// long of it. This is meant to protect against handlers accidentally
+	// getting stuck in a loop. It is disabled by default.
+	CoalesceWindow time.Duration
+
+	// CoalesceCount makes the Client send a single copy of a dropped line,
+	// suffixed with "(xN)" where N is how many copies were dropped, once
+	// there have been no repeats for CoalesceWindow.
+	CoalesceCount bool
+
+	// OutputFilters are given every outgoing message, in order, before it is
+	// split, coalesced, or rate limited, and can rewrite, split, or drop it.
+	// They are called while the Client's Writer is locked, so they must not
+	// write to the Client themselves.
+	OutputFilters []OutputFilter
+
+	// WireInspector is called synchronously with the final bytes of every
+	// outgoing line, including the trailing \r\n, right before they are
+	// written to the connection. If it returns an error, the line will not be
+	// sent and the error will be returned to the caller. It is called after
+	// rate limiting, so any time spent here directly delays every write and
+	// blocks other writers; implementations should return within a few
+	// milliseconds. The line must not be modified or retained.
+	WireInspector func(line []byte) error
+
 	// Handler is used for message dispatching.
 	Handler Handler
+
+	// Handlers are given every message after the Handler, in order, so
+	// separate parts of an application don't need to be funneled through a
+	// single Handler. Client.AddHandler can be used to add more later.
+	Handlers []Handler
+
+	// Middleware wraps the Handler and Handlers, with the first one being the
+	// outermost, so cross-cutting concerns like logging, metrics, or ignore
+	// lists can be added without building a wrapper Handler. If there are no
+	// handlers, the Middleware are still given every message. Batches given to a
+	// BatchHandler and CTCP queries given to the CTCPHandler don't pass through
+	// the Middleware.
+	Middleware []func(Handler) Handler
+
+	// Workers makes the Handler, CTCPHandler, and BatchHandler get messages on
+	// a pool of this many goroutines rather than the one reading from the
+	// connection, so a slow handler doesn't hold up things like answering
+	// PINGs. The handlers must then be safe for concurrent use. State tracking
+	// still happens as messages are read, so it may be ahead of the message
+	// being handled. Queued messages are handled before Run returns.
+	Workers int
+
+	// WorkerQueue is how many messages can be waiting for a worker before
+	// reading from the connection is held up.
+	WorkerQueue int
+
+	// OrderByTarget makes sure messages for the same channel, or from the same
+	// user for everything else, are handled in the order they arrived when
+	// Workers is set. Each target is always given to the same worker, so
+	// different targets are still handled in parallel, but a slow handler
+	// will also hold up any other targets which share its worker. Without
+	// this, messages in the same conversation may be handled out of order.
+	OrderByTarget bool
+
+	// CTCPHandler, if set, is given all CTCP queries and replies other than
+	// ACTION instead of the Handler.
+	CTCPHandler CTCPHandler
+
+	// CTCPResponder, if set, enables automatic replies to CTCP VERSION, PING,
+	// TIME, and CLIENTINFO queries. The queries are still passed to the
+	// Handler or CTCPHandler.
+	CTCPResponder *CTCPResponder
 }
 
 type capStatus struct {
@@ -54,6 +316,9 @@ type capStatus struct {
 
 	// Available means that the server supports this cap
 	Available bool
+
+	// Value is the value the server advertised for this cap, if any
+	Value string
 }
 
 // Client is a wrapper around irc.Conn which is designed to make common
@@ -63,39 +328,110 @@ type Client struct {
 	closer   io.Closer
 	ISupport *ISupportTracker
 	Tracker  *Tracker
+	Monitor  *Monitor
+
+	config     ClientConfig
+	configLock sync.RWMutex
+
+	// handler passes messages through any Middleware to all the handlers.
+	handler      Handler
+	handlers     []Handler
+	handlersLock sync.Mutex
+
+	// dispatcher runs the handler on worker goroutines if Workers is set.
+	dispatcher *dispatcher
 
-	config ClientConfig
+	ctx     context.Context
+	ctxLock sync.Mutex
 
 	// Internal state
 	currentNick           string
+	currentUser           string
+	currentHost           string
+	userModes             string
 	limiter               *rate.Limiter
+	pingConfigChan        chan struct{}
 	incomingPongChan      chan string
 	errChan               chan error
-	caps                  map[string]capStatus
 	remainingCapResponses int
 	connected             bool
+	burstDone             bool
+	channelsJoined        bool
+	identified            bool
+	servicesStarted       time.Time
+	registered            bool
+	botModeSet            bool
+	saslMechanism         string
+	handlerDisabled       int32
+	lag                   int64
+	quitting              int32
+	altNickIndex          int
+	lastRegain            time.Time
+	quitOnce              sync.Once
+	batches               *batchCollector
+	coalescer             *coalescer
+	historyLock           sync.Mutex
+	history               []*historyRequest
+	joinLock              sync.Mutex
+	joins                 []*joinRequest
+	resume                resumeState
+	ctcpLimiter           *ctcpLimiter
+	multilineRef          uint32
+	sts                   *stsState
+	welcomeChan           chan struct{}
+	doneChan              chan struct{}
+	runErr                error
+
+	// stateLock guards caps and connected, which are changed by the read
+	// loop but can be read from any goroutine. The read loop itself can read
+	// connected without it.
+	stateLock sync.RWMutex
+	caps      map[string]capStatus
 }
 
+// ErrClientClosed is returned by WaitForRegistration if the client was closed
+// with Quit or Close before registration finished.
+var ErrClientClosed = errors.New("irc: client closed")
+
 // NewClient creates a client given an io stream and a client config.
 func NewClient(rwc io.ReadWriteCloser, config ClientConfig) *Client {
 	c := &Client{ //nolint:exhaustruct
-		Conn:        NewConn(rwc),
-		closer:      rwc,
-		config:      config,
-		currentNick: config.Nick,
-		errChan:     make(chan error, 1),
-		caps:        make(map[string]capStatus),
+		Conn:           NewConn(rwc),
+		closer:         rwc,
+		config:         config,
+		currentNick:    config.Nick,
+		errChan:        make(chan error, 1),
+		caps:           make(map[string]capStatus),
+		pingConfigChan: make(chan struct{}, 1),
+		batches:        newBatchCollector(),
+		welcomeChan:    make(chan struct{}),
+		doneChan:       make(chan struct{}),
 	}
 
-	if config.SendLimit != 0 {
-		if config.SendBurst == 0 {
-			config.SendBurst = 1
-		}
+	c.updateLimiter()
+	if config.Handler != nil {
+		c.handlers = append(c.handlers, config.Handler)
+	}
+	c.handlers = append(c.handlers, config.Handlers...)
+	c.handler = wrapHandler(HandlerFunc(c.handleAll), config.Middleware)
+
+	if config.CoalesceWindow > 0 {
+		c.coalescer = newCoalescer(config.CoalesceWindow, config.CoalesceCount, c.Write)
+	}
 
-		c.limiter = rate.NewLimiter(rate.Every(config.SendLimit), config.SendBurst)
+	if config.CTCPResponder != nil {
+		c.ctcpLimiter = newCTCPLimiter(config.CTCPResponder.Interval)
 	}
 
-	if config.EnableISupport || config.EnableTracker {
+	if config.ExperimentalResume {
+		c.CapRequest(resumeCap, false)
+	}
+
+	if config.SASLLogin != "" || config.SASLExternal {
+		c.CapRequest("sasl", true)
+	}
+
+	if config.EnableISupport || config.EnableTracker || config.EnableMonitor {
 		c.ISupport = NewISupportTracker()
 	}
 
@@ -103,6 +439,10 @@ func NewClient(rwc io.ReadWriteCloser, config ClientConfig) *Client {
 		c.Tracker = NewTracker(c.ISupport)
 	}
 
+	if config.EnableMonitor {
+		c.Monitor = NewMonitor(c.Writer, c.ISupport)
+	}
+
 	// Replace the writer writeCallback with one of our own
 	c.Conn.Writer.WriteCallback = c.writeCallback
 
@@ -110,30 +450,86 @@ func NewClient(rwc io.ReadWriteCloser, config ClientConfig) *Client {
 }
 
 func (c *Client) writeCallback(w *Writer, line string) error {
-	if c.limiter != nil {
-		// Note that context.Background imitates the previous implementation,
-		// but it may be worth looking for a way to use this with a passed in
-		// context in the future.
-		err := c.limiter.Wait(context.Background())
+	lines := []string{line}
+	if len(c.config.OutputFilters) > 0 {
+		var err error
+		lines, err = c.filterOutput(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, line := range lines {
+		// Client-only tags will be rejected or dropped by servers which
+		// haven't enabled message-tags, so we catch that here.
+		if strings.HasPrefix(line, "@") && !c.CapEnabled("message-tags") {
+			if i := strings.IndexByte(line, ' '); i != -1 && ParseTags(line[1:i]).HasClientTags() {
+				return ErrMessageTagsNotEnabled
+			}
+		}
+	}
+
+	if c.config.SplitLongMessages {
+		var split []string
+		for _, line := range lines {
+			split = append(split, c.splitLine(line)...)
+		}
+		lines = split
+	}
+
+	if c.coalescer != nil {
+		var filtered []string
+		for _, line := range lines {
+			filtered = append(filtered, c.coalescer.filter(line)...)
+		}
+		lines = filtered
+	}
+
+	for _, line := range lines {
+		err := c.writeLine(w, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeLine handles rate limiting and writing a single line to the
+// connection.
+func (c *Client) writeLine(w *Writer, line string) error {
+	limiter := c.currentLimiter()
+
+	if limiter != nil {
+		err := waitLimiter(limiter, line, w.noWait)
 		if err != nil {
 			return err
 		}
 	}
 
-	_, err := w.RawWrite([]byte(line + "\r\n"))
+	data := make([]byte, 0, len(line)+2)
+	data = append(data, line...)
+	data = append(data, '\r', '\n')
+
+	if c.config.WireInspector != nil {
+		err := c.config.WireInspector(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.RawWrite(data)
 	if err != nil {
 		c.sendError(err)
 	}
 	return err
 }
 
-// maybeStartPingLoop will start a goroutine to send out PING messages at the
-// PingFrequency in the config if the frequency is not 0.
-func (c *Client) maybeStartPingLoop(wg *sync.WaitGroup, exiting chan struct{}) {
-	if c.config.PingFrequency <= 0 {
-		return
-	}
-
+// startPingLoop will start a goroutine to send out PING messages at the
+// PingFrequency in the config. No PINGs will be sent while the frequency is 0,
+// but the loop keeps running so the frequency can be changed with
+// UpdateConfig.
+func (c *Client) startPingLoop(wg *sync.WaitGroup, exiting chan struct{}) {
 	wg.Add(1)
 
 	c.incomingPongChan = make(chan string, 5)
@@ -142,13 +538,39 @@ func (c *Client) maybeStartPingLoop(wg *sync.WaitGroup, exiting chan struct{}) {
 		defer wg.Done()
 
 		pingHandlers := make(map[string]chan struct{})
-		ticker := time.NewTicker(c.config.PingFrequency)
 
-		defer ticker.Stop()
+		var ticker *time.Ticker
+		var tick <-chan time.Time
+
+		resetTicker := func() {
+			if ticker != nil {
+				ticker.Stop()
+				ticker, tick = nil, nil
+			}
+
+			c.configLock.RLock()
+			frequency := c.config.PingFrequency
+			c.configLock.RUnlock()
+
+			if frequency > 0 {
+				ticker = time.NewTicker(frequency)
+				tick = ticker.C
+			}
+		}
+
+		resetTicker()
+
+		defer func() {
+			if ticker != nil {
+				ticker.Stop()
+			}
+		}()
 
 		for {
 			select {
-			case <-ticker.C:
+			case <-c.pingConfigChan:
+				resetTicker()
+			case <-tick:
 				// Each time we get a tick, we send off a ping and start a
 				// goroutine to handle the pong.
 				timestamp := time.Now().Unix()
@@ -173,22 +595,44 @@ func (c *Client) maybeStartPingLoop(wg *sync.WaitGroup, exiting chan struct{}) {
 	}()
 }
 
+// recordLag stores the round-trip time of a PING and passes it to OnPong.
+func (c *Client) recordLag(lag time.Duration) {
+	atomic.StoreInt64(&c.lag, int64(lag))
+
+	if c.config.OnPong != nil {
+		c.config.OnPong(lag)
+	}
+}
+
+// Lag returns the round-trip time of the most recent PING sent because of
+// PingFrequency. It will be zero until the first PONG is received.
+func (c *Client) Lag() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.lag))
+}
+
 func (c *Client) handlePing(timestamp int64, pongChan chan struct{}, wg *sync.WaitGroup, exiting chan struct{}) {
 	defer wg.Done()
 
-	err := c.Writef("PING :%d", timestamp)
+	err := c.Write("PING :" + strconv.FormatInt(timestamp, 10))
 	if err != nil {
 		c.sendError(err)
 		return
 	}
 
-	timer := time.NewTimer(c.config.PingTimeout)
+	c.configLock.RLock()
+	timeout := c.config.PingTimeout
+	c.configLock.RUnlock()
+
+	sent := time.Now()
+
+	timer := time.NewTimer(timeout)
 	defer timer.Stop()
 
 	select {
 	case <-timer.C:
 		c.sendError(errors.New("ping timeout"))
 	case <-pongChan:
+		c.recordLag(time.Since(sent))
 		return
 	case <-exiting:
 		return
@@ -196,26 +640,36 @@ func (c *Client) handlePing(timestamp int64, pongChan chan struct{}, wg *sync.Wa
 }
 
 // maybeStartCapHandshake will run a CAP LS and all the relevant CAP REQ
-// commands if there are any CAPs requested.
+// commands if there are any CAPs requested or STS is enabled.
 func (c *Client) maybeStartCapHandshake() error {
-	if len(c.caps) == 0 {
+	c.stateLock.RLock()
+	var requested []string
+	for key, cap := range c.caps {
+		if cap.Requested {
+			requested = append(requested, key)
+		}
+	}
+	noCaps := len(c.caps) == 0
+	c.stateLock.RUnlock()
+
+	// STS policies are advertised in CAP LS, so we need to send it even if
+	// no caps were requested.
+	if noCaps && c.sts == nil {
 		return nil
 	}
 
-	err := c.Write("CAP LS")
+	err := c.Write("CAP LS 302")
 	if err != nil {
 		return err
 	}
 
 	c.remainingCapResponses = 1 // We count the CAP LS response as a normal response
-	for key, cap := range c.caps {
-		if cap.Requested {
-			err = c.Writef("CAP REQ :%s", key)
-			if err != nil {
-				return err
-			}
-			c.remainingCapResponses++
+	for _, key := range requested {
+		err = c.Writef("CAP REQ :%s", key)
+		if err != nil {
+			return err
 		}
+		c.remainingCapResponses++
 	}
 
 	return nil
@@ -227,24 +681,91 @@ func (c *Client) maybeStartCapHandshake() error {
 // the CAP is marked as required, the client will exit if that CAP could not be
 // negotiated during the handshake.
 func (c *Client) CapRequest(capName string, required bool) {
-	capStatus := c.caps[capName]
-	capStatus.Requested = true
-	capStatus.Required = capStatus.Required || required
-	c.caps[capName] = capStatus
+	c.updateCap(capName, func(status *capStatus) {
+		status.Requested = true
+		status.Required = status.Required || required
+	})
 }
 
 // CapEnabled allows you to check if a CAP is enabled for this connection. Note
 // that it will not be populated until after the CAP handshake is done, so it is
 // recommended to wait to check this until after a message like 001.
 func (c *Client) CapEnabled(capName string) bool {
-	return c.caps[capName].Enabled
+	return c.getCap(capName).Enabled
+}
+
+// CapValue returns the value the server advertised for a CAP, such as the
+// list of mechanisms for sasl. It will be empty if the CAP has no value or is
+// not available. Note that it will not be populated until after the CAP
+// handshake is done.
+func (c *Client) CapValue(capName string) string {
+	return c.getCap(capName).Value
 }
 
 // CapAvailable allows you to check if a CAP is available on this server. Note
 // that it will not be populated until after the CAP handshake is done, so it is
 // recommended to wait to check this until after a message like 001.
 func (c *Client) CapAvailable(capName string) bool {
-	return c.caps[capName].Available
+	return c.getCap(capName).Available
+}
+
+// getCap returns the current status of a cap.
+func (c *Client) getCap(capName string) capStatus {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	return c.caps[capName]
+}
+
+// updateCap changes the status of a cap with fn while holding the lock.
+func (c *Client) updateCap(capName string, fn func(status *capStatus)) capStatus {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	status := c.caps[capName]
+	fn(&status)
+	c.caps[capName] = status
+
+	return status
+}
+
+// enabledCaps returns the names of all enabled caps, sorted.
+func (c *Client) enabledCaps() []string {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	ret := []string{}
+	for name, status := range c.caps {
+		if status.Enabled {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// isConnected returns true once the server has sent RPL_WELCOME.
+func (c *Client) isConnected() bool {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	return c.connected
+}
+
+// missingRequiredCap returns a required cap which wasn't enabled, if there
+// is one.
+func (c *Client) missingRequiredCap() (string, bool) {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	for key, status := range c.caps {
+		if status.Required && !status.Enabled {
+			return key, true
+		}
+	}
+
+	return "", false
 }
 
 func (c *Client) sendError(err error) {
@@ -260,35 +781,242 @@ func (c *Client) startReadLoop(wg *sync.WaitGroup, exiting chan struct{}) {
 	go func() {
 		defer wg.Done()
 
+		parseErrors := 0
+
 		for {
 			select {
 			case <-exiting:
 				return
 			default:
-				m, err := c.ReadMessage()
+				m, err := c.readMessage(&parseErrors)
 				if err != nil {
 					c.sendError(err)
 					break
 				}
 
-				if f, ok := clientFilters[m.Command]; ok {
-					f(c, m)
+				if m != nil {
+					c.handleMessage(m)
 				}
+			}
+		}
+	}()
+}
 
-				if c.ISupport != nil {
-					_ = c.ISupport.Handle(m)
-				}
+// readMessage reads the next message from the connection. Parse errors are
+// passed to the ParseErrorHandler and skipped (returning a nil message) until
+// MaxParseErrors of them have been seen in a row.
+func (c *Client) readMessage(parseErrors *int) (*Message, error) {
+	m, err := c.ReadMessage()
+	if isParseError(err) && *parseErrors < c.config.MaxParseErrors {
+		*parseErrors++
+		if c.config.ParseErrorHandler != nil {
+			c.config.ParseErrorHandler(err)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-				if c.Tracker != nil {
-					_ = c.Tracker.Handle(m)
-				}
+	*parseErrors = 0
 
-				if c.config.Handler != nil {
-					c.config.Handler.Handle(c, m)
-				}
+	return m, nil
+}
+
+// handleMessage runs a single incoming message through the client filters,
+// state trackers, and finally the Handler.
+func (c *Client) handleMessage(m *Message) {
+	if c.config.RecoverPanics {
+		defer c.recoverPanic(m)
+	}
+
+	c.maybeRegainNick(false)
+
+	if f, ok := clientFilters[m.Command]; ok {
+		f(c, m)
+	}
+
+	c.checkServicesAuth(m)
+
+	if c.connected && !c.burstDone && !isWelcomeBurst(m.Command) {
+		c.burstDone = true
+		c.startServicesAuth()
+	}
+
+	if c.burstDone && !c.channelsJoined && !c.waitingForServices() {
+		c.joinConfigChannels()
+	}
+
+	if c.ISupport != nil {
+		c.handleStateError(m, c.ISupport.Handle(m))
+	}
+
+	if c.Tracker != nil {
+		c.handleStateError(m, c.Tracker.Handle(m))
+	}
+
+	if c.Monitor != nil {
+		c.handleStateError(m, c.Monitor.Handle(m))
+	}
+
+	for _, tracker := range c.config.StateTrackers {
+		c.handleStateError(m, tracker.Handle(m))
+	}
+
+	batch, done := c.batches.handle(m)
+	if batch != nil {
+		c.handleHistoryBatch(m, batch, done)
+	}
+
+	if batch != nil && batch.claimed {
+		return
+	}
+
+	// Handlers which understand batches are given the whole batch once it
+	// ends, rather than the messages in it.
+	handler := c.handler
+	if batch != nil {
+		if done && c.HandlerEnabled() {
+			for _, bh := range c.batchHandlers() {
+				bh := bh
+				c.dispatch(m, func() {
+					bh.HandleBatch(c, batch)
+				})
 			}
 		}
-	}()
+
+		handler = wrapHandler(HandlerFunc(c.handleUnbatched), c.config.Middleware)
+	}
+
+	// Multiline batches are passed to the Handler as a single message once
+	// they end.
+	if batch != nil && batch.Type == multilineBatchType {
+		if !done {
+			return
+		}
+
+		combined, ok := batch.CombineMultiline()
+		if !ok {
+			return
+		}
+
+		m = combined
+	}
+
+	if c.config.IgnoreEchoes && c.isEcho(m) {
+		return
+	}
+
+	if c.isResumeDuplicate(m) {
+		return
+	}
+
+	if !c.HandlerEnabled() {
+		return
+	}
+
+	if c.config.CTCPHandler != nil {
+		if ctcp, ok := ParseCTCP(m); ok && ctcp.Command != "ACTION" {
+			c.dispatch(m, func() {
+				c.config.CTCPHandler.HandleCTCP(c, m, ctcp)
+			})
+			return
+		}
+	}
+
+	c.dispatch(m, func() {
+		handler.Handle(c, m)
+	})
+}
+
+// StateError is passed to ClientConfig.StateErrorHandler when a state tracker
+// fails to handle a message.
+type StateError struct {
+	// Message is the message which caused the error.
+	Message *Message
+
+	// Err is the error returned by the state tracker.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *StateError) Error() string {
+	return "irc: failed to track state for " + e.Message.Command + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *StateError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is passed to ClientConfig.PanicHandler when the Handler, or
+// anything else given incoming messages, panics and RecoverPanics is set.
+type PanicError struct {
+	// Message is the message being handled when the panic happened.
+	Message *Message
+
+	// Value is the value passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine which panicked.
+	Stack []byte
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("irc: panic handling %s: %v", e.Message.Command, e.Value)
+}
+
+// Unwrap returns the value passed to panic if it was an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// recoverPanic turns a panic while handling a message into a PanicError.
+func (c *Client) recoverPanic(m *Message) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	err := &PanicError{Message: m, Value: r, Stack: debug.Stack()}
+
+	if c.config.PanicHandler != nil {
+		c.config.PanicHandler(err)
+	}
+
+	if c.config.DisconnectOnPanic {
+		c.sendError(err)
+	}
+}
+
+// handleStateError passes any error from a state tracker to the
+// StateErrorHandler.
+func (c *Client) handleStateError(m *Message, err error) {
+	if err == nil || c.config.StateErrorHandler == nil {
+		return
+	}
+
+	c.config.StateErrorHandler(&StateError{Message: m, Err: err})
+}
+
+// SetHandlerEnabled controls whether incoming messages are passed to the
+// Handler. Internal state tracking continues either way. The Handler is
+// enabled by default.
+func (c *Client) SetHandlerEnabled(enabled bool) {
+	var disabled int32
+	if !enabled {
+		disabled = 1
+	}
+
+	atomic.StoreInt32(&c.handlerDisabled, disabled)
+}
+
+// HandlerEnabled returns whether incoming messages are being passed to the
+// Handler.
+func (c *Client) HandlerEnabled() bool {
+	return atomic.LoadInt32(&c.handlerDisabled) == 0
 }
 
 // Run starts the main loop for this IRC connection. Note that it may break in
@@ -299,15 +1027,199 @@ func (c *Client) Run() error {
 }
 
 // RunContext is the same as Run but a context.Context can be passed in for
-// cancelation.
+// cancelation. If Connect has already been called, registration will be
+// skipped.
 func (c *Client) RunContext(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.setContext(ctx)
+
 	// exiting is used by the main goroutine here to ensure any sub-goroutines
 	// get closed when exiting.
 	exiting := make(chan struct{})
 	var wg sync.WaitGroup
 
-	c.maybeStartPingLoop(&wg, exiting)
+	c.startPingLoop(&wg, exiting)
+
+	if !c.registered {
+		err := c.sendRegistration()
+		if err != nil {
+			if atomic.LoadInt32(&c.quitting) != 0 {
+				err = nil
+			}
+
+			close(exiting)
+			wg.Wait()
+			c.finishRun(err)
+
+			return err
+		}
+	}
+
+	// Now that the handshake is pretty much done, we can start listening for
+	// messages.
+	c.startReadLoop(&wg, exiting)
+
+	// Wait for an error from any goroutine or for the context to time out, then
+	// signal we're exiting and wait for the goroutines to exit.
+	var err error
+	select {
+	case err = <-c.errChan:
+		// After Quit or Close, the connection going away is expected.
+		if atomic.LoadInt32(&c.quitting) != 0 {
+			err = nil
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	close(exiting)
+	c.closer.Close()
+	wg.Wait()
+
+	// Let any handlers still running know the connection is gone before
+	// waiting for them.
+	cancel()
+	c.stopDispatcher()
+
+	c.saveResumeState()
+
+	c.emitConnectionEvent(ConnectionEvent{Type: ConnectionDisconnected, Client: c, Err: err}) //nolint:exhaustruct
+
+	c.finishRun(err)
+
+	return err
+}
+
+// finishRun records the error Run is returning and wakes anything blocked in
+// WaitForRegistration.
+func (c *Client) finishRun(err error) {
+	select {
+	case <-c.doneChan:
+	default:
+		c.runErr = err
+		close(c.doneChan)
+	}
+}
+
+// Close closes the connection immediately without sending a QUIT. Run will
+// return nil. Use Quit to disconnect cleanly.
+func (c *Client) Close() error {
+	atomic.StoreInt32(&c.quitting, 1)
+	return c.closer.Close()
+}
+
+// WaitForRegistration blocks until the server has accepted our registration
+// with RPL_WELCOME (001). It is meant to be called from a different goroutine
+// than Run. If Run returns first, its error will be returned.
+func (c *Client) WaitForRegistration(ctx context.Context) error {
+	select {
+	case <-c.welcomeChan:
+		return nil
+	case <-c.doneChan:
+		// The 001 may have been handled right before Run returned.
+		select {
+		case <-c.welcomeChan:
+			return nil
+		default:
+		}
+
+		if c.runErr == nil {
+			return ErrClientClosed
+		}
+
+		return c.runErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Connect performs registration with the server, including the CAP handshake
+// and SASL if configured, and returns once the server has finished sending the
+// welcome burst (001 through 005). Messages received during registration are
+// passed to the Handler as usual. Once Connect returns, Run or RunContext
+// should be called to process all remaining messages. If Connect returns an
+// error, the connection should be considered unusable.
+func (c *Client) Connect(ctx context.Context) error {
+	if c.registered {
+		return errors.New("irc: client already registered")
+	}
+
+	c.setContext(ctx)
+
+	err := c.connect(ctx)
+	if err != nil {
+		c.stopDispatcher()
+	}
+
+	return err
+}
+
+// connect does the work for Connect.
+func (c *Client) connect(ctx context.Context) error {
+	err := c.sendRegistration()
+	if err != nil {
+		return err
+	}
+
+	// ReadMessage can't be interrupted, so if the context is canceled we
+	// need to close the connection to stop it.
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.closer.Close()
+		case <-done:
+		}
+	}()
 
+	parseErrors := 0
+
+	for {
+		m, err := c.readMessage(&parseErrors)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+
+		c.handleMessage(m)
+
+		// Any problems reported by the filters (ERROR, rejected caps, SASL
+		// failures) mean registration failed.
+		select {
+		case err = <-c.errChan:
+			return err
+		default:
+		}
+
+		if c.connected && !isWelcomeBurst(m.Command) {
+			c.registered = true
+			return nil
+		}
+	}
+}
+
+// isWelcomeBurst returns true for the numerics sent immediately after a client
+// has registered.
+func isWelcomeBurst(command string) bool {
+	switch command {
+	case RPL_WELCOME, RPL_YOURHOST, RPL_CREATED, RPL_MYINFO, RPL_ISUPPORT:
+		return true
+	}
+
+	return false
+}
+
+// sendRegistration sends PASS, the CAP handshake, NICK, and USER.
+func (c *Client) sendRegistration() error {
 	if c.config.Pass != "" {
 		err := c.Writef("PASS :%s", c.config.Pass)
 		if err != nil {
@@ -340,34 +1252,73 @@ func (c *Client) RunContext(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	err = c.Writef("USER %s 0 * :%s", user, name)
-	if err != nil {
-		return err
+
+	return c.Writef("USER %s 0 * :%s", user, name)
+}
+
+// CurrentNick returns what the nick of the client is known to be at this point
+// in time.
+func (c *Client) CurrentNick() string {
+	return c.currentNick
+}
+
+// CurrentPrefix returns the nick!user@host the server uses for this client, as
+// learned from the welcome message, RPL_VISIBLEHOST, USERHOST replies, and our
+// own JOINs. The User and Host will be empty until they are known.
+func (c *Client) CurrentPrefix() *Prefix {
+	return &Prefix{
+		Name: c.currentNick,
+		User: c.currentUser,
+		Host: c.currentHost,
 	}
+}
 
-	// Now that the handshake is pretty much done, we can start listening for
-	// messages.
-	c.startReadLoop(&wg, exiting)
+// UserModes returns the user modes the client is known to have, such as "+iw".
+// Modes are sorted and any mode parameters are not included.
+func (c *Client) UserModes() string {
+	return "+" + c.userModes
+}
 
-	// Wait for an error from any goroutine or for the context to time out, then
-	// signal we're exiting and wait for the goroutines to exit.
-	select {
-	case err = <-c.errChan:
-	case <-ctx.Done():
-		err = ctx.Err()
+// HasUserMode returns true if the client is known to have the given user mode,
+// such as 'o' for IRC operators.
+func (c *Client) HasUserMode(mode rune) bool {
+	return strings.ContainsRune(c.userModes, mode)
+}
+
+// NetworkName returns the name of the network this client is connected to. It
+// uses ClientConfig.NetworkName if it was provided, otherwise the NETWORK
+// ISupport token. An empty string will be returned if neither are available.
+func (c *Client) NetworkName() string {
+	if c.config.NetworkName != "" {
+		return c.config.NetworkName
 	}
 
-	close(exiting)
-	c.closer.Close()
-	wg.Wait()
+	if c.ISupport != nil {
+		if name, ok := c.ISupport.GetRaw("NETWORK"); ok {
+			return name
+		}
+	}
 
-	return err
+	return ""
 }
 
-// CurrentNick returns what the nick of the client is known to be at this point
-// in time.
-func (c *Client) CurrentNick() string {
-	return c.currentNick
+// IsSelf returns true if the given message was sent by this client. When the
+// echo-message cap is enabled, servers send copies of our own PRIVMSG, NOTICE,
+// and TAGMSG messages back to us, so this can be used to avoid replying to
+// them.
+func (c *Client) IsSelf(m *Message) bool {
+	return m.Prefix != nil && m.Prefix.Name != "" && c.foldNick(m.Prefix.Name) == c.foldNick(c.currentNick)
+}
+
+// isEcho returns true if the message is an echo-message copy of something we
+// sent.
+func (c *Client) isEcho(m *Message) bool {
+	switch m.Command {
+	case "PRIVMSG", "NOTICE", "TAGMSG":
+		return c.CapEnabled("echo-message") && c.IsSelf(m)
+	}
+
+	return false
 }
 
 // FromChannel takes a Message representing a PRIVMSG and returns if that
@@ -381,3 +1332,16 @@ func (c *Client) FromChannel(m *Message) bool {
 	// the message came from a channel.
 	return m.Params[0] != c.currentNick
 }
+
+// IsChannel returns true if name looks like a channel, based on the CHANTYPES
+// ISupport token, or # and & if it isn't known.
+func (c *Client) IsChannel(name string) bool {
+	chanTypes := "#&"
+	if c.ISupport != nil {
+		if types, ok := c.ISupport.GetRaw("CHANTYPES"); ok {
+			chanTypes = types
+		}
+	}
+
+	return name != "" && strings.IndexByte(chanTypes, name[0]) != -1
+}
@@ -0,0 +1,306 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReasonTooLong is returned when sending an away, quit, or kick reason
+// which is longer than the server allows and ClientConfig.RejectLongReasons is
+// set.
+var ErrReasonTooLong = errors.New("irc: reason is longer than the server allows")
+
+// ErrChannelLimitReached is returned from Client.Join if joining the requested
+// channels would put the client over the CHANLIMIT the server advertised.
+var ErrChannelLimitReached = errors.New("irc: joining would exceed the server's channel limit")
+
+// ErrMessageTagsNotEnabled is returned when trying to send client-only tags
+// or a TAGMSG without the message-tags cap being enabled.
+var ErrMessageTagsNotEnabled = errors.New("irc: message-tags cap is not enabled")
+
+// limitReason checks the given reason against the ISupport token which limits
+// its length. It will either truncate the reason or return ErrReasonTooLong
+// depending on the ClientConfig.
+func (c *Client) limitReason(token, reason string) (string, error) {
+	if c.ISupport == nil {
+		return reason, nil
+	}
+
+	limit, ok := c.ISupport.GetInt(token)
+	if !ok || limit == 0 || len(reason) <= limit {
+		return reason, nil
+	}
+
+	c.configLock.RLock()
+	reject := c.config.RejectLongReasons
+	c.configLock.RUnlock()
+
+	if reject {
+		return "", ErrReasonTooLong
+	}
+
+	return truncateUTF8(reason, limit), nil
+}
+
+// splitTargets breaks targets up into groups which are no larger than the
+// TARGMAX limit the server advertised for the given command. If there is no
+// known limit, all targets will be returned in a single group.
+func (c *Client) splitTargets(command string, targets []string) [][]string {
+	limit := 0
+	if c.ISupport != nil {
+		limit, _ = c.ISupport.GetTargMax(command)
+	}
+
+	if limit <= 0 || len(targets) <= limit {
+		return [][]string{targets}
+	}
+
+	ret := make([][]string, 0, (len(targets)+limit-1)/limit)
+	for len(targets) > limit {
+		ret = append(ret, targets[:limit])
+		targets = targets[limit:]
+	}
+
+	return append(ret, targets)
+}
+
+// WriteTargets sends the given command with a comma separated list of targets
+// as the first param, followed by any additional params. If the server limits
+// the number of targets for this command with TARGMAX, the targets will be
+// split up over as many messages as needed. ISupport must be enabled for limits
+// to be respected.
+func (c *Client) WriteTargets(command string, targets []string, params ...string) error {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	for _, group := range c.splitTargets(command, targets) {
+		err := c.WriteMessage(&Message{
+			Command: command,
+			Params:  append([]string{strings.Join(group, ",")}, params...),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Join joins the given channels, splitting them over multiple JOIN messages
+// if the server limits the number of targets. If both the ISupport and Tracker
+// are enabled, ErrChannelLimitReached will be returned without joining any
+// channels if this would exceed the server's CHANLIMIT, unless
+// ClientConfig.IgnoreChanLimit is set.
+func (c *Client) Join(channels ...string) error {
+	if !c.config.IgnoreChanLimit && !c.withinChanLimit(channels) {
+		return ErrChannelLimitReached
+	}
+
+	return c.WriteTargets("JOIN", channels)
+}
+
+// joinConfigChannels joins the channels from ClientConfig.Channels. Keys are
+// matched to channels by position, so channels with keys come first in each
+// JOIN.
+func (c *Client) joinConfigChannels() {
+	c.channelsJoined = true
+
+	var channels, keys, unkeyed []string
+	for _, entry := range c.config.Channels {
+		fields := strings.Fields(entry)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			unkeyed = append(unkeyed, fields[0])
+		default:
+			channels = append(channels, fields[0])
+			keys = append(keys, fields[1])
+		}
+	}
+
+	channels = append(channels, unkeyed...)
+	if len(channels) == 0 {
+		return
+	}
+
+	for _, group := range c.splitTargets("JOIN", channels) {
+		params := []string{strings.Join(group, ",")}
+		if len(keys) > 0 {
+			n := len(group)
+			if n > len(keys) {
+				n = len(keys)
+			}
+
+			params = append(params, strings.Join(keys[:n], ","))
+			keys = keys[n:]
+		}
+
+		err := c.WriteMessage(&Message{Command: "JOIN", Params: params})
+		if err != nil {
+			return
+		}
+	}
+}
+
+// withinChanLimit checks if joining the given channels would stay within the
+// server's CHANLIMIT. If the limits or current channels aren't known, this
+// will always return true.
+func (c *Client) withinChanLimit(channels []string) bool {
+	if c.ISupport == nil || c.Tracker == nil {
+		return true
+	}
+
+	limits := c.ISupport.getChanLimits()
+	if len(limits) == 0 {
+		return true
+	}
+
+	joined := make(map[string]struct{})
+	for _, name := range c.Tracker.ListChannels() {
+		joined[c.foldNick(name)] = struct{}{}
+	}
+
+	for _, name := range channels {
+		joined[c.foldNick(name)] = struct{}{}
+	}
+
+	for _, limit := range limits {
+		count := 0
+		for name := range joined {
+			if name != "" && strings.IndexByte(limit.prefixes, name[0]) != -1 {
+				count++
+			}
+		}
+
+		if count > limit.limit {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Kick kicks the given nicks from a channel, splitting them over multiple KICK
+// messages if the server limits the number of targets.
+func (c *Client) Kick(channel, reason string, nicks ...string) error {
+	if len(nicks) == 0 {
+		return nil
+	}
+
+	reason, err := c.limitReason("KICKLEN", reason)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range c.splitTargets("KICK", nicks) {
+		params := []string{channel, strings.Join(group, ",")}
+		if reason != "" {
+			params = append(params, reason)
+		}
+
+		err = c.WriteMessage(&Message{
+			Command: "KICK",
+			Params:  params,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Away marks the client as away with the given reason, respecting AWAYLEN. An
+// empty reason is the same as calling Back.
+func (c *Client) Away(reason string) error {
+	if reason == "" {
+		return c.Back()
+	}
+
+	reason, err := c.limitReason("AWAYLEN", reason)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteMessage(&Message{Command: "AWAY", Params: []string{reason}})
+}
+
+// Back removes the away status from the client.
+func (c *Client) Back() error {
+	return c.Write("AWAY")
+}
+
+// Reply sends text as a PRIVMSG to wherever m came from: the channel if it
+// was sent to one, otherwise the user who sent it. Long text is split in the
+// same way as SendSplit.
+func (c *Client) Reply(m *Message, text string) error {
+	target := m.Param(0)
+	if !c.FromChannel(m) && m.Prefix != nil {
+		target = m.Prefix.Name
+	}
+
+	return c.SendSplit(target, text)
+}
+
+// defaultQuitTimeout is used when QuitTimeout isn't set.
+const defaultQuitTimeout = 5 * time.Second
+
+// Quit sends a QUIT to the server with the given reason, respecting QUITLEN.
+// Any pending output, such as coalesced message summaries, is sent first.
+// Run keeps handling messages until the server closes the connection, or
+// ClientConfig.QuitTimeout passes, and then returns nil.
+func (c *Client) Quit(reason string) error {
+	m := &Message{Command: "QUIT"}
+
+	if reason != "" {
+		limited, err := c.limitReason("QUITLEN", reason)
+		if err != nil {
+			return err
+		}
+
+		m.Params = []string{limited}
+	}
+
+	atomic.StoreInt32(&c.quitting, 1)
+
+	if c.coalescer != nil {
+		c.coalescer.flushAll()
+	}
+
+	err := c.WriteMessage(m)
+
+	c.quitOnce.Do(func() {
+		c.configLock.RLock()
+		timeout := c.config.QuitTimeout
+		c.configLock.RUnlock()
+
+		if timeout <= 0 {
+			timeout = defaultQuitTimeout
+		}
+
+		time.AfterFunc(timeout, func() {
+			c.closer.Close()
+		})
+	})
+
+	return err
+}
+
+// SendTagMsg sends a TAGMSG with the given tags to a target. The message-tags
+// cap must have been requested and enabled, otherwise ErrMessageTagsNotEnabled
+// will be returned.
+func (c *Client) SendTagMsg(target string, tags Tags) error {
+	if !c.CapEnabled("message-tags") {
+		return ErrMessageTagsNotEnabled
+	}
+
+	return c.WriteMessage(&Message{
+		Tags:    tags,
+		Command: "TAGMSG",
+		Params:  []string{target},
+	})
+}
@@ -0,0 +1,243 @@
+package irc_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func newCommandTestClient(t *testing.T, config irc.ClientConfig, isupport ...string) (*irc.Client, *bytes.Buffer) {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	c := irc.NewClient(newNopCloser(buf), config)
+
+	for _, line := range isupport {
+		require.NotNil(t, c.ISupport)
+		require.NoError(t, c.ISupport.Handle(irc.MustParseMessage(line)))
+	}
+
+	return c, buf
+}
+
+func bufferLines(buf *bytes.Buffer) []string {
+	ret := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	buf.Reset()
+	return ret
+}
+
+func TestWriteTargets(t *testing.T) {
+	t.Parallel()
+
+	// Without ISupport, everything is sent at once
+	c, buf := newCommandTestClient(t, irc.ClientConfig{Nick: "test_nick"})
+	assert.NoError(t, c.Join("#a", "#b", "#c"))
+	assert.Equal(t, []string{"JOIN #a,#b,#c"}, bufferLines(buf))
+
+	assert.NoError(t, c.WriteTargets("PRIVMSG", nil, "hello world"))
+	assert.Equal(t, 0, buf.Len())
+
+	c, buf = newCommandTestClient(t, irc.ClientConfig{Nick: "test_nick", EnableISupport: true},
+		"005 test_nick TARGMAX=PRIVMSG:2,JOIN:,KICK:1 :are supported by this server")
+
+	assert.NoError(t, c.WriteTargets("PRIVMSG", []string{"a", "b", "c", "d", "e"}, "hello world"))
+	assert.Equal(t, []string{
+		"PRIVMSG a,b :hello world",
+		"PRIVMSG c,d :hello world",
+		"PRIVMSG e :hello world",
+	}, bufferLines(buf))
+
+	// An empty limit is unlimited
+	assert.NoError(t, c.Join("#a", "#b", "#c"))
+	assert.Equal(t, []string{"JOIN #a,#b,#c"}, bufferLines(buf))
+
+	assert.NoError(t, c.Kick("#a", "bye now", "a", "b"))
+	assert.Equal(t, []string{
+		"KICK #a a :bye now",
+		"KICK #a b :bye now",
+	}, bufferLines(buf))
+
+	// Commands without a limit are sent at once
+	assert.NoError(t, c.WriteTargets("NOTICE", []string{"a", "b", "c"}, "hi"))
+	assert.Equal(t, []string{"NOTICE a,b,c hi"}, bufferLines(buf))
+
+	// MAXTARGETS is used as a fallback for PRIVMSG and NOTICE
+	c, buf = newCommandTestClient(t, irc.ClientConfig{Nick: "test_nick", EnableISupport: true},
+		"005 test_nick MAXTARGETS=2 :are supported by this server")
+
+	assert.NoError(t, c.WriteTargets("NOTICE", []string{"a", "b", "c"}, "hi"))
+	assert.Equal(t, []string{"NOTICE a,b hi", "NOTICE c hi"}, bufferLines(buf))
+
+	assert.NoError(t, c.Join("#a", "#b", "#c"))
+	assert.Equal(t, []string{"JOIN #a,#b,#c"}, bufferLines(buf))
+}
+
+func TestReasonLength(t *testing.T) {
+	t.Parallel()
+
+	isupport := "005 test_nick AWAYLEN=5 QUITLEN=4 KICKLEN=2 :are supported by this server"
+
+	c, buf := newCommandTestClient(t, irc.ClientConfig{Nick: "test_nick", EnableISupport: true}, isupport)
+
+	assert.NoError(t, c.Away("hello world"))
+	assert.NoError(t, c.Away(""))
+	assert.NoError(t, c.Back())
+	assert.NoError(t, c.Quit("goodbye"))
+	assert.NoError(t, c.Kick("#a", "go away", "a"))
+	assert.Equal(t, []string{
+		"AWAY hello",
+		"AWAY",
+		"AWAY",
+		"QUIT good",
+		"KICK #a a go",
+	}, bufferLines(buf))
+
+	// Multi-byte characters should not be split
+	assert.NoError(t, c.Away("abcdé"))
+	assert.NoError(t, c.Quit("ééé"))
+	assert.Equal(t, []string{
+		"AWAY abcd",
+		"QUIT éé",
+	}, bufferLines(buf))
+
+	c, buf = newCommandTestClient(t, irc.ClientConfig{
+		Nick:              "test_nick",
+		EnableISupport:    true,
+		RejectLongReasons: true,
+	}, isupport)
+
+	assert.Equal(t, irc.ErrReasonTooLong, c.Away("hello world"))
+	assert.Equal(t, irc.ErrReasonTooLong, c.Quit("goodbye"))
+	assert.Equal(t, irc.ErrReasonTooLong, c.Kick("#a", "go away", "a"))
+	assert.NoError(t, c.Quit("bye"))
+	assert.Equal(t, []string{"QUIT bye"}, bufferLines(buf))
+}
+
+func TestJoinChanLimit(t *testing.T) {
+	t.Parallel()
+
+	isupport := "005 test_nick CHANLIMIT=#&:2,+:,!:1 :are supported by this server"
+
+	c, buf := newCommandTestClient(t, irc.ClientConfig{Nick: "test_nick", EnableTracker: true}, isupport)
+	require.NoError(t, c.Tracker.Handle(irc.MustParseMessage("001 test_nick :Welcome")))
+	require.NoError(t, c.Tracker.Handle(irc.MustParseMessage(":test_nick!user@host JOIN #a")))
+
+	assert.Equal(t, irc.ErrChannelLimitReached, c.Join("#b", "&c"))
+	assert.Equal(t, irc.ErrChannelLimitReached, c.Join("!a", "!b"))
+	assert.Equal(t, 0, buf.Len())
+
+	// Channels we're already in don't count twice
+	assert.NoError(t, c.Join("#a", "&c"))
+	assert.NoError(t, c.Join("#A", "&c"))
+	assert.NoError(t, c.Join("+a", "+b", "+c", "!a"))
+	assert.Equal(t, []string{"JOIN #a,&c", "JOIN #A,&c", "JOIN +a,+b,+c,!a"}, bufferLines(buf))
+
+	// The limit can be ignored
+	c, buf = newCommandTestClient(t, irc.ClientConfig{
+		Nick:            "test_nick",
+		EnableTracker:   true,
+		IgnoreChanLimit: true,
+	}, isupport)
+	assert.NoError(t, c.Join("#a", "#b", "#c"))
+	assert.Equal(t, []string{"JOIN #a,#b,#c"}, bufferLines(buf))
+}
+
+func TestClientTags(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, irc.IsClientTag("+typing"))
+	assert.False(t, irc.IsClientTag("time"))
+	assert.True(t, irc.Tags{"time": "", "+draft/react": "x"}.HasClientTags())
+	assert.False(t, irc.Tags{"time": ""}.HasClientTags())
+
+	// Without message-tags, client-only tags can't be sent.
+	c, buf := newCommandTestClient(t, irc.ClientConfig{Nick: "test_nick"})
+	assert.Equal(t, irc.ErrMessageTagsNotEnabled, c.SendTagMsg("#chan", irc.Tags{"+typing": "active"}))
+	assert.Equal(t, irc.ErrMessageTagsNotEnabled, c.Write("@+typing=active PRIVMSG #chan :hi"))
+	assert.NoError(t, c.Write("@label=abc PRIVMSG #chan :hi"))
+	assert.Equal(t, []string{"@label=abc PRIVMSG #chan :hi"}, bufferLines(buf))
+
+	var errs []error
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command != "001" {
+				return
+			}
+
+			errs = append(errs, c.SendTagMsg("#chan", irc.Tags{"+typing": "active"}))
+		}),
+	}
+
+	runClientTest(t, config, io.EOF, func(c *irc.Client) {
+		c.CapRequest("message-tags", false)
+	}, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :message-tags\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :message-tags\r\n"),
+		SendLine("CAP * ACK :message-tags\r\n"),
+		ExpectLine("CAP END\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		ExpectLine("@+typing=active TAGMSG #chan\r\n"),
+	})
+
+	assert.Equal(t, []error{nil}, errs)
+}
+
+func TestCoalesce(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{
+		CoalesceWindow: 200 * time.Millisecond,
+		CoalesceCount:  true,
+	})
+	c := ht.Client
+
+	for _, line := range []string{
+		"PRIVMSG #a :hi",
+		"PRIVMSG #a :hi",
+		"PRIVMSG #a :hi",
+		"PRIVMSG #b :hi",
+		"PRIVMSG #a :hi",
+		"JOIN #c",
+		"JOIN #c",
+		"PRIVMSG #a :bye",
+		"PRIVMSG #a :bye",
+	} {
+		assert.NoError(t, c.Write(line))
+	}
+
+	assert.NoError(t, ht.ExpectWrites(
+		"PRIVMSG #a :hi",
+		"PRIVMSG #b :hi",
+		"JOIN #c",
+		"JOIN #c",
+		"PRIVMSG #a :hi (x3)",
+		"PRIVMSG #a :bye",
+	))
+
+	// Once the window passes, the remaining repeats are summarized.
+	assert.Eventually(t, func() bool {
+		return ht.ExpectWrites("PRIVMSG #a :bye (x1)") == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Without CoalesceCount, repeats are simply dropped.
+	ht = irc.NewHandlerTester(nil, irc.ClientConfig{CoalesceWindow: time.Minute})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, ht.Client.Write("NOTICE #a :loop"))
+	}
+	assert.NoError(t, ht.ExpectWrites("NOTICE #a :loop"))
+}
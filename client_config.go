@@ -0,0 +1,140 @@
+package irc
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// MutableConfig is the subset of ClientConfig which can be safely changed
+// while a Client is running by using UpdateConfig. The fields have the same
+// meaning as their ClientConfig counterparts.
+type MutableConfig struct {
+	SendLimit time.Duration
+	SendBurst int
+
+	PingFrequency time.Duration
+	PingTimeout   time.Duration
+
+	RejectLongReasons bool
+}
+
+func (mc *MutableConfig) validate() error {
+	if mc.SendLimit < 0 {
+		return errors.New("irc: SendLimit must not be negative")
+	}
+
+	if mc.SendBurst < 0 {
+		return errors.New("irc: SendBurst must not be negative")
+	}
+
+	if mc.PingFrequency < 0 {
+		return errors.New("irc: PingFrequency must not be negative")
+	}
+
+	if mc.PingTimeout < 0 {
+		return errors.New("irc: PingTimeout must not be negative")
+	}
+
+	return nil
+}
+
+// UpdateConfig allows changing a subset of the ClientConfig without having to
+// reconnect. The update function will be called with the current values and
+// any changes it makes will be validated and applied once it returns. If
+// validation fails, an error will be returned and nothing will be changed.
+func (c *Client) UpdateConfig(update func(*MutableConfig)) error {
+	c.configLock.Lock()
+
+	mc := MutableConfig{
+		SendLimit:         c.config.SendLimit,
+		SendBurst:         c.config.SendBurst,
+		PingFrequency:     c.config.PingFrequency,
+		PingTimeout:       c.config.PingTimeout,
+		RejectLongReasons: c.config.RejectLongReasons,
+	}
+
+	update(&mc)
+
+	err := mc.validate()
+	if err != nil {
+		c.configLock.Unlock()
+		return err
+	}
+
+	pingChanged := mc.PingFrequency != c.config.PingFrequency
+
+	c.config.SendLimit = mc.SendLimit
+	c.config.SendBurst = mc.SendBurst
+	c.config.PingFrequency = mc.PingFrequency
+	c.config.PingTimeout = mc.PingTimeout
+	c.config.RejectLongReasons = mc.RejectLongReasons
+
+	c.configLock.Unlock()
+
+	c.updateLimiter()
+
+	// Let the ping loop know it needs to pick up the new frequency. If there's
+	// already a pending notification, it will pick up this change as well.
+	if pingChanged {
+		select {
+		case c.pingConfigChan <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// updateLimiter makes sure the rate limiter matches the current SendLimit and
+// SendBurst values in the config.
+func (c *Client) updateLimiter() {
+	c.configLock.Lock()
+	defer c.configLock.Unlock()
+
+	if c.config.SendLimit == 0 {
+		c.limiter = nil
+		return
+	}
+
+	burst := c.config.SendBurst
+	if burst == 0 {
+		burst = 1
+	}
+
+	if c.limiter == nil {
+		c.limiter = rate.NewLimiter(rate.Every(c.config.SendLimit), burst)
+		return
+	}
+
+	c.limiter.SetLimit(rate.Every(c.config.SendLimit))
+	c.limiter.SetBurst(burst)
+}
+
+// defaultRegistrationGrace is used when RegistrationGrace isn't set.
+const defaultRegistrationGrace = 10 * time.Second
+
+// startRegistrationBurst swaps in a limiter with a larger burst if a
+// RegistrationBurst is configured. The normal limits are restored once the
+// grace period is over. Note that calling UpdateConfig will also restore the
+// normal limits.
+func (c *Client) startRegistrationBurst() {
+	c.configLock.Lock()
+	defer c.configLock.Unlock()
+
+	if c.limiter == nil || c.config.RegistrationBurst <= c.config.SendBurst {
+		return
+	}
+
+	// A new limiter starts with a full bucket, which is what lets the burst
+	// happen immediately.
+	c.limiter = rate.NewLimiter(rate.Every(c.config.SendLimit), c.config.RegistrationBurst)
+
+	grace := c.config.RegistrationGrace
+	if grace <= 0 {
+		grace = defaultRegistrationGrace
+	}
+
+	time.AfterFunc(grace, c.updateLimiter)
+}
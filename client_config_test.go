@@ -0,0 +1,112 @@
+package irc_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	t.Parallel()
+
+	c := irc.NewClient(newNopCloser(&bytes.Buffer{}), irc.ClientConfig{Nick: "test_nick"})
+
+	// Invalid values should be rejected
+	assert.Error(t, c.UpdateConfig(func(mc *irc.MutableConfig) { mc.SendLimit = -1 }))
+	assert.Error(t, c.UpdateConfig(func(mc *irc.MutableConfig) { mc.SendBurst = -1 }))
+	assert.Error(t, c.UpdateConfig(func(mc *irc.MutableConfig) { mc.PingFrequency = -1 }))
+	assert.Error(t, c.UpdateConfig(func(mc *irc.MutableConfig) { mc.PingTimeout = -1 }))
+
+	// Failed updates shouldn't change anything
+	assert.NoError(t, c.UpdateConfig(func(mc *irc.MutableConfig) {
+		assert.Equal(t, irc.MutableConfig{}, *mc)
+	}))
+
+	assert.NoError(t, c.UpdateConfig(func(mc *irc.MutableConfig) {
+		mc.SendLimit = time.Second
+		mc.SendBurst = 5
+	}))
+	assert.NoError(t, c.UpdateConfig(func(mc *irc.MutableConfig) {
+		assert.Equal(t, irc.MutableConfig{SendLimit: time.Second, SendBurst: 5}, *mc)
+
+		// Turn off the limiter again
+		mc.SendLimit = 0
+	}))
+
+	// Enabling the ping loop at runtime should start sending pings.
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		Pass: "test_pass",
+		User: "test_user",
+		Name: "test_name",
+
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command != "001" {
+				return
+			}
+
+			err := c.UpdateConfig(func(mc *irc.MutableConfig) {
+				mc.PingFrequency = 10 * time.Millisecond
+				mc.PingTimeout = time.Second
+			})
+			assert.NoError(t, err)
+		}),
+	}
+
+	var lastPing *irc.Message
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 :hello_world\r\n"),
+		LineFunc(func(m *irc.Message) {
+			lastPing = m
+		}),
+	})
+
+	if assert.NotNil(t, lastPing) {
+		assert.Equal(t, "PING", lastPing.Command)
+	}
+}
+
+func TestRegistrationBurst(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		SendLimit:         time.Second,
+		SendBurst:         2,
+		RegistrationBurst: 4,
+
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command != "001" {
+				return
+			}
+
+			for _, channel := range []string{"#a", "#b", "#c", "#d"} {
+				_ = c.Writef("JOIN %s", channel)
+			}
+		}),
+	}
+
+	// Without the registration burst, each JOIN after the first would be
+	// delayed by a full second.
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		ExpectLineWithTimeout("JOIN #a\r\n", 500*time.Millisecond),
+		ExpectLineWithTimeout("JOIN #b\r\n", 500*time.Millisecond),
+		ExpectLineWithTimeout("JOIN #c\r\n", 500*time.Millisecond),
+		ExpectLineWithTimeout("JOIN #d\r\n", 500*time.Millisecond),
+	})
+}
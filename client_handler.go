@@ -1,5 +1,7 @@
 package irc
 
+import "context"
+
 // Handler is a simple interface meant for dispatching a message from
 // a Client connection.
 type Handler interface {
@@ -14,3 +16,159 @@ type HandlerFunc func(*Client, *Message)
 func (f HandlerFunc) Handle(c *Client, m *Message) {
 	f(c, m)
 }
+
+// ContextHandler is like Handler, but is also given a context. The context is
+// the one passed to RunContext, or Connect during registration, and it is
+// canceled when Run returns, so handlers making HTTP requests or database
+// queries can stop when the client disconnects. Use WithContext to pass one as
+// a Handler.
+type ContextHandler interface {
+	HandleContext(context.Context, *Client, *Message)
+}
+
+// ContextHandlerFunc is a simple wrapper around a function which allows it to
+// be used as a ContextHandler or a Handler.
+type ContextHandlerFunc func(context.Context, *Client, *Message)
+
+// HandleContext calls f(ctx, c, m).
+func (f ContextHandlerFunc) HandleContext(ctx context.Context, c *Client, m *Message) {
+	f(ctx, c, m)
+}
+
+// Handle calls f with the Client's Context.
+func (f ContextHandlerFunc) Handle(c *Client, m *Message) {
+	f(c.Context(), c, m)
+}
+
+// WithContext adapts a ContextHandler to a Handler, passing it the Client's
+// Context.
+func WithContext(h ContextHandler) Handler {
+	return HandlerFunc(func(c *Client, m *Message) {
+		h.HandleContext(c.Context(), c, m)
+	})
+}
+
+// Context returns the context for the current connection. It is derived from
+// the one passed to RunContext, or Connect during registration, and is
+// canceled once Run returns. If neither has been called, it is derived from
+// context.Background. It always carries the Client, which can be retrieved
+// with ClientFromContext or NetworkFromContext.
+func (c *Client) Context() context.Context {
+	c.ctxLock.Lock()
+	defer c.ctxLock.Unlock()
+
+	if c.ctx == nil {
+		return context.WithValue(context.Background(), clientContextKey{}, c)
+	}
+
+	return c.ctx
+}
+
+func (c *Client) setContext(ctx context.Context) {
+	c.ctxLock.Lock()
+	defer c.ctxLock.Unlock()
+
+	c.ctx = context.WithValue(ctx, clientContextKey{}, c)
+}
+
+// clientContextKey is the context key for the Client a context belongs to.
+type clientContextKey struct{}
+
+// ClientFromContext returns the Client a context from Client.Context belongs
+// to, such as the one given to a ContextHandler. This lets code shared between
+// several Clients tell them apart without being passed the Client directly.
+func ClientFromContext(ctx context.Context) (*Client, bool) {
+	c, ok := ctx.Value(clientContextKey{}).(*Client)
+	return c, ok
+}
+
+// NetworkFromContext returns the NetworkName of the Client a context belongs
+// to, so handlers shared between networks can keep their state per network.
+// The bool will be false if the context doesn't belong to a Client or the
+// network name isn't known yet.
+func NetworkFromContext(ctx context.Context) (string, bool) {
+	c, ok := ClientFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	name := c.NetworkName()
+	return name, name != ""
+}
+
+// AddHandler adds a Handler which will be given every message after the
+// Handler and Handlers from the ClientConfig, and any added before it. It is
+// safe to call while the Client is running.
+func (c *Client) AddHandler(h Handler) {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
+	// The slice is copied so handleAll can keep using the old one without
+	// holding the lock.
+	handlers := make([]Handler, len(c.handlers), len(c.handlers)+1)
+	copy(handlers, c.handlers)
+	c.handlers = append(handlers, h)
+}
+
+func (c *Client) getHandlers() []Handler {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
+	return c.handlers
+}
+
+// handleAll passes a message to every handler in order.
+func (c *Client) handleAll(cl *Client, m *Message) {
+	for _, h := range c.getHandlers() {
+		h.Handle(cl, m)
+	}
+}
+
+// handleUnbatched passes a message which is part of a batch to every handler
+// which isn't a BatchHandler.
+func (c *Client) handleUnbatched(cl *Client, m *Message) {
+	for _, h := range c.getHandlers() {
+		if _, ok := h.(BatchHandler); !ok {
+			h.Handle(cl, m)
+		}
+	}
+}
+
+// batchHandlers returns every handler which is a BatchHandler.
+func (c *Client) batchHandlers() []BatchHandler {
+	var ret []BatchHandler
+	for _, h := range c.getHandlers() {
+		if bh, ok := h.(BatchHandler); ok {
+			ret = append(ret, bh)
+		}
+	}
+
+	return ret
+}
+
+// wrapHandler applies the middleware to a handler, with the first middleware
+// being the outermost.
+func wrapHandler(handler Handler, middleware []func(Handler) Handler) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// StateTracker is implemented by types which keep track of connection state
+// by watching incoming messages, such as the ISupportTracker and Tracker.
+// The built-in trackers all live in this package, but any other type which
+// implements this interface can be given to a Client with
+// ClientConfig.StateTrackers.
+type StateTracker interface {
+	Handle(*Message) error
+}
+
+var (
+	_ StateTracker = (*ISupportTracker)(nil)
+	_ StateTracker = (*Tracker)(nil)
+	_ StateTracker = (*HistoryBuffer)(nil)
+	_ StateTracker = (*Monitor)(nil)
+	_ StateTracker = (*Collector)(nil)
+)
@@ -19,3 +19,95 @@ func TestHandlerFunc(t *testing.T) {
 	f.Handle(nil, nil)
 	assert.True(t, hit, "HandlerFunc doesn't work correctly as Handler")
 }
+
+type testStateTracker struct {
+	commands []string
+}
+
+func (st *testStateTracker) Handle(m *irc.Message) error {
+	st.commands = append(st.commands, m.Command)
+	return nil
+}
+
+func TestStateTrackers(t *testing.T) {
+	t.Parallel()
+
+	tracker := &testStateTracker{}
+
+	var seen []string
+	ht := irc.NewHandlerTester(irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+		// State trackers should have already seen the message
+		seen = append(seen, tracker.commands[len(tracker.commands)-1])
+	}), irc.ClientConfig{StateTrackers: []irc.StateTracker{tracker}})
+
+	assert.NoError(t, ht.Feed("001 test_nick :Welcome", "PING :hello"))
+	assert.Equal(t, []string{"001", "PING"}, tracker.commands)
+	assert.Equal(t, []string{"001", "PING"}, seen)
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+
+	record := func(name string) func(irc.Handler) irc.Handler {
+		return func(next irc.Handler) irc.Handler {
+			return irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+				calls = append(calls, name+" "+m.Command)
+				next.Handle(c, m)
+			})
+		}
+	}
+
+	ignorePings := func(next irc.Handler) irc.Handler {
+		return irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command != "PING" {
+				next.Handle(c, m)
+			}
+		})
+	}
+
+	ht := irc.NewHandlerTester(irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+		calls = append(calls, "handler "+m.Command)
+	}), irc.ClientConfig{Middleware: []func(irc.Handler) irc.Handler{record("outer"), ignorePings, record("inner")}})
+
+	assert.NoError(t, ht.Feed("001 test_nick :Welcome", "PING :hello"))
+	assert.Equal(t, []string{
+		"outer 001",
+		"inner 001",
+		"handler 001",
+		"outer PING",
+	}, calls)
+
+	// Middleware still sees messages when there is no Handler.
+	calls = nil
+
+	ht = irc.NewHandlerTester(nil, irc.ClientConfig{Middleware: []func(irc.Handler) irc.Handler{record("outer")}})
+	assert.NoError(t, ht.Feed("001 test_nick :Welcome"))
+	assert.Equal(t, []string{"outer 001"}, calls)
+}
+
+func TestMultipleHandlers(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+
+	record := func(name string) irc.Handler {
+		return irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			calls = append(calls, name+" "+m.Command)
+		})
+	}
+
+	ht := irc.NewHandlerTester(record("handler"), irc.ClientConfig{
+		Handlers: []irc.Handler{record("first"), record("second")},
+	})
+	ht.Client.AddHandler(record("added"))
+
+	assert.NoError(t, ht.Feed("PING :hello"))
+	assert.Equal(t, []string{
+		"handler PING",
+		"first PING",
+		"second PING",
+		"added PING",
+	}, calls)
+}
@@ -2,6 +2,7 @@ package irc
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -11,13 +12,127 @@ type clientFilter func(*Client, *Message)
 // types. These were moved from below to keep the complexity of each
 // component down.
 var clientFilters = map[string]clientFilter{
-	"001":  handle001,
-	"433":  handle433,
-	"437":  handle437,
-	"PING": handlePing,
-	"PONG": handlePong,
-	"NICK": handleNick,
-	"CAP":  handleCap,
+	"001":     handle001,
+	"005":     handle005,
+	"433":     handle433,
+	"437":     handle437,
+	"464":     handleRegistrationError,
+	"465":     handleRegistrationError,
+	"PING":    handlePing,
+	"PONG":    handlePong,
+	"NICK":    handleNick,
+	"QUIT":    handleNickFreed,
+	"JOIN":    handleJoin,
+	"MODE":    handleMode,
+	"221":     handle221,
+	"302":     handle302,
+	"396":     handle396,
+	"353":     handle353,
+	"366":     handle366,
+	"403":     handleJoinError,
+	"405":     handleJoinError,
+	"471":     handleJoinError,
+	"473":     handleJoinError,
+	"474":     handleJoinError,
+	"475":     handleJoinError,
+	"CAP":     handleCap,
+	"ERROR":   handleError,
+	"KILL":    handleKill,
+	"NOTICE":  handleNotice,
+	"PRIVMSG": handleCTCPQuery,
+	"FAIL":    handleFail,
+	"REDACT":  handleRedact,
+	"RESUME":  handleResume,
+
+	"AUTHENTICATE": handleAuthenticate,
+	"900":          handle900,
+	"903":          handleSASLSuccess,
+	"904":          handleSASLFailure,
+	"905":          handleSASLFailure,
+	"908":          handleSASLMechs,
+}
+
+// handleMode keeps track of our own user modes.
+func handleMode(c *Client, m *Message) {
+	if len(m.Params) < 2 || c.foldNick(m.Params[0]) != c.foldNick(c.currentNick) {
+		return
+	}
+
+	c.userModes = applyUserModes(c.userModes, m.Params[1])
+}
+
+// From rfc2812 section 5.1 (Command responses)
+//
+//	221    RPL_UMODEIS
+//	"<user mode string>"
+func handle221(c *Client, m *Message) {
+	if len(m.Params) < 2 {
+		return
+	}
+
+	c.userModes = applyUserModes("", m.Params[1])
+}
+
+// applyUserModes applies a mode string like "+iw-x" to a set of modes and
+// returns the sorted result.
+func applyUserModes(modes, changes string) string {
+	set := make(map[rune]bool)
+	for _, mode := range modes {
+		set[mode] = true
+	}
+
+	adding := true
+	for _, mode := range changes {
+		switch mode {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		default:
+			if adding {
+				set[mode] = true
+			} else {
+				delete(set, mode)
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(set))
+	for mode := range set {
+		ret = append(ret, string(mode))
+	}
+	sort.Strings(ret)
+
+	return strings.Join(ret, "")
+}
+
+// handleJoin records our own user and host from our JOINs, and asks for the
+// modes and users of channels we join so the Tracker knows about them, if
+// TrackChannelModes or WhoOnJoin are set.
+func handleJoin(c *Client, m *Message) {
+	if len(m.Params) < 1 || m.Prefix.Name != c.currentNick {
+		return
+	}
+
+	c.updateCurrentPrefix(m.Prefix)
+
+	if c.Tracker == nil {
+		return
+	}
+
+	channel := m.Params[0]
+
+	if c.config.TrackChannelModes {
+		_ = c.Writef("MODE %s", channel)
+	}
+
+	if c.config.WhoOnJoin {
+		if c.ISupport.IsEnabled("WHOX") {
+			_ = c.Writef("WHO %s %s", channel, whoxFields)
+		} else {
+			_ = c.Writef("WHO %s", channel)
+		}
+	}
 }
 
 // From rfc2812 section 5.1 (Command responses)
@@ -27,7 +142,90 @@ var clientFilters = map[string]clientFilter{
 //	<nick>!<user>@<host>"
 func handle001(c *Client, m *Message) {
 	c.currentNick = m.Params[0]
+	if !c.connected {
+		close(c.welcomeChan)
+	}
+
+	c.stateLock.Lock()
 	c.connected = true
+	c.stateLock.Unlock()
+
+	c.startRegistrationBurst()
+
+	// Many servers end the welcome message with our full nick!user@host.
+	if fields := strings.Fields(m.Trailing()); len(fields) > 0 {
+		c.updateCurrentPrefix(ParsePrefix(fields[len(fields)-1]))
+	}
+
+	c.emitConnectionEvent(ConnectionEvent{Type: ConnectionRegistered, Client: c}) //nolint:exhaustruct
+}
+
+// updateCurrentPrefix records our user and host if the prefix is for us and
+// has both of them.
+func (c *Client) updateCurrentPrefix(p *Prefix) {
+	if p == nil || p.Name != c.currentNick || p.User == "" || p.Host == "" {
+		return
+	}
+
+	c.currentUser = p.User
+	c.currentHost = p.Host
+}
+
+// From rfc1459 section 6.2 (Command responses)
+//
+//	302    RPL_USERHOST
+//
+// Replies are in the form nick[*]=[+-]user@host, so if we're in one, we can
+// learn our own user and host.
+func handle302(c *Client, m *Message) {
+	for _, reply := range strings.Fields(m.Trailing()) {
+		parts := strings.SplitN(reply, "=", 2)
+		if len(parts) != 2 || len(parts[1]) < 1 {
+			continue
+		}
+
+		nick := strings.TrimSuffix(parts[0], "*")
+		c.updateCurrentPrefix(ParsePrefix(nick + "!" + parts[1][1:]))
+	}
+}
+
+// RPL_VISIBLEHOST (396) is sent when our displayed host changes, such as
+// when a cloak is applied. Some servers send user@host rather than just the
+// host.
+func handle396(c *Client, m *Message) {
+	if len(m.Params) < 2 || m.Params[0] != c.currentNick {
+		return
+	}
+
+	host := m.Params[1]
+	if i := strings.IndexByte(host, '@'); i != -1 {
+		c.currentUser = host[:i]
+		host = host[i+1:]
+	}
+
+	c.currentHost = host
+}
+
+// From http://www.irc.org/tech_docs/draft-brocklesby-irc-isupport-03.txt
+//
+//	005    RPL_ISUPPORT
+//
+// If the client is configured as a bot, we look for the BOT token so we know
+// which user mode to set on ourselves.
+func handle005(c *Client, m *Message) {
+	if !c.config.Bot || c.botModeSet || len(m.Params) < 2 {
+		return
+	}
+
+	for _, param := range m.Params[1 : len(m.Params)-1] {
+		if !strings.HasPrefix(param, "BOT=") || len(param) <= len("BOT=") {
+			continue
+		}
+
+		c.botModeSet = true
+		_ = c.Writef("MODE %s +%s", c.currentNick, param[len("BOT="):])
+		return
+	}
 }
 
 // From rfc2812 section 5.2 (Error Replies)
@@ -39,13 +237,7 @@ func handle001(c *Client, m *Message) {
 //	  in an attempt to change to a currently existing
 //	  nickname.
 func handle433(c *Client, m *Message) {
-	// We only want to try and handle nick collisions during the initial
-	// handshake.
-	if c.connected {
-		return
-	}
-	c.currentNick += "_"
-	_ = c.Writef("NICK :%s", c.currentNick)
+	c.handleNickCollision()
 }
 
 // From rfc2812 section 5.2 (Error Replies)
@@ -60,13 +252,63 @@ func handle433(c *Client, m *Message) {
 //	  when the desired nickname is blocked by the nick delay
 //	  mechanism.
 func handle437(c *Client, m *Message) {
-	// We only want to try and handle nick collisions during the initial
-	// handshake.
-	if c.connected {
+	c.handleNickCollision()
+}
+
+func handleError(c *Client, m *Message) {
+	c.sendError(ParseServerError(m))
+}
+
+// From rfc2812 section 5.2 (Error Replies)
+//
+//	464    ERR_PASSWDMISMATCH
+//	       ":Password incorrect"
+//
+//	465    ERR_YOUREBANNEDCREEP
+//	       ":You are banned from this server"
+//
+// The server will close the connection after either of these, so we return
+// them from Run rather than the read error which follows.
+func handleRegistrationError(c *Client, m *Message) {
+	c.sendError(&RegistrationError{
+		Numeric: m.Command,
+		Text:    m.Trailing(),
+	})
+}
+
+func handleKill(c *Client, m *Message) {
+	if len(m.Params) < 1 || m.Params[0] != c.currentNick {
+		return
+	}
+
+	c.sendError(&KillError{
+		Killer: m.Prefix.Copy(),
+		Reason: m.Param(1),
+	})
+}
+
+func handleNotice(c *Client, m *Message) {
+	// Gates are only relevant before registration is complete.
+	if c.connected || len(c.config.NoticeGates) == 0 {
 		return
 	}
-	c.currentNick += "_"
-	_ = c.Writef("NICK :%s", c.currentNick)
+
+	gate, wait, ok := matchNoticeGate(c.config.NoticeGates, m.Trailing())
+	if !ok {
+		return
+	}
+
+	if c.config.OnNoticeGate != nil {
+		c.config.OnNoticeGate(*gate, wait, m)
+	}
+
+	if gate.Fatal {
+		c.sendError(&NoticeGateError{
+			Gate: gate.Name,
+			Wait: wait,
+			Text: m.Trailing(),
+		})
+	}
 }
 
 func handlePing(c *Client, m *Message) {
@@ -90,7 +332,10 @@ func handlePong(c *Client, m *Message) {
 func handleNick(c *Client, m *Message) {
 	if m.Prefix.Name == c.currentNick && len(m.Params) > 0 {
 		c.currentNick = m.Params[0]
+		return
 	}
+
+	handleNickFreed(c, m)
 }
 
 var capFilters = map[string]clientFilter{
@@ -99,8 +344,23 @@ var capFilters = map[string]clientFilter{
 	"NAK": handleCapNak,
 }
 
+// capNotifyFilters handle CAP messages which arrive after the handshake is
+// done.
+var capNotifyFilters = map[string]clientFilter{
+	"NEW": handleCapNew,
+	"DEL": handleCapDel,
+	"ACK": handleCapNotifyAck,
+}
+
 func handleCap(c *Client, m *Message) {
-	if c.remainingCapResponses <= 0 || len(m.Params) <= 2 {
+	if len(m.Params) <= 2 {
+		return
+	}
+
+	if c.remainingCapResponses <= 0 {
+		if filter, ok := capNotifyFilters[m.Params[1]]; ok {
+			filter(c, m)
+		}
 		return
 	}
 
@@ -109,11 +369,15 @@ func handleCap(c *Client, m *Message) {
 	}
 
 	if c.remainingCapResponses <= 0 {
-		for key, capStatus := range c.caps {
-			if capStatus.Required && !capStatus.Enabled {
-				c.sendError(fmt.Errorf("CAP %s requested but not accepted", key))
-				return
-			}
+		if key, ok := c.missingRequiredCap(); ok {
+			c.sendError(fmt.Errorf("CAP %s requested but not accepted", key))
+			return
+		}
+
+		// If we're resuming a session or need to authenticate, CAP END will
+		// be sent once that is done.
+		if c.maybeStartResume() || c.maybeStartSASL() {
+			return
 		}
 
 		_ = c.Write("CAP END")
@@ -122,18 +386,39 @@ func handleCap(c *Client, m *Message) {
 
 func handleCapLs(c *Client, m *Message) {
 	for _, key := range strings.Split(m.Trailing(), " ") {
-		capStatus := c.caps[key]
-		capStatus.Available = true
-		c.caps[key] = capStatus
+		if key == "" {
+			continue
+		}
+
+		var value string
+		if i := strings.IndexByte(key, '='); i != -1 {
+			key, value = key[:i], key[i+1:]
+		}
+
+		c.updateCap(key, func(status *capStatus) {
+			status.Available = true
+			status.Value = value
+		})
+
+		if key == stsCap {
+			c.handleSTS(value)
+		}
 	}
+
+	// With CAP LS 302, a * before the list of caps means there are more
+	// lines to come, so we only count the final line as the response.
+	if len(m.Params) > 3 && m.Params[2] == "*" {
+		return
+	}
+
 	c.remainingCapResponses--
 }
 
 func handleCapAck(c *Client, m *Message) {
 	for _, key := range strings.Split(m.Trailing(), " ") {
-		capStatus := c.caps[key]
-		capStatus.Enabled = true
-		c.caps[key] = capStatus
+		c.updateCap(key, func(status *capStatus) {
+			status.Enabled = true
+		})
 	}
 	c.remainingCapResponses--
 }
@@ -142,10 +427,96 @@ func handleCapNak(c *Client, m *Message) {
 	// If we got a NAK and this REQ was required, we need to bail
 	// with an error.
 	for _, key := range strings.Split(m.Trailing(), " ") {
-		if c.caps[key].Required {
+		if c.getCap(key).Required {
 			c.sendError(fmt.Errorf("CAP %s requested but was rejected", key))
 			return
 		}
 	}
 	c.remainingCapResponses--
 }
+
+// From https://ircv3.net/specs/extensions/capability-negotiation.html#cap-notify
+//
+// Caps which appear with CAP NEW are re-requested if they were requested
+// during the handshake but weren't available.
+func handleCapNew(c *Client, m *Message) {
+	var added []string
+
+	for _, key := range strings.Split(m.Trailing(), " ") {
+		if key == "" {
+			continue
+		}
+
+		var value string
+		if i := strings.IndexByte(key, '='); i != -1 {
+			key, value = key[:i], key[i+1:]
+		}
+
+		status := c.updateCap(key, func(status *capStatus) {
+			status.Available = true
+			status.Value = value
+		})
+
+		added = append(added, key)
+
+		if key == stsCap {
+			c.handleSTS(value)
+		}
+
+		if status.Requested && !status.Enabled {
+			_ = c.Writef("CAP REQ :%s", key)
+		}
+	}
+
+	if c.config.OnCapChange != nil && len(added) > 0 {
+		c.config.OnCapChange(added, nil)
+	}
+}
+
+func handleCapDel(c *Client, m *Message) {
+	var removed []string
+
+	for _, key := range strings.Split(m.Trailing(), " ") {
+		if key == "" {
+			continue
+		}
+
+		c.updateCap(key, func(status *capStatus) {
+			status.Available = false
+			status.Enabled = false
+			status.Value = ""
+		})
+
+		removed = append(removed, key)
+	}
+
+	if c.config.OnCapChange != nil && len(removed) > 0 {
+		c.config.OnCapChange(nil, removed)
+	}
+}
+
+// Caps in an ACK with a - prefix were disabled rather than enabled.
+func handleCapNotifyAck(c *Client, m *Message) {
+	for _, key := range strings.Split(m.Trailing(), " ") {
+		if key == "" {
+			continue
+		}
+
+		enabled := !strings.HasPrefix(key, "-")
+		c.updateCap(strings.TrimPrefix(key, "-"), func(status *capStatus) {
+			status.Enabled = enabled
+		})
+	}
+}
+
+// From https://ircv3.net/specs/extensions/standard-replies
+//
+// FAIL messages are passed on to whichever feature sent the failed command.
+func handleFail(c *Client, m *Message) {
+	switch m.Param(0) {
+	case "CHATHISTORY":
+		handleHistoryFail(c, m)
+	case "RESUME":
+		handleResumeFail(c, m)
+	}
+}
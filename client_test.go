@@ -3,13 +3,18 @@ package irc_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"gopkg.in/irc.v4"
 )
@@ -49,7 +54,7 @@ func TestCapReq(t *testing.T) {
 		c.CapRequest("multi-prefix", true)
 	}, []TestAction{
 		ExpectLine("PASS :test_pass\r\n"),
-		ExpectLine("CAP LS\r\n"),
+		ExpectLine("CAP LS 302\r\n"),
 		ExpectLine("CAP REQ :multi-prefix\r\n"),
 		ExpectLine("NICK :test_nick\r\n"),
 		ExpectLine("USER test_user 0 * :test_name\r\n"),
@@ -69,7 +74,7 @@ func TestCapReq(t *testing.T) {
 		c.CapRequest("multi-prefix", true)
 	}, []TestAction{
 		ExpectLine("PASS :test_pass\r\n"),
-		ExpectLine("CAP LS\r\n"),
+		ExpectLine("CAP LS 302\r\n"),
 		ExpectLine("CAP REQ :multi-prefix\r\n"),
 		ExpectLine("NICK :test_nick\r\n"),
 		ExpectLine("USER test_user 0 * :test_name\r\n"),
@@ -97,7 +102,7 @@ func TestCapReq(t *testing.T) {
 		c.CapRequest("multi-prefix", true)
 	}, []TestAction{
 		ExpectLine("PASS :test_pass\r\n"),
-		ExpectLine("CAP LS\r\n"),
+		ExpectLine("CAP LS 302\r\n"),
 		ExpectLine("CAP REQ :multi-prefix\r\n"),
 		ExpectLine("NICK :test_nick\r\n"),
 		ExpectLine("USER test_user 0 * :test_name\r\n"),
@@ -117,7 +122,7 @@ func TestCapReq(t *testing.T) {
 		c.CapRequest("multi-prefix", false)
 	}, []TestAction{
 		ExpectLine("PASS :test_pass\r\n"),
-		ExpectLine("CAP LS\r\n"),
+		ExpectLine("CAP LS 302\r\n"),
 		ExpectLine("CAP REQ :multi-prefix\r\n"),
 		ExpectLine("NICK :test_nick\r\n"),
 		ExpectLine("USER test_user 0 * :test_name\r\n"),
@@ -136,7 +141,7 @@ func TestCapReq(t *testing.T) {
 		c.CapRequest("multi-prefix", true)
 	}, []TestAction{
 		ExpectLine("PASS :test_pass\r\n"),
-		ExpectLine("CAP LS\r\n"),
+		ExpectLine("CAP LS 302\r\n"),
 		ExpectLine("CAP REQ :multi-prefix\r\n"),
 		ExpectLine("NICK :test_nick\r\n"),
 		ExpectLine("USER test_user 0 * :test_name\r\n"),
@@ -154,7 +159,7 @@ func TestCapReq(t *testing.T) {
 		c.CapRequest("multi-prefix", true)
 	}, []TestAction{
 		ExpectLine("PASS :test_pass\r\n"),
-		ExpectLine("CAP LS\r\n"),
+		ExpectLine("CAP LS 302\r\n"),
 		ExpectLine("CAP REQ :multi-prefix\r\n"),
 		ExpectLine("NICK :test_nick\r\n"),
 		ExpectLine("USER test_user 0 * :test_name\r\n"),
@@ -248,6 +253,77 @@ func TestClient(t *testing.T) {
 	assert.Equal(t, "test_nick_", c.CurrentNick())
 }
 
+func TestAltNicks(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick:     "test_nick",
+		User:     "test_user",
+		Name:     "test_name",
+		AltNicks: []string{"alt_nick"},
+		NickTransform: func(nick string) string {
+			return nick + "`"
+		},
+	}
+
+	c := runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("433\r\n"),
+		ExpectLine("NICK :alt_nick\r\n"),
+		SendLine("437\r\n"),
+		ExpectLine("NICK :alt_nick`\r\n"),
+		SendLine("433\r\n"),
+		ExpectLine("NICK :alt_nick``\r\n"),
+	})
+	assert.Equal(t, "alt_nick``", c.CurrentNick())
+}
+
+func TestRegainNick(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick:           "test_nick",
+		User:           "test_user",
+		Name:           "test_name",
+		RegainInterval: time.Hour,
+	}
+
+	// The first attempt happens right after registration, and another is made
+	// as soon as the nick is freed.
+	c := runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("433\r\n"),
+		ExpectLine("NICK :test_nick_\r\n"),
+		SendLine("001 test_nick_ :Welcome\r\n"),
+		SendLine("PING :hello\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("PONG hello\r\n"),
+		SendLine("433 test_nick_ test_nick :Nickname is already in use\r\n"),
+		SendLine(":Test_Nick!u@h QUIT :bye\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		SendLine(":test_nick_!u@h NICK :test_nick\r\n"),
+	})
+	assert.Equal(t, "test_nick", c.CurrentNick())
+
+	// RegainFunc replaces the NICK.
+	config.RegainFunc = func(c *irc.Client, nick string) error {
+		return c.Writef("PRIVMSG NickServ :REGAIN %s", nick)
+	}
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("433\r\n"),
+		ExpectLine("NICK :test_nick_\r\n"),
+		SendLine("001 test_nick_ :Welcome\r\n"),
+		SendLine("PING :hello\r\n"),
+		ExpectLine("PRIVMSG NickServ :REGAIN test_nick\r\n"),
+		ExpectLine("PONG hello\r\n"),
+	})
+}
+
 func TestSendLimit(t *testing.T) {
 	t.Parallel()
 
@@ -335,6 +411,51 @@ func TestFromChannel(t *testing.T) {
 	assert.False(t, c.FromChannel(m))
 }
 
+func TestLag(t *testing.T) {
+	t.Parallel()
+
+	pongs := make(chan time.Duration, 1)
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		PingFrequency: 20 * time.Millisecond,
+		PingTimeout:   50 * time.Millisecond,
+		OnPong: func(lag time.Duration) {
+			pongs <- lag
+		},
+	}
+
+	var lastPing *irc.Message
+
+	c := runClientTest(t, config, io.EOF, func(c *irc.Client) {
+		assert.Equal(t, time.Duration(0), c.Lag())
+	}, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 :hello_world\r\n"),
+		Delay(20 * time.Millisecond),
+		LineFunc(func(m *irc.Message) {
+			lastPing = m
+		}),
+		Delay(5 * time.Millisecond),
+		SendFunc(func() string {
+			return fmt.Sprintf("PONG :%s\r\n", lastPing.Trailing())
+		}),
+		Delay(10 * time.Millisecond),
+	})
+
+	select {
+	case lag := <-pongs:
+		assert.GreaterOrEqual(t, lag, 5*time.Millisecond)
+		assert.Equal(t, lag, c.Lag())
+	case <-time.After(time.Second):
+		assert.Fail(t, "Timeout waiting for OnPong")
+	}
+}
+
 func TestPingLoop(t *testing.T) {
 	t.Parallel()
 
@@ -428,3 +549,901 @@ func TestPingLoop(t *testing.T) {
 		AssertClosed(),
 	})
 }
+
+func TestBotMode(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		Pass: "test_pass",
+		User: "test_user",
+		Name: "test_name",
+
+		Bot: true,
+	}
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :hello_world\r\n"),
+		SendLine("005 test_nick NETWORK=test :are supported by this server\r\n"),
+		SendLine("005 test_nick BOT=B :are supported by this server\r\n"),
+		ExpectLine("MODE test_nick +B\r\n"),
+		SendLine("005 test_nick BOT=B :are supported by this server\r\n"),
+		SendLine("PING :done\r\n"),
+		ExpectLine("PONG done\r\n"),
+	})
+
+	// Without the config option, nothing should be sent.
+	config.Bot = false
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :hello_world\r\n"),
+		SendLine("005 test_nick BOT=B :are supported by this server\r\n"),
+		SendLine("PING :done\r\n"),
+		ExpectLine("PONG done\r\n"),
+	})
+}
+
+func TestWireInspector(t *testing.T) {
+	t.Parallel()
+
+	blocked := errors.New("blocked")
+
+	var inspected []string
+	buf := &bytes.Buffer{}
+	c := irc.NewClient(newNopCloser(buf), irc.ClientConfig{
+		Nick: "test_nick",
+		WireInspector: func(line []byte) error {
+			inspected = append(inspected, string(line))
+			if bytes.Contains(line, []byte("secret")) {
+				return blocked
+			}
+			return nil
+		},
+	})
+
+	assert.NoError(t, c.Write("PRIVMSG #a :hello"))
+	assert.Equal(t, blocked, c.Write("PRIVMSG #a :secret"))
+	assert.Equal(t, "PRIVMSG #a :hello\r\n", buf.String())
+	assert.Equal(t, []string{
+		"PRIVMSG #a :hello\r\n",
+		"PRIVMSG #a :secret\r\n",
+	}, inspected)
+}
+
+func TestNetworkName(t *testing.T) {
+	t.Parallel()
+
+	c := irc.NewClient(newNopCloser(&bytes.Buffer{}), irc.ClientConfig{Nick: "test_nick"})
+	assert.Equal(t, "", c.NetworkName())
+
+	c = irc.NewClient(newNopCloser(&bytes.Buffer{}), irc.ClientConfig{Nick: "test_nick", EnableISupport: true})
+	assert.Equal(t, "", c.NetworkName())
+
+	err := c.ISupport.Handle(irc.MustParseMessage("005 test_nick NETWORK=Libera.Chat :are supported by this server"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Libera.Chat", c.NetworkName())
+
+	c = irc.NewClient(newNopCloser(&bytes.Buffer{}), irc.ClientConfig{
+		Nick:           "test_nick",
+		NetworkName:    "libera",
+		EnableISupport: true,
+	})
+	err = c.ISupport.Handle(irc.MustParseMessage("005 test_nick NETWORK=Libera.Chat :are supported by this server"))
+	assert.NoError(t, err)
+	assert.Equal(t, "libera", c.NetworkName())
+}
+
+func TestMaxParseErrors(t *testing.T) {
+	t.Parallel()
+
+	handler := &TestHandler{}
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		Pass: "test_pass",
+		User: "test_user",
+		Name: "test_name",
+
+		Handler: handler,
+	}
+
+	// By default, a malformed line is fatal.
+	runClientTest(t, config, irc.ErrMissingDataAfterPrefix, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine(":invalid_message\r\n"),
+	})
+	handler.Messages()
+
+	var parseErrors []error
+	config.MaxParseErrors = 2
+	config.ParseErrorHandler = func(err error) {
+		parseErrors = append(parseErrors, err)
+	}
+
+	runClientTest(t, config, irc.ErrMissingCommand, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine(":invalid_message\r\n"),
+		SendLine("001 :hello_world\r\n"),
+		SendLine("@tags\r\n"),
+		SendLine(":invalid_message\r\n"),
+		SendLine(":prefix  \r\n"),
+	})
+
+	assert.Equal(t, []error{
+		irc.ErrMissingDataAfterPrefix,
+		irc.ErrMissingDataAfterTags,
+		irc.ErrMissingDataAfterPrefix,
+	}, parseErrors)
+
+	messages := handler.Messages()
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, "001", messages[0].Command)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		Pass: "test_pass",
+		User: "test_user",
+		Name: "test_name",
+	}
+
+	runClientTest(t, config, &irc.ServerError{
+		Text:   "Closing Link: test_nick[127.0.0.1] (Z-Lined)",
+		Host:   "test_nick[127.0.0.1]",
+		Reason: "Z-Lined",
+		Banned: true,
+	}, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("ERROR :Closing Link: test_nick[127.0.0.1] (Z-Lined)\r\n"),
+	})
+
+	runClientTest(t, config, &irc.KillError{
+		Killer: &irc.Prefix{Name: "an_oper", User: "oper", Host: "host"},
+		Reason: "go away",
+	}, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine(":an_oper!oper@host KILL other_nick :not you\r\n"),
+		SendLine(":an_oper!oper@host KILL test_nick :go away\r\n"),
+	})
+
+	runClientTest(t, config, &irc.RegistrationError{
+		Numeric: "464",
+		Text:    "Password incorrect",
+	}, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("464 * :Password incorrect\r\n"),
+	})
+
+	runClientTest(t, config, &irc.RegistrationError{
+		Numeric: "465",
+		Text:    "You are banned from this server",
+	}, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("465 * :You are banned from this server\r\n"),
+	})
+}
+
+func TestNoticeGates(t *testing.T) {
+	t.Parallel()
+
+	var gates []string
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		Pass: "test_pass",
+		User: "test_user",
+		Name: "test_name",
+
+		NoticeGates: irc.DefaultNoticeGates,
+		OnNoticeGate: func(gate irc.NoticeGate, wait time.Duration, m *irc.Message) {
+			gates = append(gates, fmt.Sprintf("%s %s", gate.Name, wait))
+		},
+	}
+
+	runClientTest(t, config, &irc.NoticeGateError{
+		Gate: "throttle",
+		Wait: 30 * time.Second,
+		Text: "Please wait 30 seconds before reconnecting.",
+	}, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine(":irc.example.com NOTICE * :*** Looking up your hostname...\r\n"),
+		SendLine(":irc.example.com NOTICE * :*** Checking Ident\r\n"),
+		SendLine(":irc.example.com NOTICE * :*** Something else\r\n"),
+		SendLine(":irc.example.com NOTICE * :Please wait 30 seconds before reconnecting.\r\n"),
+	})
+	assert.Equal(t, []string{"hostname 0s", "ident 0s", "throttle 30s"}, gates)
+
+	// After registration, notices are ignored.
+	gates = nil
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("PASS :test_pass\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :hello_world\r\n"),
+		SendLine(":irc.example.com NOTICE * :Please wait 30 seconds before reconnecting.\r\n"),
+	})
+	assert.Nil(t, gates)
+}
+
+func TestCapValues(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	c := runClientTest(t, config, io.EOF, func(c *irc.Client) {
+		c.CapRequest("multi-prefix", true)
+
+		// Caps can be checked from other goroutines while they are being
+		// negotiated.
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					_ = c.CapEnabled("multi-prefix")
+					runtime.Gosched()
+				}
+			}
+		}()
+	}, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :multi-prefix\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS * :multi-prefix sasl=PLAIN,EXTERNAL\r\n"),
+		SendLine("CAP * LS :draft/example=a=b server-time\r\n"),
+		SendLine("CAP * ACK :multi-prefix\r\n"),
+		ExpectLine("CAP END\r\n"),
+	})
+	assert.True(t, c.CapAvailable("multi-prefix"))
+	assert.True(t, c.CapAvailable("sasl"))
+	assert.True(t, c.CapAvailable("server-time"))
+	assert.Equal(t, "", c.CapValue("multi-prefix"))
+	assert.Equal(t, "PLAIN,EXTERNAL", c.CapValue("sasl"))
+	assert.Equal(t, "a=b", c.CapValue("draft/example"))
+	assert.Equal(t, "", c.CapValue("missing"))
+}
+
+func TestConnect(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+	}
+
+	handler := &TestHandler{}
+	config.Handler = handler
+
+	rw := newTestReadWriter()
+	c := irc.NewClient(rw, config)
+
+	go func() {
+		err := c.Connect(context.Background())
+		assert.NoError(t, err)
+
+		// The whole welcome burst should have been handled by the time
+		// Connect returns.
+		assert.Equal(t, "new_nick", c.CurrentNick())
+		assert.Len(t, handler.Messages(), 4)
+
+		assert.NoError(t, c.Write("JOIN #a"))
+
+		err = c.Run()
+		assert.Equal(t, io.EOF, err)
+		close(rw.clientDone)
+	}()
+
+	runTest(t, rw, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 new_nick :Welcome\r\n"),
+		SendLine("004 new_nick irc.example.com version o o\r\n"),
+		SendLine("005 new_nick NETWORK=Example :are supported by this server\r\n"),
+		SendLine("251 new_nick :There are 2 users\r\n"),
+		ExpectLine("JOIN #a\r\n"),
+		SendLine("PING :hello\r\n"),
+		ExpectLine("PONG hello\r\n"),
+	})
+
+	// Errors during registration should be returned from Connect.
+	rw = newTestReadWriter()
+	c = irc.NewClient(rw, config)
+
+	go func() {
+		err := c.Connect(context.Background())
+		assert.Equal(t, &irc.ServerError{Text: "go away", Reason: "go away"}, err)
+		close(rw.clientDone)
+	}()
+
+	runTest(t, rw, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("ERROR :go away\r\n"),
+	})
+}
+
+func TestCapNotify(t *testing.T) {
+	t.Parallel()
+
+	var added, removed []string
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		OnCapChange: func(a, r []string) {
+			added = append(added, a...)
+			removed = append(removed, r...)
+		},
+	}
+
+	c := runClientTest(t, config, io.EOF, func(c *irc.Client) {
+		c.CapRequest("away-notify", false)
+	}, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :away-notify\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :multi-prefix\r\n"),
+		SendLine("CAP * NAK :away-notify\r\n"),
+		ExpectLine("CAP END\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+
+		// Requested caps which show up later should be requested again.
+		SendLine("CAP test_nick NEW :away-notify sasl=PLAIN\r\n"),
+		ExpectLine("CAP REQ :away-notify\r\n"),
+		SendLine("CAP test_nick ACK :away-notify\r\n"),
+		SendLine("CAP test_nick DEL :multi-prefix\r\n"),
+	})
+
+	assert.True(t, c.CapEnabled("away-notify"))
+	assert.True(t, c.CapAvailable("sasl"))
+	assert.Equal(t, "PLAIN", c.CapValue("sasl"))
+	assert.False(t, c.CapEnabled("sasl"))
+	assert.False(t, c.CapAvailable("multi-prefix"))
+
+	assert.Equal(t, []string{"away-notify", "sasl"}, added)
+	assert.Equal(t, []string{"multi-prefix"}, removed)
+}
+
+func TestCapNotifyAckRemoval(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+	}
+
+	c := runClientTest(t, config, io.EOF, func(c *irc.Client) {
+		c.CapRequest("multi-prefix", false)
+	}, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :multi-prefix\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :multi-prefix\r\n"),
+		SendLine("CAP * ACK :multi-prefix\r\n"),
+		ExpectLine("CAP END\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		SendLine("CAP test_nick ACK :-multi-prefix\r\n"),
+	})
+
+	assert.False(t, c.CapEnabled("multi-prefix"))
+	assert.True(t, c.CapAvailable("multi-prefix"))
+}
+
+func TestEchoMessage(t *testing.T) {
+	t.Parallel()
+
+	handler := &TestHandler{}
+	config := irc.ClientConfig{
+		Nick:         "test_nick",
+		User:         "test_user",
+		Name:         "test_name",
+		IgnoreEchoes: true,
+		Handler:      handler,
+	}
+
+	c := runClientTest(t, config, io.EOF, func(c *irc.Client) {
+		c.CapRequest("echo-message", true)
+	}, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :echo-message\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :echo-message\r\n"),
+		SendLine("CAP * ACK :echo-message\r\n"),
+		ExpectLine("CAP END\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		SendLine(":test_nick!user@host PRIVMSG #chan :echoed\r\n"),
+		SendLine(":Test_Nick!user@host PRIVMSG #chan :echoed with different case\r\n"),
+		SendLine(":other!user@host PRIVMSG #chan :not echoed\r\n"),
+	})
+
+	var trailing []string
+	for _, m := range handler.Messages() {
+		if m.Command == "PRIVMSG" {
+			trailing = append(trailing, m.Trailing())
+		}
+	}
+	assert.Equal(t, []string{"not echoed"}, trailing)
+
+	assert.True(t, c.IsSelf(irc.MustParseMessage(":test_nick!user@host PRIVMSG #chan :hi")))
+	assert.True(t, c.IsSelf(irc.MustParseMessage(":TEST_NICK!user@host PRIVMSG #chan :hi")))
+	assert.False(t, c.IsSelf(irc.MustParseMessage(":other!user@host PRIVMSG #chan :hi")))
+	assert.False(t, c.IsSelf(irc.MustParseMessage("PRIVMSG #chan :hi")))
+}
+
+type discardReadWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func (discardReadWriter) Close() error { return nil }
+
+func BenchmarkClientWrite(b *testing.B) {
+	rw := discardReadWriter{bytes.NewReader(nil), ioutil.Discard}
+	c := irc.NewClient(rw, irc.ClientConfig{
+		// This is fast enough to never block, but makes sure the limiter is
+		// part of the measured path.
+		SendLimit: time.Nanosecond,
+		SendBurst: 1000,
+	})
+
+	m := &irc.Message{
+		Command: "PRIVMSG",
+		Params:  []string{"#channel", "some message"},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = c.WriteMessage(m)
+	}
+}
+
+func TestTrackerJoinModes(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{
+		Nick:              "test_nick",
+		EnableTracker:     true,
+		TrackChannelModes: true,
+	})
+	require.NoError(t, ht.Feed(
+		"001 test_nick :Welcome",
+		":other!user@host JOIN #chan",
+		":test_nick!user@host JOIN #chan",
+	))
+	assert.NoError(t, ht.ExpectWrites("MODE #chan"))
+}
+
+func TestTrackerWhoOnJoin(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{
+		Nick:          "test_nick",
+		EnableTracker: true,
+		WhoOnJoin:     true,
+	})
+	require.NoError(t, ht.Feed(
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		"005 test_nick WHOX :are supported by this server",
+		":other!user@host JOIN #other",
+		":test_nick!user@host JOIN #other",
+	))
+	assert.NoError(t, ht.ExpectWrites(
+		"WHO #chan",
+		"WHO #other %tcuhnfar,152",
+	))
+}
+
+func TestStateErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	var stateErrors []error
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{
+		Nick:          "test_nick",
+		EnableTracker: true,
+		StateErrorHandler: func(err error) {
+			stateErrors = append(stateErrors, err)
+		},
+	})
+	require.NoError(t, ht.Feed(
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":other!user@host PART #unknown",
+	))
+
+	if assert.Len(t, stateErrors, 1) {
+		var stateErr *irc.StateError
+		require.True(t, errors.As(stateErrors[0], &stateErr))
+		assert.Equal(t, "PART", stateErr.Message.Command)
+		assert.EqualError(t, stateErr.Err, "received PART message for unknown channel")
+	}
+}
+
+func TestCurrentPrefix(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{Nick: "test_nick"})
+	assert.Equal(t, &irc.Prefix{Name: "test_nick"}, ht.Client.CurrentPrefix())
+
+	require.NoError(t, ht.Feed("001 test_nick :Welcome to the Network test_nick!~user@a.host"))
+	assert.Equal(t, "test_nick!~user@a.host", ht.Client.CurrentPrefix().String())
+
+	require.NoError(t, ht.Feed(":irc.example.com 396 test_nick b.host :is now your displayed host"))
+	assert.Equal(t, "test_nick!~user@b.host", ht.Client.CurrentPrefix().String())
+
+	require.NoError(t, ht.Feed(":irc.example.com 396 test_nick ident@c.host :is now your displayed host"))
+	assert.Equal(t, "test_nick!ident@c.host", ht.Client.CurrentPrefix().String())
+
+	require.NoError(t, ht.Feed(":irc.example.com 302 test_nick :other=+x@y test_nick*=-user@d.host"))
+	assert.Equal(t, "test_nick!user@d.host", ht.Client.CurrentPrefix().String())
+
+	// Other users joining shouldn't change anything.
+	require.NoError(t, ht.Feed(
+		":other!x@y JOIN #chan",
+		":test_nick!joined@e.host JOIN #chan",
+		":test_nick NICK new_nick",
+	))
+	assert.Equal(t, "new_nick!joined@e.host", ht.Client.CurrentPrefix().String())
+}
+
+func TestUserModes(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{Nick: "test_nick"})
+	assert.Equal(t, "+", ht.Client.UserModes())
+
+	require.NoError(t, ht.Feed(
+		"001 test_nick :Welcome",
+		":test_nick MODE test_nick :+wi",
+		// Modes for other users and channels should be ignored.
+		":other MODE other :+o",
+		":op MODE #chan +o test_nick",
+	))
+	assert.Equal(t, "+iw", ht.Client.UserModes())
+	assert.False(t, ht.Client.HasUserMode('o'))
+
+	// The server may send our nick in a different case.
+	require.NoError(t, ht.Feed(":irc.example.com MODE Test_Nick :+o-w"))
+	assert.Equal(t, "+io", ht.Client.UserModes())
+	assert.True(t, ht.Client.HasUserMode('o'))
+
+	// RPL_UMODEIS replaces everything.
+	require.NoError(t, ht.Feed(":irc.example.com 221 test_nick +Bx"))
+	assert.Equal(t, "+Bx", ht.Client.UserModes())
+	assert.True(t, ht.Client.HasUserMode('B'))
+}
+
+func TestConnectionEvents(t *testing.T) {
+	t.Parallel()
+
+	events := make(chan irc.ConnectionEvent, 10)
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+		OnConnectionEvent: func(event irc.ConnectionEvent) {
+			events <- event
+		},
+	}
+
+	c := runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+	})
+
+	event := <-events
+	assert.Equal(t, irc.ConnectionRegistered, event.Type)
+	assert.Equal(t, c, event.Client)
+	assert.NoError(t, event.Err)
+
+	event = <-events
+	assert.Equal(t, irc.ConnectionDisconnected, event.Type)
+	assert.Equal(t, c, event.Client)
+	assert.Equal(t, io.EOF, event.Err)
+	assert.Equal(t, "disconnected", event.Type.String())
+}
+
+func TestJoinChannelsOnConnect(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick:           "test_nick",
+		User:           "test_user",
+		Name:           "test_name",
+		EnableISupport: true,
+		Channels:       []string{"#a", "#b secret", "#c", "#d key"},
+	}
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		SendLine("005 test_nick TARGMAX=JOIN:3 :are supported by this server\r\n"),
+		// Channels are joined once the welcome burst is over, so the limits
+		// are known. Channels with keys need to come first.
+		SendLine("375 test_nick :- MOTD -\r\n"),
+		ExpectLine("JOIN #b,#d,#a secret,key\r\n"),
+		ExpectLine("JOIN #c\r\n"),
+	})
+}
+
+func TestWaitForRegistration(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+	}
+
+	registered := make(chan error, 1)
+	c := runClientTest(t, config, io.EOF, func(c *irc.Client) {
+		go func() {
+			registered <- c.WaitForRegistration(context.Background())
+		}()
+	}, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+	})
+	assert.NoError(t, <-registered)
+	assert.NoError(t, c.WaitForRegistration(context.Background()))
+
+	// If Run returns first, its error should be returned.
+	c = runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+	})
+	assert.Equal(t, io.EOF, c.WaitForRegistration(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = irc.NewClient(newNopCloser(&bytes.Buffer{}), config)
+	assert.Equal(t, context.Canceled, c.WaitForRegistration(ctx))
+}
+
+func TestQuit(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command == "001" {
+				assert.NoError(t, c.Quit("bye"))
+			}
+		}),
+	}
+
+	// The server closing the connection after a QUIT isn't an error.
+	runClientTest(t, config, nil, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		ExpectLine("QUIT bye\r\n"),
+		SendLine("ERROR :Closing link\r\n"),
+	})
+
+	// If the server never closes the connection, we should give up after the
+	// QuitTimeout.
+	server, conn := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, server)
+	}()
+
+	c := irc.NewClient(conn, irc.ClientConfig{Nick: "test_nick", QuitTimeout: 10 * time.Millisecond})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Run()
+	}()
+
+	require.NoError(t, c.Quit(""))
+
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Timeout waiting for Run to return after Quit")
+	}
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+
+	server, conn := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, server)
+	}()
+
+	c := irc.NewClient(conn, irc.ClientConfig{Nick: "test_nick"})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Run()
+	}()
+
+	require.NoError(t, c.Close())
+
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Timeout waiting for Run to return after Close")
+	}
+
+	assert.Equal(t, irc.ErrClientClosed, c.WaitForRegistration(context.Background()))
+}
+
+func TestRecoverPanics(t *testing.T) {
+	t.Parallel()
+
+	errs := make(chan error, 1)
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		RecoverPanics: true,
+		PanicHandler: func(err error) {
+			errs <- err
+		},
+
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command == "PRIVMSG" {
+				panic("oops")
+			}
+		}),
+	}
+
+	// The client should keep going after a panic.
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine(":nick!user@host PRIVMSG test_nick :hello\r\n"),
+		SendLine("PING :hello\r\n"),
+		ExpectLine("PONG hello\r\n"),
+	})
+
+	var panicErr *irc.PanicError
+	require.Len(t, errs, 1)
+	require.True(t, errors.As(<-errs, &panicErr))
+	assert.Equal(t, "oops", panicErr.Value)
+	assert.Equal(t, "PRIVMSG", panicErr.Message.Command)
+	assert.Contains(t, string(panicErr.Stack), "TestRecoverPanics")
+
+	// With DisconnectOnPanic, Run should return the panic.
+	config.DisconnectOnPanic = true
+
+	server, conn := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, server)
+	}()
+
+	c := irc.NewClient(conn, config)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run()
+	}()
+
+	_, err := server.Write([]byte(":nick!user@host PRIVMSG test_nick :hello\r\n"))
+	require.NoError(t, err)
+
+	select {
+	case err := <-done:
+		assert.True(t, errors.As(err, &panicErr))
+	case <-time.After(time.Second):
+		assert.Fail(t, "Timeout waiting for Run to return after a panic")
+	}
+	assert.Len(t, errs, 1)
+}
+
+func TestContextHandler(t *testing.T) {
+	t.Parallel()
+
+	contexts := make(chan context.Context, 2)
+
+	config := irc.ClientConfig{
+		Nick:        "test_nick",
+		User:        "test_user",
+		Name:        "test_name",
+		NetworkName: "Example",
+		Handler: irc.ContextHandlerFunc(func(ctx context.Context, c *irc.Client, m *irc.Message) {
+			if m.Command == "001" {
+				assert.NoError(t, ctx.Err())
+				contexts <- ctx
+			}
+		}),
+	}
+
+	c := runClientTest(t, config, io.EOF, func(c *irc.Client) {
+		assert.NoError(t, c.Context().Err())
+	}, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+	})
+
+	// The context is canceled once Run returns.
+	require.Len(t, contexts, 1)
+	ctx := <-contexts
+	assert.Equal(t, context.Canceled, ctx.Err())
+	assert.Equal(t, context.Canceled, c.Context().Err())
+
+	// The context carries the Client and its network.
+	client, ok := irc.ClientFromContext(ctx)
+	assert.True(t, ok)
+	assert.Same(t, c, client)
+
+	network, ok := irc.NetworkFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "Example", network)
+
+	_, ok = irc.ClientFromContext(context.Background())
+	assert.False(t, ok)
+	_, ok = irc.NetworkFromContext(context.Background())
+	assert.False(t, ok)
+
+	// WithContext adapts a ContextHandler to a Handler.
+	ctx = nil
+	ht := irc.NewHandlerTester(irc.WithContext(irc.ContextHandlerFunc(func(hctx context.Context, c *irc.Client, m *irc.Message) {
+		ctx = hctx
+	})), irc.ClientConfig{EnableISupport: true})
+	require.NoError(t, ht.Feed("005 test_nick NETWORK=Other :are supported by this server"))
+	assert.NoError(t, ctx.Err())
+
+	client, _ = irc.ClientFromContext(ctx)
+	assert.Same(t, ht.Client, client)
+
+	network, _ = irc.NetworkFromContext(ctx)
+	assert.Equal(t, "Other", network)
+}
@@ -0,0 +1,226 @@
+// Command irccat connects to an IRC server, sends each line read from stdin to
+// a target, and prints incoming traffic. It is intended both as a small
+// utility and as a minimal end-to-end example of using the irc package.
+//
+// Usage:
+//
+//	echo "hello world" | irccat -nick catbot ircs://irc.example.com/#channel
+//
+// The URL is parsed with irc.ParseURL. The target is the first channel in the
+// URL and can be overridden with -target, such as to send to a nick. A nick
+// and password in the URL take the place of -nick and -pass. Use irc:// for
+// plaintext and ircs:// for TLS.
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"gopkg.in/irc.v4"
+)
+
+func main() {
+	var (
+		nick         = flag.String("nick", "irccat", "nick to connect with")
+		user         = flag.String("user", "", "username to connect with, defaults to nick")
+		name         = flag.String("name", "irccat", "realname to connect with")
+		pass         = flag.String("pass", "", "server password")
+		target       = flag.String("target", "", "channel or nick to send stdin to, overrides the URL")
+		saslLogin    = flag.String("sasl-login", "", "SASL PLAIN login")
+		saslPassword = flag.String("sasl-password", "", "SASL PLAIN password")
+		certFile     = flag.String("cert", "", "TLS client certificate, enables SASL EXTERNAL")
+		keyFile      = flag.String("key", "", "TLS client key")
+		proxy        = flag.String("proxy", "", "SOCKS5 proxy to connect through, such as socks5://127.0.0.1:9050")
+		insecure     = flag.Bool("insecure", false, "skip TLS certificate verification")
+		quiet        = flag.Bool("quiet", false, "only print PRIVMSG and NOTICE messages")
+		stay         = flag.Bool("stay", false, "keep running and printing messages after stdin is closed")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] irc[s]://[nick[:pass]@]host[:port]/[#channel]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	u, err := irc.ParseURL(flag.Arg(0))
+	if err != nil {
+		log.Fatalln("invalid url:", err)
+	}
+
+	if *target == "" && len(u.Channels) > 0 {
+		*target = u.Channels[0]
+	}
+
+	if u.Config.Nick != "" {
+		*nick = u.Config.Nick
+	}
+
+	if u.Config.Pass != "" {
+		*pass = u.Config.Pass
+	}
+
+	if *user == "" {
+		*user = *nick
+	}
+
+	var tlsConfig *tls.Config
+	if u.TLS {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: *insecure, //nolint:gosec
+		}
+
+		if *certFile != "" {
+			cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+			if err != nil {
+				log.Fatalln("failed to load client certificate:", err)
+			}
+
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	lines := make(chan string)
+	go readLines(os.Stdin, lines)
+
+	config := irc.ClientConfig{
+		Nick:          *nick,
+		User:          *user,
+		Name:          *name,
+		Pass:          *pass,
+		SASLLogin:     *saslLogin,
+		SASLPassword:  *saslPassword,
+		SASLExternal:  tlsConfig != nil && len(tlsConfig.Certificates) > 0,
+		PingFrequency: time.Minute,
+		PingTimeout:   time.Minute,
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command == "001" {
+				go sendLines(c, *target, lines, *stay)
+			}
+
+			if line := formatMessage(m, *quiet); line != "" {
+				fmt.Println(line)
+			}
+		}),
+	}
+
+	client, err := dial(u, *proxy, tlsConfig, config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = client.Run()
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Fatalln(err)
+	}
+}
+
+func dial(u *irc.URL, proxy string, tlsConfig *tls.Config, config irc.ClientConfig) (*irc.Client, error) {
+	dialer := &irc.Dialer{TLSConfig: tlsConfig}
+
+	if proxy != "" {
+		var err error
+		dialer.Proxy, err = irc.ProxyFromURL(proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dialer.Dial(u.Addr, config)
+}
+
+func readLines(r io.Reader, lines chan<- string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines <- scanner.Text()
+	}
+
+	close(lines)
+}
+
+// sendLines joins the target if it's a channel, then sends everything read
+// from stdin to it. Once stdin is closed, the client quits unless stay is set.
+func sendLines(c *irc.Client, target string, lines <-chan string, stay bool) {
+	if target == "" {
+		if !stay {
+			for range lines {
+				// Nothing to send to, so wait for stdin to close.
+			}
+			_ = c.Quit("")
+		}
+
+		return
+	}
+
+	if c.FromChannel(&irc.Message{Params: []string{target}}) {
+		if err := c.Join(target); err != nil {
+			log.Println("failed to join:", err)
+		}
+	}
+
+	for line := range lines {
+		if line == "" {
+			continue
+		}
+
+		err := c.WriteMessage(&irc.Message{
+			Command: "PRIVMSG",
+			Params:  []string{target, line},
+		})
+		if err != nil {
+			log.Println("failed to send:", err)
+			return
+		}
+	}
+
+	if !stay {
+		_ = c.Quit("")
+	}
+}
+
+// formatMessage converts an incoming message into a line to print. If quiet
+// is set, anything other than PRIVMSG and NOTICE is dropped.
+func formatMessage(m *irc.Message, quiet bool) string {
+	timestamp := m.Time().Local().Format("15:04:05")
+	if m.Time().IsZero() {
+		timestamp = time.Now().Format("15:04:05")
+	}
+
+	switch m.Command {
+	case "PRIVMSG", "NOTICE":
+		if len(m.Params) < 2 || m.Prefix == nil {
+			break
+		}
+
+		text := m.Trailing()
+		if m.IsAction() {
+			return fmt.Sprintf("%s %s * %s %s", timestamp, m.Params[0], m.Prefix.Name, m.ActionText())
+		}
+
+		if m.Command == "NOTICE" {
+			return fmt.Sprintf("%s %s -%s- %s", timestamp, m.Params[0], m.Prefix.Name, text)
+		}
+
+		return fmt.Sprintf("%s %s <%s> %s", timestamp, m.Params[0], m.Prefix.Name, text)
+	case "PING", "PONG":
+		return ""
+	}
+
+	if quiet {
+		return ""
+	}
+
+	return timestamp + " " + m.String()
+}
@@ -0,0 +1,149 @@
+package irc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// coalescer drops identical PRIVMSG and NOTICE lines sent to the same target
+// within a short window of each other. This protects against handlers which
+// accidentally end up in a loop.
+type coalescer struct {
+	sync.Mutex
+
+	window  time.Duration
+	count   bool
+	write   func(line string) error
+	targets map[string]*coalesceState
+}
+
+// coalesceState tracks the last line sent to a single target.
+type coalesceState struct {
+	line       string
+	msg        *Message
+	last       time.Time
+	suppressed int
+	timer      *time.Timer
+}
+
+func newCoalescer(window time.Duration, count bool, write func(string) error) *coalescer {
+	return &coalescer{
+		window:  window,
+		count:   count,
+		write:   write,
+		targets: make(map[string]*coalesceState),
+	}
+}
+
+// filter returns the lines which should actually be sent in place of the
+// given line. If the line is a repeat it will return nothing, and if it ends a
+// run of repeats, a summary line may come before it.
+func (co *coalescer) filter(line string) []string {
+	m, err := ParseMessage(line)
+	if err != nil || (m.Command != "PRIVMSG" && m.Command != "NOTICE") || len(m.Params) < 2 {
+		return []string{line}
+	}
+
+	target := m.Params[0]
+	now := time.Now()
+
+	co.Lock()
+	defer co.Unlock()
+
+	state := co.targets[target]
+	if state != nil && state.line == line && now.Sub(state.last) < co.window {
+		state.last = now
+		state.suppressed++
+
+		if co.count {
+			if state.timer != nil {
+				state.timer.Stop()
+			}
+			state.timer = time.AfterFunc(co.window, func() {
+				co.flush(target, state)
+			})
+		}
+
+		return nil
+	}
+
+	var ret []string
+	if state != nil {
+		if summary := co.summary(state); summary != "" {
+			ret = append(ret, summary)
+		}
+	}
+
+	co.expire(now)
+
+	co.targets[target] = &coalesceState{
+		line: line,
+		msg:  m,
+		last: now,
+	}
+
+	return append(ret, line)
+}
+
+// summary returns the line summarizing a run of repeats, or an empty string
+// if there isn't anything to summarize. It must be called with the lock held.
+func (co *coalescer) summary(state *coalesceState) string {
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+
+	if !co.count || state.suppressed == 0 {
+		return ""
+	}
+
+	m := state.msg.Copy()
+	m.Params[len(m.Params)-1] += fmt.Sprintf(" (x%d)", state.suppressed)
+	state.suppressed = 0
+
+	return m.String()
+}
+
+// flush sends the summary for a target once its window has passed.
+func (co *coalescer) flush(target string, state *coalesceState) {
+	co.Lock()
+	var summary string
+	if co.targets[target] == state {
+		summary = co.summary(state)
+	}
+	co.Unlock()
+
+	if summary != "" {
+		_ = co.write(summary)
+	}
+}
+
+// flushAll sends the summaries for every target right away, such as before
+// quitting.
+func (co *coalescer) flushAll() {
+	var summaries []string
+
+	co.Lock()
+	for _, state := range co.targets {
+		if summary := co.summary(state); summary != "" {
+			summaries = append(summaries, summary)
+		}
+	}
+	co.Unlock()
+
+	for _, summary := range summaries {
+		_ = co.write(summary)
+	}
+}
+
+// expire drops state for any targets which haven't been written to within the
+// window and have nothing left to summarize. It must be called with the lock
+// held.
+func (co *coalescer) expire(now time.Time) {
+	for target, state := range co.targets {
+		if state.suppressed == 0 && now.Sub(state.last) >= co.window {
+			delete(co.targets, target)
+		}
+	}
+}
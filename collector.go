@@ -0,0 +1,82 @@
+package irc
+
+import "sync"
+
+// Collector groups replies which the server sends as a sequence of numerics,
+// such as the lines of a WHOIS, LIST, NAMES, STATS, or LINKS reply, and passes
+// the whole group to a callback once the end numeric is received. It
+// implements StateTracker, so it can be added to ClientConfig.StateTrackers.
+//
+// Only one group is collected at a time, so overlapping replies which share
+// numerics will be merged.
+type Collector struct {
+	sync.Mutex
+
+	start map[string]bool
+	lines map[string]bool
+	end   map[string]bool
+
+	callback func([]*Message)
+
+	open    bool
+	current []*Message
+}
+
+// NewCollector creates a Collector for the given numerics. Messages with a
+// start command begin a new group, line commands are added to the current
+// group, and end commands finish the group and call the callback. If no start
+// commands are given, the first line starts the group. The start and end
+// messages are included in the group passed to the callback.
+func NewCollector(start, lines, end []string, callback func(messages []*Message)) *Collector {
+	return &Collector{
+		start:    commandSet(start),
+		lines:    commandSet(lines),
+		end:      commandSet(end),
+		callback: callback,
+	}
+}
+
+func commandSet(commands []string) map[string]bool {
+	ret := make(map[string]bool, len(commands))
+	for _, command := range commands {
+		ret[command] = true
+	}
+
+	return ret
+}
+
+// Handle needs to be called for all messages with the configured numerics.
+// All other messages will be ignored.
+func (c *Collector) Handle(m *Message) error {
+	c.Lock()
+
+	switch {
+	case c.start[m.Command]:
+		c.open = true
+		c.current = []*Message{m}
+	case c.lines[m.Command]:
+		if !c.open && len(c.start) > 0 {
+			// We missed the start of this group, so there's nothing we can
+			// do with it.
+			break
+		}
+
+		c.open = true
+		c.current = append(c.current, m)
+	case c.end[m.Command]:
+		messages := append(c.current, m)
+		c.open = false
+		c.current = nil
+		c.Unlock()
+
+		// The callback is called without the lock held so it can safely
+		// call back into the Collector.
+		c.callback(messages)
+
+		return nil
+	}
+
+	c.Unlock()
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func collectTrailing(messages []*irc.Message) []string {
+	var ret []string
+	for _, m := range messages {
+		ret = append(ret, m.Command+" "+m.Trailing())
+	}
+
+	return ret
+}
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+
+	var groups [][]string
+	collector := irc.NewCollector(
+		[]string{irc.RPL_LISTSTART},
+		[]string{irc.RPL_LIST},
+		[]string{irc.RPL_LISTEND},
+		func(messages []*irc.Message) {
+			groups = append(groups, collectTrailing(messages))
+		},
+	)
+
+	// Lines before the start of a group should be ignored.
+	feed := []string{
+		":server 322 nick #stray 1 :stray",
+		":server 321 nick Channel :Users  Name",
+		":server 322 nick #a 1 :first",
+		":server PRIVMSG nick :unrelated",
+		":server 322 nick #b 2 :second",
+		":server 323 nick :End of /LIST",
+		":server 321 nick Channel :Users  Name",
+		":server 323 nick :End of /LIST",
+	}
+	for _, line := range feed {
+		assert.NoError(t, collector.Handle(irc.MustParseMessage(line)))
+	}
+
+	assert.Equal(t, [][]string{
+		{"321 Users  Name", "322 first", "322 second", "323 End of /LIST"},
+		{"321 Users  Name", "323 End of /LIST"},
+	}, groups)
+}
+
+func TestCollectorNoStart(t *testing.T) {
+	t.Parallel()
+
+	var groups [][]string
+	collector := irc.NewCollector(
+		nil,
+		[]string{irc.RPL_LINKS},
+		[]string{irc.RPL_ENDOFLINKS},
+		func(messages []*irc.Message) {
+			groups = append(groups, collectTrailing(messages))
+		},
+	)
+
+	feed := []string{
+		":server 364 nick a.example.com b.example.com :1 A",
+		":server 364 nick b.example.com b.example.com :0 B",
+		":server 365 nick * :End of /LINKS list",
+		":server 365 nick * :End of /LINKS list",
+	}
+	for _, line := range feed {
+		assert.NoError(t, collector.Handle(irc.MustParseMessage(line)))
+	}
+
+	assert.Equal(t, [][]string{
+		{"364 1 A", "364 0 B", "365 End of /LINKS list"},
+		{"365 End of /LINKS list"},
+	}, groups)
+}
@@ -0,0 +1,239 @@
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnterminatedQuote is returned by SplitArgs when a quote is opened but
+// never closed.
+var ErrUnterminatedQuote = errors.New("irc: unterminated quote")
+
+// SplitArgs splits command text into arguments on whitespace. Single or double
+// quotes can be used to keep spaces in an argument, and a backslash keeps the
+// next character as is, such as a quote.
+func SplitArgs(text string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range text {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, ErrUnterminatedQuote
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+// ArgError is returned by the Args getters when an argument is missing or
+// invalid. A CommandMux replies to the user with it when it is returned from
+// an ArgsFunc.
+type ArgError struct {
+	// Index is the position of the argument.
+	Index int
+
+	// Want describes what the argument should have been, such as "a number".
+	Want string
+
+	// Value is what was given. It is empty if the argument was missing.
+	Value string
+}
+
+// Error implements the error interface.
+func (e *ArgError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("argument %d: missing %s", e.Index+1, e.Want)
+	}
+
+	return fmt.Sprintf("argument %d: %q is not %s", e.Index+1, e.Value, e.Want)
+}
+
+// Args are the arguments to a command, with flags like --force or --count=3
+// separated out. A lone -- stops flag parsing.
+type Args struct {
+	// Positional are the arguments which aren't flags.
+	Positional []string
+
+	// Flags maps the name of each flag to its value, which is empty if it
+	// didn't have one.
+	Flags map[string]string
+
+	client *Client
+}
+
+// ParseArgs separates flags from the positional arguments. The Client is used
+// to validate nicks and channels and may be nil.
+func ParseArgs(c *Client, args []string) *Args {
+	a := &Args{
+		Positional: nil,
+		Flags:      make(map[string]string),
+		client:     c,
+	}
+
+	for i, arg := range args {
+		if arg == "--" {
+			a.Positional = append(a.Positional, args[i+1:]...)
+			break
+		}
+
+		if !strings.HasPrefix(arg, "--") {
+			a.Positional = append(a.Positional, arg)
+			continue
+		}
+
+		kv := strings.SplitN(arg[2:], "=", 2)
+		if len(kv) == 1 {
+			a.Flags[kv[0]] = ""
+		} else {
+			a.Flags[kv[0]] = kv[1]
+		}
+	}
+
+	return a
+}
+
+// Len returns the number of positional arguments.
+func (a *Args) Len() int {
+	return len(a.Positional)
+}
+
+// Flag returns true if the flag was given.
+func (a *Args) Flag(name string) bool {
+	_, ok := a.Flags[name]
+	return ok
+}
+
+// String returns the positional argument at i.
+func (a *Args) String(i int) (string, error) {
+	if i >= len(a.Positional) {
+		return "", &ArgError{Index: i, Want: "argument"}
+	}
+
+	return a.Positional[i], nil
+}
+
+// Rest returns the positional arguments from i on, joined by spaces.
+func (a *Args) Rest(i int) string {
+	if i >= len(a.Positional) {
+		return ""
+	}
+
+	return strings.Join(a.Positional[i:], " ")
+}
+
+// Int returns the positional argument at i as an int.
+func (a *Args) Int(i int) (int, error) {
+	var n int
+
+	_, err := a.check(i, "a number", func(s string) bool {
+		var err error
+		n, err = strconv.Atoi(s)
+		return err == nil
+	})
+
+	return n, err
+}
+
+// Duration returns the positional argument at i as a time.Duration, such as
+// 1h30m.
+func (a *Args) Duration(i int) (time.Duration, error) {
+	var d time.Duration
+
+	_, err := a.check(i, "a duration", func(s string) bool {
+		var err error
+		d, err = time.ParseDuration(s)
+		return err == nil
+	})
+
+	return d, err
+}
+
+// Nick returns the positional argument at i if it is a valid nick.
+func (a *Args) Nick(i int) (string, error) {
+	return a.check(i, "a nick", a.isNick)
+}
+
+// Channel returns the positional argument at i if it is a channel.
+func (a *Args) Channel(i int) (string, error) {
+	return a.check(i, "a channel", func(s string) bool {
+		return a.isChannel(s) && !strings.ContainsAny(s, ", \x07")
+	})
+}
+
+// check returns the positional argument at i, or an ArgError if it's missing
+// or isn't valid.
+func (a *Args) check(i int, want string, valid func(string) bool) (string, error) {
+	if i >= len(a.Positional) {
+		return "", &ArgError{Index: i, Want: want}
+	}
+
+	s := a.Positional[i]
+	if !valid(s) {
+		return "", &ArgError{Index: i, Want: want, Value: s}
+	}
+
+	return s, nil
+}
+
+func (a *Args) isChannel(s string) bool {
+	if a.client != nil {
+		return a.client.IsChannel(s)
+	}
+
+	return s != "" && (s[0] == '#' || s[0] == '&')
+}
+
+// isNick checks for the characters rfc2812 allows in nicks, other than the
+// length limit.
+func (a *Args) isNick(s string) bool {
+	if s == "" || a.isChannel(s) || (s[0] >= '0' && s[0] <= '9') || s[0] == '-' {
+		return false
+	}
+
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("[]\\`_^{|}-", r):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,128 @@
+package irc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestSplitArgs(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct { //nolint:gofumpt
+		Input  string
+		Expect []string
+		Err    error
+	}{
+		{Input: "", Expect: nil},
+		{Input: "  a  b\tc ", Expect: []string{"a", "b", "c"}},
+		{Input: `kick "bad user" 'for being bad'`, Expect: []string{"kick", "bad user", "for being bad"}},
+		{Input: `say "it's" '"quoted"'`, Expect: []string{"say", "it's", `"quoted"`}},
+		{Input: `a\ b \"c`, Expect: []string{"a b", `"c`}},
+		{Input: `empty ""`, Expect: []string{"empty", ""}},
+		{Input: `pre"fix"ed`, Expect: []string{"prefixed"}},
+		{Input: `"unterminated`, Err: irc.ErrUnterminatedQuote},
+	}
+
+	for _, testCase := range testCases {
+		args, err := irc.SplitArgs(testCase.Input)
+		assert.Equal(t, testCase.Err, err, testCase.Input)
+		assert.Equal(t, testCase.Expect, args, testCase.Input)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	t.Parallel()
+
+	args := irc.ParseArgs(nil, []string{"#chan", "--force", "test_nick", "--count=3", "5m", "12", "--", "--literal"})
+
+	assert.Equal(t, []string{"#chan", "test_nick", "5m", "12", "--literal"}, args.Positional)
+	assert.Equal(t, 5, args.Len())
+	assert.True(t, args.Flag("force"))
+	assert.False(t, args.Flag("quiet"))
+	assert.Equal(t, "3", args.Flags["count"])
+	assert.Equal(t, "5m 12 --literal", args.Rest(2))
+	assert.Equal(t, "", args.Rest(5))
+
+	channel, err := args.Channel(0)
+	require.NoError(t, err)
+	assert.Equal(t, "#chan", channel)
+
+	nick, err := args.Nick(1)
+	require.NoError(t, err)
+	assert.Equal(t, "test_nick", nick)
+
+	d, err := args.Duration(2)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, d)
+
+	n, err := args.Int(3)
+	require.NoError(t, err)
+	assert.Equal(t, 12, n)
+
+	s, err := args.String(4)
+	require.NoError(t, err)
+	assert.Equal(t, "--literal", s)
+
+	// Invalid and missing arguments
+	_, err = args.Nick(0)
+	assert.Equal(t, &irc.ArgError{Index: 0, Want: "a nick", Value: "#chan"}, err)
+	assert.EqualError(t, err, `argument 1: "#chan" is not a nick`)
+
+	_, err = args.Channel(1)
+	assert.Equal(t, &irc.ArgError{Index: 1, Want: "a channel", Value: "test_nick"}, err)
+
+	_, err = args.Int(2)
+	assert.Equal(t, &irc.ArgError{Index: 2, Want: "a number", Value: "5m"}, err)
+
+	_, err = args.Duration(4)
+	assert.Equal(t, &irc.ArgError{Index: 4, Want: "a duration", Value: "--literal"}, err)
+
+	_, err = args.Int(5)
+	assert.EqualError(t, err, "argument 6: missing a number")
+
+	// CHANTYPES is used when the Client knows it.
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{EnableISupport: true})
+	require.NoError(t, ht.Feed("005 test_nick CHANTYPES=! :are supported by this server"))
+
+	args = irc.ParseArgs(ht.Client, []string{"!chan", "#chan"})
+
+	_, err = args.Channel(0)
+	assert.NoError(t, err)
+
+	_, err = args.Channel(1)
+	assert.Error(t, err)
+}
+
+func TestCommandArgs(t *testing.T) {
+	t.Parallel()
+
+	mux := irc.NewCommandMux("!")
+	mux.CommandArgs("kick", "Kick someone", func(c *irc.Client, m *irc.Message, args *irc.Args) error {
+		nick, err := args.Nick(0)
+		if err != nil {
+			return err
+		}
+
+		return c.Kick(m.Params[0], args.Rest(1), nick)
+	})
+
+	ht := irc.NewHandlerTester(mux, irc.ClientConfig{})
+
+	assert.NoError(t, ht.Feed(`:nick!user@host PRIVMSG #chan :!kick bad_user "go away"`))
+	assert.NoError(t, ht.ExpectWrites("KICK #chan bad_user :go away"))
+
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!kick #chan"))
+	assert.NoError(t, ht.ExpectWrites(`PRIVMSG #chan :Error: argument 1: "#chan" is not a nick`))
+
+	assert.NoError(t, ht.Feed(`:nick!user@host PRIVMSG #chan :!kick "bad_user`))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :Error: unterminated quote"))
+
+	// Quoting problems in commands for someone else are ignored.
+	assert.NoError(t, ht.Feed(`:nick!user@host PRIVMSG #chan :!other "oops`))
+	assert.NoError(t, ht.ExpectWrites())
+}
@@ -0,0 +1,235 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CommandFunc runs a command sent to a CommandMux. The args are the words
+// following the command's name, split by SplitArgs so quotes can be used.
+type CommandFunc func(c *Client, m *Message, args []string)
+
+// ArgsFunc runs a command sent to a CommandMux, with its arguments parsed by
+// ParseArgs. If it returns an error, such as an *ArgError from one of the Args
+// getters, the error is sent back to the user.
+type ArgsFunc func(c *Client, m *Message, args *Args) error
+
+// commandEntry is either a command or a group of subcommands.
+type commandEntry struct {
+	help string
+	fn   CommandFunc
+	sub  *CommandMux
+}
+
+// CommandMux is a Handler which runs bot commands sent in a PRIVMSG, such as
+// "!admin user add bob". Commands can be nested with Group, and every level
+// answers "help" with a list of the commands it has, so "!help" lists the top
+// level commands and "!admin help", or just "!admin", lists the admin ones.
+// Unknown top level commands are ignored so the bot doesn't respond to
+// commands meant for other bots. Commands and groups can be limited to some
+// users with Restrict, and are left out of help for anyone else.
+type CommandMux struct {
+	// Prefix is what a PRIVMSG must start with to be a command, such as "!".
+	// It is only used by the top level CommandMux.
+	Prefix string
+
+	// OnDenied is called when a Policy doesn't allow the sender to run a
+	// command. If it is nil, the user will be told permission was denied. It
+	// is only used by the top level CommandMux.
+	OnDenied func(c *Client, m *Message)
+
+	lock     sync.RWMutex
+	commands map[string]*commandEntry
+	policies map[string][]Policy
+}
+
+// NewCommandMux creates an empty CommandMux with the given prefix.
+func NewCommandMux(prefix string) *CommandMux {
+	return &CommandMux{
+		Prefix:   prefix,
+		OnDenied: nil,
+		lock:     sync.RWMutex{},
+		commands: make(map[string]*commandEntry),
+		policies: make(map[string][]Policy),
+	}
+}
+
+// Command adds a command with the given name. The help text is shown next to
+// the name when listing commands. Names are not case sensitive.
+func (mux *CommandMux) Command(name, help string, fn CommandFunc) {
+	mux.add(name, &commandEntry{help: help, fn: fn})
+}
+
+// CommandArgs is the same as Command, but the arguments are parsed into Args
+// and any error returned is sent back to the user.
+func (mux *CommandMux) CommandArgs(name, help string, fn ArgsFunc) {
+	mux.Command(name, help, func(c *Client, m *Message, args []string) {
+		err := fn(c, m, ParseArgs(c, args))
+		if err != nil {
+			replyError(c, m, err)
+		}
+	})
+}
+
+// Group adds a command with the given name which has subcommands, and returns
+// the CommandMux they should be added to.
+func (mux *CommandMux) Group(name, help string) *CommandMux {
+	sub := NewCommandMux("")
+	mux.add(name, &commandEntry{help: help, sub: sub})
+	return sub
+}
+
+// Restrict limits the command or group with the given name to senders allowed
+// by all of the policies. Restricting a group applies to all of its
+// subcommands. It can be called before or after the command is added.
+func (mux *CommandMux) Restrict(name string, policies ...Policy) {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+
+	name = strings.ToLower(name)
+	mux.policies[name] = append(mux.policies[name], policies...)
+}
+
+func (mux *CommandMux) add(name string, entry *commandEntry) {
+	mux.lock.Lock()
+	defer mux.lock.Unlock()
+
+	mux.commands[strings.ToLower(name)] = entry
+}
+
+func (mux *CommandMux) lookup(name string) *commandEntry {
+	mux.lock.RLock()
+	defer mux.lock.RUnlock()
+
+	return mux.commands[strings.ToLower(name)]
+}
+
+// allowed checks the policies for the named command. The lock must be held.
+func (mux *CommandMux) allowed(c *Client, m *Message, name string) bool {
+	for _, p := range mux.policies[name] {
+		if !p.Allow(c, m) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (mux *CommandMux) isAllowed(c *Client, m *Message, name string) bool {
+	mux.lock.RLock()
+	defer mux.lock.RUnlock()
+
+	return mux.allowed(c, m, strings.ToLower(name))
+}
+
+// Handle implements Handler by running the command in m, if there is one.
+func (mux *CommandMux) Handle(c *Client, m *Message) {
+	if m.Command != "PRIVMSG" || len(m.Params) < 2 {
+		return
+	}
+
+	text := m.Trailing()
+	if !strings.HasPrefix(text, mux.Prefix) {
+		return
+	}
+
+	if _, ok := ParseCTCP(m); ok {
+		return
+	}
+
+	fields, err := SplitArgs(text[len(mux.Prefix):])
+	if err != nil {
+		// Only complain if this was meant to be one of our commands.
+		if words := strings.Fields(text[len(mux.Prefix):]); mux.lookup(words[0]) != nil {
+			replyError(c, m, err)
+		}
+		return
+	}
+
+	if len(fields) == 0 {
+		return
+	}
+
+	mux.run(c, m, mux, mux.Prefix, fields)
+}
+
+// run finds and runs the command named by the first field. The root is the
+// top level CommandMux, and the path is how this level of commands is reached,
+// like "!admin ", for use in help.
+func (mux *CommandMux) run(c *Client, m *Message, root *CommandMux, path string, fields []string) {
+	name := fields[0]
+
+	entry := mux.lookup(name)
+	switch {
+	case entry == nil && strings.EqualFold(name, "help"):
+		mux.help(c, m, path, fields[1:])
+	case entry == nil && mux != root:
+		mux.help(c, m, path, nil)
+	case entry == nil:
+		return
+	case !mux.isAllowed(c, m, name):
+		root.denied(c, m)
+	case entry.sub != nil && len(fields) == 1:
+		entry.sub.help(c, m, path+strings.ToLower(name)+" ", nil)
+	case entry.sub != nil:
+		entry.sub.run(c, m, root, path+strings.ToLower(name)+" ", fields[1:])
+	default:
+		entry.fn(c, m, fields[1:])
+	}
+}
+
+// help replies with the commands at this level. If args name a group, its
+// commands are listed instead.
+func (mux *CommandMux) help(c *Client, m *Message, path string, args []string) {
+	if len(args) > 0 {
+		if entry := mux.lookup(args[0]); entry != nil && entry.sub != nil && mux.isAllowed(c, m, args[0]) {
+			entry.sub.help(c, m, path+strings.ToLower(args[0])+" ", args[1:])
+			return
+		}
+	}
+
+	mux.lock.RLock()
+	names := make([]string, 0, len(mux.commands))
+	for name := range mux.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		if !mux.allowed(c, m, name) {
+			continue
+		}
+
+		line := path + name
+		if help := mux.commands[name].help; help != "" {
+			line += " - " + help
+		}
+		lines = append(lines, line)
+	}
+	mux.lock.RUnlock()
+
+	if len(lines) == 0 {
+		return
+	}
+
+	_ = c.Reply(m, strings.Join(lines, "\n"))
+}
+
+// denied handles a command the sender isn't allowed to run.
+func (mux *CommandMux) denied(c *Client, m *Message) {
+	if mux.OnDenied != nil {
+		mux.OnDenied(c, m)
+		return
+	}
+
+	replyError(c, m, ErrPermissionDenied)
+}
+
+// replyError lets the user know their command failed.
+func replyError(c *Client, m *Message, err error) {
+	_ = c.Reply(m, "Error: "+strings.TrimPrefix(err.Error(), "irc: "))
+}
+
+var _ Handler = (*CommandMux)(nil)
@@ -0,0 +1,76 @@
+package irc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestCommandMux(t *testing.T) {
+	t.Parallel()
+
+	mux := irc.NewCommandMux("!")
+	mux.Command("echo", "Repeat after me", func(c *irc.Client, m *irc.Message, args []string) {
+		_ = c.Reply(m, strings.Join(args, " "))
+	})
+
+	admin := mux.Group("admin", "Bot administration")
+	users := admin.Group("user", "Manage users")
+	users.Command("add", "Add a user", func(c *irc.Client, m *irc.Message, args []string) {
+		_ = c.Reply(m, "added "+strings.Join(args, ","))
+	})
+	users.Command("del", "", func(c *irc.Client, m *irc.Message, args []string) {
+		_ = c.Reply(m, "deleted "+strings.Join(args, ","))
+	})
+
+	ht := irc.NewHandlerTester(mux, irc.ClientConfig{})
+
+	// Commands
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!echo hello   world"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :hello world"))
+
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!ADMIN user add bob alice"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :added bob,alice"))
+
+	// Replies to private messages go to the sender.
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG test_nick :!admin user del bob"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG nick :deleted bob"))
+
+	// Help at each level
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!help"))
+	assert.NoError(t, ht.ExpectWrites(
+		"PRIVMSG #chan :!admin - Bot administration",
+		"PRIVMSG #chan :!echo - Repeat after me",
+	))
+
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!admin"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :!admin user - Manage users"))
+
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!admin user help"))
+	assert.NoError(t, ht.ExpectWrites(
+		"PRIVMSG #chan :!admin user add - Add a user",
+		"PRIVMSG #chan :!admin user del",
+	))
+
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!help admin user"))
+	assert.NoError(t, ht.ExpectWrites(
+		"PRIVMSG #chan :!admin user add - Add a user",
+		"PRIVMSG #chan :!admin user del",
+	))
+
+	// Unknown subcommands get help, but unknown top level commands and
+	// anything else are ignored.
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!admin nope"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :!admin user - Manage users"))
+
+	assert.NoError(t, ht.Feed(
+		":nick!user@host PRIVMSG #chan :!nope",
+		":nick!user@host PRIVMSG #chan :echo hello",
+		":nick!user@host PRIVMSG #chan :!",
+		":nick!user@host NOTICE #chan :!echo hello",
+	))
+	assert.NoError(t, ht.ExpectWrites())
+}
@@ -0,0 +1,112 @@
+package irc
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ErrPermissionDenied is sent back to the user by a CommandMux when a Policy
+// doesn't allow them to run a command.
+var ErrPermissionDenied = errors.New("irc: permission denied")
+
+// Policy decides whether the sender of a message may run a command. Policies
+// are added to commands with CommandMux.Restrict.
+type Policy interface {
+	Allow(c *Client, m *Message) bool
+}
+
+// PolicyFunc is a simple wrapper around a function which implements the
+// Policy interface.
+type PolicyFunc func(c *Client, m *Message) bool
+
+// Allow calls f(c, m).
+func (f PolicyFunc) Allow(c *Client, m *Message) bool {
+	return f(c, m)
+}
+
+// AllowMasks allows senders whose prefix matches any of the masks, such as
+// "*!*@admin.example.com".
+func AllowMasks(masks ...string) Policy {
+	regexes := make([]*regexp.Regexp, 0, len(masks))
+	for _, mask := range masks {
+		if re, err := MaskToRegex(mask); err == nil {
+			regexes = append(regexes, re)
+		}
+	}
+
+	return PolicyFunc(func(c *Client, m *Message) bool {
+		if m.Prefix == nil {
+			return false
+		}
+
+		prefix := m.Prefix.String()
+		for _, re := range regexes {
+			if re.MatchString(prefix) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// AllowAccounts allows senders logged in to any of the given services
+// accounts. The account is taken from the account tag if there is one, or the
+// Tracker otherwise, so the account-tag or account-notify cap is needed.
+func AllowAccounts(accounts ...string) Policy {
+	return PolicyFunc(func(c *Client, m *Message) bool {
+		account, ok := senderAccount(c, m)
+		if !ok {
+			return false
+		}
+
+		for _, allowed := range accounts {
+			if c.foldNick(allowed) == c.foldNick(account) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// AllowChannelModes allows senders who have any of the given prefix modes,
+// such as "o" or "qao", in the channel the command was sent to. The Tracker
+// must be enabled, and commands sent in private are never allowed.
+func AllowChannelModes(modes string) Policy {
+	return PolicyFunc(func(c *Client, m *Message) bool {
+		if c.Tracker == nil || m.Prefix == nil || len(m.Params) == 0 || !c.IsChannel(m.Params[0]) {
+			return false
+		}
+
+		userModes, ok := c.Tracker.UserModes(m.Params[0], m.Prefix.Name)
+		return ok && strings.ContainsAny(userModes, modes)
+	})
+}
+
+// AllowAny allows senders who are allowed by at least one of the policies.
+func AllowAny(policies ...Policy) Policy {
+	return PolicyFunc(func(c *Client, m *Message) bool {
+		for _, p := range policies {
+			if p.Allow(c, m) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// senderAccount looks up the services account of whoever sent m.
+func senderAccount(c *Client, m *Message) (string, bool) {
+	if account := m.Tags["account"]; account != "" && account != "*" {
+		return account, true
+	}
+
+	if c.Tracker == nil || m.Prefix == nil {
+		return "", false
+	}
+
+	return c.Tracker.AccountFor(m.Prefix.Name)
+}
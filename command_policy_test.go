@@ -0,0 +1,83 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestCommandMuxRestrict(t *testing.T) {
+	t.Parallel()
+
+	mux := irc.NewCommandMux("!")
+	mux.Command("ping", "Check the bot is alive", func(c *irc.Client, m *irc.Message, args []string) {
+		_ = c.Reply(m, "pong")
+	})
+	mux.Command("topic", "Set the topic", func(c *irc.Client, m *irc.Message, args []string) {
+		_ = c.Reply(m, "topic set")
+	})
+	mux.Restrict("topic", irc.AllowChannelModes("o"))
+
+	admin := mux.Group("admin", "Bot administration")
+	admin.Command("quit", "Disconnect", func(c *irc.Client, m *irc.Message, args []string) {
+		_ = c.Reply(m, "bye")
+	})
+	mux.Restrict("admin", irc.AllowAny(
+		irc.AllowMasks("*!*@admin.example.com"),
+		irc.AllowAccounts("Owner"),
+	))
+
+	ht := irc.NewHandlerTester(mux, irc.ClientConfig{EnableTracker: true})
+	require.NoError(t, ht.Feed(
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		"353 test_nick = #chan :test_nick @op nick",
+		"366 test_nick #chan :End of /NAMES list",
+	))
+	require.NoError(t, ht.ExpectWrites())
+
+	// Unrestricted commands work for everyone.
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!ping"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :pong"))
+
+	// Channel modes
+	assert.NoError(t, ht.Feed(":op!user@host PRIVMSG #chan :!topic"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :topic set"))
+
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!topic"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :Error: permission denied"))
+
+	assert.NoError(t, ht.Feed(":op!user@host PRIVMSG test_nick :!topic"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG op :Error: permission denied"))
+
+	// Masks and accounts, which also restrict subcommands.
+	assert.NoError(t, ht.Feed(":nick!user@admin.example.com PRIVMSG #chan :!admin quit"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :bye"))
+
+	assert.NoError(t, ht.Feed("@account=owner :owner!user@host PRIVMSG #chan :!admin quit"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :bye"))
+
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!admin quit"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :Error: permission denied"))
+
+	// Help only lists what the sender can run.
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!help"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :!ping - Check the bot is alive"))
+
+	assert.NoError(t, ht.Feed(":op!user@host PRIVMSG #chan :!help"))
+	assert.NoError(t, ht.ExpectWrites(
+		"PRIVMSG #chan :!ping - Check the bot is alive",
+		"PRIVMSG #chan :!topic - Set the topic",
+	))
+
+	// OnDenied replaces the default reply.
+	mux.OnDenied = func(c *irc.Client, m *irc.Message) {
+		_ = c.Reply(m, "nope")
+	}
+
+	assert.NoError(t, ht.Feed(":nick!user@host PRIVMSG #chan :!admin"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :nope"))
+}
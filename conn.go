@@ -2,9 +2,14 @@ package irc
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Conn represents a simple IRC client. It embeds an irc.Reader and an
@@ -22,6 +27,78 @@ func NewConn(rw io.ReadWriter) *Conn {
 	}
 }
 
+// SuppressEcho enables dropping incoming lines which exactly match one of the
+// last size lines written to the connection. This is meant for half-duplex or
+// loopback-style transports (such as serial bridges) which read back
+// everything we send. A size of 0 disables echo suppression.
+func (c *Conn) SuppressEcho(size int) {
+	if size <= 0 {
+		c.Writer.written = nil
+		c.Reader.skip = nil
+		return
+	}
+
+	echo := &echoTracker{size: size}
+	c.Writer.written = echo.record
+	c.Reader.skip = echo.match
+}
+
+// echoTracker keeps track of recently written lines so they can be dropped if
+// they are read back.
+type echoTracker struct {
+	sync.Mutex
+
+	size   int
+	recent []string
+}
+
+func (e *echoTracker) record(line string) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.recent = append(e.recent, line)
+	if len(e.recent) > e.size {
+		e.recent = e.recent[len(e.recent)-e.size:]
+	}
+}
+
+func (e *echoTracker) match(line string) bool {
+	e.Lock()
+	defer e.Unlock()
+
+	line = strings.TrimRight(line, "\r\n")
+
+	for i, recent := range e.recent {
+		if recent == line {
+			e.recent = append(e.recent[:i], e.recent[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ErrUnsafeLine is returned when trying to write a line or message param which
+// contains a CR, LF, or NUL. Sending these could allow anyone who controls
+// part of the line to inject extra commands.
+var ErrUnsafeLine = errors.New("irc: line contains CR, LF, or NUL")
+
+// ErrLineTooLong is returned by ReadMessage when a line is longer than the
+// Reader's MaxLineLength. The rest of the line will be skipped, so it is safe
+// to keep reading.
+var ErrLineTooLong = errors.New("irc: line too long")
+
+// ErrDeadlineUnsupported is returned when trying to set a deadline on a
+// connection which doesn't support them. Anything implementing net.Conn does.
+var ErrDeadlineUnsupported = errors.New("irc: connection does not support deadlines")
+
+// defaultMaxLineLength is enough for 4096 bytes of tags plus the rest of the
+// message, as allowed by the message-tags spec.
+const defaultMaxLineLength = 8191
+
+// unsafeChars are the characters which may never appear in an outgoing line.
+const unsafeChars = "\r\n\x00"
+
 // Writer is the outgoing side of a connection.
 type Writer struct {
 	// DebugCallback is called for each outgoing message. The name of this may
@@ -29,22 +106,50 @@ type Writer struct {
 	DebugCallback func(line string)
 
 	// WriteCallback is called for each outgoing message. It needs to write the
-	// message to the connection. Note that this API is not a part of the semver
-	// stability guarantee.
+	// message to the connection. If it is nil, lines are written directly to
+	// the connection, which lets WriteMessage avoid allocating. Note that this
+	// API is not a part of the semver stability guarantee.
 	WriteCallback func(w *Writer, line string) error
 
+	// WriteTimeout is how long each write to the underlying connection may
+	// take before it fails, so a stalled connection returns an error rather
+	// than blocking forever. It is ignored if it is zero or the connection
+	// doesn't support write deadlines.
+	WriteTimeout time.Duration
+
 	// Internal fields
-	writer io.Writer
+	lock    sync.Mutex
+	writer  io.Writer
+	written func(line string)
+
+	// pending is the number of writes which are waiting for or holding the
+	// lock.
+	pending int32
+
+	// noWait is set while writing a message which should fail rather than
+	// wait for rate limiting. The lock must be held when using it.
+	noWait bool
+
+	// buf is reused for encoding outgoing lines. The lock must be held when
+	// using it.
+	buf []byte
 }
 
 func defaultWriteCallback(w *Writer, line string) error {
-	_, err := w.RawWrite([]byte(line + "\r\n"))
+	w.buf = append(append(w.buf[:0], line...), "\r\n"...)
+	_, err := w.RawWrite(w.buf)
 	return err
 }
 
 // NewWriter creates an irc.Writer from an io.Writer.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{nil, defaultWriteCallback, w}
+	return &Writer{
+		DebugCallback: nil,
+		WriteCallback: nil,
+		WriteTimeout:  0,
+		writer:        w,
+		written:       nil,
+	}
 }
 
 // RawWrite will write the given data to the underlying connection, skipping the
@@ -53,17 +158,67 @@ func NewWriter(w io.Writer) *Writer {
 // recommended to avoid this function and use one of the other helpers. Also
 // note that it will not append \r\n to the end of the line.
 func (w *Writer) RawWrite(data []byte) (int, error) {
+	if d, ok := w.writer.(interface{ SetWriteDeadline(time.Time) error }); ok && w.WriteTimeout > 0 {
+		err := d.SetWriteDeadline(time.Now().Add(w.WriteTimeout))
+		if err != nil && !errors.Is(err, ErrDeadlineUnsupported) {
+			return 0, err
+		}
+	}
+
 	return w.writer.Write(data)
 }
 
+// SetWriteDeadline sets the deadline for writes to the underlying connection,
+// such as a net.Conn. A zero value disables the deadline. Note that if
+// WriteTimeout is set, it will replace this deadline on the next write.
+// ErrDeadlineUnsupported will be returned if the connection doesn't support
+// deadlines.
+func (w *Writer) SetWriteDeadline(t time.Time) error {
+	d, ok := w.writer.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok {
+		return ErrDeadlineUnsupported
+	}
+
+	return d.SetWriteDeadline(t)
+}
+
 // Write is a simple function which will write the given line to the
-// underlying connection.
+// underlying connection. It is safe to call from multiple goroutines; writes
+// are serialized so lines will never be interleaved. ErrUnsafeLine will be
+// returned if the line contains a CR, LF, or NUL.
 func (w *Writer) Write(line string) error {
+	if strings.ContainsAny(line, unsafeChars) {
+		return ErrUnsafeLine
+	}
+
+	atomic.AddInt32(&w.pending, 1)
+	defer atomic.AddInt32(&w.pending, -1)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.write(line)
+}
+
+// write sends a line through the callbacks. The lock must be held when calling
+// this.
+func (w *Writer) write(line string) error {
 	if w.DebugCallback != nil {
 		w.DebugCallback(line)
 	}
 
-	return w.WriteCallback(w, line)
+	var err error
+	if w.WriteCallback != nil {
+		err = w.WriteCallback(w, line)
+	} else {
+		err = defaultWriteCallback(w, line)
+	}
+
+	if err == nil && w.written != nil {
+		w.written(line)
+	}
+
+	return err
 }
 
 // Writef is a wrapper around the connection's Write method and
@@ -73,9 +228,40 @@ func (w *Writer) Writef(format string, args ...interface{}) error {
 	return w.Write(fmt.Sprintf(format, args...))
 }
 
-// WriteMessage writes the given message to the stream.
+// WriteMessage writes the given message to the stream. ErrUnsafeLine will be
+// returned if the command or any of the params contain a CR, LF, or NUL.
 func (w *Writer) WriteMessage(m *Message) error {
-	return w.Write(m.String())
+	return w.writeMessage(m, false)
+}
+
+// writeMessage is the same as WriteMessage, but noWait lets the WriteCallback
+// know that the caller doesn't want to be held up by rate limiting.
+func (w *Writer) writeMessage(m *Message, noWait bool) error {
+	atomic.AddInt32(&w.pending, 1)
+	defer atomic.AddInt32(&w.pending, -1)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.noWait = noWait
+	defer func() {
+		w.noWait = false
+	}()
+
+	w.buf = m.AppendTo(w.buf[:0])
+	if bytes.ContainsAny(w.buf, unsafeChars) {
+		return ErrUnsafeLine
+	}
+
+	// If nothing needs the line as a string, it can be written straight from
+	// the buffer.
+	if w.WriteCallback == nil && w.DebugCallback == nil && w.written == nil {
+		w.buf = append(w.buf, '\r', '\n')
+		_, err := w.RawWrite(w.buf)
+		return err
+	}
+
+	return w.write(string(w.buf))
 }
 
 // Reader is the incoming side of a connection. The data will be
@@ -86,8 +272,31 @@ type Reader struct {
 	// not be stable.
 	DebugCallback func(string)
 
+	// RecordReceiveTime will make ReadMessage record when each message was
+	// read, which is used by Message.Time when there is no server-time tag.
+	RecordReceiveTime bool
+
+	// ReuseMessages will make ReadMessage return messages from a shared pool
+	// rather than allocating a new one for each line. The caller owns each
+	// message until it calls Release on it, after which the message, its
+	// Tags, Prefix, and Params must not be used again; use Copy to keep
+	// anything longer. Pooled messages will have empty rather than nil
+	// Params. This should not be used with a Client, as the Client and its
+	// trackers may keep references to messages.
+	ReuseMessages bool
+
+	// MaxLineLength is the longest line, not including the \r\n, which
+	// ReadMessage will accept. Longer lines will return ErrLineTooLong. If it
+	// is zero or less, lines of any length will be read. NewReader sets it to
+	// 8191.
+	MaxLineLength int
+
 	// Internal fields
-	reader *bufio.Reader
+	lock       sync.Mutex
+	conn       io.Reader
+	reader     *bufio.Reader
+	skip       func(line string) bool
+	discarding bool
 }
 
 // NewReader creates an irc.Reader from an io.Reader. Note that once a reader is
@@ -96,32 +305,122 @@ type Reader struct {
 // Message being read when you call ReadMessage.
 func NewReader(r io.Reader) *Reader {
 	return &Reader{
-		nil,
-		bufio.NewReader(r),
+		DebugCallback:     nil,
+		RecordReceiveTime: false,
+		MaxLineLength:     defaultMaxLineLength,
+		conn:              r,
+		reader:            bufio.NewReader(r),
+		skip:              nil,
+	}
+}
+
+// SetReadDeadline sets the deadline for reads from the underlying connection,
+// such as a net.Conn, so a stalled connection will make ReadMessage return an
+// error rather than blocking forever. A zero value disables the deadline. It
+// is safe to call while another goroutine is blocked in ReadMessage.
+// ErrDeadlineUnsupported will be returned if the connection doesn't support
+// deadlines.
+func (r *Reader) SetReadDeadline(t time.Time) error {
+	d, ok := r.conn.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return ErrDeadlineUnsupported
 	}
+
+	return d.SetReadDeadline(t)
 }
 
 // ReadMessage returns the next message from the stream or an error.
-// It ignores empty messages.
+// It ignores empty messages. It is safe to call from multiple goroutines, but
+// each message will only be returned to one of them.
 func (r *Reader) ReadMessage() (*Message, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	var msg *Message
+	if r.ReuseMessages {
+		msg = getPooledMessage()
+	}
 
 	// It's valid for a message to be empty. Clients should ignore these,
 	// so we do to be good citizens.
 	err := ErrZeroLengthMessage
 	for errors.Is(err, ErrZeroLengthMessage) {
 		var line string
-		line, err = r.reader.ReadString('\n')
+		line, err = r.readLine()
 		if err != nil {
-			return nil, err
+			break
 		}
 
 		if r.DebugCallback != nil {
 			r.DebugCallback(line)
 		}
 
+		if r.skip != nil && r.skip(line) {
+			err = ErrZeroLengthMessage
+			continue
+		}
+
 		// Parse the message from our line
-		msg, err = ParseMessage(line)
+		if r.ReuseMessages {
+			err = msg.parse(line)
+		} else {
+			msg, err = ParseMessage(line)
+		}
 	}
+
+	if err != nil {
+		msg.Release()
+		return nil, err
+	}
+
+	if r.RecordReceiveTime {
+		msg.received = time.Now()
+	}
+
 	return msg, err
 }
+
+// readLine reads the next line, including the \r\n. If the line is longer
+// than MaxLineLength, ErrLineTooLong will be returned as soon as that is
+// known, and the rest of the line will be skipped by the next call. The lock
+// must be held when calling this.
+func (r *Reader) readLine() (string, error) {
+	if r.MaxLineLength <= 0 {
+		return r.reader.ReadString('\n')
+	}
+
+	var line []byte
+
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+
+		if r.discarding {
+			// Once we hit a newline, we're back at the start of a line.
+			r.discarding = err != nil
+			continue
+		}
+
+		// Most lines fit in the buffer, so we can skip copying them.
+		if err == nil && line == nil {
+			if len(bytes.TrimRight(chunk, "\r\n")) > r.MaxLineLength {
+				return "", ErrLineTooLong
+			}
+
+			return string(chunk), nil
+		}
+
+		line = append(line, chunk...)
+
+		if len(bytes.TrimRight(line, "\r\n")) > r.MaxLineLength {
+			r.discarding = err != nil
+			return "", ErrLineTooLong
+		}
+
+		if err == nil {
+			return string(line), nil
+		}
+	}
+}
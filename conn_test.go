@@ -4,10 +4,16 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"gopkg.in/irc.v4"
 )
@@ -114,6 +120,44 @@ func TestWriteMessageError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestWriteUnsafe(t *testing.T) {
+	t.Parallel()
+
+	rwc := newTestReadWriteCloser()
+	c := irc.NewConn(rwc)
+
+	for _, line := range []string{
+		"PRIVMSG #chan :hi\r\nQUIT",
+		"PRIVMSG #chan :hi\nQUIT",
+		"PRIVMSG #chan :hi\x00",
+	} {
+		assert.Equal(t, irc.ErrUnsafeLine, c.Write(line), line)
+	}
+
+	assert.Equal(t, irc.ErrUnsafeLine, c.Writef("PRIVMSG %s :%s", "#chan", "hi\r\nQUIT :bye"))
+	assert.Equal(t, irc.ErrUnsafeLine, c.WriteMessage(&irc.Message{
+		Command: "PRIVMSG",
+		Params:  []string{"#chan", "hi\rQUIT"},
+	}))
+	assert.Equal(t, irc.ErrUnsafeLine, c.WriteMessage(&irc.Message{
+		Command: "PRIVMSG\n",
+		Params:  []string{"#chan", "hi"},
+	}))
+
+	// Nothing should have been written.
+	assert.Equal(t, "", rwc.client.String())
+
+	// Tag values are escaped, so they are safe.
+	assert.NoError(t, c.WriteMessage(&irc.Message{
+		Tags:    irc.Tags{"+data": "a\r\nb"},
+		Command: "TAGMSG",
+		Params:  []string{"#chan"},
+	}))
+	testLines(t, rwc, []string{
+		"@+data=a\\r\\nb TAGMSG #chan",
+	})
+}
+
 func TestConn(t *testing.T) {
 	t.Parallel()
 
@@ -164,6 +208,192 @@ func TestConn(t *testing.T) {
 	assert.Equal(t, io.EOF, err, "Didn't get expected EOF")
 }
 
+func TestReuseMessages(t *testing.T) {
+	t.Parallel()
+
+	rwc := newTestReadWriteCloser()
+	c := irc.NewConn(rwc)
+	c.ReuseMessages = true
+
+	rwc.server.WriteString("@a=b :nick!user@host PRIVMSG #chan :hello world\r\n")
+	rwc.server.WriteString("\r\n")
+	rwc.server.WriteString("PING\r\n")
+	rwc.server.WriteString(":invalid_message\r\n")
+	rwc.server.WriteString(":server 001 test_nick :Welcome\r\n")
+
+	m := testReadMessage(t, c)
+	assert.Equal(t, irc.Tags{"a": "b"}, m.Tags)
+	assert.Equal(t, &irc.Prefix{Name: "nick", User: "user", Host: "host"}, m.Prefix)
+	assert.Equal(t, "PRIVMSG", m.Command)
+	assert.Equal(t, []string{"#chan", "hello world"}, m.Params)
+
+	// Copies should be unaffected by releasing the original.
+	saved := m.Copy()
+	m.Release()
+	assert.Equal(t, "PRIVMSG", saved.Command)
+	assert.Equal(t, []string{"#chan", "hello world"}, saved.Params)
+	saved.Release()
+	assert.Equal(t, "PRIVMSG", saved.Command)
+
+	m = testReadMessage(t, c)
+	assert.Empty(t, m.Tags)
+	assert.Equal(t, &irc.Prefix{}, m.Prefix)
+	assert.Equal(t, "PING", m.Command)
+	assert.Empty(t, m.Params)
+	m.Release()
+
+	_, err := c.ReadMessage()
+	assert.Equal(t, irc.ErrMissingDataAfterPrefix, err)
+
+	m = testReadMessage(t, c)
+	assert.Equal(t, "001", m.Command)
+	assert.Equal(t, []string{"test_nick", "Welcome"}, m.Params)
+	m.Release()
+
+	_, err = c.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+
+	// Releasing a message which didn't come from the pool should do
+	// nothing.
+	m = irc.MustParseMessage("PING :hello")
+	m.Release()
+	assert.Equal(t, []string{"hello"}, m.Params)
+}
+
+func TestMaxLineLength(t *testing.T) {
+	t.Parallel()
+
+	rwc := newTestReadWriteCloser()
+	c := irc.NewConn(rwc)
+
+	// The default should allow for a full set of tags, even when that's
+	// larger than the read buffer.
+	tags := "@a=" + strings.Repeat("x", 8191-len("@a= PING"))
+	rwc.server.WriteString(tags + " PING\r\n")
+	rwc.server.WriteString(tags + "x PING\r\n")
+	rwc.server.WriteString("PING :after\r\n")
+
+	m := testReadMessage(t, c)
+	assert.Equal(t, "PING", m.Command)
+
+	_, err := c.ReadMessage()
+	assert.Equal(t, irc.ErrLineTooLong, err)
+
+	m = testReadMessage(t, c)
+	assert.Equal(t, []string{"after"}, m.Params)
+
+	// A line which is too long should be reported once the read buffer is
+	// full, without waiting for a newline, then skipped once it arrives.
+	c.MaxLineLength = 10
+	rwc.server.WriteString("PRIVMSG #chan :" + strings.Repeat("a", 5000))
+
+	_, err = c.ReadMessage()
+	assert.Equal(t, irc.ErrLineTooLong, err)
+
+	rwc.server.WriteString(" and keeps going\r\nPING :ok\r\n")
+	m = testReadMessage(t, c)
+	assert.Equal(t, []string{"ok"}, m.Params)
+
+	// Disabling the limit should allow anything.
+	c.MaxLineLength = 0
+	rwc.server.WriteString(tags + "x PING\r\n")
+	m = testReadMessage(t, c)
+	assert.Equal(t, "PING", m.Command)
+}
+
+func TestDeadlines(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c := irc.NewConn(client)
+
+	// Nothing is ever sent, so the read should time out.
+	assert.NoError(t, c.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err := c.ReadMessage()
+	var netErr net.Error
+	if assert.True(t, errors.As(err, &netErr)) {
+		assert.True(t, netErr.Timeout())
+	}
+
+	// Nothing is reading from the pipe, so writes should time out.
+	c.WriteTimeout = 10 * time.Millisecond
+	err = c.Write("PING :hello")
+	if assert.True(t, errors.As(err, &netErr)) {
+		assert.True(t, netErr.Timeout())
+	}
+
+	// Once the deadline is cleared, writes should work again.
+	c.WriteTimeout = 0
+	assert.NoError(t, c.SetWriteDeadline(time.Time{}))
+
+	go func() {
+		_, _ = ioutil.ReadAll(server)
+	}()
+	assert.NoError(t, c.Write("PING :hello"))
+
+	// Connections without deadlines should return an error.
+	c = irc.NewConn(newTestReadWriteCloser())
+	assert.Equal(t, irc.ErrDeadlineUnsupported, c.SetReadDeadline(time.Now()))
+	assert.Equal(t, irc.ErrDeadlineUnsupported, c.SetWriteDeadline(time.Now()))
+
+	c.WriteTimeout = time.Second
+	assert.NoError(t, c.Write("PING :hello"))
+}
+
+func BenchmarkReadMessage(b *testing.B) {
+	for _, reuse := range []bool{false, true} {
+		reuse := reuse
+
+		name := "New"
+		if reuse {
+			name = "Reuse"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			r := irc.NewReader(strings.NewReader(strings.Repeat(benchmarkLine+"\r\n", b.N)))
+			r.ReuseMessages = reuse
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				m, err := r.ReadMessage()
+				if err != nil {
+					b.Fatal(err)
+				}
+				m.Release()
+			}
+		})
+	}
+}
+
+func BenchmarkWriteMessage(b *testing.B) {
+	m := irc.MustParseMessage(benchmarkLine)
+
+	b.Run("String", func(b *testing.B) {
+		w := irc.NewWriter(ioutil.Discard)
+
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			_ = w.Write(m.String())
+		}
+	})
+
+	b.Run("WriteMessage", func(b *testing.B) {
+		w := irc.NewWriter(ioutil.Discard)
+
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			_ = w.WriteMessage(m)
+		}
+	})
+}
+
 func TestDebugCallback(t *testing.T) {
 	t.Parallel()
 
@@ -190,3 +420,125 @@ func TestDebugCallback(t *testing.T) {
 	assert.True(t, readerHit)
 	assert.True(t, writerHit)
 }
+
+// TestWriteMessageAllocs can't run in parallel because AllocsPerRun counts
+// allocations from every goroutine.
+func TestWriteMessageAllocs(t *testing.T) {
+	m := irc.MustParseMessage(benchmarkLine)
+	buf := &bytes.Buffer{}
+	w := irc.NewWriter(buf)
+
+	// Without any callbacks, nothing should need to be allocated once the
+	// Writer's buffer has grown.
+	require.NoError(t, w.WriteMessage(m))
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		_ = w.WriteMessage(m)
+	})
+	assert.Zero(t, allocs)
+	assert.Equal(t, m.String()+"\r\n", buf.String())
+
+	// Callbacks should still be given the line.
+	var lines []string
+	w.WriteCallback = func(w *irc.Writer, line string) error {
+		lines = append(lines, line)
+		return nil
+	}
+	require.NoError(t, w.WriteMessage(m))
+	assert.Equal(t, []string{m.String()}, lines)
+}
+
+func TestSuppressEcho(t *testing.T) {
+	t.Parallel()
+
+	rwc := newTestReadWriteCloser()
+	c := irc.NewConn(rwc)
+	c.SuppressEcho(2)
+
+	assert.NoError(t, c.Write("PRIVMSG #a :one"))
+	assert.NoError(t, c.Write("PRIVMSG #a :two"))
+	assert.NoError(t, c.Write("PRIVMSG #a :three"))
+
+	// "one" has fallen out of the window, so it should be passed through, but
+	// the others should be dropped.
+	rwc.server.WriteString("PRIVMSG #a :one\r\nPRIVMSG #a :two\r\nPRIVMSG #a :three\r\nPRIVMSG #a :two\r\n")
+
+	m := testReadMessage(t, c)
+	assert.Equal(t, "one", m.Trailing())
+
+	// Each written line should only be suppressed once.
+	m = testReadMessage(t, c)
+	assert.Equal(t, "two", m.Trailing())
+
+	_, err := c.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+
+	// Disabling it should pass everything through.
+	c.SuppressEcho(0)
+	assert.NoError(t, c.Write("PRIVMSG #a :four"))
+	rwc.server.WriteString("PRIVMSG #a :four\r\n")
+	m = testReadMessage(t, c)
+	assert.Equal(t, "four", m.Trailing())
+}
+
+func TestConcurrentConn(t *testing.T) {
+	t.Parallel()
+
+	const count = 50
+
+	buf := &bytes.Buffer{}
+	w := irc.NewWriter(buf)
+
+	// Write each line in pieces so any interleaving would show up.
+	w.WriteCallback = func(w *irc.Writer, line string) error {
+		for _, piece := range []string{line[:4], line[4:], "\r\n"} {
+			_, err := w.RawWrite([]byte(piece))
+			if err != nil {
+				return err
+			}
+			runtime.Gosched()
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, w.Write("PRIVMSG #chan :hello world"))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, strings.Repeat("PRIVMSG #chan :hello world\r\n", count), buf.String())
+
+	r := irc.NewReader(buf)
+
+	var lock sync.Mutex
+	var messages []*irc.Message
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				m, err := r.ReadMessage()
+				if err != nil {
+					assert.Equal(t, io.EOF, err)
+					return
+				}
+
+				lock.Lock()
+				messages = append(messages, m)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, messages, count)
+	for _, m := range messages {
+		assert.Equal(t, "hello world", m.Trailing())
+	}
+}
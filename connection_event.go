@@ -0,0 +1,70 @@
+package irc
+
+import "time"
+
+// ConnectionEventType is the kind of a ConnectionEvent.
+type ConnectionEventType int
+
+// These are the connection state changes reported by ConnectionEvent.
+const (
+	// ConnectionConnecting is sent by ReconnectingClient before each
+	// connection attempt.
+	ConnectionConnecting ConnectionEventType = iota
+
+	// ConnectionConnected is sent by ReconnectingClient once the connection
+	// has been opened, before registration.
+	ConnectionConnected
+
+	// ConnectionRegistered is sent when the server accepts our registration
+	// with RPL_WELCOME (001). This is when it's safe to start joining
+	// channels.
+	ConnectionRegistered
+
+	// ConnectionDisconnected is sent when Run returns, or by
+	// ReconnectingClient when a connection attempt fails. Err is why.
+	ConnectionDisconnected
+)
+
+// String returns the name of the event type, such as "registered".
+func (t ConnectionEventType) String() string {
+	switch t {
+	case ConnectionConnecting:
+		return "connecting"
+	case ConnectionConnected:
+		return "connected"
+	case ConnectionRegistered:
+		return "registered"
+	case ConnectionDisconnected:
+		return "disconnected"
+	}
+
+	return "unknown"
+}
+
+// ConnectionEvent describes a change in the state of a connection.
+type ConnectionEvent struct {
+	Type ConnectionEventType
+
+	// Client is the client for this connection. It is nil if the connection
+	// was never opened.
+	Client *Client
+
+	// Err is why the connection was closed, for ConnectionDisconnected.
+	Err error
+
+	// Attempt is how many connection attempts in a row have failed before
+	// this one. It is only set by ReconnectingClient.
+	Attempt int
+
+	// RetryIn is how long until the next connection attempt, for
+	// ConnectionDisconnected. It is only set by ReconnectingClient.
+	RetryIn time.Duration
+}
+
+// emitConnectionEvent passes the event to the OnConnectionEvent callback if
+// there is one.
+func (c *Client) emitConnectionEvent(event ConnectionEvent) {
+	if c.config.OnConnectionEvent != nil {
+		c.config.OnConnectionEvent(event)
+	}
+}
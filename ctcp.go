@@ -0,0 +1,146 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ctcpDelim marks the start and end of a CTCP message.
+const ctcpDelim = "\x01"
+
+// CTCP represents a single CTCP query or reply, such as VERSION, PING, TIME,
+// ACTION, or DCC, which has been sent as part of a PRIVMSG or NOTICE.
+type CTCP struct {
+	// Command is the CTCP command, such as VERSION. It is always uppercase.
+	Command string
+
+	// Params is everything after the command, which may be empty.
+	Params string
+
+	// Reply is true if this was sent in a NOTICE, which is used for replies
+	// to queries.
+	Reply bool
+}
+
+// ParseCTCP checks if a message is a CTCP query or reply and parses it if so.
+// The bool will be false for anything other than a PRIVMSG or NOTICE with a
+// CTCP payload. A missing closing \x01 is allowed, as some clients don't send
+// it.
+func ParseCTCP(m *Message) (*CTCP, bool) {
+	if m.Command != "PRIVMSG" && m.Command != "NOTICE" || len(m.Params) != 2 {
+		return nil, false
+	}
+
+	text := m.Params[1]
+	if !strings.HasPrefix(text, ctcpDelim) {
+		return nil, false
+	}
+
+	text = strings.TrimSuffix(text[1:], ctcpDelim)
+	if text == "" {
+		return nil, false
+	}
+
+	ret := &CTCP{Reply: m.Command == "NOTICE"}
+
+	if i := strings.IndexByte(text, ' '); i != -1 {
+		ret.Command = text[:i]
+		ret.Params = text[i+1:]
+	} else {
+		ret.Command = text
+	}
+
+	ret.Command = strings.ToUpper(ret.Command)
+
+	return ret, true
+}
+
+// Args splits the Params on spaces. This is useful for commands with multiple
+// arguments, such as DCC.
+func (c *CTCP) Args() []string {
+	return strings.Fields(c.Params)
+}
+
+// String encodes the CTCP so it can be used as the text of a PRIVMSG or
+// NOTICE.
+func (c *CTCP) String() string {
+	return encodeCTCP(c.Command, c.Params)
+}
+
+func encodeCTCP(command, params string) string {
+	if params == "" {
+		return ctcpDelim + command + ctcpDelim
+	}
+
+	return ctcpDelim + command + " " + params + ctcpDelim
+}
+
+// NewCTCPQuery creates a PRIVMSG which sends a CTCP query to the given target.
+// The params may be empty.
+func NewCTCPQuery(target, command, params string) *Message {
+	return &Message{
+		Command: "PRIVMSG",
+		Params:  []string{target, encodeCTCP(command, params)},
+	}
+}
+
+// NewCTCPReply creates a NOTICE which sends a CTCP reply to the given target.
+// The params may be empty.
+func NewCTCPReply(target, command, params string) *Message {
+	return &Message{
+		Command: "NOTICE",
+		Params:  []string{target, encodeCTCP(command, params)},
+	}
+}
+
+// CTCP sends a CTCP query to the given target.
+func (c *Client) CTCP(target, command, params string) error {
+	return c.WriteMessage(NewCTCPQuery(target, command, params))
+}
+
+// CTCPReply sends a CTCP reply to the given target.
+func (c *Client) CTCPReply(target, command, params string) error {
+	return c.WriteMessage(NewCTCPReply(target, command, params))
+}
+
+// Action sends a CTCP ACTION, which most clients show as "* nick text", like
+// the /me command.
+func (c *Client) Action(target, format string, args ...interface{}) error {
+	return c.CTCP(target, "ACTION", fmt.Sprintf(format, args...))
+}
+
+// IsAction returns true if this is a PRIVMSG containing a CTCP ACTION.
+func (m *Message) IsAction() bool {
+	if m.Command != "PRIVMSG" {
+		return false
+	}
+
+	ctcp, ok := ParseCTCP(m)
+	return ok && ctcp.Command == "ACTION"
+}
+
+// ActionText returns the text of a CTCP ACTION without the CTCP quoting. It
+// will return an empty string if this isn't an action.
+func (m *Message) ActionText() string {
+	if !m.IsAction() {
+		return ""
+	}
+
+	ctcp, _ := ParseCTCP(m)
+	return ctcp.Params
+}
+
+// CTCPHandler is an interface for handling CTCP queries and replies separately
+// from other messages. See ClientConfig.CTCPHandler.
+type CTCPHandler interface {
+	HandleCTCP(c *Client, m *Message, ctcp *CTCP)
+}
+
+// CTCPHandlerFunc is a simple wrapper around a function which allows it to be
+// used as a CTCPHandler.
+type CTCPHandlerFunc func(c *Client, m *Message, ctcp *CTCP)
+
+// HandleCTCP calls f(c, m, ctcp).
+func (f CTCPHandlerFunc) HandleCTCP(c *Client, m *Message, ctcp *CTCP) {
+	f(c, m, ctcp)
+}
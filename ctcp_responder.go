@@ -0,0 +1,150 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCTCPInterval is used when CTCPResponder.Interval is zero.
+const defaultCTCPInterval = 5 * time.Second
+
+// CTCPResponder configures automatic replies to common CTCP queries. See
+// ClientConfig.CTCPResponder.
+type CTCPResponder struct {
+	// Version is sent in reply to VERSION. If it is empty, VERSION will not be
+	// answered.
+	Version string
+
+	// Ping enables replying to PING with the same params.
+	Ping bool
+
+	// TimeFormat is the time layout used to reply to TIME. If it is empty,
+	// TIME will not be answered.
+	TimeFormat string
+
+	// ClientInfo is sent in reply to CLIENTINFO. If it is empty, the list of
+	// queries which will be answered is sent.
+	ClientInfo string
+
+	// Interval is the minimum time between replies to the same host, so
+	// queries can't be used to make the client flood itself off the server.
+	// Queries sent more often are ignored. If it is zero, 5 seconds will be
+	// used.
+	Interval time.Duration
+}
+
+// reply returns the reply for a query, if there is one.
+func (r *CTCPResponder) reply(ctcp *CTCP) (string, bool) {
+	switch ctcp.Command {
+	case "VERSION":
+		return r.Version, r.Version != ""
+	case "PING":
+		return ctcp.Params, r.Ping
+	case "TIME":
+		if r.TimeFormat == "" {
+			return "", false
+		}
+		return time.Now().Format(r.TimeFormat), true
+	case "CLIENTINFO":
+		if r.ClientInfo != "" {
+			return r.ClientInfo, true
+		}
+		return r.supported(), true
+	}
+
+	return "", false
+}
+
+// supported returns the queries this responder will answer, separated by
+// spaces.
+func (r *CTCPResponder) supported() string {
+	ret := []string{"CLIENTINFO"}
+	if r.Version != "" {
+		ret = append(ret, "VERSION")
+	}
+	if r.Ping {
+		ret = append(ret, "PING")
+	}
+	if r.TimeFormat != "" {
+		ret = append(ret, "TIME")
+	}
+
+	sort.Strings(ret)
+
+	return strings.Join(ret, " ")
+}
+
+// ctcpLimiter keeps track of when we last replied to each host.
+type ctcpLimiter struct {
+	sync.Mutex
+
+	interval time.Duration
+	last     map[string]time.Time
+}
+
+func newCTCPLimiter(interval time.Duration) *ctcpLimiter {
+	if interval <= 0 {
+		interval = defaultCTCPInterval
+	}
+
+	return &ctcpLimiter{
+		interval: interval,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// allow returns true if we haven't replied to the given key within the
+// interval, and records the reply if so.
+func (l *ctcpLimiter) allow(key string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+
+	// Drop anything which has expired so the map doesn't grow forever.
+	for k, last := range l.last {
+		if now.Sub(last) >= l.interval {
+			delete(l.last, k)
+		}
+	}
+
+	l.last[key] = now
+
+	return true
+}
+
+// handleCTCPQuery answers CTCP queries if ClientConfig.CTCPResponder is set.
+func handleCTCPQuery(c *Client, m *Message) {
+	if c.ctcpLimiter == nil || m.Prefix == nil || c.IsSelf(m) {
+		return
+	}
+
+	ctcp, ok := ParseCTCP(m)
+	if !ok || ctcp.Reply {
+		return
+	}
+
+	reply, ok := c.config.CTCPResponder.reply(ctcp)
+	if !ok {
+		return
+	}
+
+	// Limit by host where possible, so changing nicks doesn't get around
+	// the limit.
+	key := m.Prefix.Host
+	if key == "" {
+		key = m.Prefix.Name
+	}
+
+	if !c.ctcpLimiter.allow(key) {
+		return
+	}
+
+	_ = c.CTCPReply(m.Prefix.Name, ctcp.Command, reply)
+}
@@ -0,0 +1,138 @@
+package irc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestParseCTCP(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct {
+		Line     string
+		Expected *irc.CTCP
+	}{
+		{":a!b@c PRIVMSG nick :\x01VERSION\x01", &irc.CTCP{Command: "VERSION"}},
+		{":a!b@c PRIVMSG nick :\x01ping 1234\x01", &irc.CTCP{Command: "PING", Params: "1234"}},
+		{":a!b@c NOTICE nick :\x01VERSION irccat 1.0\x01", &irc.CTCP{Command: "VERSION", Params: "irccat 1.0", Reply: true}},
+		{":a!b@c PRIVMSG #chan :\x01ACTION waves", &irc.CTCP{Command: "ACTION", Params: "waves"}},
+		{":a!b@c PRIVMSG nick :hello", nil},
+		{":a!b@c PRIVMSG nick :\x01\x01", nil},
+		{":a!b@c TOPIC #chan :\x01VERSION\x01", nil},
+	}
+
+	for _, testCase := range testCases {
+		ctcp, ok := irc.ParseCTCP(irc.MustParseMessage(testCase.Line))
+		assert.Equal(t, testCase.Expected != nil, ok, testCase.Line)
+		assert.Equal(t, testCase.Expected, ctcp, testCase.Line)
+	}
+
+	ctcp, ok := irc.ParseCTCP(irc.MustParseMessage(":a!b@c PRIVMSG nick :\x01DCC SEND file.txt 3232235777 5000 1024\x01"))
+	require.True(t, ok)
+	assert.Equal(t, []string{"SEND", "file.txt", "3232235777", "5000", "1024"}, ctcp.Args())
+	assert.Equal(t, "\x01DCC SEND file.txt 3232235777 5000 1024\x01", ctcp.String())
+}
+
+func TestCTCPBuilders(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "PRIVMSG nick \x01VERSION\x01", irc.NewCTCPQuery("nick", "VERSION", "").String())
+	assert.Equal(t, "NOTICE nick :\x01PING 1234\x01", irc.NewCTCPReply("nick", "PING", "1234").String())
+}
+
+func TestCTCPAction(t *testing.T) {
+	t.Parallel()
+
+	m := irc.MustParseMessage(":a!b@c PRIVMSG #chan :\x01ACTION waves at everyone\x01")
+	assert.True(t, m.IsAction())
+	assert.Equal(t, "waves at everyone", m.ActionText())
+
+	for _, line := range []string{
+		":a!b@c PRIVMSG #chan :waves",
+		":a!b@c PRIVMSG #chan :\x01VERSION\x01",
+		":a!b@c NOTICE #chan :\x01ACTION waves\x01",
+	} {
+		m := irc.MustParseMessage(line)
+		assert.False(t, m.IsAction(), line)
+		assert.Equal(t, "", m.ActionText(), line)
+	}
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{Nick: "test_nick"})
+	require.NoError(t, ht.Client.Action("#chan", "waves at %s", "everyone"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :\x01ACTION waves at everyone\x01"))
+}
+
+func TestCTCPHandler(t *testing.T) {
+	t.Parallel()
+
+	var ctcps []*irc.CTCP
+	handler := &TestHandler{}
+	ht := irc.NewHandlerTester(handler, irc.ClientConfig{
+		Nick: "test_nick",
+		CTCPHandler: irc.CTCPHandlerFunc(func(c *irc.Client, m *irc.Message, ctcp *irc.CTCP) {
+			ctcps = append(ctcps, ctcp)
+			if !ctcp.Reply {
+				_ = c.CTCPReply(m.Prefix.Name, ctcp.Command, "test")
+			}
+		}),
+	})
+
+	require.NoError(t, ht.Feed(
+		":a!b@c PRIVMSG test_nick :\x01VERSION\x01",
+		":a!b@c NOTICE test_nick :\x01PING 1234\x01",
+		":a!b@c PRIVMSG #chan :\x01ACTION waves\x01",
+		":a!b@c PRIVMSG #chan :hello",
+	))
+
+	assert.Equal(t, []*irc.CTCP{
+		{Command: "VERSION"},
+		{Command: "PING", Params: "1234", Reply: true},
+	}, ctcps)
+	assert.NoError(t, ht.ExpectWrites("NOTICE a :\x01VERSION test\x01"))
+
+	// ACTION and normal messages should still go to the Handler.
+	var trailing []string
+	for _, m := range handler.Messages() {
+		trailing = append(trailing, m.Trailing())
+	}
+	assert.Equal(t, []string{"\x01ACTION waves\x01", "hello"}, trailing)
+}
+
+func TestCTCPResponder(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{
+		Nick: "test_nick",
+		CTCPResponder: &irc.CTCPResponder{
+			Version:  "test 1.0",
+			Ping:     true,
+			Interval: time.Hour,
+		},
+	})
+
+	require.NoError(t, ht.Feed(
+		":a!u@a.host PRIVMSG test_nick :\x01VERSION\x01",
+		":b!u@b.host PRIVMSG #chan :\x01PING 1234\x01",
+		":c!u@c.host PRIVMSG test_nick :\x01CLIENTINFO\x01",
+		// TIME isn't enabled and replies shouldn't be answered.
+		":d!u@d.host PRIVMSG test_nick :\x01TIME\x01",
+		":e!u@e.host NOTICE test_nick :\x01VERSION other\x01",
+		// Hosts which were already answered should be ignored, even with a
+		// different nick.
+		":a!u@a.host PRIVMSG test_nick :\x01VERSION\x01",
+		":other!u@a.host PRIVMSG test_nick :\x01PING 5678\x01",
+		// Our own queries shouldn't be answered, whatever case the nick is in.
+		":Test_Nick!u@me.host PRIVMSG test_nick :\x01VERSION\x01",
+	))
+
+	assert.NoError(t, ht.ExpectWrites(
+		"NOTICE a :\x01VERSION test 1.0\x01",
+		"NOTICE b :\x01PING 1234\x01",
+		"NOTICE c :\x01CLIENTINFO CLIENTINFO PING VERSION\x01",
+	))
+}
@@ -0,0 +1,197 @@
+package irc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Default ports used by the Dial helpers when the address doesn't include
+// one.
+const (
+	defaultPort    = "6667"
+	defaultTLSPort = "6697"
+)
+
+// Dial connects to the server at addr over plaintext TCP and returns a Client
+// using the given config. If addr doesn't include a port, 6667 is used. Run or
+// Connect still need to be called to register with the server.
+func Dial(addr string, config ClientConfig) (*Client, error) {
+	return DialContext(context.Background(), addr, nil, config)
+}
+
+// DialTLS connects to the server at addr over TLS and returns a Client using
+// the given config. If addr doesn't include a port, 6697 is used. If
+// tlsConfig is nil or doesn't set a ServerName, the host from addr will be
+// used to verify the server's certificate.
+func DialTLS(addr string, tlsConfig *tls.Config, config ClientConfig) (*Client, error) {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{} //nolint:exhaustruct
+	}
+
+	return DialContext(context.Background(), addr, tlsConfig, config)
+}
+
+// DialContext is like Dial, or DialTLS if tlsConfig is not nil, but the
+// context can be used to cancel connecting. The context is only used while
+// connecting; once DialContext returns, it has no effect on the Client.
+func DialContext(ctx context.Context, addr string, tlsConfig *tls.Config, config ClientConfig) (*Client, error) {
+	d := &Dialer{TLSConfig: tlsConfig} //nolint:exhaustruct
+	return d.DialContext(ctx, addr, config)
+}
+
+// Dialer holds the options for connecting to a server. The zero value
+// connects directly over plaintext TCP.
+type Dialer struct {
+	// TLSConfig enables TLS if it is not nil. If it doesn't set a ServerName,
+	// the host being connected to will be used.
+	TLSConfig *tls.Config
+
+	// Proxy is used to open the connection if it is set, such as a
+	// SOCKS5Dialer or a golang.org/x/net/proxy.Dialer. TLS is still handled
+	// by the Dialer, so it is end to end.
+	Proxy ProxyDialer
+
+	// STSStore enables support for the sts cap if it is set. Policies from
+	// servers are saved in it, and plaintext connections to any server with
+	// a saved policy will use TLS instead. If a server advertises a policy
+	// on a plaintext connection, Run will return an STSUpgradeError so the
+	// caller can reconnect with TLS.
+	STSStore Store
+}
+
+// ProxyDialer opens connections through a proxy. It matches
+// golang.org/x/net/proxy.Dialer. If it also has a DialContext method, like
+// golang.org/x/net/proxy.ContextDialer, that will be used instead of Dial.
+type ProxyDialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// contextDialer is implemented by dialers which support contexts, including
+// net.Dialer.
+type contextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Dial connects to the server at addr and returns a Client using the given
+// config. If addr doesn't include a port, 6667 or 6697 is used depending on
+// whether TLS is enabled.
+func (d *Dialer) Dial(addr string, config ClientConfig) (*Client, error) {
+	return d.DialContext(context.Background(), addr, config)
+}
+
+// DialContext is like Dial, but the context can be used to cancel connecting.
+// The context is only used while connecting; once DialContext returns, it has
+// no effect on the Client.
+func (d *Dialer) DialContext(ctx context.Context, addr string, config ClientConfig) (*Client, error) {
+	conn, sts, err := d.dialConn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewClient(conn, config)
+	c.sts = sts
+
+	return c, nil
+}
+
+// dialConn opens a connection to addr, performing the TLS handshake if
+// TLSConfig is not nil or there is an STS policy for the host. If STS is
+// enabled, the state the Client needs for it is also returned.
+func (d *Dialer) dialConn(ctx context.Context, addr string) (net.Conn, *stsState, error) {
+	tlsConfig := d.TLSConfig
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// There's no easy way to check why SplitHostPort failed, so we
+		// assume the port is missing and let Dial report anything else.
+		port = defaultPort
+		if tlsConfig != nil {
+			port = defaultTLSPort
+		}
+
+		host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		addr = net.JoinHostPort(host, port)
+	}
+
+	if tlsConfig == nil && d.STSStore != nil {
+		if stsPort, ok := lookupSTSPolicy(d.STSStore, host); ok {
+			tlsConfig = &tls.Config{} //nolint:exhaustruct
+			port = strconv.Itoa(stsPort)
+			addr = net.JoinHostPort(host, port)
+		}
+	}
+
+	var dialer contextDialer = &net.Dialer{} //nolint:exhaustruct
+	if cd, ok := d.Proxy.(contextDialer); ok {
+		dialer = cd
+	} else if d.Proxy != nil {
+		dialer = proxyContextDialer{d.Proxy}
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sts *stsState
+	if d.STSStore != nil {
+		portNum, _ := strconv.Atoi(port)
+		sts = &stsState{store: d.STSStore, host: host, port: portNum, secure: tlsConfig != nil}
+	}
+
+	if tlsConfig == nil {
+		return conn, sts, nil
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+
+	err = runWithContext(ctx, conn, tlsConn.Handshake)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return tlsConn, sts, nil
+}
+
+// proxyContextDialer adds context support to a ProxyDialer which doesn't
+// have it. The context is only checked before dialing.
+type proxyContextDialer struct {
+	ProxyDialer
+}
+
+func (d proxyContextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return d.Dial(network, addr)
+}
+
+// runWithContext runs f, which should be something like a handshake on conn,
+// giving up if the context is canceled first. The connection is closed if
+// the context is canceled.
+func runWithContext(ctx context.Context, conn net.Conn, f func() error) error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- f()
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		// Closing the connection will make f return.
+		conn.Close()
+		<-errChan
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,124 @@
+package irc_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+// acceptLine accepts a single connection on l and sends the first line read
+// from it.
+func acceptLine(t *testing.T, l net.Listener) <-chan string {
+	t.Helper()
+
+	lines := make(chan string, 1)
+	go func() {
+		defer close(lines)
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+
+	return lines
+}
+
+// newTestTLSListener starts a TLS listener on 127.0.0.1 and returns it along
+// with a pool which can be used to verify its certificate.
+func newTestTLSListener(t *testing.T) (net.Listener, *x509.CertPool) {
+	t.Helper()
+
+	// Borrow the test certificate from httptest, which is valid for
+	// 127.0.0.1.
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	serverConfig := srv.TLS
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	srv.Close()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+
+	return l, roots
+}
+
+func TestDial(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	lines := acceptLine(t, l)
+
+	c, err := irc.Dial(l.Addr().String(), irc.ClientConfig{Nick: "test_nick"})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Write("PING :hello"))
+	assert.Equal(t, "PING :hello\r\n", <-lines)
+}
+
+func TestDialTLS(t *testing.T) {
+	t.Parallel()
+
+	l, roots := newTestTLSListener(t)
+	defer l.Close()
+
+	lines := acceptLine(t, l)
+
+	c, err := irc.DialTLS(l.Addr().String(), &tls.Config{RootCAs: roots}, irc.ClientConfig{Nick: "test_nick"}) //nolint:gosec
+	require.NoError(t, err)
+
+	require.NoError(t, c.Write("PING :hello"))
+	assert.Equal(t, "PING :hello\r\n", <-lines)
+
+	// Without the test root, the certificate should be rejected.
+	_ = acceptLine(t, l)
+	_, err = irc.DialTLS(l.Addr().String(), nil, irc.ClientConfig{Nick: "test_nick"})
+	assert.Error(t, err)
+}
+
+func TestDialContext(t *testing.T) {
+	t.Parallel()
+
+	// This server never completes the TLS handshake, so the context should
+	// be what ends it.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		<-done
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = irc.DialContext(ctx, l.Addr().String(), &tls.Config{}, irc.ClientConfig{Nick: "test_nick"}) //nolint:gosec
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,111 @@
+package irc
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// dispatcher runs Handler calls on a pool of worker goroutines. If it is
+// ordered, each worker has its own queue and messages are given to a worker
+// based on their target, so messages for the same target are handled in
+// order.
+type dispatcher struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+func newDispatcher(workers, queueSize int, ordered bool) *dispatcher {
+	d := &dispatcher{} //nolint:exhaustruct
+
+	queues := 1
+	if ordered {
+		queues = workers
+	}
+
+	for i := 0; i < queues; i++ {
+		d.queues = append(d.queues, make(chan func(), queueSize))
+	}
+
+	for i := 0; i < workers; i++ {
+		queue := d.queues[i%queues]
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+
+			for fn := range queue {
+				fn()
+			}
+		}()
+	}
+
+	return d
+}
+
+// dispatch queues fn to be run by a worker, blocking if the queue is full.
+func (d *dispatcher) dispatch(key string, fn func()) {
+	queue := d.queues[0]
+	if len(d.queues) > 1 {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		queue = d.queues[h.Sum32()%uint32(len(d.queues))]
+	}
+
+	queue <- fn
+}
+
+// stop waits for everything which has been queued to be handled, then stops
+// the workers.
+func (d *dispatcher) stop() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+
+	d.wg.Wait()
+}
+
+// dispatchKey returns what messages are ordered by: the channel for channel
+// messages, and the sender for everything else.
+func (c *Client) dispatchKey(m *Message) string {
+	if c.FromChannel(m) {
+		return c.foldNick(m.Params[0])
+	}
+
+	if m.Prefix != nil {
+		return c.foldNick(m.Prefix.Name)
+	}
+
+	return ""
+}
+
+// dispatch calls fn, which passes a message to the Handler, on a worker
+// goroutine if Workers is set. Otherwise, it's called right away.
+func (c *Client) dispatch(m *Message, fn func()) {
+	if c.config.Workers <= 0 {
+		fn()
+		return
+	}
+
+	if c.dispatcher == nil {
+		c.dispatcher = newDispatcher(c.config.Workers, c.config.WorkerQueue, c.config.OrderByTarget)
+	}
+
+	if c.config.RecoverPanics {
+		inner := fn
+		fn = func() {
+			defer c.recoverPanic(m)
+			inner()
+		}
+	}
+
+	c.dispatcher.dispatch(c.dispatchKey(m), fn)
+}
+
+// stopDispatcher waits for any queued messages to be handled and stops the
+// workers.
+func (c *Client) stopDispatcher() {
+	if c.dispatcher != nil {
+		c.dispatcher.stop()
+		c.dispatcher = nil
+	}
+}
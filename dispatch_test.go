@@ -0,0 +1,132 @@
+package irc_test
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestWorkers(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+
+	var lock sync.Mutex
+	var handled []string
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		Workers:       4,
+		WorkerQueue:   10,
+		OrderByTarget: true,
+
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command != "PRIVMSG" {
+				return
+			}
+
+			if m.Param(0) == "#slow" {
+				<-release
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			handled = append(handled, m.Param(0)+" "+m.Trailing())
+		}),
+	}
+
+	// A slow handler shouldn't hold up PINGs or other targets.
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine(":nick!user@host PRIVMSG #slow :1\r\n"),
+		SendLine(":nick!user@host PRIVMSG #slow :2\r\n"),
+		SendLine("PING :hello\r\n"),
+		ExpectLine("PONG hello\r\n"),
+		SendFunc(func() string {
+			close(release)
+			return ":nick!user@host PRIVMSG #slow :3\r\n"
+		}),
+	})
+
+	// Everything queued is handled before Run returns, and messages for the
+	// same target stay in order.
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Equal(t, []string{"#slow 1", "#slow 2", "#slow 3"}, handled)
+}
+
+func TestWorkersOrderByTarget(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	fastDone := make(chan struct{})
+
+	var lock sync.Mutex
+	var handled []string
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		Workers:       4,
+		WorkerQueue:   10,
+		OrderByTarget: true,
+
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command != "PRIVMSG" {
+				return
+			}
+
+			if m.Param(0) == "#slow" {
+				<-release
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			handled = append(handled, m.Param(0)+" "+m.Trailing())
+
+			if m.Trailing() == "b" {
+				close(fastDone)
+			}
+		}),
+	}
+
+	// #slow and #fast hash to different workers, so #fast can be handled
+	// while #slow is blocked, but each one stays in order.
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine(":nick!user@host PRIVMSG #slow :1\r\n"),
+		SendLine(":nick!user@host PRIVMSG #fast :a\r\n"),
+		SendLine(":nick!user@host PRIVMSG #slow :2\r\n"),
+		SendLine(":nick!user@host PRIVMSG #fast :b\r\n"),
+		SendFunc(func() string {
+			select {
+			case <-fastDone:
+			case <-time.After(time.Second):
+				t.Error("#fast was held up by #slow")
+			}
+
+			close(release)
+			return ":nick!user@host PRIVMSG #slow :3\r\n"
+		}),
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Equal(t, []string{"#fast a", "#fast b", "#slow 1", "#slow 2", "#slow 3"}, handled)
+}
@@ -0,0 +1,199 @@
+package irc
+
+import (
+	"sort"
+)
+
+// FeatureMatrixVersion is incremented whenever the list returned by
+// SupportedFeatures changes.
+const FeatureMatrixVersion = 25
+
+// FeatureKind describes what type of protocol feature a Feature is.
+type FeatureKind string
+
+// These are the different kinds of features which may be returned from
+// SupportedFeatures.
+const (
+	FeatureCap      FeatureKind = "cap"
+	FeatureISupport FeatureKind = "isupport"
+	FeatureCommand  FeatureKind = "command"
+	FeatureTag      FeatureKind = "tag"
+)
+
+// Feature describes a single protocol feature this library understands.
+type Feature struct {
+	// Kind is what type of feature this is.
+	Kind FeatureKind
+
+	// Name is the name of the cap, ISUPPORT token, command or numeric, or
+	// message tag.
+	Name string
+
+	// Component is the part of the library which handles this feature, such
+	// as Client, ISupportTracker, or Tracker.
+	Component string
+
+	// Since is the FeatureMatrixVersion this feature was first listed in.
+	Since int
+}
+
+// FeatureMatrix is the full list of features understood by this library.
+type FeatureMatrix struct {
+	// Version is the FeatureMatrixVersion of this list.
+	Version int
+
+	// Features is sorted by Kind, then Name, then Component.
+	Features []Feature
+}
+
+// features is the source of truth for SupportedFeatures. It should be updated
+// along with FeatureMatrixVersion whenever support for something is added,
+// with the new version as the Since of any new entries.
+var features = []Feature{
+	{FeatureCap, "account-notify", "Tracker", 11},
+	{FeatureCap, "account-tag", "Tracker", 11},
+	{FeatureCap, "batch", "Client", 4},
+	{FeatureCap, "cap-notify", "Client", 1},
+	{FeatureCap, "chghost", "Tracker", 13},
+	{FeatureCap, "draft/chathistory", "Client", 5},
+	{FeatureCap, "draft/message-redaction", "Client", 6},
+	{FeatureCap, "draft/multiline", "Client", 22},
+	{FeatureCap, "draft/resume-0.5", "Client", 9},
+	{FeatureCap, "echo-message", "Client", 7},
+	{FeatureCap, "extended-join", "Tracker", 12},
+	{FeatureCap, "message-tags", "Client", 2},
+	{FeatureCap, "multi-prefix", "Tracker", 1},
+	{FeatureCap, "sasl", "Client", 1},
+	{FeatureCap, "server-time", "Message", 3},
+	{FeatureCap, "setname", "Tracker", 13},
+	{FeatureCap, "sts", "Dialer", 23},
+
+	{FeatureISupport, "AWAYLEN", "Client", 1},
+	{FeatureISupport, "BOT", "Client", 1},
+	{FeatureISupport, "BOT", "Tracker", 1},
+	{FeatureISupport, "CASEMAPPING", "ISupportTracker", 19},
+	{FeatureISupport, "CHANLIMIT", "Client", 1},
+	{FeatureISupport, "CHANMODES", "ISupportTracker", 20},
+	{FeatureISupport, "CHANMODES", "Tracker", 14},
+	{FeatureISupport, "CHANNELLEN", "ISupportTracker", 19},
+	{FeatureISupport, "CHATHISTORY", "Client", 5},
+	{FeatureISupport, "KICKLEN", "Client", 1},
+	{FeatureISupport, "MAXTARGETS", "ISupportTracker", 1},
+	{FeatureISupport, "MODES", "ISupportTracker", 19},
+	{FeatureISupport, "MONITOR", "Monitor", 8},
+	{FeatureISupport, "NETWORK", "Client", 1},
+	{FeatureISupport, "NICKLEN", "ISupportTracker", 19},
+	{FeatureISupport, "PREFIX", "ISupportTracker", 1},
+	{FeatureISupport, "PREFIX", "Tracker", 14},
+	{FeatureISupport, "QUITLEN", "Client", 1},
+	{FeatureISupport, "TARGMAX", "ISupportTracker", 1},
+	{FeatureISupport, "WATCH", "Monitor", 10},
+	{FeatureISupport, "WHOX", "Client", 18},
+
+	{FeatureCommand, "001", "Client", 1},
+	{FeatureCommand, "001", "Tracker", 1},
+	{FeatureCommand, "005", "Client", 1},
+	{FeatureCommand, "005", "ISupportTracker", 1},
+	{FeatureCommand, "221", "Client", 16},
+	{FeatureCommand, "302", "Client", 21},
+	{FeatureCommand, "324", "Tracker", 15},
+	{FeatureCommand, "329", "Tracker", 15},
+	{FeatureCommand, "332", "Tracker", 1},
+	{FeatureCommand, "333", "Tracker", 17},
+	{FeatureCommand, "346", "Tracker", 15},
+	{FeatureCommand, "348", "Tracker", 15},
+	{FeatureCommand, "352", "Tracker", 1},
+	{FeatureCommand, "353", "Client", 25},
+	{FeatureCommand, "353", "Tracker", 1},
+	{FeatureCommand, "354", "Tracker", 18},
+	{FeatureCommand, "366", "Client", 25},
+	{FeatureCommand, "367", "Tracker", 15},
+	{FeatureCommand, "396", "Client", 21},
+	{FeatureCommand, "403", "Client", 25},
+	{FeatureCommand, "405", "Client", 25},
+	{FeatureCommand, "433", "Client", 1},
+	{FeatureCommand, "437", "Client", 1},
+	{FeatureCommand, "471", "Client", 25},
+	{FeatureCommand, "473", "Client", 25},
+	{FeatureCommand, "474", "Client", 25},
+	{FeatureCommand, "475", "Client", 25},
+	{FeatureCommand, "512", "Monitor", 10},
+	{FeatureCommand, "600", "Monitor", 10},
+	{FeatureCommand, "601", "Monitor", 10},
+	{FeatureCommand, "604", "Monitor", 10},
+	{FeatureCommand, "605", "Monitor", 10},
+	{FeatureCommand, "730", "Monitor", 8},
+	{FeatureCommand, "731", "Monitor", 8},
+	{FeatureCommand, "734", "Monitor", 8},
+	{FeatureCommand, "761", "MetadataDialect", 1},
+	{FeatureCommand, "900", "Tracker", 11},
+	{FeatureCommand, "901", "Tracker", 11},
+	{FeatureCommand, "903", "Client", 1},
+	{FeatureCommand, "904", "Client", 1},
+	{FeatureCommand, "905", "Client", 1},
+	{FeatureCommand, "908", "Client", 1},
+	{FeatureCommand, "ACCOUNT", "Tracker", 11},
+	{FeatureCommand, "AUTHENTICATE", "Client", 1},
+	{FeatureCommand, "BATCH", "Client", 4},
+	{FeatureCommand, "BRB", "Client", 9},
+	{FeatureCommand, "CAP", "Client", 1},
+	{FeatureCommand, "CHATHISTORY", "Client", 5},
+	{FeatureCommand, "CHGHOST", "Tracker", 13},
+	{FeatureCommand, "ERROR", "Client", 1},
+	{FeatureCommand, "FAIL", "Client", 5},
+	{FeatureCommand, "JOIN", "Client", 15},
+	{FeatureCommand, "JOIN", "Tracker", 1},
+	{FeatureCommand, "KICK", "Tracker", 1},
+	{FeatureCommand, "KILL", "Client", 1},
+	{FeatureCommand, "MD", "UnrealDialect", 24},
+	{FeatureCommand, "METADATA", "MetadataDialect", 1},
+	{FeatureCommand, "MODE", "Client", 16},
+	{FeatureCommand, "MODE", "Tracker", 14},
+	{FeatureCommand, "MONITOR", "Monitor", 8},
+	{FeatureCommand, "NICK", "Client", 1},
+	{FeatureCommand, "NICK", "Tracker", 1},
+	{FeatureCommand, "NOTICE", "Client", 1},
+	{FeatureCommand, "PART", "Tracker", 1},
+	{FeatureCommand, "PING", "Client", 1},
+	{FeatureCommand, "PONG", "Client", 1},
+	{FeatureCommand, "QUIT", "Tracker", 1},
+	{FeatureCommand, "REDACT", "Client", 6},
+	{FeatureCommand, "REDACT", "HistoryBuffer", 6},
+	{FeatureCommand, "RESUME", "Client", 9},
+	{FeatureCommand, "SETNAME", "Tracker", 13},
+	{FeatureCommand, "TAGMSG", "Client", 2},
+	{FeatureCommand, "TAGMSG", "InspIRCdDialect", 24},
+	{FeatureCommand, "TOPIC", "Tracker", 1},
+	{FeatureCommand, "WATCH", "Monitor", 10},
+
+	{FeatureTag, DefaultRelayTag, "RelayWatermark", 1},
+	{FeatureTag, "account", "Tracker", 11},
+	{FeatureTag, "batch", "Client", 4},
+	{FeatureTag, "bot", "Tracker", 1},
+	{FeatureTag, "draft/multiline-concat", "Client", 22},
+	{FeatureTag, "msgid", "HistoryBuffer", 6},
+	{FeatureTag, "time", "Message", 3},
+}
+
+// SupportedFeatures returns a list of all the IRCv3 caps, ISUPPORT tokens,
+// commands, and message tags this library understands. The returned value is
+// a copy and may be modified.
+func SupportedFeatures() FeatureMatrix {
+	ret := make([]Feature, len(features))
+	copy(ret, features)
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Kind != ret[j].Kind {
+			return ret[i].Kind < ret[j].Kind
+		}
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Component < ret[j].Component
+	})
+
+	return FeatureMatrix{
+		Version:  FeatureMatrixVersion,
+		Features: ret,
+	}
+}
@@ -0,0 +1,43 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestSupportedFeatures(t *testing.T) {
+	t.Parallel()
+
+	matrix := irc.SupportedFeatures()
+	assert.Equal(t, irc.FeatureMatrixVersion, matrix.Version)
+
+	seen := make(map[irc.Feature]bool)
+	latest := false
+	for i, feature := range matrix.Features {
+		assert.False(t, seen[feature], "duplicate feature %v", feature)
+		seen[feature] = true
+
+		assert.NotEmpty(t, feature.Name)
+		assert.NotEmpty(t, feature.Component)
+		assert.True(t, feature.Since >= 1 && feature.Since <= matrix.Version)
+		latest = latest || feature.Since == matrix.Version
+
+		if i > 0 {
+			prev := matrix.Features[i-1]
+			assert.True(t, prev.Kind <= feature.Kind, "features not sorted")
+		}
+	}
+
+	assert.True(t, seen[irc.Feature{Kind: irc.FeatureCap, Name: "sasl", Component: "Client", Since: 1}])
+	assert.True(t, seen[irc.Feature{Kind: irc.FeatureISupport, Name: "PREFIX", Component: "ISupportTracker", Since: 1}])
+
+	// Each version should have added something.
+	assert.True(t, latest, "nothing was added in version %d", matrix.Version)
+
+	// Modifying the result shouldn't affect later calls.
+	matrix.Features[0].Name = "modified"
+	assert.NotEqual(t, "modified", irc.SupportedFeatures().Features[0].Name)
+}
@@ -0,0 +1,136 @@
+package irc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// HandlerTester makes it possible to unit test a Handler without a network
+// connection. Messages fed into the tester go through the same processing as
+// they would on a running Client, including the ISupport and Tracker if they
+// are enabled in the ClientConfig, and anything the Handler writes is captured
+// so it can be checked.
+type HandlerTester struct {
+	// Client is the Client passed to the Handler. It can be used to set up
+	// any additional state before feeding messages.
+	Client *Client
+
+	output *testerBuffer
+}
+
+// testerBuffer is an io.ReadWriteCloser which never returns any data and
+// captures everything written to it.
+type testerBuffer struct {
+	sync.Mutex
+
+	buf bytes.Buffer
+}
+
+func (b *testerBuffer) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("irc: HandlerTester does not support reading")
+}
+
+func (b *testerBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *testerBuffer) Close() error {
+	return nil
+}
+
+// NewHandlerTester creates a HandlerTester for the given Handler. The
+// ClientConfig is used to create the Client passed to the Handler, but its
+// Handler field is replaced and rate limiting is disabled. If no Nick is set,
+// "test_nick" will be used.
+func NewHandlerTester(handler Handler, config ClientConfig) *HandlerTester {
+	if config.Nick == "" {
+		config.Nick = "test_nick"
+	}
+
+	config.Handler = handler
+	config.SendLimit = 0
+
+	output := &testerBuffer{}
+
+	return &HandlerTester{
+		Client: NewClient(output, config),
+		output: output,
+	}
+}
+
+// Feed parses each of the given lines and passes them to the Handler in
+// order. It will return an error if any line fails to parse.
+func (ht *HandlerTester) Feed(lines ...string) error {
+	for _, line := range lines {
+		m, err := ParseMessage(line)
+		if err != nil {
+			return fmt.Errorf("irc: failed to parse %q: %w", line, err)
+		}
+
+		ht.FeedMessage(m)
+	}
+
+	return nil
+}
+
+// FeedMessage passes a single message to the Handler.
+func (ht *HandlerTester) FeedMessage(m *Message) {
+	ht.Client.handleMessage(m)
+}
+
+// Writes returns all messages written since the last call to Writes and
+// clears them.
+func (ht *HandlerTester) Writes() []*Message {
+	ht.output.Lock()
+	data := ht.output.buf.String()
+	ht.output.buf.Reset()
+	ht.output.Unlock()
+
+	var ret []*Message
+
+	for _, line := range strings.Split(data, "\r\n") {
+		m, err := ParseMessage(line)
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, m)
+	}
+
+	return ret
+}
+
+// ExpectWrites compares everything written since the last call to Writes or
+// ExpectWrites with the expected lines. Lines are compared after being parsed
+// and re-serialized so insignificant differences like an optional trailing
+// colon are ignored. An error describing the first difference is returned if
+// they do not match.
+func (ht *HandlerTester) ExpectWrites(expected ...string) error {
+	writes := ht.Writes()
+
+	for i, line := range expected {
+		m, err := ParseMessage(line)
+		if err != nil {
+			return fmt.Errorf("irc: failed to parse expected line %q: %w", line, err)
+		}
+
+		if i >= len(writes) {
+			return fmt.Errorf("irc: missing write %d: expected %q", i, m.String())
+		}
+
+		if writes[i].String() != m.String() {
+			return fmt.Errorf("irc: write %d: expected %q, got %q", i, m.String(), writes[i].String())
+		}
+	}
+
+	if len(writes) > len(expected) {
+		return fmt.Errorf("irc: unexpected write %d: %q", len(expected), writes[len(expected)].String())
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestHandlerTester(t *testing.T) {
+	t.Parallel()
+
+	handler := irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+		if m.Command != "PRIVMSG" || !c.FromChannel(m) {
+			return
+		}
+
+		if m.Trailing() == "!users" {
+			state := c.Tracker.GetChannel(m.Params[0])
+			_ = c.Writef("PRIVMSG %s :%d users", m.Params[0], len(state.Users))
+		}
+	})
+
+	ht := irc.NewHandlerTester(handler, irc.ClientConfig{EnableTracker: true})
+
+	assert.NoError(t, ht.Feed(
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":other!user@host JOIN #chan",
+		":other!user@host PRIVMSG #chan :!users",
+	))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :2 users"))
+	assert.Equal(t, "test_nick", ht.Client.CurrentNick())
+
+	// Client filters still run
+	assert.NoError(t, ht.Feed("PING :hello world"))
+	writes := ht.Writes()
+	if assert.Len(t, writes, 1) {
+		assert.Equal(t, "PONG", writes[0].Command)
+	}
+
+	// Mismatches should be reported
+	assert.NoError(t, ht.Feed(":other!user@host PRIVMSG #chan :!users"))
+	assert.Error(t, ht.ExpectWrites("PRIVMSG #chan :3 users"))
+
+	assert.NoError(t, ht.Feed(":other!user@host PRIVMSG #chan :!users"))
+	assert.Error(t, ht.ExpectWrites())
+
+	assert.Error(t, ht.ExpectWrites("PRIVMSG #chan :2 users"))
+	assert.Error(t, ht.Feed(":invalid"))
+}
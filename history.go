@@ -0,0 +1,165 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrHistoryNotSupported is returned from Client.History if the chathistory
+// cap has not been enabled.
+var ErrHistoryNotSupported = errors.New("irc: chathistory cap is not enabled")
+
+// HistoryDirection selects which messages are returned by Client.History
+// relative to the reference.
+type HistoryDirection string
+
+// These are the supported CHATHISTORY subcommands.
+const (
+	HistoryBefore HistoryDirection = "BEFORE"
+	HistoryAfter  HistoryDirection = "AFTER"
+	HistoryAround HistoryDirection = "AROUND"
+	HistoryLatest HistoryDirection = "LATEST"
+)
+
+// HistoryTimestamp returns a reference for Client.History pointing at the
+// given time.
+func HistoryTimestamp(t time.Time) string {
+	return "timestamp=" + t.UTC().Format(serverTimeFormat)
+}
+
+// HistoryMsgID returns a reference for Client.History pointing at the message
+// with the given msgid.
+func HistoryMsgID(msgid string) string {
+	return "msgid=" + msgid
+}
+
+// historyRequest is a pending call to Client.History.
+type historyRequest struct {
+	target string
+	result chan historyResult
+}
+
+type historyResult struct {
+	messages []*Message
+	err      error
+}
+
+// History requests scrollback for a target using the draft/chathistory
+// extension and waits for the server to send it. The ref should be created
+// with HistoryTimestamp or HistoryMsgID, or be "*" when using HistoryLatest to
+// get the most recent messages. To page through history, call History again
+// with HistoryBefore and a reference to the oldest message returned.
+//
+// The draft/chathistory and batch caps must be requested before connecting.
+// Messages returned here are not passed to the Handler. History must not be
+// called from the Handler, as the response is read by the same goroutine.
+func (c *Client) History(ctx context.Context, target string, direction HistoryDirection, ref string, limit int) ([]*Message, error) {
+	if !c.CapEnabled("draft/chathistory") && !c.CapEnabled("chathistory") {
+		return nil, ErrHistoryNotSupported
+	}
+
+	if c.ISupport != nil {
+		if max, ok := c.ISupport.GetInt("CHATHISTORY"); ok && max > 0 && limit > max {
+			limit = max
+		}
+	}
+
+	req := &historyRequest{
+		target: target,
+		result: make(chan historyResult, 1),
+	}
+
+	c.historyLock.Lock()
+	c.history = append(c.history, req)
+	c.historyLock.Unlock()
+
+	err := c.Writef("CHATHISTORY %s %s %s %d", direction, target, ref, limit)
+	if err != nil {
+		c.removeHistoryRequest(req)
+		return nil, err
+	}
+
+	select {
+	case res := <-req.result:
+		return res.messages, res.err
+	case <-ctx.Done():
+		c.removeHistoryRequest(req)
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) removeHistoryRequest(req *historyRequest) {
+	c.historyLock.Lock()
+	defer c.historyLock.Unlock()
+
+	for i, pending := range c.history {
+		if pending == req {
+			c.history = append(c.history[:i], c.history[i+1:]...)
+			return
+		}
+	}
+}
+
+// popHistoryRequest removes and returns the oldest pending request, if there
+// is one. If target is not empty, only requests for that target are
+// considered.
+func (c *Client) popHistoryRequest(target string) *historyRequest {
+	c.historyLock.Lock()
+	defer c.historyLock.Unlock()
+
+	for i, req := range c.history {
+		if target == "" || strings.EqualFold(req.target, target) {
+			c.history = append(c.history[:i], c.history[i+1:]...)
+			return req
+		}
+	}
+
+	return nil
+}
+
+// handleHistoryBatch claims chathistory batches for pending History calls and
+// delivers them once they are done.
+func (c *Client) handleHistoryBatch(m *Message, batch *Batch, done bool) {
+	if batch.Type != "chathistory" && batch.Type != "draft/chathistory" {
+		return
+	}
+
+	// Batches are claimed as soon as they start so the messages in them
+	// aren't passed to the Handler.
+	if m == batch.Start {
+		var target string
+		if len(batch.Params) > 0 {
+			target = batch.Params[0]
+		}
+
+		req := c.popHistoryRequest(target)
+		if req == nil {
+			return
+		}
+
+		batch.claimed = true
+		batch.request = req
+
+		return
+	}
+
+	if done && batch.request != nil {
+		batch.request.result <- historyResult{messages: batch.Messages}
+	}
+}
+
+// handleHistoryFail is called for FAIL CHATHISTORY messages. The oldest
+// pending History call will return an error.
+func handleHistoryFail(c *Client, m *Message) {
+	req := c.popHistoryRequest("")
+	if req == nil {
+		return
+	}
+
+	req.result <- historyResult{
+		err: fmt.Errorf("irc: CHATHISTORY failed: %s: %s", m.Param(1), m.Trailing()),
+	}
+}
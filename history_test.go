@@ -0,0 +1,100 @@
+package irc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	handler := &TestHandler{}
+	config := irc.ClientConfig{
+		Nick:           "test_nick",
+		User:           "test_user",
+		Name:           "test_name",
+		EnableISupport: true,
+		Handler:        handler,
+	}
+
+	type result struct {
+		messages []*irc.Message
+		err      error
+	}
+	results := make(chan result, 2)
+
+	var c *irc.Client
+	history := func(direction irc.HistoryDirection, ref string) TestAction {
+		return func(t *testing.T, rw *testReadWriter) {
+			go func() {
+				messages, err := c.History(context.Background(), "#chan", direction, ref, 100)
+				results <- result{messages, err}
+			}()
+		}
+	}
+
+	rw := newTestReadWriter()
+	c = irc.NewClient(rw, config)
+	c.CapRequest("batch", true)
+	c.CapRequest("draft/chathistory", true)
+
+	_, err := c.History(context.Background(), "#chan", irc.HistoryLatest, "*", 10)
+	assert.Equal(t, irc.ErrHistoryNotSupported, err)
+
+	go func() {
+		assert.Equal(t, io.EOF, c.Run())
+		close(rw.clientDone)
+	}()
+
+	runTest(t, rw, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		LineFunc(func(m *irc.Message) { assert.Equal(t, "CAP", m.Command) }),
+		LineFunc(func(m *irc.Message) { assert.Equal(t, "CAP", m.Command) }),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :batch draft/chathistory\r\n"),
+		SendLine("CAP * ACK :batch\r\n"),
+		SendLine("CAP * ACK :draft/chathistory\r\n"),
+		ExpectLine("CAP END\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		SendLine("005 test_nick CHATHISTORY=50 :are supported by this server\r\n"),
+
+		// The limit should be capped to what the server allows.
+		history(irc.HistoryLatest, "*"),
+		ExpectLine("CHATHISTORY LATEST #chan * 50\r\n"),
+		SendLine("BATCH +ref chathistory #chan\r\n"),
+		SendLine("@batch=ref;time=2020-01-01T00:00:00.000Z :a!u@h PRIVMSG #chan :one\r\n"),
+		SendLine("@batch=ref;time=2020-01-01T00:00:01.000Z :b!u@h PRIVMSG #chan :two\r\n"),
+		SendLine("BATCH -ref\r\n"),
+		func(t *testing.T, rw *testReadWriter) {
+			res := <-results
+			assert.NoError(t, res.err)
+			if assert.Len(t, res.messages, 2) {
+				assert.Equal(t, "one", res.messages[0].Trailing())
+				assert.Equal(t, "two", res.messages[1].Trailing())
+			}
+		},
+
+		// Paging backwards from the oldest message.
+		history(irc.HistoryBefore, irc.HistoryTimestamp(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))),
+		ExpectLine("CHATHISTORY BEFORE #chan timestamp=2020-01-01T00:00:00.000Z 50\r\n"),
+		SendLine("FAIL CHATHISTORY MESSAGE_ERROR BEFORE #chan :Messages could not be retrieved\r\n"),
+		func(t *testing.T, rw *testReadWriter) {
+			res := <-results
+			assert.EqualError(t, res.err, "irc: CHATHISTORY failed: MESSAGE_ERROR: Messages could not be retrieved")
+		},
+	})
+
+	// History messages shouldn't be passed to the Handler, but everything
+	// else should be.
+	for _, m := range handler.Messages() {
+		assert.NotEqual(t, "PRIVMSG", m.Command)
+		assert.NotEqual(t, "BATCH", m.Command)
+	}
+}
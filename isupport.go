@@ -2,6 +2,7 @@ package irc
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -16,14 +17,19 @@ type ISupportTracker struct {
 	sync.RWMutex
 
 	data map[string]string
+
+	onChange []func(ISupportChange)
 }
 
+// defaultPrefix is used when the server doesn't send PREFIX.
+const defaultPrefix = "(ov)@+"
+
 // NewISupportTracker creates a new tracker instance with a set of sane defaults
 // if the server is missing them.
 func NewISupportTracker() *ISupportTracker {
 	return &ISupportTracker{
 		data: map[string]string{
-			"PREFIX": "(ov)@+",
+			"PREFIX": defaultPrefix,
 		},
 	}
 }
@@ -46,22 +52,72 @@ func (t *ISupportTracker) Handle(msg *Message) error {
 	}
 
 	t.Lock()
-	defer t.Unlock()
+
+	var prev ISupportSnapshot
+	if len(t.onChange) > 0 {
+		prev = t.snapshot()
+	}
 
 	for _, param := range msg.Params[1 : len(msg.Params)-1] {
+		// A token prefixed with - means the server no longer supports it,
+		// which usually happens after a rehash.
+		if strings.HasPrefix(param, "-") {
+			delete(t.data, param[1:])
+			if param[1:] == "PREFIX" {
+				t.data["PREFIX"] = defaultPrefix
+			}
+
+			continue
+		}
+
 		data := strings.SplitN(param, "=", 2)
 		if len(data) < 2 {
 			t.data[data[0]] = ""
 			continue
 		}
 
-		// TODO: this should properly handle decoding values containing \xHH
-		t.data[data[0]] = data[1]
+		t.data[data[0]] = decodeISupportValue(data[1])
 	}
 
+	var changes []ISupportChange
+	if prev != nil {
+		changes = diffISupport(prev, t.data)
+	}
+
+	callbacks := t.onChange
+
+	t.Unlock()
+
+	notifyChanges(callbacks, changes)
+
 	return nil
 }
 
+// decodeISupportValue decodes \xHH escapes in an ISupport value. Invalid
+// escapes are left as-is.
+func decodeISupportValue(value string) string {
+	if strings.IndexByte(value, '\\') == -1 {
+		return value
+	}
+
+	var ret strings.Builder
+	ret.Grow(len(value))
+
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if b, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				ret.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+		}
+
+		ret.WriteByte(value[i])
+	}
+
+	return ret.String()
+}
+
 // IsEnabled will check for boolean ISupport values. Note that for ISupport
 // boolean true simply means the value exists.
 func (t *ISupportTracker) IsEnabled(key string) bool {
@@ -155,3 +211,281 @@ func (t *ISupportTracker) GetPrefixMap() (map[rune]rune, bool) {
 
 	return prefixes, true
 }
+
+// getPrefixModes returns the modes from the PREFIX value in order, from
+// highest to lowest rank, such as "qaohv".
+func (t *ISupportTracker) getPrefixModes() string {
+	prefix, _ := t.GetRaw("PREFIX")
+
+	i := strings.IndexByte(prefix, ')')
+	if len(prefix) == 0 || prefix[0] != '(' || i < 0 {
+		return ""
+	}
+
+	return prefix[1:i]
+}
+
+// defaultChanModes is used when the server doesn't send CHANMODES.
+const defaultChanModes = "beI,k,l,imnpst"
+
+// ChanModeType is the type of a channel mode, as described by the CHANMODES
+// and PREFIX ISupport tokens.
+type ChanModeType int
+
+// Channel mode types. Type A modes are lists and always take a parameter, type
+// B always take a parameter, type C only take a parameter when set, and type D
+// never take a parameter. Prefix modes, such as o or v, always take a nick as
+// a parameter.
+const (
+	ChanModeUnknown ChanModeType = iota
+	ChanModePrefix
+	ChanModeA
+	ChanModeB
+	ChanModeC
+	ChanModeD
+)
+
+// HasParam returns true if a mode of this type takes a parameter when it is
+// being set or unset.
+func (m ChanModeType) HasParam(adding bool) bool {
+	switch m {
+	case ChanModePrefix, ChanModeA, ChanModeB:
+		return true
+	case ChanModeC:
+		return adding
+	}
+
+	return false
+}
+
+// ChanModes contains the four classes of channel modes from the CHANMODES
+// ISupport token.
+type ChanModes struct {
+	A map[rune]struct{}
+	B map[rune]struct{}
+	C map[rune]struct{}
+	D map[rune]struct{}
+}
+
+// GetChanModes returns the four classes of channel modes from the CHANMODES
+// value. If the server didn't send CHANMODES, a common default will be used.
+func (t *ISupportTracker) GetChanModes() ChanModes {
+	a, b, c, d := t.getChanModes()
+
+	return ChanModes{
+		A: runeSet(a),
+		B: runeSet(b),
+		C: runeSet(c),
+		D: runeSet(d),
+	}
+}
+
+func runeSet(s string) map[rune]struct{} {
+	ret := make(map[rune]struct{}, len(s))
+	for _, r := range s {
+		ret[r] = struct{}{}
+	}
+
+	return ret
+}
+
+// ClassifyChanMode returns the type of the given channel mode, based on the
+// PREFIX and CHANMODES values. ChanModeUnknown will be returned for modes the
+// server didn't mention.
+func (t *ISupportTracker) ClassifyChanMode(mode rune) ChanModeType {
+	ranks := t.getPrefixModes()
+	a, b, c, d := t.getChanModes()
+
+	return classifyChanMode(mode, ranks, a, b, c, d)
+}
+
+// classifyChanMode is ClassifyChanMode for when the mode strings have already
+// been looked up.
+func classifyChanMode(mode rune, ranks, a, b, c, d string) ChanModeType {
+	switch {
+	case strings.ContainsRune(ranks, mode):
+		return ChanModePrefix
+	case strings.ContainsRune(a, mode):
+		return ChanModeA
+	case strings.ContainsRune(b, mode):
+		return ChanModeB
+	case strings.ContainsRune(c, mode):
+		return ChanModeC
+	case strings.ContainsRune(d, mode):
+		return ChanModeD
+	}
+
+	return ChanModeUnknown
+}
+
+// getChanModes returns the four classes of channel modes from the CHANMODES
+// value as strings.
+func (t *ISupportTracker) getChanModes() (a, b, c, d string) {
+	data, ok := t.GetRaw("CHANMODES")
+	if !ok {
+		data = defaultChanModes
+	}
+
+	classes := strings.SplitN(data, ",", 5)
+	for len(classes) < 4 {
+		classes = append(classes, "")
+	}
+
+	return classes[0], classes[1], classes[2], classes[3]
+}
+
+// GetTargMax returns the maximum number of targets the server accepts for the
+// given command, based on the TARGMAX value (or MAXTARGETS for PRIVMSG and
+// NOTICE on older servers). A limit of 0 means there is no limit. The bool
+// will be false if the server did not specify a limit for this command.
+func (t *ISupportTracker) GetTargMax(command string) (int, bool) {
+	command = strings.ToUpper(command)
+
+	if targMax, ok := t.GetMap("TARGMAX"); ok {
+		limit, ok := targMax[command]
+		if !ok {
+			return 0, false
+		}
+
+		// An empty limit means there is no limit for this command.
+		if limit == "" {
+			return 0, true
+		}
+
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+
+		return n, true
+	}
+
+	if command != "PRIVMSG" && command != "NOTICE" {
+		return 0, false
+	}
+
+	limit, ok := t.GetRaw("MAXTARGETS")
+	if !ok {
+		return 0, false
+	}
+
+	if limit == "" {
+		return 0, true
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// isupportDefaults are the values assumed for tokens the server doesn't send,
+// as described in the ISUPPORT draft. They are only used by the typed getters;
+// GetRaw will always return what the server sent.
+var isupportDefaults = map[string]string{
+	"CASEMAPPING": "rfc1459",
+	"CHANNELLEN":  "200",
+	"MODES":       "3",
+	"NICKLEN":     "9",
+}
+
+// getWithDefault returns the raw value for a token, falling back to the
+// default if the server didn't send it.
+func (t *ISupportTracker) getWithDefault(key string) (string, bool) {
+	if data, ok := t.GetRaw(key); ok {
+		return data, true
+	}
+
+	data, ok := isupportDefaults[key]
+	return data, ok
+}
+
+// GetInt returns the value of a numeric ISupport token, such as NICKLEN,
+// CHANNELLEN, TOPICLEN, MODES, or AWAYLEN. If the server sent the token
+// without a value, 0 will be returned, which means there is no limit. Tokens
+// the server didn't send will use the default from the ISUPPORT draft, if
+// there is one. The bool will be false if the token is missing without a
+// default or isn't a valid non-negative number.
+func (t *ISupportTracker) GetInt(key string) (int, bool) {
+	data, ok := t.getWithDefault(key)
+	if !ok {
+		return 0, false
+	}
+
+	if data == "" {
+		return 0, true
+	}
+
+	n, err := strconv.Atoi(data)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// GetBool returns true if a boolean ISupport token, such as WHOX or EXCEPTS,
+// was sent. It is the same as IsEnabled.
+func (t *ISupportTracker) GetBool(key string) bool {
+	return t.IsEnabled(key)
+}
+
+// GetEnum returns the value of an ISupport token which can only be one of a
+// few values, such as CASEMAPPING. Tokens the server didn't send will use the
+// default from the ISUPPORT draft, if there is one. If any values are given,
+// the bool will be false unless the value is one of them.
+func (t *ISupportTracker) GetEnum(key string, values ...string) (string, bool) {
+	data, ok := t.getWithDefault(key)
+	if !ok {
+		return "", false
+	}
+
+	if len(values) == 0 {
+		return data, true
+	}
+
+	for _, v := range values {
+		if data == v {
+			return data, true
+		}
+	}
+
+	return "", false
+}
+
+// chanLimit is a single group from the CHANLIMIT token. The limit applies to
+// the total number of channels joined with any of the prefixes.
+type chanLimit struct {
+	prefixes string
+	limit    int
+}
+
+// getChanLimits parses the CHANLIMIT token. Groups without a limit are
+// skipped.
+func (t *ISupportTracker) getChanLimits() []chanLimit {
+	// Sample: #&:10,+:5
+	data, ok := t.GetRaw("CHANLIMIT")
+	if !ok || data == "" {
+		return nil
+	}
+
+	var ret []chanLimit
+
+	for _, group := range strings.Split(data, ",") {
+		parts := strings.SplitN(group, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			continue
+		}
+
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit < 0 {
+			continue
+		}
+
+		ret = append(ret, chanLimit{prefixes: parts[0], limit: limit})
+	}
+
+	return ret
+}
@@ -0,0 +1,95 @@
+package irc
+
+import "sort"
+
+// ISupportSnapshot is a copy of all the raw ISupport values at a point in
+// time.
+type ISupportSnapshot map[string]string
+
+// ISupportChange describes a single ISupport token which was added, changed,
+// or removed.
+type ISupportChange struct {
+	Token string
+
+	// Old is the previous value. It will be empty if the token was added.
+	Old string
+
+	// New is the current value. It will be empty if the token was removed.
+	New string
+
+	Added   bool
+	Removed bool
+}
+
+// Snapshot returns a copy of all the raw ISupport values.
+func (t *ISupportTracker) Snapshot() ISupportSnapshot {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.snapshot()
+}
+
+// snapshot is Snapshot without locking. The lock must be held when calling
+// this.
+func (t *ISupportTracker) snapshot() ISupportSnapshot {
+	ret := make(ISupportSnapshot, len(t.data))
+	for k, v := range t.data {
+		ret[k] = v
+	}
+
+	return ret
+}
+
+// Diff returns everything which changed since the given snapshot was taken,
+// sorted by token. This can be used to find out what changed after
+// reconnecting.
+func (t *ISupportTracker) Diff(prev ISupportSnapshot) []ISupportChange {
+	t.RLock()
+	defer t.RUnlock()
+
+	return diffISupport(prev, t.data)
+}
+
+func diffISupport(prev, current map[string]string) []ISupportChange {
+	var ret []ISupportChange
+
+	for token, value := range current {
+		old, ok := prev[token]
+		if !ok {
+			ret = append(ret, ISupportChange{Token: token, New: value, Added: true})
+		} else if old != value {
+			ret = append(ret, ISupportChange{Token: token, Old: old, New: value})
+		}
+	}
+
+	for token, old := range prev {
+		if _, ok := current[token]; !ok {
+			ret = append(ret, ISupportChange{Token: token, Old: old, Removed: true})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Token < ret[j].Token
+	})
+
+	return ret
+}
+
+// OnChange registers a callback which will be called for every token added,
+// changed, or removed by a 005 message. Callbacks are called after the
+// ISupportTracker has been updated, without any locks held.
+func (t *ISupportTracker) OnChange(f func(ISupportChange)) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.onChange = append(t.onChange, f)
+}
+
+// notifyChanges sends changes to the given callbacks.
+func notifyChanges(callbacks []func(ISupportChange), changes []ISupportChange) {
+	for _, change := range changes {
+		for _, f := range callbacks {
+			f(change)
+		}
+	}
+}
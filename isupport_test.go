@@ -0,0 +1,209 @@
+package irc_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestISupportTypedGetters(t *testing.T) {
+	t.Parallel()
+
+	isupport := irc.NewISupportTracker()
+
+	// Before the server sends anything, the defaults should be used.
+	n, ok := isupport.GetInt("NICKLEN")
+	assert.True(t, ok)
+	assert.Equal(t, 9, n)
+
+	n, ok = isupport.GetInt("MODES")
+	assert.True(t, ok)
+	assert.Equal(t, 3, n)
+
+	_, ok = isupport.GetInt("TOPICLEN")
+	assert.False(t, ok)
+
+	casemapping, ok := isupport.GetEnum("CASEMAPPING")
+	assert.True(t, ok)
+	assert.Equal(t, "rfc1459", casemapping)
+
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick NICKLEN=30 MODES TOPICLEN=abc AWAYLEN=200 CASEMAPPING=ascii WHOX :are supported by this server",
+	)))
+
+	n, ok = isupport.GetInt("NICKLEN")
+	assert.True(t, ok)
+	assert.Equal(t, 30, n)
+
+	// A token without a value means there is no limit.
+	n, ok = isupport.GetInt("MODES")
+	assert.True(t, ok)
+	assert.Equal(t, 0, n)
+
+	_, ok = isupport.GetInt("TOPICLEN")
+	assert.False(t, ok)
+
+	n, ok = isupport.GetInt("AWAYLEN")
+	assert.True(t, ok)
+	assert.Equal(t, 200, n)
+
+	assert.True(t, isupport.GetBool("WHOX"))
+	assert.False(t, isupport.GetBool("EXCEPTS"))
+
+	casemapping, ok = isupport.GetEnum("CASEMAPPING", "ascii", "rfc1459", "rfc1459-strict")
+	assert.True(t, ok)
+	assert.Equal(t, "ascii", casemapping)
+
+	_, ok = isupport.GetEnum("CASEMAPPING", "rfc1459")
+	assert.False(t, ok)
+
+	_, ok = isupport.GetEnum("NETWORK")
+	assert.False(t, ok)
+}
+
+func TestISupportChanModes(t *testing.T) {
+	t.Parallel()
+
+	isupport := irc.NewISupportTracker()
+
+	// Without CHANMODES, the defaults should be used.
+	modes := isupport.GetChanModes()
+	assert.Contains(t, modes.A, 'b')
+	assert.Contains(t, modes.B, 'k')
+	assert.Contains(t, modes.C, 'l')
+	assert.Contains(t, modes.D, 'n')
+
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick CHANMODES=beIq,k,flj,CFLMPQcgimnprstz PREFIX=(ohv)@%+ :are supported by this server",
+	)))
+
+	modes = isupport.GetChanModes()
+	assert.Equal(t, map[rune]struct{}{'b': {}, 'e': {}, 'I': {}, 'q': {}}, modes.A)
+	assert.Equal(t, map[rune]struct{}{'k': {}}, modes.B)
+	assert.Equal(t, map[rune]struct{}{'f': {}, 'l': {}, 'j': {}}, modes.C)
+	assert.Contains(t, modes.D, 'z')
+
+	assert.Equal(t, irc.ChanModePrefix, isupport.ClassifyChanMode('h'))
+	assert.Equal(t, irc.ChanModeA, isupport.ClassifyChanMode('q'))
+	assert.Equal(t, irc.ChanModeB, isupport.ClassifyChanMode('k'))
+	assert.Equal(t, irc.ChanModeC, isupport.ClassifyChanMode('j'))
+	assert.Equal(t, irc.ChanModeD, isupport.ClassifyChanMode('Q'))
+	assert.Equal(t, irc.ChanModeUnknown, isupport.ClassifyChanMode('Z'))
+
+	assert.True(t, irc.ChanModeB.HasParam(false))
+	assert.True(t, irc.ChanModeC.HasParam(true))
+	assert.False(t, irc.ChanModeC.HasParam(false))
+	assert.False(t, irc.ChanModeD.HasParam(true))
+}
+
+func TestISupportHandle(t *testing.T) {
+	t.Parallel()
+
+	isupport := irc.NewISupportTracker()
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		`005 test_nick NETWORK=Example\x20Network\x3Dtest EXCEPTS WHOX BAD=\xZZ\x4 :are supported by this server`,
+	)))
+
+	network, ok := isupport.GetRaw("NETWORK")
+	assert.True(t, ok)
+	assert.Equal(t, "Example Network=test", network)
+
+	// Invalid escapes should be left alone.
+	bad, ok := isupport.GetRaw("BAD")
+	assert.True(t, ok)
+	assert.Equal(t, `\xZZ\x4`, bad)
+
+	// Negated tokens should be removed.
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick -EXCEPTS -UNKNOWN :are supported by this server",
+	)))
+	assert.False(t, isupport.IsEnabled("EXCEPTS"))
+	assert.True(t, isupport.IsEnabled("WHOX"))
+
+	// PREFIX should go back to the default rather than disappearing.
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick PREFIX=(qov)~@+ :are supported by this server",
+	)))
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick -PREFIX :are supported by this server",
+	)))
+	prefix, ok := isupport.GetRaw("PREFIX")
+	assert.True(t, ok)
+	assert.Equal(t, "(ov)@+", prefix)
+}
+
+func TestISupportChanges(t *testing.T) {
+	t.Parallel()
+
+	isupport := irc.NewISupportTracker()
+
+	var changes []irc.ISupportChange
+	isupport.OnChange(func(change irc.ISupportChange) {
+		// Callbacks should be able to query the tracker.
+		_, _ = isupport.GetRaw(change.Token)
+		changes = append(changes, change)
+	})
+
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick NETWORK=Example CASEMAPPING=rfc1459 EXCEPTS :are supported by this server",
+	)))
+	assert.Equal(t, []irc.ISupportChange{
+		{Token: "CASEMAPPING", New: "rfc1459", Added: true},
+		{Token: "EXCEPTS", Added: true},
+		{Token: "NETWORK", New: "Example", Added: true},
+	}, changes)
+
+	snapshot := isupport.Snapshot()
+	assert.Equal(t, irc.ISupportSnapshot{
+		"CASEMAPPING": "rfc1459",
+		"EXCEPTS":     "",
+		"NETWORK":     "Example",
+		"PREFIX":      "(ov)@+",
+	}, snapshot)
+
+	// Re-sending the same values shouldn't be reported.
+	changes = nil
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick NETWORK=Example :are supported by this server",
+	)))
+	assert.Empty(t, changes)
+
+	require.NoError(t, isupport.Handle(irc.MustParseMessage(
+		"005 test_nick NETWORK=Other CASEMAPPING=ascii -EXCEPTS :are supported by this server",
+	)))
+	expected := []irc.ISupportChange{
+		{Token: "CASEMAPPING", Old: "rfc1459", New: "ascii"},
+		{Token: "EXCEPTS", Removed: true},
+		{Token: "NETWORK", Old: "Example", New: "Other"},
+	}
+	assert.Equal(t, expected, changes)
+	assert.Equal(t, expected, isupport.Diff(snapshot))
+	assert.Empty(t, isupport.Diff(isupport.Snapshot()))
+}
+
+func TestISupportOnChangeWhileHandling(t *testing.T) {
+	t.Parallel()
+
+	isupport := irc.NewISupportTracker()
+	isupport.OnChange(func(irc.ISupportChange) {})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			isupport.OnChange(func(irc.ISupportChange) {})
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, isupport.Handle(irc.MustParseMessage(
+			fmt.Sprintf("005 test_nick NICKLEN=%d :are supported by this server", i),
+		)))
+	}
+
+	<-done
+}
@@ -0,0 +1,116 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrSendQueueFull is returned by Client.TrySend when the message can't be
+// sent without waiting for rate limiting.
+var ErrSendQueueFull = errors.New("irc: send queue is full")
+
+// Limiter controls how quickly outgoing lines are sent to the server. It can
+// be set as ClientConfig.Limiter to replace the token bucket configured with
+// SendLimit and SendBurst, such as to implement penalty-based flood control
+// or give some commands a higher cost than others.
+//
+// Limiters may also have an Allow(line string) bool method, which returns
+// whether a line can be sent right now without waiting. It is used by
+// Client.TrySend; without it, TrySend will wait like any other write.
+type Limiter interface {
+	// Wait blocks until the given line, which does not include the trailing
+	// \r\n, may be sent. If it returns an error, the line will not be sent and
+	// the error will be returned to the caller.
+	Wait(ctx context.Context, line string) error
+}
+
+// LimiterFunc is a simple wrapper around a function which allows it to be
+// used as a Limiter.
+type LimiterFunc func(ctx context.Context, line string) error
+
+// Wait calls f(ctx, line).
+func (f LimiterFunc) Wait(ctx context.Context, line string) error {
+	return f(ctx, line)
+}
+
+// rateLimiter adapts a token bucket to the Limiter interface, charging the
+// same for every line. This is what SendLimit and SendBurst configure.
+type rateLimiter struct {
+	limiter *rate.Limiter
+}
+
+// NewRateLimiter returns a Limiter which allows burst lines to be sent at once
+// and then one line for every interval after that. This is the Limiter used
+// for SendLimit and SendBurst, and can be wrapped to exempt some lines from
+// rate limiting.
+func NewRateLimiter(interval time.Duration, burst int) Limiter {
+	return rateLimiter{limiter: rate.NewLimiter(rate.Every(interval), burst)}
+}
+
+// Wait implements the Limiter interface.
+func (l rateLimiter) Wait(ctx context.Context, line string) error {
+	return l.limiter.Wait(ctx)
+}
+
+// Allow returns true if the line can be sent right now.
+func (l rateLimiter) Allow(line string) bool {
+	return l.limiter.Allow()
+}
+
+// currentLimiter returns the Limiter which should be used for the next line,
+// or nil if there is no rate limiting.
+func (c *Client) currentLimiter() Limiter {
+	c.configLock.RLock()
+	defer c.configLock.RUnlock()
+
+	if c.config.Limiter != nil {
+		return c.config.Limiter
+	}
+
+	if c.limiter != nil {
+		return rateLimiter{limiter: c.limiter}
+	}
+
+	return nil
+}
+
+// waitLimiter waits for the limiter to let the line through. If noWait is
+// set, ErrSendQueueFull is returned rather than waiting, as long as the
+// limiter supports it.
+func waitLimiter(limiter Limiter, line string, noWait bool) error {
+	if a, ok := limiter.(interface{ Allow(line string) bool }); ok && noWait {
+		if !a.Allow(line) {
+			return ErrSendQueueFull
+		}
+		return nil
+	}
+
+	// Note that context.Background imitates the previous implementation, but
+	// it may be worth looking for a way to use this with a passed in context
+	// in the future.
+	return limiter.Wait(context.Background(), line)
+}
+
+// TrySend writes a message, but rather than joining a long line of writes held
+// up by rate limiting, it gives up with ErrSendQueueFull. If more than
+// ClientConfig.MaxSendQueue lines are already waiting to be sent,
+// ErrSendQueueFull will be returned. If MaxSendQueue is zero, the message
+// must also be able to go out right away without waiting for the Limiter.
+// This lets interactive applications drop low-priority output while flood
+// protection is holding things up. If the message is split into several
+// lines, some of them may be sent before ErrSendQueueFull is returned.
+func (c *Client) TrySend(m *Message) error {
+	c.configLock.RLock()
+	maxQueue := c.config.MaxSendQueue
+	c.configLock.RUnlock()
+
+	if int(atomic.LoadInt32(&c.Writer.pending)) > maxQueue {
+		return ErrSendQueueFull
+	}
+
+	return c.Writer.writeMessage(m, maxQueue == 0)
+}
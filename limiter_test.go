@@ -0,0 +1,149 @@
+package irc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	errBlocked := errors.New("blocked")
+
+	var lock sync.Mutex
+	var lines []string
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		// SendLimit is ignored when a Limiter is set.
+		SendLimit: time.Hour,
+
+		Limiter: irc.LimiterFunc(func(ctx context.Context, line string) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			lines = append(lines, line)
+			if strings.HasPrefix(line, "PRIVMSG #blocked ") {
+				return errBlocked
+			}
+			return nil
+		}),
+
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command == "001" {
+				assert.Equal(t, errBlocked, c.Write("PRIVMSG #blocked :hello"))
+				assert.NoError(t, c.Write("PRIVMSG #allowed :hello"))
+			}
+		}),
+	}
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 :hello_world\r\n"),
+		ExpectLine("PRIVMSG #allowed :hello\r\n"),
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Equal(t, []string{
+		"NICK :test_nick",
+		"USER test_user 0 * :test_name",
+		"PRIVMSG #blocked :hello",
+		"PRIVMSG #allowed :hello",
+	}, lines)
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := irc.NewRateLimiter(20*time.Millisecond, 2)
+
+	before := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, limiter.Wait(context.Background(), "PING :test"))
+	}
+	assert.GreaterOrEqual(t, time.Since(before), 15*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, limiter.Wait(ctx, "PING :test"))
+}
+
+func TestTrySend(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		SendLimit: time.Hour,
+		SendBurst: 3,
+
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command != "001" {
+				return
+			}
+
+			// Registration used two of the three lines in the burst.
+			assert.NoError(t, c.TrySend(irc.MustParseMessage("PRIVMSG #a :first")))
+			assert.Equal(t, irc.ErrSendQueueFull, c.TrySend(irc.MustParseMessage("PRIVMSG #a :second")))
+		}),
+	}
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 :hello_world\r\n"),
+		ExpectLine("PRIVMSG #a first\r\n"),
+	})
+}
+
+func TestTrySendQueue(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	waiting := make(chan struct{}, 1)
+
+	c := irc.NewClient(newNopCloser(&bytes.Buffer{}), irc.ClientConfig{
+		Nick: "test_nick",
+		Limiter: irc.LimiterFunc(func(ctx context.Context, line string) error {
+			if line == "PRIVMSG #a slow" {
+				waiting <- struct{}{}
+				<-release
+			}
+			return nil
+		}),
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Write("PRIVMSG #a slow")
+	}()
+	<-waiting
+
+	// A line is already waiting, so there's no room in the queue.
+	assert.Equal(t, irc.ErrSendQueueFull, c.TrySend(irc.MustParseMessage("PRIVMSG #a dropped")))
+
+	close(release)
+	assert.NoError(t, <-done)
+
+	// Limiters without Allow are waited on as usual once the queue is empty.
+	assert.NoError(t, c.TrySend(irc.MustParseMessage("PRIVMSG #a sent")))
+}
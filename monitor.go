@@ -0,0 +1,365 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrMonitorListFull is returned from Monitor.Add if adding the nicks would go
+// over the limit the server advertised.
+var ErrMonitorListFull = errors.New("irc: monitor list is full")
+
+// monitorLineLength is roughly how long a single MONITOR line's target list
+// can be while leaving plenty of room for the command itself.
+const monitorLineLength = 400
+
+// Monitor keeps track of whether a set of nicks are online using the MONITOR
+// command. See https://ircv3.net/specs/extensions/monitor for details. On
+// networks which advertise WATCH but not MONITOR in ISUPPORT, the older WATCH
+// command is used instead.
+type Monitor struct {
+	sync.RWMutex
+
+	writer   *Writer
+	isupport *ISupportTracker
+
+	// nicks maps the nick, as it was added, to its state. Nicks are folded
+	// when they're looked up rather than when they're added, as CASEMAPPING
+	// may not be known yet or may change later.
+	nicks     map[string]*monitorState
+	callbacks []func(nick string, online bool)
+}
+
+type monitorState struct {
+	nick   string
+	known  bool
+	online bool
+}
+
+// NewMonitor creates a Monitor which sends commands with the given Writer and
+// checks the MONITOR limit using the given ISupportTracker.
+func NewMonitor(w *Writer, isupport *ISupportTracker) *Monitor {
+	return &Monitor{
+		writer:   w,
+		isupport: isupport,
+		nicks:    make(map[string]*monitorState),
+	}
+}
+
+// OnChange registers a callback which will be called whenever a monitored
+// nick comes online or goes offline. Callbacks are called without any locks
+// held, from the goroutine which called Handle.
+func (m *Monitor) OnChange(f func(nick string, online bool)) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.callbacks = append(m.callbacks, f)
+}
+
+// usingWatch returns true if the server only supports WATCH.
+func (m *Monitor) usingWatch() bool {
+	return !m.isupport.IsEnabled("MONITOR") && m.isupport.IsEnabled("WATCH")
+}
+
+// Add starts monitoring the given nicks. If the server advertised a MONITOR
+// or WATCH limit and adding these nicks would go over it, ErrMonitorListFull
+// will be returned and nothing will be sent.
+func (m *Monitor) Add(nicks ...string) error {
+	m.Lock()
+
+	var added []string
+	seen := make(map[string]struct{})
+	for _, nick := range nicks {
+		if _, state := m.lookup(nick); state != nil {
+			continue
+		}
+
+		key := m.isupport.Fold(nick)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+
+		seen[key] = struct{}{}
+		added = append(added, nick)
+	}
+
+	token := "MONITOR"
+	if m.usingWatch() {
+		token = "WATCH"
+	}
+
+	if limit, ok := m.isupport.GetInt(token); ok && limit > 0 && len(m.nicks)+len(added) > limit {
+		m.Unlock()
+		return ErrMonitorListFull
+	}
+
+	for _, nick := range added {
+		m.nicks[nick] = &monitorState{nick: nick}
+	}
+
+	m.Unlock()
+
+	return m.send("+", added)
+}
+
+// Remove stops monitoring the given nicks.
+func (m *Monitor) Remove(nicks ...string) error {
+	m.Lock()
+
+	var removed []string
+	for _, nick := range nicks {
+		key, state := m.lookup(nick)
+		if state == nil {
+			continue
+		}
+
+		delete(m.nicks, key)
+		removed = append(removed, nick)
+	}
+
+	m.Unlock()
+
+	return m.send("-", removed)
+}
+
+// Clear stops monitoring all nicks.
+func (m *Monitor) Clear() error {
+	m.Lock()
+	m.nicks = make(map[string]*monitorState)
+	m.Unlock()
+
+	if m.usingWatch() {
+		return m.writer.Write("WATCH C")
+	}
+
+	return m.writer.Write("MONITOR C")
+}
+
+// Refresh asks the server to send the current status of all monitored nicks.
+func (m *Monitor) Refresh() error {
+	if m.usingWatch() {
+		return m.writer.Write("WATCH L")
+	}
+
+	return m.writer.Write("MONITOR S")
+}
+
+// List returns all the nicks currently being monitored.
+func (m *Monitor) List() []string {
+	m.RLock()
+	defer m.RUnlock()
+
+	ret := make([]string, 0, len(m.nicks))
+	for _, state := range m.nicks {
+		ret = append(ret, state.nick)
+	}
+
+	return ret
+}
+
+// IsOnline returns whether the given nick is online. known will be false if
+// the nick isn't being monitored or the server hasn't told us its status yet.
+func (m *Monitor) IsOnline(nick string) (online, known bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	_, state := m.lookup(nick)
+	if state == nil {
+		return false, false
+	}
+
+	return state.online, state.known
+}
+
+// lookup finds the monitored nick matching the given nick with the current
+// casemapping, returning its key in m.nicks and its state. The state will be
+// nil if the nick isn't being monitored. The lock must be held when calling
+// this.
+func (m *Monitor) lookup(nick string) (string, *monitorState) {
+	if state, ok := m.nicks[nick]; ok {
+		return nick, state
+	}
+
+	folded := m.isupport.Fold(nick)
+	for key, state := range m.nicks {
+		if m.isupport.Fold(key) == folded {
+			return key, state
+		}
+	}
+
+	return "", nil
+}
+
+// forget stops tracking the given nick. The lock must be held when calling
+// this.
+func (m *Monitor) forget(nick string) {
+	if key, state := m.lookup(nick); state != nil {
+		delete(m.nicks, key)
+	}
+}
+
+// send writes MONITOR or WATCH commands for the given nicks, splitting them
+// across multiple lines if needed.
+func (m *Monitor) send(op string, nicks []string) error {
+	if m.usingWatch() {
+		return m.sendWatch(op, nicks)
+	}
+
+	for len(nicks) > 0 {
+		length := 0
+		i := 0
+		for ; i < len(nicks); i++ {
+			length += len(nicks[i]) + 1
+			if length > monitorLineLength && i > 0 {
+				break
+			}
+		}
+
+		err := m.writer.Writef("MONITOR %s %s", op, strings.Join(nicks[:i], ","))
+		if err != nil {
+			return err
+		}
+
+		nicks = nicks[i:]
+	}
+
+	return nil
+}
+
+// sendWatch writes WATCH commands for the given nicks. Unlike MONITOR, each
+// nick is prefixed with the operation.
+func (m *Monitor) sendWatch(op string, nicks []string) error {
+	for len(nicks) > 0 {
+		line := "WATCH"
+		i := 0
+		for ; i < len(nicks); i++ {
+			if len(line)+len(nicks[i])+2 > monitorLineLength && i > 0 {
+				break
+			}
+
+			line += " " + op + nicks[i]
+		}
+
+		err := m.writer.Write(line)
+		if err != nil {
+			return err
+		}
+
+		nicks = nicks[i:]
+	}
+
+	return nil
+}
+
+// Handle needs to be called for all 730, 731, and 734 messages, along with
+// the WATCH numerics 512, 600, 601, 604, and 605. All other messages will be
+// ignored.
+func (m *Monitor) Handle(msg *Message) error {
+	switch msg.Command {
+	case RPL_MONONLINE:
+		return m.handleMonitorStatus(msg, true)
+	case RPL_MONOFFLINE:
+		return m.handleMonitorStatus(msg, false)
+	case ERR_MONLISTFULL:
+		return m.handleListFull(msg)
+	case "600", "604": // RPL_LOGON, RPL_NOWON
+		return m.handleWatchStatus(msg, true)
+	case "601", "605": // RPL_LOGOFF, RPL_NOWOFF
+		return m.handleWatchStatus(msg, false)
+	case "512": // ERR_TOOMANYWATCH
+		return m.handleTooManyWatch(msg)
+	}
+
+	return nil
+}
+
+func (m *Monitor) handleMonitorStatus(msg *Message, online bool) error {
+	if len(msg.Params) < 2 {
+		return errors.New("malformed MONITOR status message")
+	}
+
+	var nicks []string
+	for _, target := range strings.Split(msg.Trailing(), ",") {
+		// RPL_MONONLINE includes the full prefix.
+		nicks = append(nicks, ParsePrefix(target).Name)
+	}
+
+	m.updateStatus(nicks, online)
+
+	return nil
+}
+
+func (m *Monitor) handleWatchStatus(msg *Message, online bool) error {
+	if len(msg.Params) < 2 {
+		return errors.New("malformed WATCH status message")
+	}
+
+	m.updateStatus([]string{msg.Params[1]}, online)
+
+	return nil
+}
+
+func (m *Monitor) handleTooManyWatch(msg *Message) error {
+	if len(msg.Params) < 2 {
+		return errors.New("malformed ERR_TOOMANYWATCH message")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.forget(msg.Params[1])
+
+	return nil
+}
+
+// updateStatus records the new status of the given nicks and calls the
+// callbacks for any which changed.
+func (m *Monitor) updateStatus(nicks []string, online bool) {
+	type change struct {
+		nick   string
+		online bool
+	}
+
+	var changes []change
+
+	m.Lock()
+	for _, nick := range nicks {
+		_, state := m.lookup(nick)
+		if state == nil {
+			continue
+		}
+
+		if state.known && state.online == online {
+			continue
+		}
+
+		state.known = true
+		state.online = online
+		changes = append(changes, change{state.nick, online})
+	}
+	callbacks := m.callbacks
+	m.Unlock()
+
+	for _, c := range changes {
+		for _, f := range callbacks {
+			f(c.nick, c.online)
+		}
+	}
+}
+
+func (m *Monitor) handleListFull(msg *Message) error {
+	if len(msg.Params) < 3 {
+		return errors.New("malformed ERR_MONLISTFULL message")
+	}
+
+	// The server didn't add these nicks, so we stop tracking them.
+	m.Lock()
+	defer m.Unlock()
+
+	for _, nick := range strings.Split(msg.Params[2], ",") {
+		m.forget(nick)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package irc_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestMonitor(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{EnableMonitor: true})
+	monitor := ht.Client.Monitor
+	require.NotNil(t, monitor)
+
+	type change struct {
+		nick   string
+		online bool
+	}
+	var changes []change
+	monitor.OnChange(func(nick string, online bool) {
+		changes = append(changes, change{nick, online})
+	})
+
+	require.NoError(t, ht.Feed("005 test_nick MONITOR=3 :are supported by this server"))
+
+	assert.NoError(t, monitor.Add("Alice", "bob"))
+	assert.NoError(t, ht.ExpectWrites("MONITOR + Alice,bob"))
+
+	// Nicks which are already monitored don't count towards the limit.
+	assert.Equal(t, irc.ErrMonitorListFull, monitor.Add("alice", "carol", "dave"))
+	assert.NoError(t, monitor.Add("alice", "carol"))
+	assert.NoError(t, ht.ExpectWrites("MONITOR + carol"))
+
+	_, known := monitor.IsOnline("alice")
+	assert.False(t, known)
+
+	require.NoError(t, ht.Feed(
+		":irc.example.com 730 test_nick :alice!user@host,bob!user@host",
+		":irc.example.com 731 test_nick :carol,unknown",
+		":irc.example.com 730 test_nick :alice!user@host",
+		":irc.example.com 731 test_nick :bob",
+	))
+
+	assert.Equal(t, []change{
+		{"Alice", true},
+		{"bob", true},
+		{"carol", false},
+		{"bob", false},
+	}, changes)
+
+	online, known := monitor.IsOnline("ALICE")
+	assert.True(t, known)
+	assert.True(t, online)
+
+	assert.NoError(t, monitor.Remove("bob", "unknown"))
+	assert.NoError(t, ht.ExpectWrites("MONITOR - bob"))
+	assert.ElementsMatch(t, []string{"Alice", "carol"}, monitor.List())
+
+	// Nicks the server refused are dropped.
+	require.NoError(t, ht.Feed(":irc.example.com 734 test_nick 3 carol :Monitor list is full."))
+	assert.Equal(t, []string{"Alice"}, monitor.List())
+
+	// Nicks are compared using the server's casemapping.
+	assert.NoError(t, monitor.Add("nick[m]", "NICK{M}"))
+	assert.NoError(t, ht.ExpectWrites("MONITOR + nick[m]"))
+	require.NoError(t, ht.Feed(":irc.example.com 730 test_nick :NICK{M}!user@host"))
+
+	online, known = monitor.IsOnline("Nick[M]")
+	assert.True(t, known)
+	assert.True(t, online)
+
+	assert.NoError(t, monitor.Clear())
+	assert.NoError(t, monitor.Refresh())
+	assert.NoError(t, ht.ExpectWrites("MONITOR C", "MONITOR S"))
+	assert.Empty(t, monitor.List())
+
+	// Without a limit, long lists are split across lines.
+	ht = irc.NewHandlerTester(nil, irc.ClientConfig{EnableMonitor: true})
+
+	var nicks []string
+	for i := 0; i < 100; i++ {
+		nicks = append(nicks, fmt.Sprintf("nick_%03d", i))
+	}
+	assert.NoError(t, ht.Client.Monitor.Add(append(nicks, "nick_000")...))
+
+	var sent []string
+	writes := ht.Writes()
+	assert.True(t, len(writes) > 1)
+	for _, m := range writes {
+		assert.True(t, len(m.String()) < 510)
+		sent = append(sent, strings.Split(m.Trailing(), ",")...)
+	}
+	assert.Equal(t, nicks, sent)
+}
+
+func TestMonitorCasemappingChange(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{EnableMonitor: true})
+	monitor := ht.Client.Monitor
+	require.NotNil(t, monitor)
+
+	// Nicks added before CASEMAPPING is known should still match once it
+	// arrives.
+	assert.NoError(t, monitor.Add("NICK[M]"))
+	assert.NoError(t, ht.ExpectWrites("MONITOR + NICK[M]"))
+	require.NoError(t, ht.Feed(
+		"005 test_nick MONITOR CASEMAPPING=ascii :are supported by this server",
+		":irc.example.com 730 test_nick :nick[m]!user@host",
+	))
+
+	online, known := monitor.IsOnline("Nick[m]")
+	assert.True(t, known)
+	assert.True(t, online)
+
+	_, known = monitor.IsOnline("nick{m}")
+	assert.False(t, known)
+
+	assert.NoError(t, monitor.Remove("nick[m]"))
+	assert.NoError(t, ht.ExpectWrites("MONITOR - nick[m]"))
+	assert.Empty(t, monitor.List())
+}
+
+func TestMonitorWatch(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{EnableMonitor: true})
+	monitor := ht.Client.Monitor
+
+	var changes []string
+	monitor.OnChange(func(nick string, online bool) {
+		changes = append(changes, fmt.Sprintf("%s %v", nick, online))
+	})
+
+	require.NoError(t, ht.Feed("005 test_nick WATCH=2 :are supported by this server"))
+
+	assert.Equal(t, irc.ErrMonitorListFull, monitor.Add("alice", "bob", "carol"))
+	assert.NoError(t, monitor.Add("alice", "bob"))
+	assert.NoError(t, ht.ExpectWrites("WATCH +alice +bob"))
+
+	require.NoError(t, ht.Feed(
+		":irc.example.com 604 test_nick alice user host 1600000000 :is online",
+		":irc.example.com 605 test_nick bob * * 0 :is offline",
+		":irc.example.com 601 test_nick alice user host 1600000001 :logged offline",
+		":irc.example.com 600 test_nick bob user host 1600000002 :logged online",
+	))
+
+	assert.Equal(t, []string{"alice true", "bob false", "alice false", "bob true"}, changes)
+
+	assert.NoError(t, monitor.Remove("alice"))
+	assert.NoError(t, monitor.Refresh())
+	assert.NoError(t, monitor.Clear())
+	assert.NoError(t, ht.ExpectWrites("WATCH -alice", "WATCH L", "WATCH C"))
+
+	// Nicks the server refused are dropped.
+	assert.NoError(t, monitor.Add("dave"))
+	require.NoError(t, ht.Feed(":irc.example.com 512 test_nick dave :Maximum size for WATCH-list is 2 entries"))
+	assert.Empty(t, monitor.List())
+
+	// MONITOR is preferred when both are supported.
+	ht = irc.NewHandlerTester(nil, irc.ClientConfig{EnableMonitor: true})
+	require.NoError(t, ht.Feed("005 test_nick WATCH=128 MONITOR=100 :are supported by this server"))
+	assert.NoError(t, ht.Client.Monitor.Add("alice"))
+	assert.NoError(t, ht.ExpectWrites("MONITOR + alice"))
+}
@@ -0,0 +1,206 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+const (
+	multilineCap       = "draft/multiline"
+	multilineBatchType = "draft/multiline"
+	multilineConcatTag = "draft/multiline-concat"
+)
+
+// CombineMultiline joins the lines of a draft/multiline batch into a single
+// PRIVMSG or NOTICE, with lines separated by \n. Lines marked with the
+// draft/multiline-concat tag are joined to the previous line directly. The
+// tags from the BATCH message, such as msgid and time, are used for the
+// combined message. The bool will be false if this isn't a multiline batch or
+// it doesn't contain any lines.
+func (b *Batch) CombineMultiline() (*Message, bool) {
+	if b.Type != multilineBatchType || len(b.Params) < 1 || len(b.Messages) == 0 {
+		return nil, false
+	}
+
+	first := b.Messages[0]
+	if first.Command != "PRIVMSG" && first.Command != "NOTICE" {
+		return nil, false
+	}
+
+	var text strings.Builder
+	for i, m := range b.Messages {
+		if m.Command != first.Command || len(m.Params) < 2 {
+			continue
+		}
+
+		if _, ok := m.Tags[multilineConcatTag]; i > 0 && !ok {
+			text.WriteByte('\n')
+		}
+
+		text.WriteString(m.Trailing())
+	}
+
+	tags := first.Tags.Copy()
+	delete(tags, "batch")
+	delete(tags, multilineConcatTag)
+
+	for k, v := range b.Start.Tags {
+		tags[k] = v
+	}
+
+	prefix := b.Start.Prefix
+	if prefix == nil {
+		prefix = first.Prefix
+	}
+
+	return &Message{
+		Tags:     tags,
+		Prefix:   prefix.Copy(),
+		Command:  first.Command,
+		Params:   []string{b.Params[0], text.String()},
+		received: first.received,
+	}, true
+}
+
+// multilineLimits returns the max-bytes and max-lines values from the
+// draft/multiline cap. A limit of 0 means there is no limit.
+func (c *Client) multilineLimits() (maxBytes, maxLines int) {
+	for _, param := range strings.Split(c.CapValue(multilineCap), ",") {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 0 {
+			continue
+		}
+
+		switch parts[0] {
+		case "max-bytes":
+			maxBytes = n
+		case "max-lines":
+			maxLines = n
+		}
+	}
+
+	return maxBytes, maxLines
+}
+
+// multilineLine is a single line of a multiline batch.
+type multilineLine struct {
+	text   string
+	concat bool
+}
+
+// SendMultiline sends text to the target as a PRIVMSG. If the draft/multiline
+// and batch caps are enabled, it will be sent in a draft/multiline batch so
+// clients can show it as a single message, otherwise it will be sent with
+// SendSplit. Long lines are split as with SendSplit, but marked so they are
+// joined back together. If the text is larger than the server allows in a
+// single batch, multiple batches will be sent. Both caps need to be requested
+// with CapRequest.
+func (c *Client) SendMultiline(target, text string) error {
+	if !c.CapEnabled(multilineCap) || !c.CapEnabled("batch") {
+		return c.SendSplit(target, text)
+	}
+
+	maxBytes, maxLines := c.multilineLimits()
+	maxText := c.maxTextLength("PRIVMSG", target)
+
+	var batch []multilineLine
+	var batchBytes int
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+
+		chunks := splitTextConcat(line, maxText)
+		if len(chunks) == 0 {
+			chunks = []string{""}
+		}
+
+		for j, chunk := range chunks {
+			size := len(chunk)
+			if i > 0 && j == 0 {
+				// Account for the newline between lines.
+				size++
+			}
+
+			full := len(batch) > 0 &&
+				((maxLines > 0 && len(batch)+1 > maxLines) || (maxBytes > 0 && batchBytes+size > maxBytes))
+			if full {
+				err := c.writeMultiline(target, batch)
+				if err != nil {
+					return err
+				}
+
+				batch = nil
+				batchBytes = 0
+				size = len(chunk)
+			}
+
+			// The first line of a batch can't be concatenated with anything.
+			batch = append(batch, multilineLine{text: chunk, concat: j > 0 && len(batch) > 0})
+			batchBytes += size
+		}
+	}
+
+	return c.writeMultiline(target, batch)
+}
+
+// writeMultiline sends a single draft/multiline batch.
+func (c *Client) writeMultiline(target string, lines []multilineLine) error {
+	ref := "ml" + strconv.FormatUint(uint64(atomic.AddUint32(&c.multilineRef, 1)), 10)
+
+	err := c.Writef("BATCH +%s %s %s", ref, multilineBatchType, target)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		tags := Tags{"batch": ref}
+		if line.concat {
+			tags[multilineConcatTag] = ""
+		}
+
+		err = c.WriteMessage(&Message{
+			Tags:    tags,
+			Command: "PRIVMSG",
+			Params:  []string{target, line.text},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.Writef("BATCH -%s", ref)
+}
+
+// splitTextConcat is like splitText, but spaces at split points are kept at
+// the end of the previous chunk so the chunks can be joined back together
+// exactly.
+func splitTextConcat(text string, max int) []string {
+	var ret []string
+
+	for len(text) > max {
+		cut := len(truncateUTF8(text, max))
+		if i := strings.LastIndexByte(text[:cut], ' '); i > 0 {
+			cut = i + 1
+		}
+
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+
+		ret = append(ret, text[:cut])
+		text = text[cut:]
+	}
+
+	if text != "" {
+		ret = append(ret, text)
+	}
+
+	return ret
+}
@@ -0,0 +1,111 @@
+package irc_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestMultiline(t *testing.T) {
+	t.Parallel()
+
+	handler := &TestHandler{}
+	rw := newTestReadWriter()
+	c := irc.NewClient(rw, irc.ClientConfig{
+		Nick:    "test_nick",
+		User:    "test_user",
+		Name:    "test_name",
+		Handler: handler,
+	})
+	c.CapRequest("batch", true)
+	c.CapRequest("draft/multiline", true)
+
+	go func() {
+		assert.Equal(t, io.EOF, c.Run())
+		close(rw.clientDone)
+	}()
+
+	long := strings.Repeat("a ", 250)
+
+	runTest(t, rw, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		LineFunc(func(m *irc.Message) { assert.Equal(t, "CAP", m.Command) }),
+		LineFunc(func(m *irc.Message) { assert.Equal(t, "CAP", m.Command) }),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :batch draft/multiline=max-bytes=600,max-lines=3\r\n"),
+		SendLine("CAP * ACK :batch\r\n"),
+		SendLine("CAP * ACK :draft/multiline\r\n"),
+		ExpectLine("CAP END\r\n"),
+		SendLine("001 test_nick :Welcome test_nick!test_user@host\r\n"),
+
+		// Incoming batches should be combined into a single message.
+		SendLine("@msgid=abc :a!u@h BATCH +ref draft/multiline #chan\r\n"),
+		SendLine("@batch=ref :a!u@h PRIVMSG #chan :hello\r\n"),
+		SendLine("@batch=ref :a!u@h PRIVMSG #chan :wor\r\n"),
+		SendLine("@batch=ref;draft/multiline-concat :a!u@h PRIVMSG #chan :ld\r\n"),
+		SendLine("@batch=ref :a!u@h PRIVMSG #chan :\r\n"),
+		SendLine("@batch=ref :a!u@h PRIVMSG #chan :bye\r\n"),
+		SendLine(":a!u@h BATCH -ref\r\n"),
+
+		func(t *testing.T, rw *testReadWriter) {
+			go func() { assert.NoError(t, c.SendMultiline("#chan", "one\ntwo\r\nthree\nfour")) }()
+		},
+		ExpectLine("BATCH +ml1 draft/multiline #chan\r\n"),
+		ExpectLine("@batch=ml1 PRIVMSG #chan one\r\n"),
+		ExpectLine("@batch=ml1 PRIVMSG #chan two\r\n"),
+		ExpectLine("@batch=ml1 PRIVMSG #chan three\r\n"),
+		ExpectLine("BATCH -ml1\r\n"),
+		ExpectLine("BATCH +ml2 draft/multiline #chan\r\n"),
+		ExpectLine("@batch=ml2 PRIVMSG #chan four\r\n"),
+		ExpectLine("BATCH -ml2\r\n"),
+
+		// Long lines are split and marked to be joined back together, and
+		// the byte limit should be respected.
+		func(t *testing.T, rw *testReadWriter) {
+			go func() { assert.NoError(t, c.SendMultiline("#chan", long+"\n"+long)) }()
+		},
+		ExpectLine("BATCH +ml3 draft/multiline #chan\r\n"),
+		ExpectLine("@batch=ml3 PRIVMSG #chan :" + long[:468] + "\r\n"),
+		LineFunc(func(m *irc.Message) {
+			assert.Equal(t, irc.Tags{"batch": "ml3", "draft/multiline-concat": ""}, m.Tags)
+			assert.Equal(t, []string{"#chan", long[468:]}, m.Params)
+		}),
+		ExpectLine("BATCH -ml3\r\n"),
+		ExpectLine("BATCH +ml4 draft/multiline #chan\r\n"),
+		ExpectLine("@batch=ml4 PRIVMSG #chan :" + long[:468] + "\r\n"),
+		LineFunc(func(m *irc.Message) {
+			assert.Equal(t, irc.Tags{"batch": "ml4", "draft/multiline-concat": ""}, m.Tags)
+			assert.Equal(t, []string{"#chan", long[468:]}, m.Params)
+		}),
+		ExpectLine("BATCH -ml4\r\n"),
+	})
+
+	var privmsgs []*irc.Message
+	for _, m := range handler.Messages() {
+		assert.NotEqual(t, "BATCH", m.Command)
+		if m.Command == "PRIVMSG" {
+			privmsgs = append(privmsgs, m)
+		}
+	}
+
+	if assert.Len(t, privmsgs, 1) {
+		assert.Equal(t, "hello\nworld\n\nbye", privmsgs[0].Trailing())
+		assert.Equal(t, "#chan", privmsgs[0].Params[0])
+		assert.Equal(t, "a", privmsgs[0].Prefix.Name)
+		assert.Equal(t, irc.Tags{"msgid": "abc"}, privmsgs[0].Tags)
+	}
+}
+
+func TestSendMultilineFallback(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{Nick: "test_nick"})
+	require.NoError(t, ht.Client.SendMultiline("#chan", "one\ntwo"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan one", "PRIVMSG #chan two"))
+}
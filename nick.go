@@ -0,0 +1,92 @@
+package irc
+
+import "time"
+
+// defaultNickTransform is used when NickTransform isn't set.
+func defaultNickTransform(nick string) string {
+	return nick + "_"
+}
+
+// nextNick picks the nick to try after the current one was rejected during
+// registration. Each of the AltNicks is tried in order before falling back to
+// the NickTransform.
+func (c *Client) nextNick() string {
+	if c.altNickIndex < len(c.config.AltNicks) {
+		nick := c.config.AltNicks[c.altNickIndex]
+		c.altNickIndex++
+		return nick
+	}
+
+	transform := c.config.NickTransform
+	if transform == nil {
+		transform = defaultNickTransform
+	}
+
+	return transform(c.currentNick)
+}
+
+// handleNickCollision tries the next nick if the one we registered with is in
+// use or unavailable.
+func (c *Client) handleNickCollision() {
+	// We only want to try and handle nick collisions during the initial
+	// handshake.
+	if c.connected {
+		return
+	}
+
+	c.currentNick = c.nextNick()
+	_ = c.Writef("NICK :%s", c.currentNick)
+}
+
+// foldNick lowercases a nick so it can be compared, using the server's
+// CASEMAPPING if it's known.
+func (c *Client) foldNick(nick string) string {
+	if c.ISupport != nil {
+		return c.ISupport.Fold(nick)
+	}
+
+	return CasefoldRFC1459(nick)
+}
+
+// hasPrimaryNick returns true if we are using the Nick we were configured
+// with.
+func (c *Client) hasPrimaryNick() bool {
+	return c.foldNick(c.currentNick) == c.foldNick(c.config.Nick)
+}
+
+// maybeRegainNick tries to change back to our primary nick if RegainInterval
+// is set and it has been at least that long since the last attempt. If force
+// is true, the interval is ignored, such as when we see the nick become free.
+func (c *Client) maybeRegainNick(force bool) {
+	if c.config.RegainInterval <= 0 || !c.connected || c.hasPrimaryNick() {
+		return
+	}
+
+	now := time.Now()
+	if !force && now.Sub(c.lastRegain) < c.config.RegainInterval {
+		return
+	}
+
+	c.lastRegain = now
+
+	if c.config.RegainFunc != nil {
+		_ = c.config.RegainFunc(c, c.config.Nick)
+		return
+	}
+
+	_ = c.Writef("NICK :%s", c.config.Nick)
+}
+
+// handleNickFreed watches for whoever is using our primary nick quitting or
+// changing away from it so we can try to regain it right away.
+func handleNickFreed(c *Client, m *Message) {
+	if m.Prefix == nil || c.config.RegainInterval <= 0 {
+		return
+	}
+
+	if c.foldNick(m.Prefix.Name) != c.foldNick(c.config.Nick) {
+		return
+	}
+
+	c.maybeRegainNick(true)
+}
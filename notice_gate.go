@@ -0,0 +1,92 @@
+package irc
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// NoticeGate describes a server NOTICE which may be sent during registration
+// to tell the client about a check it is waiting on, such as an ident lookup,
+// or a throttle which will cause the connection to be dropped.
+type NoticeGate struct {
+	// Name identifies this gate in callbacks and errors.
+	Name string
+
+	// Pattern is matched against the text of every NOTICE received before
+	// registration completes. If it contains a submatch, the first one will be
+	// parsed as the number of seconds the server wants us to wait.
+	Pattern *regexp.Regexp
+
+	// Fatal should be set if the server will close the connection after
+	// sending this notice. When a fatal gate matches, Run will return a
+	// *NoticeGateError.
+	Fatal bool
+
+	// Wait is how long to wait before reconnecting when the notice doesn't
+	// say. A ReconnectingClient won't try again any sooner than this, or the
+	// time from the notice.
+	Wait time.Duration
+}
+
+// DefaultNoticeGates is a set of NoticeGates matching notices sent by common
+// ircds.
+var DefaultNoticeGates = []NoticeGate{
+	{
+		Name:    "ident",
+		Pattern: regexp.MustCompile(`(?i)checking ident`),
+	},
+	{
+		Name:    "hostname",
+		Pattern: regexp.MustCompile(`(?i)looking up your hostname`),
+	},
+	{
+		Name:    "throttle",
+		Pattern: regexp.MustCompile(`(?i)wait (\d+) seconds? before reconnecting`),
+		Fatal:   true,
+	},
+}
+
+// NoticeGateError is returned from Run when a fatal NoticeGate matches a
+// notice from the server.
+type NoticeGateError struct {
+	// Gate is the name of the NoticeGate which matched.
+	Gate string
+
+	// Wait is how long the server asked us to wait before reconnecting, or
+	// the gate's Wait if no time was given.
+	Wait time.Duration
+
+	// Text is the full text of the notice.
+	Text string
+}
+
+// Error implements the error interface.
+func (e *NoticeGateError) Error() string {
+	return "irc: registration blocked by " + e.Gate + " gate: " + e.Text
+}
+
+// matchNoticeGate returns the first gate matching the given text along with
+// the requested wait time, falling back to the gate's Wait.
+func matchNoticeGate(gates []NoticeGate, text string) (*NoticeGate, time.Duration, bool) {
+	for i := range gates {
+		gate := &gates[i]
+
+		match := gate.Pattern.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+
+		wait := gate.Wait
+		if len(match) > 1 {
+			seconds, err := strconv.Atoi(match[1])
+			if err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+		}
+
+		return gate, wait, true
+	}
+
+	return nil, 0, false
+}
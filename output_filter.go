@@ -0,0 +1,60 @@
+package irc
+
+import "strings"
+
+// OutputFilter can rewrite, split, or drop outgoing messages. See
+// ClientConfig.OutputFilters.
+type OutputFilter interface {
+	// FilterOutput returns the messages which should be sent in place of m.
+	// Returning m unchanged sends it as is, and returning nothing drops it.
+	FilterOutput(c *Client, m *Message) []*Message
+}
+
+// OutputFilterFunc is a simple wrapper around a function which allows it to be
+// used as an OutputFilter.
+type OutputFilterFunc func(c *Client, m *Message) []*Message
+
+// FilterOutput calls f(c, m).
+func (f OutputFilterFunc) FilterOutput(c *Client, m *Message) []*Message {
+	return f(c, m)
+}
+
+// filterOutput runs an outgoing line through the OutputFilters, returning the
+// lines which should be sent in its place. Lines which can't be parsed are
+// passed through untouched so the server can reject them.
+func (c *Client) filterOutput(raw string) ([]string, error) {
+	m, err := ParseMessage(raw)
+	if err != nil {
+		return []string{raw}, nil //nolint:nilerr
+	}
+
+	// Messages which come through untouched are sent as they were written,
+	// rather than being reformatted.
+	unchanged := m.String()
+
+	messages := []*Message{m}
+	for _, filter := range c.config.OutputFilters {
+		var next []*Message
+		for _, m := range messages {
+			next = append(next, filter.FilterOutput(c, m)...)
+		}
+		messages = next
+	}
+
+	lines := make([]string, 0, len(messages))
+	for _, m := range messages {
+		line := m.String()
+		if line == unchanged {
+			lines = append(lines, raw)
+			continue
+		}
+
+		if strings.ContainsAny(line, unsafeChars) {
+			return nil, ErrUnsafeLine
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
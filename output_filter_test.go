@@ -0,0 +1,80 @@
+package irc_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestOutputFilters(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		OutputFilters: []irc.OutputFilter{
+			// Drop anything sent to #quiet.
+			irc.OutputFilterFunc(func(c *irc.Client, m *irc.Message) []*irc.Message {
+				if m.Command == "PRIVMSG" && m.Param(0) == "#quiet" {
+					return nil
+				}
+				return []*irc.Message{m}
+			}),
+
+			// Send a copy of everything sent to #a to #b.
+			irc.OutputFilterFunc(func(c *irc.Client, m *irc.Message) []*irc.Message {
+				if m.Command != "PRIVMSG" || m.Param(0) != "#a" {
+					return []*irc.Message{m}
+				}
+
+				cp := m.Copy()
+				cp.Params[0] = "#b"
+				return []*irc.Message{m, cp}
+			}),
+
+			// Shout.
+			irc.OutputFilterFunc(func(c *irc.Client, m *irc.Message) []*irc.Message {
+				if m.Command == "PRIVMSG" {
+					m.Params[1] = strings.ToUpper(m.Params[1])
+				}
+				return []*irc.Message{m}
+			}),
+		},
+
+		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
+			if m.Command != "001" {
+				return
+			}
+
+			assert.NoError(t, c.Write("PRIVMSG #quiet :hello"))
+			assert.NoError(t, c.Write("PRIVMSG #a :hello"))
+		}),
+	}
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 :hello_world\r\n"),
+		ExpectLine("PRIVMSG #a HELLO\r\n"),
+		ExpectLine("PRIVMSG #b HELLO\r\n"),
+	})
+
+	// Filters can't be used to sneak in extra lines.
+	config.OutputFilters = []irc.OutputFilter{
+		irc.OutputFilterFunc(func(c *irc.Client, m *irc.Message) []*irc.Message {
+			m.Params = append(m.Params, "oops\r\nQUIT")
+			return []*irc.Message{m}
+		}),
+	}
+	config.Handler = nil
+
+	c := irc.NewClient(newNopCloser(&bytes.Buffer{}), config)
+	assert.Equal(t, irc.ErrUnsafeLine, c.Write("PRIVMSG #a :hello"))
+}
@@ -3,10 +3,12 @@ package irc
 import (
 	"bytes"
 	"errors"
+	"sort"
 	"strings"
+	"time"
 )
 
-var tagDecodeSlashMap = map[rune]rune{
+var tagDecodeSlashMap = map[byte]byte{
 	':':  ';',
 	's':  ' ',
 	'\\': '\\',
@@ -14,7 +16,7 @@ var tagDecodeSlashMap = map[rune]rune{
 	'n':  '\n',
 }
 
-var tagEncodeMap = map[rune]string{
+var tagEncodeMap = map[byte]string{
 	';':  "\\:",
 	' ':  "\\s",
 	'\\': "\\\\",
@@ -40,35 +42,48 @@ var (
 	ErrMissingCommand = errors.New("irc: missing message command")
 )
 
+// isParseError returns true if the given error came from a malformed or
+// overlong message rather than from the underlying connection.
+func isParseError(err error) bool {
+	return errors.Is(err, ErrMissingDataAfterPrefix) ||
+		errors.Is(err, ErrMissingDataAfterTags) ||
+		errors.Is(err, ErrMissingCommand) ||
+		errors.Is(err, ErrLineTooLong)
+}
+
 // ParseTagValue parses an encoded tag value as a string. If you need to set a
 // tag, you probably want to just set the string itself, so it will be encoded
 // properly.
 func ParseTagValue(v string) string {
+	// Most tag values don't have any escapes, so we can avoid allocating.
+	if strings.IndexByte(v, '\\') == -1 {
+		return v
+	}
+
 	ret := &bytes.Buffer{}
+	ret.Grow(len(v))
 
-	input := bytes.NewBufferString(v)
+	// Tag values are processed byte by byte rather than by rune so any
+	// invalid UTF-8 is passed through untouched.
+	for i := 0; i < len(v); i++ {
+		c := v[i]
 
-	for {
-		c, _, err := input.ReadRune()
-		if err != nil {
+		if c != '\\' {
+			ret.WriteByte(c)
+			continue
+		}
+
+		// If we got a backslash followed by the end of the tag value, we
+		// should just ignore the backslash.
+		i++
+		if i >= len(v) {
 			break
 		}
 
-		if c == '\\' {
-			// If we got a backslash followed by the end of the tag value, we
-			// should just ignore the backslash.
-			c2, _, err := input.ReadRune()
-			if err != nil {
-				break
-			}
-
-			if replacement, ok := tagDecodeSlashMap[c2]; ok {
-				ret.WriteRune(replacement)
-			} else {
-				ret.WriteRune(c2)
-			}
+		if replacement, ok := tagDecodeSlashMap[v[i]]; ok {
+			ret.WriteByte(replacement)
 		} else {
-			ret.WriteRune(c)
+			ret.WriteByte(v[i])
 		}
 	}
 
@@ -77,17 +92,28 @@ func ParseTagValue(v string) string {
 
 // EncodeTagValue converts a raw string to the format in the connection.
 func EncodeTagValue(v string) string {
-	ret := &bytes.Buffer{}
+	if strings.IndexAny(v, ";\\ \r\n") == -1 {
+		return v
+	}
 
-	for _, c := range v {
-		if replacement, ok := tagEncodeMap[c]; ok {
-			ret.WriteString(replacement)
+	return string(appendTagValue(make([]byte, 0, len(v)+8), v))
+}
+
+// appendTagValue appends the encoded form of a tag value to buf.
+func appendTagValue(buf []byte, v string) []byte {
+	if strings.IndexAny(v, ";\\ \r\n") == -1 {
+		return append(buf, v...)
+	}
+
+	for i := 0; i < len(v); i++ {
+		if replacement, ok := tagEncodeMap[v[i]]; ok {
+			buf = append(buf, replacement...)
 		} else {
-			ret.WriteRune(c)
+			buf = append(buf, v[i])
 		}
 	}
 
-	return ret.String()
+	return buf
 }
 
 // Tags represents the IRCv3 message tags.
@@ -96,20 +122,42 @@ type Tags map[string]string
 // ParseTags takes a tag string and parses it into a tag map. It will
 // always return a tag map, even if there are no valid tags.
 func ParseTags(line string) Tags {
-	ret := Tags{}
+	ret := make(Tags, strings.Count(line, ";")+1)
+	ret.parse(line)
+
+	return ret
+}
 
-	tags := strings.Split(line, ";")
-	for _, tag := range tags {
-		parts := strings.SplitN(tag, "=", 2)
-		if len(parts) < 2 {
-			ret[parts[0]] = ""
+// parse adds the tags from a tag string to t.
+func (t Tags) parse(line string) {
+	for len(line) > 0 {
+		var tag string
+		if i := strings.IndexByte(line, ';'); i != -1 {
+			tag, line = line[:i], line[i+1:]
+		} else {
+			tag, line = line, ""
+		}
+
+		// Skip any empty tags, which can come from extra semicolons.
+		if tag == "" || tag[0] == '=' {
 			continue
 		}
 
-		ret[parts[0]] = ParseTagValue(parts[1])
+		i := strings.IndexByte(tag, '=')
+		if i == -1 {
+			t[tag] = ""
+			continue
+		}
+
+		t[tag[:i]] = ParseTagValue(tag[i+1:])
 	}
+}
 
-	return ret
+// clear removes all tags, keeping the map so it can be reused.
+func (t Tags) clear() {
+	for k := range t {
+		delete(t, k)
+	}
 }
 
 // Copy will create a new copy of all IRC tags attached to this
@@ -124,24 +172,101 @@ func (t Tags) Copy() Tags {
 	return ret
 }
 
+// IsClientTag returns true if the given tag key is a client-only tag, which
+// is one starting with a +. Client-only tags may only be sent when the
+// message-tags cap has been negotiated.
+func IsClientTag(key string) bool {
+	return strings.HasPrefix(key, "+")
+}
+
+// HasClientTags returns true if any of the tags are client-only tags.
+func (t Tags) HasClientTags() bool {
+	for k := range t {
+		if IsClientTag(k) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetTag returns the unescaped value of the given tag and whether it was
+// present at all. Note that tags without a value will return an empty string
+// and true.
+func (t Tags) GetTag(key string) (string, bool) {
+	v, ok := t[key]
+	return v, ok
+}
+
+// SetTag sets the value of the given tag, creating the Tags if needed. The
+// value should not be escaped; that will be done when the message is
+// converted to a string.
+func (t *Tags) SetTag(key, value string) {
+	if *t == nil {
+		*t = Tags{}
+	}
+
+	(*t)[key] = value
+}
+
+// DeleteTag removes the given tag if it exists.
+func (t Tags) DeleteTag(key string) {
+	delete(t, key)
+}
+
 // String ensures this is stringable.
 func (t Tags) String() string {
-	buf := &bytes.Buffer{}
+	return string(t.appendTo(nil))
+}
 
-	for k, v := range t {
-		buf.WriteByte(';')
-		buf.WriteString(k)
-		if v != "" {
-			buf.WriteByte('=')
-			buf.WriteString(EncodeTagValue(v))
+// appendTo appends the encoded tags to buf, sorted by key so the output is
+// stable.
+func (t Tags) appendTo(buf []byte) []byte {
+	// Most messages only have a few tags, so they can be sorted on the stack
+	// without allocating.
+	var stack [16]string
+
+	var keys []string
+	if len(t) <= len(stack) {
+		keys = stack[:0]
+		for k := range t {
+			keys = insertSorted(keys, k)
+		}
+	} else {
+		keys = make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
 		}
+		sort.Strings(keys)
 	}
 
-	// We don't need the first byte because that's an extra ';'
-	// character.
-	_, _ = buf.ReadByte()
+	for i, k := range keys {
+		if i > 0 {
+			buf = append(buf, ';')
+		}
+
+		buf = append(buf, k...)
+		if v := t[k]; v != "" {
+			buf = append(buf, '=')
+			buf = appendTagValue(buf, v)
+		}
+	}
+
+	return buf
+}
+
+// insertSorted adds s to the sorted slice keys, keeping it sorted. There must
+// be room in keys for it.
+func insertSorted(keys []string, s string) []string {
+	i := len(keys)
+	keys = append(keys, s)
+
+	for ; i > 0 && keys[i-1] > s; i-- {
+		keys[i] = keys[i-1]
+	}
+	keys[i] = s
 
-	return buf.String()
+	return keys
 }
 
 // Prefix represents the prefix of a message, generally the user who sent it.
@@ -161,22 +286,24 @@ type Prefix struct {
 // identity struct. It will always return an Prefix struct and never
 // nil.
 func ParsePrefix(line string) *Prefix {
-	// Start by creating an Prefix with nothing but the host
-	id := &Prefix{
-		Name: line,
-	}
+	id := &Prefix{}
+	id.parse(line)
 
-	uh := strings.SplitN(id.Name, "@", 2)
-	if len(uh) == 2 {
-		id.Name, id.Host = uh[0], uh[1]
-	}
+	return id
+}
+
+// parse replaces the contents of p with the parsed identity string.
+func (p *Prefix) parse(line string) {
+	// Start with nothing but the host
+	*p = Prefix{Name: line}
 
-	nu := strings.SplitN(id.Name, "!", 2)
-	if len(nu) == 2 {
-		id.Name, id.User = nu[0], nu[1]
+	if i := strings.IndexByte(p.Name, '@'); i != -1 {
+		p.Name, p.Host = p.Name[:i], p.Name[i+1:]
 	}
 
-	return id
+	if i := strings.IndexByte(p.Name, '!'); i != -1 {
+		p.Name, p.User = p.Name[:i], p.Name[i+1:]
+	}
 }
 
 // Copy will create a new copy of an Prefix.
@@ -194,20 +321,24 @@ func (p *Prefix) Copy() *Prefix {
 
 // String ensures this is stringable.
 func (p *Prefix) String() string {
-	buf := &bytes.Buffer{}
-	buf.WriteString(p.Name)
+	return string(p.appendTo(nil))
+}
+
+// appendTo appends the prefix to buf.
+func (p *Prefix) appendTo(buf []byte) []byte {
+	buf = append(buf, p.Name...)
 
 	if p.User != "" {
-		buf.WriteString("!")
-		buf.WriteString(p.User)
+		buf = append(buf, '!')
+		buf = append(buf, p.User...)
 	}
 
 	if p.Host != "" {
-		buf.WriteString("@")
-		buf.WriteString(p.Host)
+		buf = append(buf, '@')
+		buf = append(buf, p.Host...)
 	}
 
-	return buf.String()
+	return buf
 }
 
 // Message represents a line parsed from the server.
@@ -223,6 +354,14 @@ type Message struct {
 
 	// Params are all the arguments for the command.
 	Params []string
+
+	// received is when the message was read, if the Reader was configured
+	// to record it.
+	received time.Time
+
+	// pooled is set if this message came from the message pool and should
+	// be returned to it by Release.
+	pooled bool
 }
 
 // MustParseMessage calls ParseMessage and either returns the message
@@ -238,71 +377,161 @@ func MustParseMessage(line string) *Message {
 // ParseMessage takes a message string (usually a whole line) and
 // parses it into a Message struct. This will return nil in the case
 // of invalid messages.
-func ParseMessage(line string) (*Message, error) { //nolint:funlen
+func ParseMessage(line string) (*Message, error) {
+	c := &Message{}
+
+	err := c.parse(line)
+	if err != nil {
+		return nil, err
+	}
+
+	// If there are no params, set it to nil, to make writing tests and other
+	// things simpler.
+	if len(c.Params) == 0 {
+		c.Params = nil
+	}
+
+	return c, nil
+}
+
+// ParseMessageBytes is the same as ParseMessage, but takes a byte slice, such
+// as a line read directly from a connection. The line is copied, so the slice
+// may be reused once this returns.
+func ParseMessageBytes(line []byte) (*Message, error) {
+	c := &Message{}
+
+	err := c.Decode(line)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.Params) == 0 {
+		c.Params = nil
+	}
+
+	return c, nil
+}
+
+// Decode parses a line into m, replacing everything in it. The Tags map,
+// Prefix, and Params slice already in m are reused rather than allocating new
+// ones, so decoding into the same Message repeatedly only allocates a single
+// copy of the line, which all the strings in the Message share. Because of
+// this, the Tags, Prefix, and Params from a previous Decode must not be kept
+// after calling Decode again; use Copy if they are needed. Unlike
+// ParseMessage, Params will be empty rather than nil if there are none. If an
+// error is returned, m is left in an unspecified state.
+func (m *Message) Decode(line []byte) error {
+	for len(line) > 0 && (line[len(line)-1] == '\r' || line[len(line)-1] == '\n') {
+		line = line[:len(line)-1]
+	}
+
+	if len(line) == 0 {
+		return ErrZeroLengthMessage
+	}
+
+	return m.parse(string(line))
+}
+
+// parse does the work of ParseMessage and Decode, reusing anything already
+// allocated in m.
+func (m *Message) parse(line string) error { //nolint:funlen
 	// Trim the line and make sure we have data
 	line = strings.TrimRight(line, "\r\n")
 	if len(line) == 0 {
-		return nil, ErrZeroLengthMessage
+		return ErrZeroLengthMessage
 	}
 
-	c := &Message{
-		Tags:   Tags{},
-		Prefix: &Prefix{},
-	}
+	m.received = time.Time{}
 
 	if line[0] == '@' {
-		loc := strings.Index(line, " ")
-		if loc == -1 {
-			return nil, ErrMissingDataAfterTags
+		loc := strings.IndexByte(line, ' ')
+		if loc == -1 || loc == len(line)-1 {
+			return ErrMissingDataAfterTags
 		}
 
-		c.Tags = ParseTags(line[1:loc])
+		if m.Tags == nil {
+			m.Tags = make(Tags, strings.Count(line[1:loc], ";")+1)
+		} else {
+			m.Tags.clear()
+		}
+
+		m.Tags.parse(line[1:loc])
 		line = line[loc+1:]
+	} else if m.Tags == nil {
+		m.Tags = Tags{}
+	} else {
+		m.Tags.clear()
+	}
+
+	if m.Prefix == nil {
+		m.Prefix = &Prefix{}
 	}
 
 	if line[0] == ':' {
-		loc := strings.Index(line, " ")
+		loc := strings.IndexByte(line, ' ')
 		if loc == -1 {
-			return nil, ErrMissingDataAfterPrefix
+			return ErrMissingDataAfterPrefix
 		}
 
 		// Parse the identity, if there was one
-		c.Prefix = ParsePrefix(line[1:loc])
+		m.Prefix.parse(line[1:loc])
 		line = line[loc+1:]
+	} else {
+		*m.Prefix = Prefix{}
 	}
 
 	// Split out the trailing then the rest of the args. Because
 	// we expect there to be at least one result as an arg (the
 	// command) we don't need to special case the trailing arg and
 	// can just attempt a split on " :"
-	split := strings.SplitN(line, " :", 2)
-	c.Params = strings.FieldsFunc(split[0], func(r rune) bool {
-		return r == ' '
-	})
+	var trailing string
+	hasTrailing := false
+	if loc := strings.Index(line, " :"); loc != -1 {
+		trailing = line[loc+2:]
+		line = line[:loc]
+		hasTrailing = true
+	}
+
+	params := m.Params[:0]
+	if params == nil {
+		params = make([]string, 0, strings.Count(line, " ")+1)
+	}
+
+	// The first arg is the command and everything else is a param.
+	var command string
+	for len(line) > 0 {
+		var arg string
+		if loc := strings.IndexByte(line, ' '); loc != -1 {
+			arg, line = line[:loc], line[loc+1:]
+		} else {
+			arg, line = line, ""
+		}
+
+		switch {
+		case arg == "":
+			continue
+		case command == "":
+			command = arg
+		default:
+			params = append(params, arg)
+		}
+	}
 
 	// If there are no args, we need to bail because we need at
 	// least the command.
-	if len(c.Params) == 0 {
-		return nil, ErrMissingCommand
+	if command == "" {
+		return ErrMissingCommand
 	}
 
 	// If we had a trailing arg, append it to the other args
-	if len(split) == 2 {
-		c.Params = append(c.Params, split[1])
+	if hasTrailing {
+		params = append(params, trailing)
 	}
 
-	// Because of how it's parsed, the Command will show up as the
-	// first arg.
-	c.Command = strings.ToUpper(c.Params[0])
-	c.Params = c.Params[1:]
-
-	// If there are no params, set it to nil, to make writing tests and other
-	// things simpler.
-	if len(c.Params) == 0 {
-		c.Params = nil
-	}
+	m.Command = strings.ToUpper(command)
+	m.Params = params
 
-	return c, nil
+	return nil
 }
 
 // Param returns the i'th argument in the Message or an empty string
@@ -324,6 +553,35 @@ func (m *Message) Trailing() string {
 	return m.Params[len(m.Params)-1]
 }
 
+// serverTimeFormat is the format used by the server-time time tag.
+const serverTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// ParseServerTime parses a value from the server-time time tag. The spec
+// requires millisecond precision in UTC, but other precisions and offsets are
+// also accepted.
+func ParseServerTime(value string) (time.Time, error) {
+	t, err := time.Parse(serverTimeFormat, value)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339Nano, value)
+	}
+
+	return t, err
+}
+
+// Time returns when this message was sent according to the server-time time
+// tag. If there is no valid time tag, it will fall back to when the message was
+// read if the Reader has RecordReceiveTime set. Otherwise, the zero time will
+// be returned.
+func (m *Message) Time() time.Time {
+	if value, ok := m.Tags["time"]; ok {
+		if t, err := ParseServerTime(value); err == nil {
+			return t
+		}
+	}
+
+	return m.received
+}
+
 // Copy will create a new copy of an message.
 func (m *Message) Copy() *Message {
 	// Create a new message
@@ -331,6 +589,7 @@ func (m *Message) Copy() *Message {
 
 	// Copy stuff from the old message
 	*newMessage = *m
+	newMessage.pooled = false
 
 	// Copy any IRcv3 tags
 	newMessage.Tags = m.Tags.Copy()
@@ -351,43 +610,75 @@ func (m *Message) Copy() *Message {
 
 // String ensures this is stringable.
 func (m *Message) String() string {
-	buf := &bytes.Buffer{}
+	// Most messages fit in a single line, so encoding them on the stack
+	// means the only allocation is the final string.
+	var stack [512]byte
+
+	buf := stack[:0]
+	if n := m.estimateLength(); n > len(stack) {
+		buf = make([]byte, 0, n)
+	}
 
+	return string(m.AppendTo(buf))
+}
+
+// AppendTo appends the encoded message to buf and returns the extended
+// buffer, like the strconv Append functions. It does not add the trailing
+// \r\n. This can be used with a reused buffer to encode messages without
+// allocating.
+func (m *Message) AppendTo(buf []byte) []byte {
 	// Write any IRCv3 tags if they exist in the message
 	if len(m.Tags) > 0 {
-		buf.WriteByte('@')
-		buf.WriteString(m.Tags.String())
-		buf.WriteByte(' ')
+		buf = append(buf, '@')
+		buf = m.Tags.appendTo(buf)
+		buf = append(buf, ' ')
 	}
 
 	// Add the prefix if we have one
 	if m.Prefix != nil && m.Prefix.Name != "" {
-		buf.WriteByte(':')
-		buf.WriteString(m.Prefix.String())
-		buf.WriteByte(' ')
+		buf = append(buf, ':')
+		buf = m.Prefix.appendTo(buf)
+		buf = append(buf, ' ')
 	}
 
 	// Add the command since we know we'll always have one
-	buf.WriteString(m.Command)
+	buf = append(buf, m.Command...)
 
 	if len(m.Params) > 0 {
 		args := m.Params[:len(m.Params)-1]
 		trailing := m.Params[len(m.Params)-1]
 
-		if len(args) > 0 {
-			buf.WriteByte(' ')
-			buf.WriteString(strings.Join(args, " "))
+		for _, arg := range args {
+			buf = append(buf, ' ')
+			buf = append(buf, arg...)
 		}
 
 		// If trailing is zero-length, contains a space or starts with
 		// a : we need to actually specify that it's trailing.
 		if len(trailing) == 0 || strings.ContainsRune(trailing, ' ') || trailing[0] == ':' {
-			buf.WriteString(" :")
+			buf = append(buf, " :"...)
 		} else {
-			buf.WriteString(" ")
+			buf = append(buf, ' ')
 		}
-		buf.WriteString(trailing)
+		buf = append(buf, trailing...)
+	}
+
+	return buf
+}
+
+// estimateLength returns roughly how long the encoded message will be so the
+// buffer in String can be allocated once.
+func (m *Message) estimateLength() int {
+	n := len(m.Command) + 2
+	for k, v := range m.Tags {
+		n += len(k) + len(v) + 2
+	}
+	if m.Prefix != nil {
+		n += len(m.Prefix.Name) + len(m.Prefix.User) + len(m.Prefix.Host) + 4
+	}
+	for _, param := range m.Params {
+		n += len(param) + 1
 	}
 
-	return buf.String()
+	return n
 }
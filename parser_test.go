@@ -1,9 +1,12 @@
 package irc_test
 
 import (
+	"fmt"
 	"io/ioutil"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,12 +15,113 @@ import (
 	"gopkg.in/irc.v4"
 )
 
+const benchmarkLine = "@tag1=something;time=2020-01-01T00:00:00.000Z :nick!user@host PRIVMSG #channel :some message"
+
 func BenchmarkParseMessage(b *testing.B) {
+	b.ReportAllocs()
+
 	for i := 0; i < b.N; i++ {
 		irc.MustParseMessage("@tag1=something :nick!user@host PRIVMSG #channel :some message")
 	}
 }
 
+func BenchmarkParseMessageNoTags(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		irc.MustParseMessage(":irc.example.com 353 nick = #channel :@alice +bob carol dave")
+	}
+}
+
+func BenchmarkParseMessageBytes(b *testing.B) {
+	line := []byte(benchmarkLine)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_, _ = irc.ParseMessageBytes(line)
+	}
+}
+
+func BenchmarkMessageDecode(b *testing.B) {
+	line := []byte(benchmarkLine)
+	m := &irc.Message{}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = m.Decode(line)
+	}
+}
+
+func BenchmarkMessageString(b *testing.B) {
+	m := irc.MustParseMessage(benchmarkLine)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = m.String()
+	}
+}
+
+func BenchmarkMessageAppendTo(b *testing.B) {
+	m := irc.MustParseMessage(benchmarkLine)
+	buf := make([]byte, 0, 512)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buf = m.AppendTo(buf[:0])
+	}
+}
+
+func BenchmarkEncodeTagValue(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = irc.EncodeTagValue("plain-value")
+		_ = irc.EncodeTagValue("value with; special\\characters")
+	}
+}
+
+func BenchmarkParseTagValue(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = irc.ParseTagValue("plain-value")
+		_ = irc.ParseTagValue("value\\swith\\:\\sspecial\\\\characters")
+	}
+}
+
+// TestAllocs makes sure the hot paths in parsing and encoding don't regress.
+func TestAllocs(t *testing.T) {
+	m := irc.MustParseMessage(benchmarkLine)
+	line := []byte(benchmarkLine)
+	decoded := &irc.Message{}
+	buf := make([]byte, 0, 512)
+
+	var allocTests = []struct { //nolint:gofumpt
+		Name string
+		Max  float64
+		Func func()
+	}{
+		{"ParseMessage", 5, func() { irc.MustParseMessage(benchmarkLine) }},
+		{"ParseMessageNoTags", 4, func() { irc.MustParseMessage("PING :irc.example.com") }},
+		{"MessageDecode", 1, func() { _ = decoded.Decode(line) }},
+		{"MessageString", 1, func() { _ = m.String() }},
+		{"MessageAppendTo", 0, func() { buf = m.AppendTo(buf[:0]) }},
+		{"EncodeTagValue", 0, func() { _ = irc.EncodeTagValue("plain-value") }},
+		{"ParseTagValue", 0, func() { _ = irc.ParseTagValue("plain-value") }},
+	}
+
+	for _, test := range allocTests {
+		allocs := testing.AllocsPerRun(100, test.Func)
+		assert.LessOrEqual(t, allocs, test.Max, test.Name)
+	}
+}
+
 func TestParseMessage(t *testing.T) {
 	t.Parallel()
 
@@ -58,6 +162,55 @@ func TestParseMessage(t *testing.T) {
 	}
 }
 
+func TestParseMessageBytes(t *testing.T) {
+	t.Parallel()
+
+	inputs := []string{
+		"",
+		"@asdf",
+		":asdf",
+		" :",
+		"PING :asdf\r\n",
+		benchmarkLine,
+		":irc.example.com 353 nick = #channel :@alice +bob carol dave",
+		"@a=b;c :nick!user@host   privmsg  #chan  :hello world",
+		"PING",
+	}
+
+	decoded := &irc.Message{}
+
+	for i, input := range inputs {
+		expected, expectedErr := irc.ParseMessage(input)
+
+		m, err := irc.ParseMessageBytes([]byte(input))
+		assert.Equal(t, expectedErr, err, "%d. Error didn't match expected", i)
+		assert.Equal(t, expected, m, "%d. Message didn't match expected", i)
+
+		// Decoding into the same message repeatedly should give the same
+		// result as parsing it fresh, apart from nil Params.
+		err = decoded.Decode([]byte(input))
+		assert.Equal(t, expectedErr, err, "%d. Error didn't match expected", i)
+
+		if expectedErr == nil {
+			assert.Equal(t, expected.Tags, decoded.Tags, "%d. Tags didn't match expected", i)
+			assert.Equal(t, expected.Prefix, decoded.Prefix, "%d. Prefix didn't match expected", i)
+			assert.Equal(t, expected.Command, decoded.Command, "%d. Command didn't match expected", i)
+			assert.Equal(t, len(expected.Params), len(decoded.Params), "%d. Params didn't match expected", i)
+
+			for j := range expected.Params {
+				assert.Equal(t, expected.Params[j], decoded.Params[j], "%d. Params didn't match expected", i)
+			}
+		}
+	}
+
+	// The input buffer should be safe to reuse.
+	line := []byte("PRIVMSG #chan :hello")
+	m, err := irc.ParseMessageBytes(line)
+	require.NoError(t, err)
+	copy(line, "XXXXXXX")
+	assert.Equal(t, "PRIVMSG", m.Command)
+}
+
 func TestMustParseMessage(t *testing.T) {
 	t.Parallel()
 
@@ -89,6 +242,39 @@ func TestMessageTrailing(t *testing.T) {
 	assert.Equal(t, "", m.Trailing())
 }
 
+func TestMessageAppendTo(t *testing.T) {
+	t.Parallel()
+
+	m := irc.MustParseMessage(`@a=b\\sc :nick!user@host PRIVMSG #chan :hello world`)
+	assert.Equal(t, m.String(), string(m.AppendTo(nil)))
+	assert.Equal(t, "prefix "+m.String(), string(m.AppendTo([]byte("prefix "))))
+
+	// Messages too long for the stack buffer in String should still be
+	// encoded correctly.
+	long := strings.Repeat("a", 1000)
+	m = &irc.Message{Command: "PRIVMSG", Params: []string{"#chan", long}}
+	assert.Equal(t, "PRIVMSG #chan "+long, m.String())
+}
+
+func TestTagOrder(t *testing.T) {
+	t.Parallel()
+
+	m := irc.MustParseMessage("@z=1;a;m=hello\\sworld;b=2 PING")
+	assert.Equal(t, `@a;b=2;m=hello\sworld;z=1 PING`, m.String())
+	assert.Equal(t, `a;b=2;m=hello\sworld;z=1`, m.Tags.String())
+
+	// Larger sets of tags should be sorted too.
+	var keys []string
+	tags := irc.Tags{}
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("tag%02d", 39-i)
+		tags[key] = ""
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, strings.Join(keys, ";"), tags.String())
+}
+
 func TestMessageCopy(t *testing.T) {
 	t.Parallel()
 
@@ -275,3 +461,72 @@ func TestUserhostSplit(t *testing.T) {
 		)
 	}
 }
+
+func TestTags(t *testing.T) {
+	t.Parallel()
+
+	m := &irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "hi"}}
+
+	_, ok := m.GetTag("example.com/key")
+	assert.False(t, ok)
+
+	m.SetTag("example.com/key", "a;b c\\d\r\ne")
+	m.SetTag("empty", "")
+
+	value, ok := m.GetTag("example.com/key")
+	assert.True(t, ok)
+	assert.Equal(t, "a;b c\\d\r\ne", value)
+
+	value, ok = m.GetTag("empty")
+	assert.True(t, ok)
+	assert.Equal(t, "", value)
+
+	// Escaped values should survive a round trip.
+	m.DeleteTag("empty")
+	assert.Equal(t, `@example.com/key=a\:b\sc\\d\r\ne PRIVMSG #chan hi`, m.String())
+
+	parsed := irc.MustParseMessage(m.String())
+	assert.Equal(t, m.Tags, parsed.Tags)
+
+	// Invalid UTF-8 and unknown escapes shouldn't be mangled.
+	assert.Equal(t, "\xff\xfeab", irc.ParseTagValue("\xff\xfe\\a\\b\\"))
+	assert.Equal(t, "\xff\\s", irc.EncodeTagValue("\xff "))
+
+	// Extra semicolons shouldn't result in empty tags.
+	assert.Equal(t, irc.Tags{"a": "b", "c": ""}, irc.ParseTags(";a=b;;c;=d"))
+}
+
+func TestMessageTime(t *testing.T) {
+	t.Parallel()
+
+	m := irc.MustParseMessage("@time=2011-10-19T16:40:51.620Z :nick!user@host PRIVMSG #chan :hello")
+	assert.Equal(t, time.Date(2011, 10, 19, 16, 40, 51, 620000000, time.UTC), m.Time().UTC())
+
+	// Other precisions and offsets are accepted as well.
+	parsed, err := irc.ParseServerTime("2011-10-19T18:40:51+02:00")
+	assert.NoError(t, err)
+	assert.True(t, parsed.Equal(time.Date(2011, 10, 19, 16, 40, 51, 0, time.UTC)))
+
+	_, err = irc.ParseServerTime("yesterday")
+	assert.Error(t, err)
+
+	// Invalid or missing times fall back to the zero time when the receive
+	// time isn't known.
+	m = irc.MustParseMessage("@time=yesterday PRIVMSG #chan :hello")
+	assert.True(t, m.Time().IsZero())
+
+	r := irc.NewReader(strings.NewReader("PRIVMSG #chan :hello\r\n@time=2011-10-19T16:40:51.620Z PRIVMSG #chan :hello\r\n"))
+	r.RecordReceiveTime = true
+
+	before := time.Now()
+	m, err = r.ReadMessage()
+	require.NoError(t, err)
+	assert.False(t, m.Time().Before(before))
+	assert.False(t, m.Time().After(time.Now()))
+	assert.Equal(t, m.Time(), m.Copy().Time())
+
+	// The tag takes priority over the receive time.
+	m, err = r.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, 2011, m.Time().Year())
+}
@@ -0,0 +1,47 @@
+package irc
+
+import "sync"
+
+// messagePool holds messages which have been released so they can be reused
+// by a Reader with ReuseMessages set.
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return &Message{}
+	},
+}
+
+// getPooledMessage returns a message from the pool which will be put back
+// when Release is called.
+func getPooledMessage() *Message {
+	m := messagePool.Get().(*Message)
+	m.pooled = true
+
+	return m
+}
+
+// Release returns a message read by a Reader with ReuseMessages set to the
+// pool so it can be reused. The message and everything in it must not be used
+// after calling this. It is a no-op for any other message, including copies
+// of pooled messages, so it is always safe to call once a message is no
+// longer needed.
+func (m *Message) Release() {
+	if m == nil || !m.pooled {
+		return
+	}
+
+	m.pooled = false
+
+	// Clear out anything which refers to the line so it can be collected
+	// while the message is sitting in the pool.
+	m.Tags.clear()
+	if m.Prefix != nil {
+		*m.Prefix = Prefix{}
+	}
+	for i := range m.Params {
+		m.Params[i] = ""
+	}
+	m.Params = m.Params[:0]
+	m.Command = ""
+
+	messagePool.Put(m)
+}
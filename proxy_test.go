@@ -0,0 +1,139 @@
+package irc_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+// socks5Result is what the test SOCKS5 server saw.
+type socks5Result struct {
+	Username string
+	Password string
+	Target   string
+	Line     string
+}
+
+// serveSOCKS5 runs a minimal SOCKS5 server for a single connection. It
+// replies to the connect request with the given code, then reads a line
+// from the client as if it was the IRC server.
+func serveSOCKS5(t *testing.T, l net.Listener, reply byte) <-chan socks5Result {
+	t.Helper()
+
+	results := make(chan socks5Result, 1)
+	go func() {
+		defer close(results)
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var result socks5Result
+		r := bufio.NewReader(conn)
+
+		readBytes := func(n int) []byte {
+			buf := make([]byte, n)
+			_, _ = io.ReadFull(r, buf)
+			return buf
+		}
+
+		// Greeting, preferring password auth if it's offered.
+		header := readBytes(2)
+		methods := readBytes(int(header[1]))
+		method := byte(0)
+		for _, m := range methods {
+			if m == 2 {
+				method = 2
+			}
+		}
+		_, _ = conn.Write([]byte{5, method})
+
+		if method == 2 {
+			result.Username = string(readBytes(int(readBytes(2)[1])))
+			result.Password = string(readBytes(int(readBytes(1)[0])))
+			_, _ = conn.Write([]byte{1, 0})
+		}
+
+		// Connect request
+		req := readBytes(4)
+		var host string
+		switch req[3] {
+		case 1:
+			host = net.IP(readBytes(4)).String()
+		case 3:
+			host = string(readBytes(int(readBytes(1)[0])))
+		case 4:
+			host = net.IP(readBytes(16)).String()
+		}
+		port := readBytes(2)
+		result.Target = net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+
+		_, _ = conn.Write([]byte{5, reply, 0, 1, 0, 0, 0, 0, 0, 0})
+		if reply != 0 {
+			results <- result
+			return
+		}
+
+		result.Line, _ = r.ReadString('\n')
+		results <- result
+	}()
+
+	return results
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	proxy, err := irc.ProxyFromURL("socks5://user:pass@" + l.Addr().String())
+	require.NoError(t, err)
+
+	results := serveSOCKS5(t, l, 0)
+
+	d := &irc.Dialer{Proxy: proxy}
+	c, err := d.Dial("irc.example.com", irc.ClientConfig{Nick: "test_nick"})
+	require.NoError(t, err)
+	require.NoError(t, c.Write("PING :hello"))
+
+	assert.Equal(t, socks5Result{
+		Username: "user",
+		Password: "pass",
+		Target:   "irc.example.com:6667",
+		Line:     "PING :hello\r\n",
+	}, <-results)
+
+	// Errors from the proxy should be reported, and IP addresses should be
+	// sent as-is.
+	results = serveSOCKS5(t, l, 5)
+
+	d = &irc.Dialer{Proxy: &irc.SOCKS5Dialer{Addr: l.Addr().String()}}
+	_, err = d.Dial("[::1]:6697", irc.ClientConfig{Nick: "test_nick"})
+	assert.EqualError(t, err, "irc: SOCKS5 proxy error: connection refused")
+	assert.Equal(t, socks5Result{Target: "[::1]:6697"}, <-results)
+}
+
+func TestProxyFromURL(t *testing.T) {
+	t.Parallel()
+
+	proxy, err := irc.ProxyFromURL("socks5h://127.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, &irc.SOCKS5Dialer{Addr: "127.0.0.1:1080"}, proxy)
+
+	_, err = irc.ProxyFromURL("http://127.0.0.1:8080")
+	assert.Error(t, err)
+
+	_, err = irc.ProxyFromURL("socks5://%zz")
+	assert.Error(t, err)
+}
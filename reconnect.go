@@ -0,0 +1,286 @@
+package irc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff controls how long to wait between connection attempts. The delay
+// starts at Initial and is multiplied by Multiplier after every failed
+// attempt, up to Max.
+type Backoff struct {
+	// Initial is the delay after the first failure. If it is zero, 1 second
+	// will be used.
+	Initial time.Duration
+
+	// Max is the longest delay. If it is zero, 5 minutes will be used.
+	Max time.Duration
+
+	// Multiplier is how much the delay grows after each failure. If it is
+	// less than 1, 2 will be used.
+	Multiplier float64
+
+	// Jitter randomly adjusts each delay by up to this fraction of it in
+	// either direction, so many clients don't all reconnect at once. For
+	// example, 0.1 allows the delay to be 10% shorter or longer.
+	Jitter float64
+}
+
+// Duration returns how long to wait after the given number of consecutive
+// failures, starting at 0.
+func (b Backoff) Duration(attempt int) time.Duration {
+	initial, max, multiplier := b.Initial, b.Max, b.Multiplier
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if max <= 0 {
+		max = 5 * time.Minute
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(initial)
+	for i := 0; i < attempt && delay < float64(max); i++ {
+		delay *= multiplier
+	}
+
+	if delay > float64(max) {
+		delay = float64(max)
+	}
+
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1) //nolint:gosec
+	}
+
+	return time.Duration(delay)
+}
+
+// Server is a single entry in ClientConfig.Servers.
+type Server struct {
+	// Addr is the host and port of the server. If the port is missing, the
+	// default for plaintext or TLS is used.
+	Addr string
+
+	// TLSConfig enables TLS for this server if it is not nil, replacing the
+	// Dialer's TLSConfig.
+	TLSConfig *tls.Config
+}
+
+// ReconnectingClient manages a Client, creating a new one and reconnecting
+// whenever the connection is lost. Each new Client uses the same config, so
+// registration, including caps and SASL, is repeated on every connection.
+type ReconnectingClient struct {
+	// Addr is the server to connect to. It is ignored if Config.Servers is
+	// set.
+	Addr string
+
+	// Dialer is used to connect. If it is nil, a plaintext connection will be
+	// made. If Config.Servers is set, the TLSConfig for each server replaces
+	// the Dialer's.
+	Dialer *Dialer
+
+	// Config is used for every Client.
+	Config ClientConfig
+
+	// Backoff controls the delay between connection attempts. With multiple
+	// servers, each server is tried in turn and the delay only applies after
+	// all of them have failed. The delay is reset once a connection
+	// successfully registers. If a fatal NoticeGate asked us to wait longer,
+	// such as a reconnect throttle, that is used instead.
+	Backoff Backoff
+
+	// MaxAttempts is how many connection attempts in a row may fail before
+	// Run gives up and returns the last error. If it is zero, Run will keep
+	// trying until its context is canceled.
+	MaxAttempts int
+
+	// OnEvent, if set, is called whenever the connection state changes. This
+	// includes ConnectionRegistered events from each Client, which are called
+	// from the Client's goroutine.
+	OnEvent func(ConnectionEvent)
+
+	lock    sync.Mutex
+	caps    map[string]bool
+	current *Client
+}
+
+// NewReconnectingClient creates a ReconnectingClient. The Backoff and other
+// options may be changed before calling Run.
+func NewReconnectingClient(addr string, dialer *Dialer, config ClientConfig) *ReconnectingClient {
+	return &ReconnectingClient{ //nolint:exhaustruct
+		Addr:   addr,
+		Dialer: dialer,
+		Config: config,
+		caps:   make(map[string]bool),
+	}
+}
+
+// CapRequest requests a cap on every connection, in the same way as
+// Client.CapRequest. It must be called before Run.
+func (rc *ReconnectingClient) CapRequest(capName string, required bool) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	rc.caps[capName] = required
+}
+
+// Client returns the Client for the current connection, or nil if there
+// isn't one.
+func (rc *ReconnectingClient) Client() *Client {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	return rc.current
+}
+
+// Run connects to the server and runs the Client, reconnecting whenever the
+// connection is lost. It only returns once the context is canceled or
+// MaxAttempts is reached. If Config.Servers is set, a failure to connect or
+// register moves on to the next server, but a registered connection which is
+// lost will reconnect to the same server first. If the server asks for an
+// upgrade to TLS with an STS policy, the client reconnects immediately using
+// TLS on the given port and keeps using it for all later connections to that
+// server.
+func (rc *ReconnectingClient) Run(ctx context.Context) error {
+	dialer := rc.Dialer
+	if dialer == nil {
+		dialer = &Dialer{} //nolint:exhaustruct
+	}
+
+	servers := append([]Server(nil), rc.Config.Servers...)
+	if len(servers) == 0 {
+		servers = []Server{{Addr: rc.Addr, TLSConfig: dialer.TLSConfig}}
+	}
+
+	attempt := 0
+	round := 0
+	current := 0
+
+	for {
+		server := servers[current]
+
+		rc.emit(ConnectionEvent{Type: ConnectionConnecting, Attempt: attempt}) //nolint:exhaustruct
+
+		c, err := rc.connect(ctx, dialer, server, attempt)
+		registered := false
+		if c != nil {
+			rc.emit(ConnectionEvent{Type: ConnectionConnected, Client: c, Attempt: attempt}) //nolint:exhaustruct
+
+			err = c.RunContext(ctx)
+
+			rc.lock.Lock()
+			rc.current = nil
+			rc.lock.Unlock()
+
+			registered = c.connected
+		}
+
+		if ctx.Err() != nil {
+			rc.emit(ConnectionEvent{Type: ConnectionDisconnected, Client: c, Err: ctx.Err(), Attempt: attempt}) //nolint:exhaustruct
+			return ctx.Err()
+		}
+
+		var upgradeErr *STSUpgradeError
+		if errors.As(err, &upgradeErr) {
+			servers[current].Addr = upgradeErr.Addr()
+			if servers[current].TLSConfig == nil {
+				servers[current].TLSConfig = &tls.Config{} //nolint:exhaustruct
+			}
+
+			rc.emit(ConnectionEvent{Type: ConnectionDisconnected, Client: c, Err: err, Attempt: attempt}) //nolint:exhaustruct
+			continue
+		}
+
+		// A connection which got far enough to register counts as a success,
+		// so the backoff starts over with the same server.
+		if registered {
+			attempt = 0
+			round = 0
+		} else if rc.MaxAttempts > 0 && attempt+1 >= rc.MaxAttempts {
+			rc.emit(ConnectionEvent{Type: ConnectionDisconnected, Client: c, Err: err, Attempt: attempt}) //nolint:exhaustruct
+			return err
+		}
+
+		// Move on to the next server if this one failed, only waiting once
+		// every server has been tried.
+		var delay time.Duration
+		if registered || (current+1)%len(servers) == 0 {
+			delay = rc.Backoff.Duration(round)
+			round++
+		}
+
+		// If the server told us how long to wait, reconnecting any sooner
+		// would only be rejected again.
+		var gateErr *NoticeGateError
+		if errors.As(err, &gateErr) && gateErr.Wait > delay {
+			delay = gateErr.Wait
+		}
+
+		rc.emit(ConnectionEvent{Type: ConnectionDisconnected, Client: c, Err: err, Attempt: attempt, RetryIn: delay})
+
+		if !registered {
+			current = (current + 1) % len(servers)
+			attempt++
+		}
+
+		if delay == 0 {
+			continue
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// connect dials the server and sets up a new Client.
+func (rc *ReconnectingClient) connect(ctx context.Context, dialer *Dialer, server Server, attempt int) (*Client, error) {
+	d := *dialer
+	d.TLSConfig = server.TLSConfig
+
+	// Registration is reported by the Client, so those events are passed
+	// on. Disconnects are reported by Run, which knows when the next
+	// attempt will be.
+	config := rc.Config
+	config.OnConnectionEvent = func(event ConnectionEvent) {
+		if rc.Config.OnConnectionEvent != nil {
+			rc.Config.OnConnectionEvent(event)
+		}
+
+		if event.Type == ConnectionRegistered {
+			event.Attempt = attempt
+			rc.emit(event)
+		}
+	}
+
+	c, err := d.DialContext(ctx, server.Addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	for capName, required := range rc.caps {
+		c.CapRequest(capName, required)
+	}
+
+	rc.current = c
+
+	return c, nil
+}
+
+func (rc *ReconnectingClient) emit(event ConnectionEvent) {
+	if rc.OnEvent != nil {
+		rc.OnEvent(event)
+	}
+}
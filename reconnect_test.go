@@ -0,0 +1,301 @@
+package irc_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	b := irc.Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+	assert.Equal(t, time.Second, b.Duration(0))
+	assert.Equal(t, 2*time.Second, b.Duration(1))
+	assert.Equal(t, 8*time.Second, b.Duration(3))
+	assert.Equal(t, 10*time.Second, b.Duration(4))
+	assert.Equal(t, 10*time.Second, b.Duration(1000))
+
+	// Defaults should be used for anything not set.
+	assert.Equal(t, time.Second, irc.Backoff{}.Duration(0))
+	assert.Equal(t, 5*time.Minute, irc.Backoff{}.Duration(100))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Duration(0)
+		assert.True(t, d >= 500*time.Millisecond && d <= 1500*time.Millisecond, "unexpected delay %s", d)
+	}
+}
+
+func TestReconnectingClient(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var lock sync.Mutex
+	var received []string
+
+	// The first connection is closed before registering and the second
+	// after, so we can see the backoff reset.
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+
+				lock.Lock()
+				received = append(received, strings.TrimSpace(line))
+				lock.Unlock()
+
+				if strings.HasPrefix(line, "CAP LS") {
+					_, _ = conn.Write([]byte("CAP * LS :multi-prefix\r\n"))
+				} else if strings.HasPrefix(line, "CAP REQ") {
+					_, _ = conn.Write([]byte("CAP * ACK :multi-prefix\r\n"))
+				} else if strings.HasPrefix(line, "USER") {
+					if i > 0 {
+						_, _ = conn.Write([]byte("001 test_nick :Welcome\r\n"))
+					}
+					_, _ = conn.Write([]byte("ERROR :Closing link\r\n"))
+					break
+				}
+			}
+
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var events []irc.ConnectionEvent
+
+	rc := irc.NewReconnectingClient(l.Addr().String(), nil, irc.ClientConfig{Nick: "test_nick"})
+	rc.CapRequest("multi-prefix", true)
+	rc.Backoff = irc.Backoff{Initial: time.Millisecond}
+	rc.OnEvent = func(event irc.ConnectionEvent) {
+		if event.Type == irc.ConnectionConnected {
+			assert.Equal(t, event.Client, rc.Client())
+		}
+
+		event.Client = nil
+		event.Err = nil
+		events = append(events, event)
+
+		if len(events) == 7 {
+			cancel()
+		}
+	}
+
+	assert.Equal(t, context.Canceled, rc.Run(ctx))
+	assert.Nil(t, rc.Client())
+
+	assert.Equal(t, []irc.ConnectionEvent{
+		{Type: irc.ConnectionConnecting, Attempt: 0},
+		{Type: irc.ConnectionConnected, Attempt: 0},
+		{Type: irc.ConnectionDisconnected, Attempt: 0, RetryIn: time.Millisecond},
+		{Type: irc.ConnectionConnecting, Attempt: 1},
+		{Type: irc.ConnectionConnected, Attempt: 1},
+		{Type: irc.ConnectionRegistered, Attempt: 1},
+		{Type: irc.ConnectionDisconnected, Attempt: 0, RetryIn: time.Millisecond},
+	}, events)
+
+	// Registration, including caps, should happen on every connection.
+	lock.Lock()
+	defer lock.Unlock()
+
+	var registrations int
+	for _, line := range received {
+		if line == "CAP REQ :multi-prefix" {
+			registrations++
+		}
+	}
+	assert.True(t, registrations >= 2, "caps were only requested %d times", registrations)
+}
+
+func TestReconnectingClientNoticeGate(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			// Wait for registration so writing it doesn't fail first.
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil || strings.HasPrefix(line, "USER") {
+					break
+				}
+			}
+
+			_, _ = conn.Write([]byte(":irc.example.com NOTICE * :You are being throttled\r\n"))
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var retries []time.Duration
+
+	rc := irc.NewReconnectingClient(l.Addr().String(), nil, irc.ClientConfig{
+		Nick: "test_nick",
+		NoticeGates: []irc.NoticeGate{{
+			Name:    "throttle",
+			Pattern: regexp.MustCompile(`throttled`),
+			Fatal:   true,
+			Wait:    20 * time.Millisecond,
+		}},
+	})
+	rc.Backoff = irc.Backoff{Initial: time.Millisecond}
+	rc.OnEvent = func(event irc.ConnectionEvent) {
+		if event.Type != irc.ConnectionDisconnected {
+			return
+		}
+
+		var gateErr *irc.NoticeGateError
+		assert.True(t, errors.As(event.Err, &gateErr))
+		retries = append(retries, event.RetryIn)
+
+		if len(retries) == 2 {
+			cancel()
+		}
+	}
+
+	// The gate's wait should be used rather than the shorter backoff.
+	assert.Equal(t, context.Canceled, rc.Run(ctx))
+	assert.Equal(t, []time.Duration{20 * time.Millisecond, 20 * time.Millisecond}, retries)
+}
+
+func TestReconnectingClientMaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	// Grab a port which nothing is listening on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	var attempts int
+
+	rc := irc.NewReconnectingClient(addr, nil, irc.ClientConfig{Nick: "test_nick"})
+	rc.Backoff = irc.Backoff{Initial: time.Millisecond}
+	rc.MaxAttempts = 3
+	rc.OnEvent = func(event irc.ConnectionEvent) {
+		if event.Type == irc.ConnectionConnecting {
+			attempts++
+		}
+		if event.Type == irc.ConnectionDisconnected {
+			assert.Nil(t, event.Client)
+			assert.Error(t, event.Err)
+		}
+	}
+
+	assert.Error(t, rc.Run(context.Background()))
+	assert.Equal(t, 3, attempts)
+}
+
+func TestReconnectingClientServers(t *testing.T) {
+	t.Parallel()
+
+	// The first server isn't listening, so the client should move on to the
+	// second one, then stay with it once it has registered.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil || strings.HasPrefix(line, "USER") {
+					break
+				}
+			}
+
+			_, _ = conn.Write([]byte("001 test_nick :Welcome\r\nERROR :Closing link\r\n"))
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var events []irc.ConnectionEvent
+	registered := 0
+
+	rc := irc.NewReconnectingClient("", nil, irc.ClientConfig{
+		Nick: "test_nick",
+		Servers: []irc.Server{
+			{Addr: closedAddr},
+			{Addr: l.Addr().String()},
+		},
+	})
+	rc.Backoff = irc.Backoff{Initial: time.Millisecond}
+	rc.OnEvent = func(event irc.ConnectionEvent) {
+		if event.Type == irc.ConnectionRegistered {
+			registered++
+			if registered == 2 {
+				cancel()
+			}
+		}
+
+		event.Client = nil
+		event.Err = nil
+		events = append(events, event)
+	}
+
+	assert.Equal(t, context.Canceled, rc.Run(ctx))
+
+	assert.Equal(t, []irc.ConnectionEvent{
+		{Type: irc.ConnectionConnecting, Attempt: 0},
+		{Type: irc.ConnectionDisconnected, Attempt: 0},
+		{Type: irc.ConnectionConnecting, Attempt: 1},
+		{Type: irc.ConnectionConnected, Attempt: 1},
+		{Type: irc.ConnectionRegistered, Attempt: 1},
+		{Type: irc.ConnectionDisconnected, Attempt: 0, RetryIn: time.Millisecond},
+		{Type: irc.ConnectionConnecting, Attempt: 0},
+		{Type: irc.ConnectionConnected, Attempt: 0},
+		{Type: irc.ConnectionRegistered, Attempt: 0},
+		{Type: irc.ConnectionDisconnected, Attempt: 0},
+	}, events)
+}
@@ -0,0 +1,162 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrRedactionNotEnabled is returned from Client.Redact if the
+// draft/message-redaction cap has not been enabled.
+var ErrRedactionNotEnabled = errors.New("irc: draft/message-redaction cap is not enabled")
+
+// Redaction is a request to delete a previously sent message, from the
+// draft/message-redaction extension.
+type Redaction struct {
+	// Prefix is who redacted the message.
+	Prefix *Prefix
+
+	// Target is the channel or nick the message was sent to.
+	Target string
+
+	// MsgID is the msgid tag of the message being deleted.
+	MsgID string
+
+	// Reason is the optional reason given for the deletion.
+	Reason string
+}
+
+// ParseRedaction converts a REDACT message into a Redaction.
+func ParseRedaction(m *Message) (*Redaction, error) {
+	if m.Command != "REDACT" || len(m.Params) < 2 {
+		return nil, errors.New("malformed REDACT message")
+	}
+
+	return &Redaction{
+		Prefix: m.Prefix.Copy(),
+		Target: m.Params[0],
+		MsgID:  m.Params[1],
+		Reason: m.Param(2),
+	}, nil
+}
+
+// Redact asks the server to delete a message previously sent to a target. The
+// draft/message-redaction cap must be enabled.
+func (c *Client) Redact(target, msgid, reason string) error {
+	if !c.CapEnabled("draft/message-redaction") {
+		return ErrRedactionNotEnabled
+	}
+
+	params := []string{target, msgid}
+	if reason != "" {
+		params = append(params, reason)
+	}
+
+	return c.WriteMessage(&Message{
+		Command: "REDACT",
+		Params:  params,
+	})
+}
+
+func handleRedact(c *Client, m *Message) {
+	if c.config.OnRedact == nil {
+		return
+	}
+
+	redaction, err := ParseRedaction(m)
+	if err != nil {
+		return
+	}
+
+	c.config.OnRedact(redaction)
+}
+
+// HistoryBuffer keeps the most recent PRIVMSG and NOTICE messages which have a
+// msgid tag so they can be looked up later. Messages deleted with REDACT are
+// removed from the buffer. It implements StateTracker, so it can be added to
+// ClientConfig.StateTrackers.
+type HistoryBuffer struct {
+	sync.RWMutex
+
+	size     int
+	messages []*Message
+}
+
+// NewHistoryBuffer creates a HistoryBuffer which holds at most size messages.
+// If size is not positive, no messages are kept.
+func NewHistoryBuffer(size int) *HistoryBuffer {
+	if size < 0 {
+		size = 0
+	}
+
+	return &HistoryBuffer{
+		size: size,
+	}
+}
+
+// Handle needs to be called for all PRIVMSG, NOTICE, and REDACT messages. All
+// other messages will be ignored.
+func (h *HistoryBuffer) Handle(m *Message) error {
+	switch m.Command {
+	case "PRIVMSG", "NOTICE":
+		if _, ok := m.Tags["msgid"]; !ok || len(m.Params) < 2 {
+			return nil
+		}
+
+		h.Lock()
+		defer h.Unlock()
+
+		h.messages = append(h.messages, m)
+		if len(h.messages) > h.size {
+			h.messages = h.messages[len(h.messages)-h.size:]
+		}
+	case "REDACT":
+		redaction, err := ParseRedaction(m)
+		if err != nil {
+			return err
+		}
+
+		h.Lock()
+		defer h.Unlock()
+
+		for i, msg := range h.messages {
+			if msg.Tags["msgid"] == redaction.MsgID && strings.EqualFold(msg.Params[0], redaction.Target) {
+				h.messages = append(h.messages[:i], h.messages[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get returns the message with the given msgid, or nil if it isn't in the
+// buffer.
+func (h *HistoryBuffer) Get(msgid string) *Message {
+	h.RLock()
+	defer h.RUnlock()
+
+	for _, msg := range h.messages {
+		if msg.Tags["msgid"] == msgid {
+			return msg
+		}
+	}
+
+	return nil
+}
+
+// Messages returns all buffered messages sent to the given target, oldest
+// first.
+func (h *HistoryBuffer) Messages(target string) []*Message {
+	h.RLock()
+	defer h.RUnlock()
+
+	var ret []*Message
+	for _, msg := range h.messages {
+		if strings.EqualFold(msg.Params[0], target) {
+			ret = append(ret, msg)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,66 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestRedaction(t *testing.T) {
+	t.Parallel()
+
+	_, err := irc.ParseRedaction(irc.MustParseMessage("REDACT #chan"))
+	assert.Error(t, err)
+
+	buffer := irc.NewHistoryBuffer(3)
+
+	var redactions []*irc.Redaction
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{
+		StateTrackers: []irc.StateTracker{buffer},
+		OnRedact: func(r *irc.Redaction) {
+			redactions = append(redactions, r)
+		},
+	})
+
+	require.NoError(t, ht.Feed(
+		"@msgid=1 :a!u@h PRIVMSG #chan :one",
+		":a!u@h PRIVMSG #chan :no msgid",
+		"@msgid=2 :a!u@h PRIVMSG #chan :two",
+		"@msgid=3 :a!u@h PRIVMSG #other :three",
+		"@msgid=4 :a!u@h NOTICE #chan :four",
+	))
+
+	// The oldest message should have been dropped.
+	assert.Nil(t, buffer.Get("1"))
+	assert.Len(t, buffer.Messages("#chan"), 2)
+
+	require.NoError(t, ht.Feed(":op!u@h REDACT #chan 2 :spam"))
+	assert.Equal(t, []*irc.Redaction{{
+		Prefix: &irc.Prefix{Name: "op", User: "u", Host: "h"},
+		Target: "#chan",
+		MsgID:  "2",
+		Reason: "spam",
+	}}, redactions)
+
+	assert.Nil(t, buffer.Get("2"))
+	if msgs := buffer.Messages("#chan"); assert.Len(t, msgs, 1) {
+		assert.Equal(t, "four", msgs[0].Trailing())
+	}
+	assert.NotNil(t, buffer.Get("3"))
+
+	// Redacting requires the cap.
+	assert.Equal(t, irc.ErrRedactionNotEnabled, ht.Client.Redact("#chan", "4", ""))
+}
+
+func TestHistoryBufferNegativeSize(t *testing.T) {
+	t.Parallel()
+
+	buffer := irc.NewHistoryBuffer(-1)
+	require.NoError(t, buffer.Handle(irc.MustParseMessage("@msgid=1 :a!u@h PRIVMSG #chan :one")))
+
+	assert.Nil(t, buffer.Get("1"))
+	assert.Empty(t, buffer.Messages("#chan"))
+}
@@ -0,0 +1,54 @@
+package irc
+
+// DefaultRelayTag is the client-only tag used by RelayWatermark if no tag is
+// specified.
+const DefaultRelayTag = "+go-irc/relay"
+
+// RelayWatermark marks messages relayed from another network or service with a
+// client-only tag naming where they came from, and checks incoming messages
+// for that tag. Bridges which all mark their output can use this to avoid
+// relaying each other's messages back and forth forever. Note that client-only
+// tags are only delivered when the message-tags capability is enabled.
+type RelayWatermark struct {
+	// Tag is the name of the tag to use. It should start with a + so servers
+	// treat it as a client-only tag. If it is empty, DefaultRelayTag will be
+	// used.
+	Tag string
+
+	// Origin is the value stored in the tag, such as "discord".
+	Origin string
+}
+
+func (rw RelayWatermark) tag() string {
+	if rw.Tag == "" {
+		return DefaultRelayTag
+	}
+	return rw.Tag
+}
+
+// Mark adds the relay tag to the given message. The message is modified in
+// place and returned for convenience.
+func (rw RelayWatermark) Mark(m *Message) *Message {
+	if m.Tags == nil {
+		m.Tags = Tags{}
+	}
+
+	m.Tags[rw.tag()] = rw.Origin
+
+	return m
+}
+
+// OriginOf returns the origin stored in the relay tag of a message, if it has
+// one.
+func (rw RelayWatermark) OriginOf(m *Message) (string, bool) {
+	origin, ok := m.Tags[rw.tag()]
+	return origin, ok
+}
+
+// IsRelayed returns true if the message has already been relayed by any bridge
+// using the same tag. Messages for which this returns true should not be
+// relayed again.
+func (rw RelayWatermark) IsRelayed(m *Message) bool {
+	_, ok := rw.OriginOf(m)
+	return ok
+}
@@ -0,0 +1,39 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestRelayWatermark(t *testing.T) {
+	t.Parallel()
+
+	rw := irc.RelayWatermark{Origin: "discord"}
+
+	m := &irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "hello"}}
+	assert.False(t, rw.IsRelayed(m))
+
+	rw.Mark(m)
+	assert.Equal(t, "@+go-irc/relay=discord PRIVMSG #chan hello", m.String())
+
+	// Incoming messages should be detected after a round trip
+	m.Prefix = &irc.Prefix{Name: "nick", User: "user", Host: "host"}
+	m = irc.MustParseMessage(m.String())
+	assert.True(t, rw.IsRelayed(m))
+
+	origin, ok := rw.OriginOf(m)
+	assert.True(t, ok)
+	assert.Equal(t, "discord", origin)
+
+	// Custom tags shouldn't match the default
+	custom := irc.RelayWatermark{Tag: "+example/relay", Origin: "slack"}
+	assert.False(t, custom.IsRelayed(m))
+
+	custom.Mark(m)
+	origin, ok = custom.OriginOf(m)
+	assert.True(t, ok)
+	assert.Equal(t, "slack", origin)
+}
@@ -0,0 +1,206 @@
+package irc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrResumeNotEnabled is returned from Client.BRB if resuming sessions isn't
+// configured or the server doesn't support it.
+var ErrResumeNotEnabled = errors.New("irc: resume is not enabled")
+
+// resumeCap is the cap for the version of the resume draft this implements.
+const resumeCap = "draft/resume-0.5"
+
+// resumeNamespace is the Store namespace used for resume state. Keys are the
+// configured nick followed by /token or /time.
+const resumeNamespace = "resume"
+
+// resumeMaxSeen is how many msgids will be remembered for dropping duplicates
+// during replay.
+const resumeMaxSeen = 1000
+
+// resumeState holds everything needed for the experimental session
+// resumption support.
+type resumeState struct {
+	sync.Mutex
+
+	// resuming is true while waiting for a response to RESUME.
+	resuming bool
+
+	// since is the time we told the server we last saw a message. Replayed
+	// messages from before it are dropped.
+	since time.Time
+
+	// last is the latest server-time we've seen.
+	last time.Time
+
+	// seen contains recent msgids so replayed messages can be dropped.
+	seen map[string]struct{}
+}
+
+func (c *Client) resumeEnabled() bool {
+	return c.config.ExperimentalResume && c.config.ResumeStore != nil
+}
+
+// maybeStartResume will try to resume a previous session if there is a stored
+// token and the server supports it. It returns true if CAP END should be
+// delayed until the server responds.
+func (c *Client) maybeStartResume() bool {
+	if !c.resumeEnabled() || !c.CapEnabled(resumeCap) {
+		return false
+	}
+
+	store := c.config.ResumeStore
+
+	token, ok, err := store.Get(resumeNamespace, c.config.Nick+"/token")
+	if err != nil || !ok || token == "" {
+		return false
+	}
+
+	line := "RESUME " + token
+
+	c.resume.Lock()
+	if value, ok, _ := store.Get(resumeNamespace, c.config.Nick+"/time"); ok {
+		if since, err := ParseServerTime(value); err == nil {
+			c.resume.since = since
+			line += " " + value
+		}
+	}
+	c.resume.resuming = true
+	c.resume.Unlock()
+
+	_ = c.Write(line)
+
+	return true
+}
+
+// finishResume continues the handshake once the server has responded to our
+// RESUME.
+func (c *Client) finishResume(success bool) {
+	c.resume.Lock()
+	resuming := c.resume.resuming
+	c.resume.resuming = false
+	c.resume.Unlock()
+
+	if !resuming {
+		return
+	}
+
+	// A resumed session is already authenticated, so SASL is only needed if
+	// resuming failed.
+	if !success && c.maybeStartSASL() {
+		return
+	}
+
+	_ = c.Write("CAP END")
+}
+
+// handleResume handles the RESUME TOKEN and RESUME SUCCESS responses.
+func handleResume(c *Client, m *Message) {
+	if !c.resumeEnabled() {
+		return
+	}
+
+	switch m.Param(0) {
+	case "TOKEN":
+		if len(m.Params) > 1 {
+			_ = c.config.ResumeStore.Set(resumeNamespace, c.config.Nick+"/token", m.Params[1])
+		}
+	case "SUCCESS":
+		if len(m.Params) > 1 {
+			c.currentNick = m.Params[1]
+		}
+
+		c.finishResume(true)
+	}
+}
+
+// handleResumeFail is called for FAIL RESUME. The stored token is no longer
+// valid so it is removed.
+func handleResumeFail(c *Client, m *Message) {
+	if !c.resumeEnabled() {
+		return
+	}
+
+	_ = c.config.ResumeStore.Delete(resumeNamespace, c.config.Nick+"/token")
+
+	c.resume.Lock()
+	c.resume.since = time.Time{}
+	c.resume.Unlock()
+
+	c.finishResume(false)
+}
+
+// isResumeDuplicate records the time and msgid of incoming messages and
+// returns true if the message is a replay of something we've already seen.
+func (c *Client) isResumeDuplicate(m *Message) bool {
+	if !c.resumeEnabled() {
+		return false
+	}
+
+	c.resume.Lock()
+	defer c.resume.Unlock()
+
+	if value, ok := m.Tags["time"]; ok {
+		if t, err := ParseServerTime(value); err == nil {
+			if t.Before(c.resume.since) {
+				return true
+			}
+
+			if t.After(c.resume.last) {
+				c.resume.last = t
+			}
+		}
+	}
+
+	if msgid, ok := m.Tags["msgid"]; ok {
+		if _, ok := c.resume.seen[msgid]; ok {
+			return true
+		}
+
+		if c.resume.seen == nil || len(c.resume.seen) >= resumeMaxSeen {
+			c.resume.seen = make(map[string]struct{})
+		}
+
+		c.resume.seen[msgid] = struct{}{}
+	}
+
+	return false
+}
+
+// saveResumeState persists the latest time we've seen so a future connection
+// can ask the server to only replay what we missed.
+func (c *Client) saveResumeState() {
+	if !c.resumeEnabled() {
+		return
+	}
+
+	c.resume.Lock()
+	last := c.resume.last
+	c.resume.Unlock()
+
+	if last.IsZero() {
+		return
+	}
+
+	_ = c.config.ResumeStore.Set(resumeNamespace, c.config.Nick+"/time", last.UTC().Format(serverTimeFormat))
+}
+
+// BRB tells the server we are about to disconnect and intend to resume the
+// session, and saves the state needed to do so. This is part of the
+// experimental resume support and requires ExperimentalResume and a
+// ResumeStore.
+func (c *Client) BRB(reason string) error {
+	if !c.resumeEnabled() || !c.CapEnabled(resumeCap) {
+		return ErrResumeNotEnabled
+	}
+
+	c.saveResumeState()
+
+	return c.WriteMessage(&Message{
+		Command: "BRB",
+		Params:  []string{reason},
+	})
+}
@@ -0,0 +1,114 @@
+package irc_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func newResumeTestClient(store irc.Store, handler irc.Handler) (*irc.Client, *testReadWriter) {
+	rw := newTestReadWriter()
+	c := irc.NewClient(rw, irc.ClientConfig{
+		Nick:               "test_nick",
+		User:               "test_user",
+		Name:               "test_name",
+		ExperimentalResume: true,
+		ResumeStore:        store,
+		Handler:            handler,
+	})
+
+	return c, rw
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+
+	store := irc.NewMemoryStore()
+	assert.NoError(t, store.Set("resume", "test_nick/token", "abcd"))
+	assert.NoError(t, store.Set("resume", "test_nick/time", "2020-01-01T00:00:00.000Z"))
+
+	handler := &TestHandler{}
+	c, rw := newResumeTestClient(store, handler)
+
+	go func() {
+		assert.Equal(t, io.EOF, c.Run())
+		close(rw.clientDone)
+	}()
+
+	runTest(t, rw, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :draft/resume-0.5\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :draft/resume-0.5\r\n"),
+		SendLine("CAP * ACK :draft/resume-0.5\r\n"),
+		ExpectLine("RESUME abcd 2020-01-01T00:00:00.000Z\r\n"),
+		SendLine("RESUME TOKEN efgh\r\n"),
+		SendLine("RESUME SUCCESS old_nick\r\n"),
+		ExpectLine("CAP END\r\n"),
+		func(t *testing.T, rw *testReadWriter) {
+			assert.Equal(t, "old_nick", c.CurrentNick())
+		},
+
+		// Anything replayed from before the last time we saw shouldn't be
+		// passed to the Handler, and neither should duplicate msgids.
+		SendLine("@time=2019-12-31T23:59:59.000Z;msgid=1 :a!u@h PRIVMSG #chan :old\r\n"),
+		SendLine("@time=2020-01-01T00:00:01.000Z;msgid=2 :a!u@h PRIVMSG #chan :new\r\n"),
+		SendLine("@time=2020-01-01T00:00:01.000Z;msgid=2 :a!u@h PRIVMSG #chan :new\r\n"),
+	})
+
+	var trailing []string
+	for _, m := range handler.Messages() {
+		if m.Command == "PRIVMSG" {
+			trailing = append(trailing, m.Trailing())
+		}
+	}
+	assert.Equal(t, []string{"new"}, trailing)
+
+	token, _, _ := store.Get("resume", "test_nick/token")
+	assert.Equal(t, "efgh", token)
+
+	// The latest time should be saved when the connection ends.
+	since, _, _ := store.Get("resume", "test_nick/time")
+	assert.Equal(t, "2020-01-01T00:00:01.000Z", since)
+}
+
+func TestResumeFail(t *testing.T) {
+	t.Parallel()
+
+	store := irc.NewMemoryStore()
+	assert.NoError(t, store.Set("resume", "test_nick/token", "abcd"))
+
+	c, rw := newResumeTestClient(store, nil)
+
+	assert.Equal(t, irc.ErrResumeNotEnabled, c.BRB("later"))
+
+	go func() {
+		assert.Equal(t, io.EOF, c.Run())
+		close(rw.clientDone)
+	}()
+
+	runTest(t, rw, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :draft/resume-0.5\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :draft/resume-0.5\r\n"),
+		SendLine("CAP * ACK :draft/resume-0.5\r\n"),
+		ExpectLine("RESUME abcd\r\n"),
+		SendLine("FAIL RESUME INVALID_TOKEN :Cannot resume connection, token is not valid\r\n"),
+		ExpectLine("CAP END\r\n"),
+		func(t *testing.T, rw *testReadWriter) {
+			_, ok, _ := store.Get("resume", "test_nick/token")
+			assert.False(t, ok)
+		},
+		SendLine("001 test_nick :Welcome\r\n"),
+		func(t *testing.T, rw *testReadWriter) {
+			go func() { assert.NoError(t, c.BRB("later")) }()
+		},
+		ExpectLine("BRB later\r\n"),
+	})
+}
@@ -0,0 +1,75 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RouteWildcard can be used as the command for a route which is given every
+// message without a more specific route.
+const RouteWildcard = "*"
+
+// Router is a Handler which passes messages on to other Handlers based on
+// their command, so it can be used directly as ClientConfig.Handler. Routes
+// can be added at any time and are checked in the order they were added.
+// Because Handle is used to implement Handler, routes are added with
+// HandleCommand, HandleFunc, and HandleNumeric.
+type Router struct {
+	lock   sync.RWMutex
+	routes map[string][]Handler
+}
+
+// NewRouter creates an empty Router.
+func NewRouter() *Router {
+	return &Router{
+		lock:   sync.RWMutex{},
+		routes: make(map[string][]Handler),
+	}
+}
+
+// HandleCommand adds a route for messages with the given command, such as
+// PRIVMSG or 001. Commands are not case sensitive. If the command is
+// RouteWildcard, the handler will be given every message which doesn't match
+// any other route.
+func (r *Router) HandleCommand(command string, h Handler) {
+	command = strings.ToUpper(command)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.routes[command] = append(r.routes[command], h)
+}
+
+// HandleFunc is the same as HandleCommand, but takes a function.
+func (r *Router) HandleFunc(command string, f HandlerFunc) {
+	r.HandleCommand(command, f)
+}
+
+// HandleNumeric adds a route for a numeric reply, such as 433 for
+// ERR_NICKNAMEINUSE.
+func (r *Router) HandleNumeric(numeric int, f HandlerFunc) {
+	command := strconv.Itoa(numeric)
+	for len(command) < 3 {
+		command = "0" + command
+	}
+
+	r.HandleCommand(command, f)
+}
+
+// Handle implements Handler by passing the message to every route for its
+// command, or the RouteWildcard routes if there are none.
+func (r *Router) Handle(c *Client, m *Message) {
+	r.lock.RLock()
+	handlers, ok := r.routes[strings.ToUpper(m.Command)]
+	if !ok {
+		handlers = r.routes[RouteWildcard]
+	}
+	r.lock.RUnlock()
+
+	for _, h := range handlers {
+		h.Handle(c, m)
+	}
+}
+
+var _ Handler = (*Router)(nil)
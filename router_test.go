@@ -0,0 +1,44 @@
+package irc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+
+	record := func(name string) irc.HandlerFunc {
+		return func(c *irc.Client, m *irc.Message) {
+			calls = append(calls, name+" "+m.Command)
+		}
+	}
+
+	r := irc.NewRouter()
+	r.HandleFunc("privmsg", record("first"))
+	r.HandleCommand("PRIVMSG", record("second"))
+	r.HandleNumeric(1, record("welcome"))
+	r.HandleNumeric(433, record("nick"))
+	r.HandleFunc(irc.RouteWildcard, record("wildcard"))
+
+	ht := irc.NewHandlerTester(r, irc.ClientConfig{})
+	assert.NoError(t, ht.Feed(
+		"001 test_nick :Welcome",
+		"433 * test_nick :Nickname is already in use",
+		":nick!user@host PRIVMSG #a :hello",
+		"PING :hello",
+	))
+
+	assert.Equal(t, []string{
+		"welcome 001",
+		"nick 433",
+		"first PRIVMSG",
+		"second PRIVMSG",
+		"wildcard PING",
+	}, calls)
+}
@@ -0,0 +1,158 @@
+package irc
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// ErrSASLFailed is returned from Run when SASL authentication is rejected by
+// the server.
+var ErrSASLFailed = errors.New("irc: SASL authentication failed")
+
+// saslChunkSize is the maximum length of a single AUTHENTICATE payload.
+const saslChunkSize = 400
+
+// maybeStartSASL will start SASL authentication if it was configured and the
+// server accepted the sasl capability. It returns true if CAP END should be
+// delayed, either because authentication was started or because it could not
+// be and the client is exiting.
+func (c *Client) maybeStartSASL() bool {
+	if !c.CapEnabled("sasl") {
+		return false
+	}
+
+	if !c.startSASL(c.saslServerMechanisms()) {
+		c.sendError(ErrSASLFailed)
+	}
+
+	return true
+}
+
+// startSASL picks the best configured mechanism which the server supports and
+// starts authenticating with it. If mechanisms is empty, the server is assumed
+// to support everything. It returns false if there was no usable mechanism.
+func (c *Client) startSASL(mechanisms []string) bool {
+	supported := func(mechanism string) bool {
+		if len(mechanisms) == 0 {
+			return true
+		}
+
+		for _, m := range mechanisms {
+			if strings.EqualFold(m, mechanism) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	switch {
+	case c.config.SASLExternal && c.saslMechanism == "" && supported("EXTERNAL"):
+		c.saslMechanism = "EXTERNAL"
+	case c.config.SASLLogin != "" && c.saslMechanism != "PLAIN" && supported("PLAIN"):
+		c.saslMechanism = "PLAIN"
+	default:
+		return false
+	}
+
+	_ = c.Writef("AUTHENTICATE %s", c.saslMechanism)
+
+	return true
+}
+
+// writeSASLPayload sends the given payload with AUTHENTICATE, splitting it
+// into chunks as required by the spec.
+func (c *Client) writeSASLPayload(payload []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(payload)
+
+	for len(encoded) >= saslChunkSize {
+		err := c.Writef("AUTHENTICATE %s", encoded[:saslChunkSize])
+		if err != nil {
+			return err
+		}
+		encoded = encoded[saslChunkSize:]
+	}
+
+	// If the last chunk was exactly the chunk size (or the payload was empty)
+	// we need to send a + to signal that we're done.
+	if encoded == "" {
+		encoded = "+"
+	}
+
+	return c.Writef("AUTHENTICATE %s", encoded)
+}
+
+func handleAuthenticate(c *Client, m *Message) {
+	if m.Param(0) != "+" {
+		return
+	}
+
+	switch c.saslMechanism {
+	case "EXTERNAL":
+		// The identity comes from the client certificate, so we send an empty
+		// response.
+		_ = c.writeSASLPayload(nil)
+	case "PLAIN":
+		payload := c.config.SASLLogin + "\x00" + c.config.SASLLogin + "\x00" + c.config.SASLPassword
+		_ = c.writeSASLPayload([]byte(payload))
+	}
+}
+
+// From https://ircv3.net/specs/extensions/sasl-3.1
+//
+//	903    RPL_SASLSUCCESS
+//	       "<nick> :SASL authentication successful"
+func handleSASLSuccess(c *Client, m *Message) {
+	if c.saslMechanism == "" {
+		return
+	}
+
+	c.saslMechanism = ""
+	_ = c.Write("CAP END")
+}
+
+// From https://ircv3.net/specs/extensions/sasl-3.1
+//
+//	904    ERR_SASLFAIL
+//	       "<nick> :SASL authentication failed"
+//
+//	905    ERR_SASLTOOLONG
+//	       "<nick> :SASL message too long"
+func handleSASLFailure(c *Client, m *Message) {
+	if c.saslMechanism == "" {
+		return
+	}
+
+	// If EXTERNAL failed, we may be able to fall back to PLAIN.
+	if c.saslMechanism == "EXTERNAL" && c.startSASL(c.saslServerMechanisms()) {
+		return
+	}
+
+	c.saslMechanism = ""
+	c.sendError(ErrSASLFailed)
+}
+
+// From https://ircv3.net/specs/extensions/sasl-3.1
+//
+//	908    RPL_SASLMECHS
+//	       "<nick> <mechanisms> :are available SASL mechanisms"
+func handleSASLMechs(c *Client, m *Message) {
+	if len(m.Params) < 3 {
+		return
+	}
+
+	c.updateCap("sasl", func(status *capStatus) {
+		status.Value = m.Params[1]
+	})
+}
+
+// saslServerMechanisms returns the SASL mechanisms the server has told us it
+// supports, or nil if we don't know.
+func (c *Client) saslServerMechanisms() []string {
+	if value := c.CapValue("sasl"); value != "" {
+		return strings.Split(value, ",")
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package irc_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestSASLPlain(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		SASLLogin:    "test_login",
+		SASLPassword: "test_password",
+	}
+
+	// base64("test_login\x00test_login\x00test_password")
+	const payload = "dGVzdF9sb2dpbgB0ZXN0X2xvZ2luAHRlc3RfcGFzc3dvcmQ="
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :sasl\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :sasl multi-prefix\r\n"),
+		SendLine("CAP * ACK :sasl\r\n"),
+		ExpectLine("AUTHENTICATE PLAIN\r\n"),
+		SendLine("AUTHENTICATE +\r\n"),
+		ExpectLine("AUTHENTICATE " + payload + "\r\n"),
+		SendLine(":irc.example.com 900 test_nick test_nick!test_user@host test_login :You are now logged in\r\n"),
+		SendLine(":irc.example.com 903 test_nick :SASL authentication successful\r\n"),
+		ExpectLine("CAP END\r\n"),
+	})
+
+	runClientTest(t, config, irc.ErrSASLFailed, nil, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :sasl\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :sasl\r\n"),
+		SendLine("CAP * ACK :sasl\r\n"),
+		ExpectLine("AUTHENTICATE PLAIN\r\n"),
+		SendLine("AUTHENTICATE +\r\n"),
+		ExpectLine("AUTHENTICATE " + payload + "\r\n"),
+		SendLine(":irc.example.com 904 test_nick :SASL authentication failed\r\n"),
+	})
+
+	// Long payloads should be split into chunks, with a trailing + if the
+	// last chunk is exactly 400 bytes.
+	config.SASLPassword = strings.Repeat("a", 300-2*len("test_login")-2)
+	encoded := "dGVzdF9sb2dpbgB0ZXN0X2xvZ2luAG" + strings.Repeat("FhYW", 92) + "Fh"
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :sasl\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :sasl\r\n"),
+		SendLine("CAP * ACK :sasl\r\n"),
+		ExpectLine("AUTHENTICATE PLAIN\r\n"),
+		SendLine("AUTHENTICATE +\r\n"),
+		ExpectLine("AUTHENTICATE " + encoded + "\r\n"),
+		ExpectLine("AUTHENTICATE +\r\n"),
+		SendLine(":irc.example.com 903 test_nick :SASL authentication successful\r\n"),
+		ExpectLine("CAP END\r\n"),
+	})
+}
+
+func TestSASLExternal(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick: "test_nick",
+		User: "test_user",
+		Name: "test_name",
+
+		SASLExternal: true,
+	}
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :sasl\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS * :multi-prefix\r\n"),
+		SendLine("CAP * LS :sasl=PLAIN,EXTERNAL\r\n"),
+		SendLine("CAP * ACK :sasl\r\n"),
+		ExpectLine("AUTHENTICATE EXTERNAL\r\n"),
+		SendLine("AUTHENTICATE +\r\n"),
+		ExpectLine("AUTHENTICATE +\r\n"),
+		SendLine(":irc.example.com 903 test_nick :SASL authentication successful\r\n"),
+		ExpectLine("CAP END\r\n"),
+	})
+
+	// If the server doesn't support EXTERNAL and we have no fallback, we
+	// should bail.
+	runClientTest(t, config, irc.ErrSASLFailed, nil, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :sasl\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :sasl=PLAIN\r\n"),
+		SendLine("CAP * ACK :sasl\r\n"),
+	})
+
+	// With a login configured, PLAIN is used when EXTERNAL isn't advertised.
+	config.SASLLogin = "test_login"
+	config.SASLPassword = "test_password"
+
+	const payload = "dGVzdF9sb2dpbgB0ZXN0X2xvZ2luAHRlc3RfcGFzc3dvcmQ="
+
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :sasl\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :sasl=PLAIN\r\n"),
+		SendLine("CAP * ACK :sasl\r\n"),
+		ExpectLine("AUTHENTICATE PLAIN\r\n"),
+		SendLine("AUTHENTICATE +\r\n"),
+		ExpectLine("AUTHENTICATE " + payload + "\r\n"),
+		SendLine(":irc.example.com 903 test_nick :SASL authentication successful\r\n"),
+		ExpectLine("CAP END\r\n"),
+	})
+
+	// If EXTERNAL fails, we fall back to PLAIN.
+	runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("CAP LS 302\r\n"),
+		ExpectLine("CAP REQ :sasl\r\n"),
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("CAP * LS :sasl\r\n"),
+		SendLine("CAP * ACK :sasl\r\n"),
+		ExpectLine("AUTHENTICATE EXTERNAL\r\n"),
+		SendLine("AUTHENTICATE +\r\n"),
+		ExpectLine("AUTHENTICATE +\r\n"),
+		SendLine(":irc.example.com 908 test_nick PLAIN :are available SASL mechanisms\r\n"),
+		SendLine(":irc.example.com 904 test_nick :SASL authentication failed\r\n"),
+		ExpectLine("AUTHENTICATE PLAIN\r\n"),
+		SendLine("AUTHENTICATE +\r\n"),
+		ExpectLine("AUTHENTICATE " + payload + "\r\n"),
+		SendLine(":irc.example.com 903 test_nick :SASL authentication successful\r\n"),
+		ExpectLine("CAP END\r\n"),
+	})
+}
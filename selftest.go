@@ -0,0 +1,323 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// SelfTestReport is the result of running SelfTest against a server.
+type SelfTestReport struct {
+	// Nick is the nick the server assigned us in RPL_WELCOME.
+	Nick string
+
+	// Caps are all the capabilities the server advertised, along with their
+	// values.
+	Caps map[string]string
+
+	// ISupport contains all the raw ISUPPORT tokens the server sent.
+	ISupport map[string]string
+
+	// PingRoundTrip is how long it took for the server to respond to a PING.
+	PingRoundTrip time.Duration
+
+	// EchoReceived is true if a message we sent to ourselves was delivered
+	// back to us.
+	EchoReceived bool
+
+	// Deviations lists any behavior which doesn't match the specs.
+	Deviations []string
+}
+
+// selfTestRead is a single result from reading the connection.
+type selfTestRead struct {
+	m   *Message
+	err error
+}
+
+// selfTestSession holds the state used while running SelfTest.
+type selfTestSession struct {
+	conn     *Conn
+	report   *SelfTestReport
+	isupport *ISupportTracker
+
+	// reads is buffered so a server sending a burst of lines can't block
+	// us from writing on unbuffered connections.
+	reads chan selfTestRead
+}
+
+// SelfTest registers with the server on the given connection, negotiates
+// caps, exercises PING/PONG, ISUPPORT parsing and message echo, then quits.
+// It returns a report of what the server supports along with any spec
+// deviations it noticed. Only the Nick, User, Name and Pass fields of the
+// ClientConfig are used. The connection will be closed when SelfTest returns.
+func SelfTest(ctx context.Context, rwc io.ReadWriteCloser, config ClientConfig) (*SelfTestReport, error) {
+	if config.Nick == "" {
+		return nil, errors.New("ClientConfig.Nick must be specified")
+	}
+
+	s := &selfTestSession{
+		conn: NewConn(rwc),
+		report: &SelfTestReport{
+			Caps:     make(map[string]string),
+			ISupport: make(map[string]string),
+		},
+		isupport: NewISupportTracker(),
+		reads:    make(chan selfTestRead, 64),
+	}
+
+	// Make sure the read loop is stopped no matter how we exit.
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		rwc.Close()
+	}()
+
+	go s.readLoop(done)
+
+	err := s.run(ctx, config)
+
+	return s.report, err
+}
+
+func (s *selfTestSession) readLoop(done chan struct{}) {
+	for {
+		m, err := s.conn.ReadMessage()
+
+		select {
+		case s.reads <- selfTestRead{m, err}:
+		case <-done:
+			return
+		}
+
+		if err != nil && !isParseError(err) {
+			return
+		}
+	}
+}
+
+func (s *selfTestSession) deviation(format string, args ...interface{}) {
+	s.report.Deviations = append(s.report.Deviations, fmt.Sprintf(format, args...))
+}
+
+// waitFor reads messages until one matches the given function.
+func (s *selfTestSession) waitFor(ctx context.Context, match func(m *Message) bool) (*Message, error) {
+	for {
+		select {
+		case read := <-s.reads:
+			if isParseError(read.err) {
+				s.deviation("unparseable line: %s", read.err)
+				continue
+			} else if read.err != nil {
+				return nil, read.err
+			}
+
+			m := read.m
+
+			if m.Command == "PING" {
+				reply := m.Copy()
+				reply.Command = "PONG"
+				err := s.conn.WriteMessage(reply)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			if m.Command == "005" {
+				s.handleISupport(m)
+			}
+
+			if m.Command == "ERROR" {
+				return nil, ParseServerError(m)
+			}
+
+			if match(m) {
+				return m, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *selfTestSession) handleISupport(m *Message) {
+	err := s.isupport.Handle(m)
+	if err != nil {
+		s.deviation("invalid RPL_ISUPPORT: %s", err)
+		return
+	}
+
+	for _, param := range m.Params[1 : len(m.Params)-1] {
+		data := strings.SplitN(param, "=", 2)
+		if len(data) < 2 {
+			s.report.ISupport[data[0]] = ""
+		} else {
+			s.report.ISupport[data[0]] = data[1]
+		}
+	}
+}
+
+func (s *selfTestSession) run(ctx context.Context, config ClientConfig) error {
+	err := s.register(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	err = s.testPing(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.testEcho(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.conn.Write("QUIT :self test complete")
+}
+
+func (s *selfTestSession) register(ctx context.Context, config ClientConfig) error {
+	if config.Pass != "" {
+		err := s.conn.Writef("PASS :%s", config.Pass)
+		if err != nil {
+			return err
+		}
+	}
+
+	user := config.User
+	if user == "" {
+		user = config.Nick
+	}
+
+	name := config.Name
+	if name == "" {
+		name = config.Nick
+	}
+
+	for _, line := range []string{
+		"CAP LS 302",
+		"NICK :" + config.Nick,
+		"USER " + user + " 0 * :" + name,
+	} {
+		err := s.conn.Write(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Collect all the caps. Servers without CAP support may skip straight to
+	// registration.
+	m, err := s.waitFor(ctx, func(m *Message) bool {
+		if m.Command == "001" {
+			return true
+		}
+
+		if m.Command != "CAP" || m.Param(1) != "LS" {
+			return false
+		}
+
+		for _, key := range strings.Fields(m.Trailing()) {
+			value := ""
+			if i := strings.IndexByte(key, '='); i != -1 {
+				key, value = key[:i], key[i+1:]
+			}
+			s.report.Caps[key] = value
+		}
+
+		return len(m.Params) < 4 || m.Params[2] != "*"
+	})
+	if err != nil {
+		return err
+	}
+
+	if m.Command != "001" {
+		err = s.conn.Write("CAP END")
+		if err != nil {
+			return err
+		}
+
+		m, err = s.waitFor(ctx, func(m *Message) bool {
+			return m.Command == "001"
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		s.deviation("server does not support CAP")
+	}
+
+	s.report.Nick = m.Param(0)
+
+	// Wait for the end of the MOTD so we know all the ISUPPORT lines have
+	// come through.
+	_, err = s.waitFor(ctx, func(m *Message) bool {
+		return m.Command == RPL_ENDOFMOTD || m.Command == ERR_NOMOTD
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.report.ISupport["CASEMAPPING"]; !ok {
+		s.deviation("server did not send CASEMAPPING in RPL_ISUPPORT")
+	}
+
+	return nil
+}
+
+func (s *selfTestSession) testPing(ctx context.Context) error {
+	token := fmt.Sprintf("selftest-%d", time.Now().UnixNano())
+	start := time.Now()
+
+	err := s.conn.Writef("PING :%s", token)
+	if err != nil {
+		return err
+	}
+
+	m, err := s.waitFor(ctx, func(m *Message) bool {
+		return m.Command == "PONG"
+	})
+	if err != nil {
+		return err
+	}
+
+	s.report.PingRoundTrip = time.Since(start)
+
+	if m.Trailing() != token {
+		s.deviation("PONG token %q did not match PING token %q", m.Trailing(), token)
+	}
+
+	return nil
+}
+
+func (s *selfTestSession) testEcho(ctx context.Context) error {
+	text := fmt.Sprintf("selftest echo %d", time.Now().UnixNano())
+
+	err := s.conn.WriteMessage(&Message{
+		Command: "PRIVMSG",
+		Params:  []string{s.report.Nick, text},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Use a PING as a marker so we don't wait forever if the server doesn't
+	// deliver messages to ourselves.
+	err = s.conn.Write("PING :selftest-echo")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.waitFor(ctx, func(m *Message) bool {
+		if m.Command == "PRIVMSG" && m.Trailing() == text {
+			s.report.EchoReceived = true
+		}
+
+		return m.Command == "PONG" && m.Trailing() == "selftest-echo"
+	})
+
+	return err
+}
@@ -0,0 +1,104 @@
+package irc_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+// runSelfTestServer is a tiny scripted server which responds to the lines
+// SelfTest sends.
+func runSelfTestServer(t *testing.T, conn net.Conn, echo bool) {
+	t.Helper()
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	write := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		m := irc.MustParseMessage(line)
+		switch m.Command {
+		case "CAP":
+			if m.Param(0) == "LS" {
+				write(":irc.example.com CAP * LS * :multi-prefix")
+				write(":irc.example.com CAP * LS :sasl=PLAIN,EXTERNAL")
+			}
+		case "USER":
+			write(":irc.example.com 001 test_nick :Welcome")
+			write(":irc.example.com PING :server-ping")
+			write(":irc.example.com 005 test_nick NETWORK=Example PREFIX=(ov)@+ :are supported by this server")
+			write(":irc.example.com 005 test_nick :not a valid isupport line")
+			write(":irc.example.com 376 test_nick :End of /MOTD command.")
+		case "PING":
+			if m.Trailing() == "selftest-echo" {
+				write(":irc.example.com PONG irc.example.com :" + m.Trailing())
+			} else {
+				write(":irc.example.com PONG irc.example.com :wrong-token")
+			}
+		case "PRIVMSG":
+			if echo {
+				write(":test_nick!user@host PRIVMSG test_nick :" + m.Trailing())
+			}
+		case "QUIT":
+			return
+		}
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, server := net.Pipe()
+	go runSelfTestServer(t, server, true)
+
+	report, err := irc.SelfTest(ctx, client, irc.ClientConfig{Nick: "test_nick"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "test_nick", report.Nick)
+	assert.Equal(t, map[string]string{
+		"multi-prefix": "",
+		"sasl":         "PLAIN,EXTERNAL",
+	}, report.Caps)
+	assert.Equal(t, map[string]string{
+		"NETWORK": "Example",
+		"PREFIX":  "(ov)@+",
+	}, report.ISupport)
+	assert.True(t, report.EchoReceived)
+
+	// The invalid ISUPPORT line, missing CASEMAPPING, and mismatched PONG
+	// token should all have been noticed.
+	if assert.Len(t, report.Deviations, 3) {
+		assert.True(t, strings.Contains(report.Deviations[0], "RPL_ISUPPORT"))
+		assert.True(t, strings.Contains(report.Deviations[1], "CASEMAPPING"))
+		assert.True(t, strings.Contains(report.Deviations[2], "PONG"))
+	}
+
+	client, server = net.Pipe()
+	go runSelfTestServer(t, server, false)
+
+	report, err = irc.SelfTest(ctx, client, irc.ClientConfig{Nick: "test_nick"})
+	require.NoError(t, err)
+	assert.False(t, report.EchoReceived)
+
+	_, err = irc.SelfTest(ctx, client, irc.ClientConfig{})
+	assert.Error(t, err)
+}
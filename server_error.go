@@ -0,0 +1,157 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrBanned matches, using errors.Is, the error returned from Run when the
+// server disconnects us because of a ban, either with ERR_YOUREBANNEDCREEP
+// (465) or an ERROR which looks like a ban.
+var ErrBanned = errors.New("irc: banned from server")
+
+// ErrBadPassword matches, using errors.Is, the error returned from Run when
+// the server rejects our PASS with ERR_PASSWDMISMATCH (464).
+var ErrBadPassword = errors.New("irc: password incorrect")
+
+// ErrKilled matches, using errors.Is, the error returned from Run when we are
+// disconnected with a KILL.
+var ErrKilled = errors.New("irc: killed")
+
+// banMarkers are substrings which show up in ERROR reasons when the server is
+// disconnecting us because of a ban of some sort.
+var banMarkers = []string{
+	"k-line",
+	"g-line",
+	"z-line",
+	"d-line",
+	"klined",
+	"glined",
+	"zlined",
+	"dlined",
+	"akill",
+	"autokill",
+	"banned",
+}
+
+// ServerError represents an ERROR message sent by the server, usually right
+// before it closes the connection. When the server sends an ERROR, this will be
+// returned from Client.Run.
+type ServerError struct {
+	// Text is the full text of the ERROR message.
+	Text string
+
+	// Host is the host from a "Closing Link: host (reason)" message. It will
+	// be empty if the message was not in that format.
+	Host string
+
+	// Reason is the reason from a "Closing Link: host (reason)" message. If
+	// the message was not in that format, it will be the same as Text.
+	Reason string
+
+	// Banned will be true if the reason looks like a ban, such as a K-line.
+	Banned bool
+}
+
+// ParseServerError converts an ERROR message into a ServerError.
+func ParseServerError(m *Message) *ServerError {
+	text := m.Trailing()
+
+	e := &ServerError{
+		Text:   text,
+		Reason: text,
+	}
+
+	// Sample: Closing Link: nick[127.0.0.1] (K-Lined)
+	const closingLink = "closing link:"
+	if len(text) >= len(closingLink) && strings.EqualFold(text[:len(closingLink)], closingLink) {
+		rest := strings.TrimSpace(text[len(closingLink):])
+
+		if start := strings.IndexByte(rest, '('); start != -1 {
+			e.Host = strings.TrimSpace(rest[:start])
+
+			reason := rest[start+1:]
+			if end := strings.LastIndexByte(reason, ')'); end != -1 {
+				reason = reason[:end]
+			}
+			e.Reason = reason
+		} else {
+			e.Host = rest
+		}
+	}
+
+	lowerReason := strings.ToLower(e.Reason)
+	for _, marker := range banMarkers {
+		if strings.Contains(lowerReason, marker) {
+			e.Banned = true
+			break
+		}
+	}
+
+	return e
+}
+
+// Error implements the error interface.
+func (e *ServerError) Error() string {
+	return "irc: server error: " + e.Text
+}
+
+// Is allows errors.Is to match ErrBanned if this looks like a ban.
+func (e *ServerError) Is(target error) bool {
+	return target == ErrBanned && e.Banned
+}
+
+// KillError represents a KILL message which disconnected this client. When the
+// client is killed, this will be returned from Client.Run.
+type KillError struct {
+	// Killer is the prefix of the user or server which sent the KILL. It may
+	// be nil if the KILL had no prefix.
+	Killer *Prefix
+
+	// Reason is the reason given for the KILL.
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *KillError) Error() string {
+	killer := "unknown"
+	if e.Killer != nil && e.Killer.Name != "" {
+		killer = e.Killer.Name
+	}
+
+	return "irc: killed by " + killer + ": " + e.Reason
+}
+
+// Is allows errors.Is to match ErrKilled.
+func (e *KillError) Is(target error) bool {
+	return target == ErrKilled
+}
+
+// RegistrationError represents a numeric sent by the server to reject our
+// registration, such as ERR_PASSWDMISMATCH (464) or ERR_YOUREBANNEDCREEP
+// (465). It will be returned from Client.Run.
+type RegistrationError struct {
+	// Numeric is the numeric the server sent.
+	Numeric string
+
+	// Text is the text the server sent along with it.
+	Text string
+}
+
+// Error implements the error interface.
+func (e *RegistrationError) Error() string {
+	return "irc: registration rejected (" + e.Numeric + "): " + e.Text
+}
+
+// Is allows errors.Is to match ErrBadPassword or ErrBanned depending on the
+// numeric.
+func (e *RegistrationError) Is(target error) bool {
+	switch e.Numeric {
+	case ERR_PASSWDMISMATCH:
+		return target == ErrBadPassword
+	case ERR_YOUREBANNEDCREEP:
+		return target == ErrBanned
+	}
+
+	return false
+}
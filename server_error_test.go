@@ -0,0 +1,78 @@
+package irc_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestParseServerError(t *testing.T) {
+	t.Parallel()
+
+	var testCases = []struct { //nolint:gofumpt
+		Input  string
+		Expect irc.ServerError
+	}{
+		{
+			Input: "ERROR :Closing Link: test_nick[127.0.0.1] (K-Lined)",
+			Expect: irc.ServerError{
+				Text:   "Closing Link: test_nick[127.0.0.1] (K-Lined)",
+				Host:   "test_nick[127.0.0.1]",
+				Reason: "K-Lined",
+				Banned: true,
+			},
+		},
+		{
+			Input: "ERROR :Closing link: 127.0.0.1 (Quit: leaving (for now))",
+			Expect: irc.ServerError{
+				Text:   "Closing link: 127.0.0.1 (Quit: leaving (for now))",
+				Host:   "127.0.0.1",
+				Reason: "Quit: leaving (for now)",
+			},
+		},
+		{
+			Input: "ERROR :Closing Link: 127.0.0.1",
+			Expect: irc.ServerError{
+				Text:   "Closing Link: 127.0.0.1",
+				Host:   "127.0.0.1",
+				Reason: "Closing Link: 127.0.0.1",
+			},
+		},
+		{
+			Input: "ERROR :You are banned from this server",
+			Expect: irc.ServerError{
+				Text:   "You are banned from this server",
+				Reason: "You are banned from this server",
+				Banned: true,
+			},
+		},
+		{
+			Input:  "ERROR",
+			Expect: irc.ServerError{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		e := irc.ParseServerError(irc.MustParseMessage(testCase.Input))
+		assert.Equal(t, testCase.Expect, *e, testCase.Input)
+	}
+}
+
+func TestServerErrorIs(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, errors.Is(&irc.ServerError{Banned: true}, irc.ErrBanned))
+	assert.False(t, errors.Is(&irc.ServerError{}, irc.ErrBanned))
+
+	assert.True(t, errors.Is(&irc.KillError{}, irc.ErrKilled))
+	assert.EqualError(t, &irc.KillError{Reason: "bye"}, "irc: killed by unknown: bye")
+	assert.EqualError(t, &irc.KillError{Killer: &irc.Prefix{Name: "oper"}, Reason: "bye"}, "irc: killed by oper: bye")
+
+	assert.True(t, errors.Is(&irc.RegistrationError{Numeric: "464"}, irc.ErrBadPassword))
+	assert.False(t, errors.Is(&irc.RegistrationError{Numeric: "464"}, irc.ErrBanned))
+	assert.True(t, errors.Is(&irc.RegistrationError{Numeric: "465"}, irc.ErrBanned))
+	assert.False(t, errors.Is(&irc.RegistrationError{Numeric: "465"}, irc.ErrBadPassword))
+}
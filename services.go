@@ -0,0 +1,170 @@
+package irc
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// defaultServicesTimeout is used when ServicesAuth.Timeout is zero.
+const defaultServicesTimeout = 30 * time.Second
+
+// defaultServicesConfirm matches the notices sent by common services packages
+// once we're identified.
+var defaultServicesConfirm = regexp.MustCompile(`(?i)(you are now (identified|logged in)|password accepted)`)
+
+// ServicesAuth configures identifying to a services bot like NickServ once
+// registration completes, for networks which don't support SASL. See
+// ClientConfig.ServicesAuth.
+type ServicesAuth struct {
+	// Account is the account to identify as. If it is empty, Nick will be
+	// used.
+	Account string
+
+	// Password is the account password.
+	Password string
+
+	// Service is the nick of the services bot. If it is empty, NickServ will
+	// be used.
+	Service string
+
+	// Command is a format string for the message sent to the Service. It is
+	// given the account and password, in that order. If it is empty,
+	// "IDENTIFY %s %s" will be used. Indexed verbs such as "IDENTIFY %[2]s"
+	// can be used for services which only want the password.
+	Command string
+
+	// ConfirmPattern is matched against every NOTICE from the Service to see
+	// if we were identified. If it is nil, the messages from common services
+	// packages will be matched. RPL_LOGGEDIN (900) and an account tag on our
+	// own messages are always accepted as confirmation.
+	ConfirmPattern *regexp.Regexp
+
+	// DelayJoins holds off joining ClientConfig.Channels until we are
+	// identified, or the Timeout passes, so channels which require an account
+	// can be joined.
+	DelayJoins bool
+
+	// Timeout is how long to wait for confirmation before joining channels
+	// anyway when DelayJoins is set. If it is zero, 30 seconds will be used.
+	Timeout time.Duration
+
+	// OnIdentified is called once we are identified.
+	OnIdentified func()
+}
+
+// message returns the line to send to the Service.
+func (s *ServicesAuth) message(nick string) string {
+	account := s.Account
+	if account == "" {
+		account = nick
+	}
+
+	command := s.Command
+	if command == "" {
+		command = "IDENTIFY %s %s"
+	}
+
+	return fmt.Sprintf(command, account, s.Password)
+}
+
+// service returns the nick of the services bot.
+func (s *ServicesAuth) service() string {
+	if s.Service == "" {
+		return "NickServ"
+	}
+
+	return s.Service
+}
+
+// timeout returns how long to wait for confirmation.
+func (s *ServicesAuth) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return defaultServicesTimeout
+	}
+
+	return s.Timeout
+}
+
+// confirms returns true if the given notice text means we're identified.
+func (s *ServicesAuth) confirms(text string) bool {
+	pattern := s.ConfirmPattern
+	if pattern == nil {
+		pattern = defaultServicesConfirm
+	}
+
+	return pattern.MatchString(text)
+}
+
+// Identified returns true if the client is known to be logged in to an
+// account, either through SASL or ServicesAuth.
+func (c *Client) Identified() bool {
+	return c.identified
+}
+
+// startServicesAuth sends the identify command once the welcome burst is
+// over. If SASL already logged us in, there's nothing to do.
+func (c *Client) startServicesAuth() {
+	c.servicesStarted = time.Now()
+
+	auth := c.config.ServicesAuth
+	if auth == nil || c.identified {
+		return
+	}
+
+	_ = c.WriteMessage(&Message{
+		Command: "PRIVMSG",
+		Params:  []string{auth.service(), auth.message(c.config.Nick)},
+	})
+}
+
+// waitingForServices returns true if channel joins should be held until we are
+// identified.
+func (c *Client) waitingForServices() bool {
+	auth := c.config.ServicesAuth
+	if auth == nil || !auth.DelayJoins || c.identified {
+		return false
+	}
+
+	return time.Since(c.servicesStarted) < auth.timeout()
+}
+
+// setIdentified marks us as logged in and lets the ServicesAuth know.
+func (c *Client) setIdentified() {
+	if c.identified {
+		return
+	}
+
+	c.identified = true
+
+	if auth := c.config.ServicesAuth; auth != nil && auth.OnIdentified != nil {
+		auth.OnIdentified()
+	}
+}
+
+// checkServicesAuth looks for evidence that we've been identified: a
+// confirmation notice from the Service, or an account tag on our own
+// messages.
+func (c *Client) checkServicesAuth(m *Message) {
+	auth := c.config.ServicesAuth
+	if auth == nil || c.identified || m.Prefix == nil {
+		return
+	}
+
+	if _, ok := m.Tags["account"]; ok && c.IsSelf(m) {
+		c.setIdentified()
+		return
+	}
+
+	if m.Command == "NOTICE" && c.foldNick(m.Prefix.Name) == c.foldNick(auth.service()) && auth.confirms(m.Trailing()) {
+		c.setIdentified()
+	}
+}
+
+// From https://ircv3.net/specs/extensions/sasl-3.1
+//
+//	900    RPL_LOGGEDIN
+//	"<nick> <nick>!<ident>@<host> <account> :You are now logged in as <user>"
+func handle900(c *Client, m *Message) {
+	c.setIdentified()
+}
@@ -0,0 +1,112 @@
+package irc_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestServicesAuth(t *testing.T) {
+	t.Parallel()
+
+	identified := make(chan struct{}, 1)
+
+	config := irc.ClientConfig{
+		Nick:     "test_nick",
+		User:     "test_user",
+		Name:     "test_name",
+		Channels: []string{"#a"},
+		ServicesAuth: &irc.ServicesAuth{
+			Password:   "hunter2",
+			DelayJoins: true,
+			OnIdentified: func() {
+				identified <- struct{}{}
+			},
+		},
+	}
+
+	// Channels aren't joined until NickServ confirms we're identified.
+	c := runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		SendLine("375 test_nick :- MOTD -\r\n"),
+		ExpectLine("PRIVMSG NickServ :IDENTIFY test_nick hunter2\r\n"),
+		SendLine(":nickserv!s@services NOTICE test_nick :Password accepted - you are now recognized.\r\n"),
+		ExpectLine("JOIN #a\r\n"),
+	})
+	assert.True(t, c.Identified())
+	assert.Len(t, identified, 1)
+
+	// A custom service and command can be used, and RPL_LOGGEDIN counts as
+	// confirmation.
+	config.ServicesAuth = &irc.ServicesAuth{
+		Account:    "test_account",
+		Password:   "hunter2",
+		Service:    "AuthServ",
+		Command:    "AUTH %s %s",
+		DelayJoins: true,
+	}
+
+	c = runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		SendLine("375 test_nick :- MOTD -\r\n"),
+		ExpectLine("PRIVMSG AuthServ :AUTH test_account hunter2\r\n"),
+		SendLine(":AuthServ!s@services NOTICE test_nick :Wrong password\r\n"),
+		SendLine("900 test_nick test_nick!u@h test_account :You are now logged in as test_account\r\n"),
+		ExpectLine("JOIN #a\r\n"),
+	})
+	assert.True(t, c.Identified())
+
+	// If we never hear back, channels are joined after the Timeout.
+	config.ServicesAuth = &irc.ServicesAuth{
+		Password:   "hunter2",
+		DelayJoins: true,
+		Timeout:    10 * time.Millisecond,
+	}
+
+	c = runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		SendLine("375 test_nick :- MOTD -\r\n"),
+		ExpectLine("PRIVMSG NickServ :IDENTIFY test_nick hunter2\r\n"),
+		Delay(20 * time.Millisecond),
+		SendLine("PING :hello\r\n"),
+		ExpectLine("PONG hello\r\n"),
+		ExpectLine("JOIN #a\r\n"),
+	})
+	assert.False(t, c.Identified())
+}
+
+func TestServicesAuthAfterSASL(t *testing.T) {
+	t.Parallel()
+
+	config := irc.ClientConfig{
+		Nick:     "test_nick",
+		User:     "test_user",
+		Name:     "test_name",
+		Channels: []string{"#a"},
+		ServicesAuth: &irc.ServicesAuth{
+			Password:   "hunter2",
+			DelayJoins: true,
+		},
+	}
+
+	// If we're already logged in, there's no need to identify.
+	c := runClientTest(t, config, io.EOF, nil, []TestAction{
+		ExpectLine("NICK :test_nick\r\n"),
+		ExpectLine("USER test_user 0 * :test_name\r\n"),
+		SendLine("900 test_nick test_nick!u@h test_nick :You are now logged in as test_nick\r\n"),
+		SendLine("001 test_nick :Welcome\r\n"),
+		SendLine("375 test_nick :- MOTD -\r\n"),
+		ExpectLine("JOIN #a\r\n"),
+	})
+	assert.True(t, c.Identified())
+}
@@ -0,0 +1,166 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineLength is the maximum length of a line, not including tags, as
+// described in RFC 1459. This includes the trailing \r\n.
+const maxLineLength = 512
+
+// perMessageTags are tags which identify a single message, so they are only
+// kept on the first line when a message is split.
+var perMessageTags = []string{"label", "msgid"}
+
+// maxHostLength is the longest host we expect the server to use for us when
+// our real host isn't known yet.
+const maxHostLength = 63
+
+// prefixLength returns how long the prefix the server adds to our messages
+// when relaying them will be, including the leading : and trailing space. If
+// our user and host aren't known yet, this is a worst-case estimate.
+func (c *Client) prefixLength() int {
+	prefix := c.CurrentPrefix()
+	if prefix.User != "" && prefix.Host != "" {
+		return 1 + len(prefix.String()) + 1
+	}
+
+	// :nick!~user@host plus a space. Some servers add a ~ to the user if
+	// ident isn't available.
+	return 1 + len(prefix.Name) + 2 + len(c.config.User) + 1 + maxHostLength + 1
+}
+
+// maxTextLength returns the longest text which can be sent to the target with
+// the given command without the server truncating it when it is relayed.
+func (c *Client) maxTextLength(command, target string) int {
+	// command target :text\r\n
+	ret := maxLineLength - c.prefixLength() - len(command) - 1 - len(target) - 2 - 2
+	if ret < 1 {
+		return 1
+	}
+
+	return ret
+}
+
+// SendSplit sends text to the target as a PRIVMSG, splitting it into multiple
+// messages if it is too long to fit in a single line, or if it contains
+// newlines. Lines are split on spaces where possible and multi-byte UTF-8
+// sequences are never split. CTCP messages, such as an ACTION, are split into
+// multiple CTCP messages.
+func (c *Client) SendSplit(target, text string) error {
+	max := c.maxTextLength("PRIVMSG", target)
+
+	for _, line := range strings.Split(text, "\n") {
+		for _, chunk := range splitMessageText(strings.TrimSuffix(line, "\r"), max) {
+			err := c.WriteMessage(&Message{
+				Command: "PRIVMSG",
+				Params:  []string{target, chunk},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitLine splits an outgoing PRIVMSG or NOTICE which is too long into
+// multiple lines. Tags are kept on each of them, other than perMessageTags
+// which are only kept on the first. Any other line is returned as-is.
+func (c *Client) splitLine(line string) []string {
+	m, err := ParseMessage(line)
+	if err != nil || (m.Command != "PRIVMSG" && m.Command != "NOTICE") || len(m.Params) != 2 {
+		return []string{line}
+	}
+
+	max := c.maxTextLength(m.Command, m.Params[0])
+	if len(m.Params[1]) <= max {
+		return []string{line}
+	}
+
+	var ret []string
+	tags := m.Tags
+	for _, chunk := range splitMessageText(m.Params[1], max) {
+		ret = append(ret, (&Message{
+			Tags:    tags,
+			Prefix:  m.Prefix,
+			Command: m.Command,
+			Params:  []string{m.Params[0], chunk},
+		}).String())
+
+		if len(ret) == 1 && len(tags) > 0 {
+			tags = tags.Copy()
+			for _, tag := range perMessageTags {
+				delete(tags, tag)
+			}
+		}
+	}
+
+	return ret
+}
+
+// splitMessageText is the same as splitText, but if the text is a CTCP
+// message, each chunk is wrapped in the same CTCP framing so it stays valid.
+func splitMessageText(text string, max int) []string {
+	if !strings.HasPrefix(text, "\x01") {
+		return splitText(text, max)
+	}
+
+	body := strings.TrimSuffix(text[1:], "\x01")
+
+	i := strings.IndexByte(body, ' ')
+	if i == -1 {
+		return []string{text}
+	}
+
+	// If the framing doesn't leave room for any of the text, there's no way
+	// to split this and keep it valid, so it is sent as-is.
+	head := "\x01" + body[:i+1]
+	if max-len(head)-1 < 1 {
+		return []string{text}
+	}
+
+	chunks := splitText(body[i+1:], max-len(head)-1)
+	for j, chunk := range chunks {
+		chunks[j] = head + chunk + "\x01"
+	}
+
+	return chunks
+}
+
+// splitText breaks text up into chunks of at most max bytes. Where possible,
+// chunks are split on a space, which is dropped. Multi-byte UTF-8 sequences
+// will not be split.
+func splitText(text string, max int) []string {
+	var ret []string
+
+	for len(text) > max {
+		cut := len(truncateUTF8(text, max))
+		if text[cut] != ' ' {
+			if i := strings.LastIndexByte(text[:cut], ' '); i > 0 {
+				cut = i
+			}
+		}
+
+		// If max is smaller than the first character, there's no good way to
+		// split this, so send the character on its own.
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+
+		ret = append(ret, text[:cut])
+
+		text = text[cut:]
+		if strings.HasPrefix(text, " ") {
+			text = text[1:]
+		}
+	}
+
+	if text != "" {
+		ret = append(ret, text)
+	}
+
+	return ret
+}
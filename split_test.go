@@ -0,0 +1,128 @@
+package irc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+// With the nick test_nick and user test_user, sending a PRIVMSG to #chan
+// leaves room for 409 bytes of text.
+const splitTestMax = 409
+
+func TestSendSplit(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{Nick: "test_nick", User: "test_user"})
+
+	// Short messages should be sent as-is, with each line sent separately.
+	require.NoError(t, ht.Client.SendSplit("#chan", "hello\r\nworld\n"))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan hello", "PRIVMSG #chan world"))
+
+	// Long messages should be split on spaces.
+	words := strings.Repeat("word ", 100)
+	require.NoError(t, ht.Client.SendSplit("#chan", words))
+
+	var lines []string
+	for _, m := range ht.Writes() {
+		assert.LessOrEqual(t, len(m.Trailing()), splitTestMax)
+		lines = append(lines, m.Trailing())
+	}
+	assert.Equal(t, words, strings.Join(lines, " "))
+	assert.Len(t, lines, 2)
+	assert.Len(t, lines[0], splitTestMax)
+
+	// Without spaces, multi-byte characters shouldn't be split.
+	text := "a" + strings.Repeat("é", 300)
+	require.NoError(t, ht.Client.SendSplit("#chan", text))
+
+	lines = nil
+	for _, m := range ht.Writes() {
+		assert.LessOrEqual(t, len(m.Trailing()), splitTestMax)
+		lines = append(lines, m.Trailing())
+	}
+	assert.Equal(t, text, strings.Join(lines, ""))
+	assert.Len(t, lines, 2)
+	assert.Len(t, lines[0], splitTestMax)
+	assert.Len(t, lines[1], 601-splitTestMax)
+
+	// So should actions.
+	require.NoError(t, ht.Client.SendSplit("#chan", "\x01ACTION "+words+"\x01"))
+
+	lines = nil
+	for _, m := range ht.Writes() {
+		ctcp, ok := irc.ParseCTCP(m)
+		require.True(t, ok)
+		assert.Equal(t, "ACTION", ctcp.Command)
+		assert.LessOrEqual(t, len(m.Trailing()), splitTestMax)
+		lines = append(lines, ctcp.Params)
+	}
+	assert.Equal(t, words, strings.Join(lines, " "))
+	assert.Len(t, lines, 2)
+
+	// A CTCP command which doesn't leave room for any text can't be split,
+	// so it's sent as-is.
+	long := "\x01" + strings.Repeat("X", splitTestMax) + " text\x01"
+	require.NoError(t, ht.Client.SendSplit("#chan", long))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :"+long))
+}
+
+func TestSplitLongMessages(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{
+		Nick:              "test_nick",
+		User:              "test_user",
+		SplitLongMessages: true,
+	})
+
+	text := strings.Repeat("a", splitTestMax) + " " + strings.Repeat("b", 10)
+	require.NoError(t, ht.Client.WriteMessage(&irc.Message{
+		Tags:    irc.Tags{"label": "1234", "example.com/key": "abc"},
+		Command: "PRIVMSG",
+		Params:  []string{"#chan", text},
+	}))
+	require.NoError(t, ht.Client.Write("NOTICE #chan :short"))
+
+	// Only the first line should keep the label.
+	assert.NoError(t, ht.ExpectWrites(
+		"@example.com/key=abc;label=1234 PRIVMSG #chan "+strings.Repeat("a", splitTestMax),
+		"@example.com/key=abc PRIVMSG #chan "+strings.Repeat("b", 10),
+		"NOTICE #chan short",
+	))
+
+	// CTCP messages should keep their framing on each line.
+	head := "\x01ACTION "
+	action := strings.Repeat("a", splitTestMax-len(head)-1) + " " + strings.Repeat("b", 10)
+	require.NoError(t, ht.Client.Write("PRIVMSG #chan :"+head+action+"\x01"))
+
+	assert.NoError(t, ht.ExpectWrites(
+		"PRIVMSG #chan :"+head+strings.Repeat("a", splitTestMax-len(head)-1)+"\x01",
+		"PRIVMSG #chan :"+head+strings.Repeat("b", 10)+"\x01",
+	))
+
+	long := "\x01" + strings.Repeat("X", splitTestMax) + " text\x01"
+	require.NoError(t, ht.Client.Write("PRIVMSG #chan :"+long))
+	assert.NoError(t, ht.ExpectWrites("PRIVMSG #chan :"+long))
+}
+
+func TestSendSplitKnownPrefix(t *testing.T) {
+	t.Parallel()
+
+	ht := irc.NewHandlerTester(nil, irc.ClientConfig{Nick: "test_nick", User: "test_user"})
+
+	// Once our host is known, it should be used rather than the worst case,
+	// leaving room for 472 bytes of text.
+	require.NoError(t, ht.Feed("001 test_nick :Welcome test_nick!test_user@h"))
+	ht.Writes()
+
+	require.NoError(t, ht.Client.SendSplit("#chan", strings.Repeat("a", 473)))
+	assert.NoError(t, ht.ExpectWrites(
+		"PRIVMSG #chan "+strings.Repeat("a", 472),
+		"PRIVMSG #chan a",
+	))
+}
@@ -0,0 +1,180 @@
+package irc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store is a small interface for persisting state between connections. Keys
+// are namespaced so multiple features can share a single Store without
+// stepping on each other. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored for the given key. The bool will be false
+	// if the key does not exist.
+	Get(namespace, key string) (string, bool, error)
+
+	// Set stores the value for the given key, replacing any previous value.
+	Set(namespace, key, value string) error
+
+	// Delete removes the given key. Deleting a key which does not exist is not
+	// an error.
+	Delete(namespace, key string) error
+}
+
+// MemoryStore is a Store which keeps everything in memory. Nothing will be
+// persisted when the process exits.
+type MemoryStore struct {
+	lock sync.RWMutex
+	data map[string]map[string]string
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: make(map[string]map[string]string),
+	}
+}
+
+// Get implements Store.Get.
+func (s *MemoryStore) Get(namespace, key string) (string, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	value, ok := s.data[namespace][key]
+	return value, ok, nil
+}
+
+// Set implements Store.Set.
+func (s *MemoryStore) Set(namespace, key, value string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.set(namespace, key, value)
+
+	return nil
+}
+
+// Delete implements Store.Delete.
+func (s *MemoryStore) Delete(namespace, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.delete(namespace, key)
+
+	return nil
+}
+
+func (s *MemoryStore) set(namespace, key, value string) {
+	ns, ok := s.data[namespace]
+	if !ok {
+		ns = make(map[string]string)
+		s.data[namespace] = ns
+	}
+
+	ns[key] = value
+}
+
+func (s *MemoryStore) delete(namespace, key string) {
+	ns, ok := s.data[namespace]
+	if !ok {
+		return
+	}
+
+	delete(ns, key)
+
+	if len(ns) == 0 {
+		delete(s.data, namespace)
+	}
+}
+
+// FileStore is a Store backed by a single JSON file. The whole file is
+// rewritten on every change, so it is meant for small amounts of state which
+// change infrequently.
+type FileStore struct {
+	MemoryStore
+
+	path string
+}
+
+// NewFileStore creates a FileStore using the file at the given path. If the
+// file exists, it will be loaded. If it does not exist, it will be created on
+// the first write.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		MemoryStore: MemoryStore{
+			data: make(map[string]map[string]string),
+		},
+		path: path,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &s.data)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.data == nil {
+		s.data = make(map[string]map[string]string)
+	}
+
+	return s, nil
+}
+
+// Set implements Store.Set.
+func (s *FileStore) Set(namespace, key, value string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.set(namespace, key, value)
+
+	return s.save()
+}
+
+// Delete implements Store.Delete.
+func (s *FileStore) Delete(namespace, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.delete(namespace, key)
+
+	return s.save()
+}
+
+// save writes the current data out to disk. It writes to a temporary file and
+// renames it so a crash mid-write will not leave a truncated file behind. The
+// lock must be held when calling this.
+func (s *FileStore) save() error {
+	data, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
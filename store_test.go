@@ -0,0 +1,82 @@
+package irc_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func testStore(t *testing.T, s irc.Store) {
+	t.Helper()
+
+	_, ok, err := s.Get("ns", "key")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, s.Set("ns", "key", "value"))
+	assert.NoError(t, s.Set("other", "key", "other value"))
+
+	val, ok, err := s.Get("ns", "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", val)
+
+	val, ok, err = s.Get("other", "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "other value", val)
+
+	assert.NoError(t, s.Delete("ns", "key"))
+	assert.NoError(t, s.Delete("ns", "missing"))
+	assert.NoError(t, s.Delete("missing", "key"))
+
+	_, ok, err = s.Get("ns", "key")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	testStore(t, irc.NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "irc-store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.json")
+
+	s, err := irc.NewFileStore(path)
+	require.NoError(t, err)
+	testStore(t, s)
+
+	// Make sure data survives a reload
+	require.NoError(t, s.Set("ns", "persisted", "hello"))
+
+	s, err = irc.NewFileStore(path)
+	require.NoError(t, err)
+
+	val, ok, err := s.Get("ns", "persisted")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "hello", val)
+
+	_, ok, err = s.Get("other", "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Invalid files should fail to load
+	require.NoError(t, ioutil.WriteFile(path, []byte("not json"), 0o600))
+	_, err = irc.NewFileStore(path)
+	assert.Error(t, err)
+}
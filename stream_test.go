@@ -53,7 +53,13 @@ func SendLineWithTimeout(output string, timeout time.Duration) TestAction {
 		case <-waitChan:
 			assert.Fail(t, "SendLine timeout on %s", output)
 		case <-rw.exiting:
-			assert.Fail(t, "Failed to send whole message")
+			// If the client exited because of this message, the buffer may
+			// have been emptied at the same time, so we need to check again.
+			select {
+			case <-rw.readEmptyChan:
+			default:
+				assert.Fail(t, "Failed to send whole message")
+			}
 		}
 	}
 }
@@ -0,0 +1,153 @@
+package irc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stsCap is the cap servers use to advertise a Strict Transport Security
+// policy.
+const stsCap = "sts"
+
+// stsNamespace is the Store namespace used for STS policies. Keys are the
+// hostname the policy applies to.
+const stsNamespace = "sts"
+
+// STSPolicy is a Strict Transport Security policy, as advertised with the sts
+// cap. See https://ircv3.net/specs/extensions/sts for details.
+type STSPolicy struct {
+	// Port is the port clients should use for TLS connections. It is only
+	// sent on plaintext connections.
+	Port int
+
+	// Duration is how long the policy should be remembered for. It is only
+	// sent on TLS connections. A duration of 0 means any stored policy
+	// should be removed.
+	Duration time.Duration
+
+	// Preload means the server is ok with the policy being included in
+	// client preload lists.
+	Preload bool
+}
+
+// ParseSTSPolicy parses the value of the sts cap. Unknown keys are ignored.
+func ParseSTSPolicy(value string) (*STSPolicy, error) {
+	ret := &STSPolicy{} //nolint:exhaustruct
+
+	for _, param := range strings.Split(value, ",") {
+		key, val := param, ""
+		if i := strings.IndexByte(param, '='); i != -1 {
+			key, val = param[:i], param[i+1:]
+		}
+
+		switch key {
+		case "port":
+			port, err := strconv.ParseUint(val, 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("irc: invalid sts port %q", val)
+			}
+			ret.Port = int(port)
+		case "duration":
+			seconds, err := strconv.ParseUint(val, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("irc: invalid sts duration %q", val)
+			}
+			ret.Duration = time.Duration(seconds) * time.Second
+		case "preload":
+			ret.Preload = true
+		}
+	}
+
+	return ret, nil
+}
+
+// STSUpgradeError is returned from Run when the server advertises an STS
+// policy on a plaintext connection. The connection will have been closed, and
+// the client should reconnect using TLS to the given port.
+type STSUpgradeError struct {
+	Host string
+	Port int
+}
+
+func (e *STSUpgradeError) Error() string {
+	return fmt.Sprintf("irc: server requires TLS on port %d", e.Port)
+}
+
+// Addr returns the address to reconnect to.
+func (e *STSUpgradeError) Addr() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// stsState is what the Client needs to know to apply an STS policy. It is
+// filled in by the Dialer.
+type stsState struct {
+	store  Store
+	host   string
+	port   int
+	secure bool
+}
+
+// storedSTSPolicy is the format policies are persisted in.
+type storedSTSPolicy struct {
+	Port    int       `json:"port"`
+	Expires time.Time `json:"expires"`
+}
+
+// lookupSTSPolicy returns the port from the stored policy for host, if there
+// is one which hasn't expired. Expired policies are removed.
+func lookupSTSPolicy(store Store, host string) (int, bool) {
+	value, ok, err := store.Get(stsNamespace, host)
+	if err != nil || !ok {
+		return 0, false
+	}
+
+	var policy storedSTSPolicy
+	if err := json.Unmarshal([]byte(value), &policy); err != nil || !time.Now().Before(policy.Expires) {
+		_ = store.Delete(stsNamespace, host)
+		return 0, false
+	}
+
+	return policy.Port, true
+}
+
+// handleSTS applies the sts cap value from CAP LS or CAP NEW. On a TLS
+// connection, the policy is stored so future connections will use TLS. On a
+// plaintext connection, the client disconnects so it can reconnect with TLS.
+func (c *Client) handleSTS(value string) {
+	if c.sts == nil {
+		return
+	}
+
+	policy, err := ParseSTSPolicy(value)
+	if err != nil {
+		return
+	}
+
+	if !c.sts.secure {
+		// Without a port, there's nothing we can upgrade to, so the policy is
+		// ignored.
+		if policy.Port != 0 {
+			c.sendError(&STSUpgradeError{Host: c.sts.host, Port: policy.Port})
+		}
+		return
+	}
+
+	if policy.Duration == 0 {
+		_ = c.sts.store.Delete(stsNamespace, c.sts.host)
+		return
+	}
+
+	data, err := json.Marshal(storedSTSPolicy{
+		Port:    c.sts.port,
+		Expires: time.Now().Add(policy.Duration).UTC(),
+	})
+	if err != nil {
+		return
+	}
+
+	_ = c.sts.store.Set(stsNamespace, c.sts.host, string(data))
+}
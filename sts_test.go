@@ -0,0 +1,134 @@
+package irc_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func TestParseSTSPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := irc.ParseSTSPolicy("port=6697")
+	require.NoError(t, err)
+	assert.Equal(t, &irc.STSPolicy{Port: 6697}, policy)
+
+	policy, err = irc.ParseSTSPolicy("duration=300,preload,unknown=value")
+	require.NoError(t, err)
+	assert.Equal(t, &irc.STSPolicy{Duration: 5 * time.Minute, Preload: true}, policy)
+
+	_, err = irc.ParseSTSPolicy("port=abc")
+	assert.Error(t, err)
+
+	_, err = irc.ParseSTSPolicy("port=0")
+	assert.Error(t, err)
+
+	_, err = irc.ParseSTSPolicy("duration=-1")
+	assert.Error(t, err)
+}
+
+// serveCapLS accepts a single connection and replies to CAP LS with the given
+// caps, then closes the connection once CAP END is received.
+func serveCapLS(t *testing.T, l net.Listener, caps string) {
+	t.Helper()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch {
+			case strings.HasPrefix(line, "CAP LS"):
+				_, _ = conn.Write([]byte("CAP * LS :" + caps + "\r\n"))
+			case strings.HasPrefix(line, "CAP END"):
+				return
+			}
+		}
+	}()
+}
+
+func TestSTSUpgrade(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	serveCapLS(t, l, "multi-prefix sts=port=6697")
+
+	store := irc.NewMemoryStore()
+	d := &irc.Dialer{STSStore: store}
+	c, err := d.Dial(l.Addr().String(), irc.ClientConfig{Nick: "test_nick"})
+	require.NoError(t, err)
+
+	var upgradeErr *irc.STSUpgradeError
+	err = c.Run()
+	require.True(t, errors.As(err, &upgradeErr), "unexpected error: %v", err)
+	assert.Equal(t, "127.0.0.1:6697", upgradeErr.Addr())
+
+	// Policies from plaintext connections must not be stored.
+	_, ok, _ := store.Get("sts", "127.0.0.1")
+	assert.False(t, ok)
+}
+
+func TestSTSPolicyStore(t *testing.T) {
+	t.Parallel()
+
+	tlsListener, roots := newTestTLSListener(t)
+	defer tlsListener.Close()
+
+	serveCapLS(t, tlsListener, "sts=duration=60,port=1234")
+
+	store := irc.NewMemoryStore()
+	d := &irc.Dialer{STSStore: store, TLSConfig: &tls.Config{RootCAs: roots}} //nolint:gosec
+	c, err := d.Dial(tlsListener.Addr().String(), irc.ClientConfig{Nick: "test_nick"})
+	require.NoError(t, err)
+	_ = c.Run()
+
+	_, ok, _ := store.Get("sts", "127.0.0.1")
+	require.True(t, ok)
+
+	// Plaintext connections should now be upgraded to TLS on the port we
+	// connected to, which will fail here as the test certificate isn't
+	// trusted by default. There must not be a fallback to plaintext.
+	plainListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer plainListener.Close()
+
+	serveCapLS(t, tlsListener, "")
+
+	d = &irc.Dialer{STSStore: store}
+	_, err = d.Dial(plainListener.Addr().String(), irc.ClientConfig{Nick: "test_nick"})
+	var certErr x509.UnknownAuthorityError
+	assert.True(t, errors.As(err, &certErr), "unexpected error: %v", err)
+
+	// A duration of 0 should remove the policy.
+	serveCapLS(t, tlsListener, "sts=duration=0")
+
+	d = &irc.Dialer{STSStore: store, TLSConfig: &tls.Config{RootCAs: roots}} //nolint:gosec
+	c, err = d.Dial(tlsListener.Addr().String(), irc.ClientConfig{Nick: "test_nick"})
+	require.NoError(t, err)
+	_ = c.Run()
+
+	_, ok, _ = store.Get("sts", "127.0.0.1")
+	assert.False(t, ok)
+}
@@ -3,12 +3,12 @@ package irc
 // TODO: store all nicks by uuid and map them in outgoing seabird events rather
 // than passing the nicks around directly
 
-// TODO: properly handle figuring out the mode when it changes for a user.
-
 import (
 	"errors"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Tracker provides a convenient interface to track users, the channels they are
@@ -17,15 +17,39 @@ type Tracker struct {
 	sync.RWMutex
 
 	channels    map[string]*ChannelState
+	bots        map[string]struct{}
+	users       map[string]*UserState
+	forced      map[string]string
+	metadata    map[string]map[string]string
+	dialects    []TrackerDialect
 	isupport    *ISupportTracker
 	currentNick string
+
+	// userChannels maps nicks to the channels they are in. This is kept in
+	// sync with ChannelState.Users so users can be looked up without
+	// checking every channel.
+	userChannels map[string]map[string]struct{}
+
+	onNickCollision func(NickCollision)
+	onUserChange    func(old, new UserState)
+	onEvent         []func(TrackerEvent)
+
+	// pending are events waiting to be sent once the current message has
+	// been handled and the lock released.
+	pending []TrackerEvent
 }
 
 // NewTracker creates a new tracker instance.
 func NewTracker(isupport *ISupportTracker) *Tracker {
 	return &Tracker{
 		channels: make(map[string]*ChannelState),
+		bots:     make(map[string]struct{}),
+		users:    make(map[string]*UserState),
+		forced:   make(map[string]string),
+		metadata: make(map[string]map[string]string),
 		isupport: isupport,
+
+		userChannels: make(map[string]map[string]struct{}),
 	}
 }
 
@@ -35,6 +59,24 @@ type ChannelState struct {
 	Name  string
 	Topic string
 	Users map[string]struct{}
+
+	// TopicSetBy is who set the topic. Depending on the server, this may be
+	// a nick or a full prefix.
+	TopicSetBy string
+
+	// TopicSetAt is when the topic was set, if known.
+	TopicSetAt time.Time
+
+	// Modes are the modes set on the channel. ClientConfig.TrackChannelModes
+	// can be used to request them when joining a channel.
+	Modes ChannelModes
+
+	// CreatedAt is when the channel was created, if the server sent it.
+	CreatedAt time.Time
+
+	// userModes maps nicks to their prefix modes in this channel, such as o
+	// or v. It should be accessed with Tracker.UserModes.
+	userModes map[string]string
 }
 
 // ListChannels will list the names of all known channels.
@@ -59,17 +101,83 @@ func (t *Tracker) GetChannel(name string) *ChannelState {
 	return t.channels[name]
 }
 
-// Handle needs to be called for all 001, 332, 353, JOIN, TOPIC, PART, KICK,
-// QUIT, and NICK messages. All other messages will be ignored. Note that this
-// will not handle calling the underlying ISupportTracker's Handle method.
+// IsBot returns true if the given user has been seen with the bot message tag
+// or the bot flag in a WHO reply. Only users we share a channel with are
+// tracked.
+func (t *Tracker) IsBot(nick string) bool {
+	t.RLock()
+	defer t.RUnlock()
+
+	_, ok := t.bots[nick]
+	return ok
+}
+
+// AccountFor returns the services account the given user is logged in to. The
+// bool will be false if the account isn't known, which is always the case
+// unless the account-notify or account-tag caps have been requested.
+func (t *Tracker) AccountFor(nick string) (string, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	user, ok := t.users[nick]
+	if !ok || user.Account == "" {
+		return "", false
+	}
+
+	return user.Account, true
+}
+
+// Handle needs to be called for all 001, 324, 329, 332, 333, 346, 348, 352,
+// 353, 354, 367, 900, 901, JOIN, TOPIC, PART, KICK, QUIT, NICK, MODE, ACCOUNT,
+// CHGHOST, and SETNAME messages, along with any messages which may have the
+// bot or account tags. Any dialects added with AddDialect will see every
+// message first. All other messages will be ignored. Note that this will not
+// handle calling the underlying ISupportTracker's Handle method.
 func (t *Tracker) Handle(msg *Message) error {
+	t.handleBotTag(msg)
+	t.handleAccountTag(msg)
+
+	t.RLock()
+	dialects := t.dialects
+	t.RUnlock()
+
+	for _, dialect := range dialects {
+		handled, err := dialect.HandleMessage(t, msg)
+		if handled || err != nil {
+			return err
+		}
+	}
+
+	err := t.handleCommand(msg)
+	t.flushEvents()
+
+	return err
+}
+
+func (t *Tracker) handleCommand(msg *Message) error {
 	switch msg.Command {
 	case "001":
 		return t.handle001(msg)
+	case "324":
+		return t.handleRplChannelModeIs(msg)
+	case "329":
+		return t.handleRplCreationTime(msg)
 	case "332":
 		return t.handleRplTopic(msg)
+	case "333":
+		return t.handleRplTopicWhoTime(msg)
+	case "346":
+		return t.handleRplListEntry(msg, "I")
+	case "348":
+		return t.handleRplListEntry(msg, "e")
+	case "367":
+		return t.handleRplListEntry(msg, "b")
+	case "352":
+		return t.handleRplWhoReply(msg)
 	case "353":
 		return t.handleRplNamReply(msg)
+	case "354":
+		return t.handleRplWhoSpcRpl(msg)
 	case "JOIN":
 		return t.handleJoin(msg)
 	case "TOPIC":
@@ -82,6 +190,18 @@ func (t *Tracker) Handle(msg *Message) error {
 		return t.handleQuit(msg)
 	case "NICK":
 		return t.handleNick(msg)
+	case "ACCOUNT":
+		return t.handleAccount(msg)
+	case "MODE":
+		return t.handleMode(msg)
+	case "CHGHOST":
+		return t.handleChghost(msg)
+	case "SETNAME":
+		return t.handleSetname(msg)
+	case "900":
+		return t.handleRplLoggedIn(msg)
+	case "901":
+		return t.handleRplLoggedOut(msg)
 	}
 
 	return nil
@@ -111,11 +231,28 @@ func (t *Tracker) handleTopic(msg *Message) error {
 	t.Lock()
 	defer t.Unlock()
 
-	if _, ok := t.channels[channel]; !ok {
+	state, ok := t.channels[channel]
+	if !ok {
 		return errors.New("received TOPIC message for unknown channel")
 	}
 
-	t.channels[channel].Topic = topic
+	// If we don't know when the message was sent, we assume it was just now.
+	setAt := msg.Time()
+	if setAt.IsZero() {
+		setAt = time.Now()
+	}
+
+	state.Topic = topic
+	state.TopicSetBy = msg.Prefix.Name
+	state.TopicSetAt = setAt
+
+	t.queueEvent(TrackerEvent{
+		Type:    TrackerTopic,
+		Channel: channel,
+		Actor:   msg.Prefix.Name,
+		Message: topic,
+		Self:    msg.Prefix.Name == t.currentNick,
+	})
 
 	return nil
 }
@@ -143,14 +280,43 @@ func (t *Tracker) handleRplTopic(msg *Message) error {
 	return nil
 }
 
+func (t *Tracker) handleRplTopicWhoTime(msg *Message) error {
+	if len(msg.Params) != 4 {
+		return errors.New("malformed RPL_TOPICWHOTIME message")
+	}
+
+	// client channel setter timestamp
+
+	channel := msg.Params[1]
+
+	ts, err := strconv.ParseInt(msg.Params[3], 10, 64)
+	if err != nil {
+		return errors.New("malformed RPL_TOPICWHOTIME message")
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	state, ok := t.channels[channel]
+	if !ok {
+		return errors.New("received RPL_TOPICWHOTIME for unknown channel")
+	}
+
+	state.TopicSetBy = msg.Params[2]
+	state.TopicSetAt = time.Unix(ts, 0)
+
+	return nil
+}
+
 func (t *Tracker) handleJoin(msg *Message) error {
-	if len(msg.Params) != 1 {
+	// With extended-join, the account and realname are also included.
+	if len(msg.Params) != 1 && len(msg.Params) != 3 {
 		return errors.New("malformed JOIN message")
 	}
 
 	// user joined channel
 	user := msg.Prefix.Name
-	channel := msg.Trailing()
+	channel := msg.Params[0]
 
 	t.Lock()
 	defer t.Unlock()
@@ -162,11 +328,34 @@ func (t *Tracker) handleJoin(msg *Message) error {
 			return errors.New("received JOIN message for unknown channel")
 		}
 
-		t.channels[channel] = &ChannelState{Name: channel, Users: make(map[string]struct{})}
+		t.channels[channel] = &ChannelState{
+			Name:      channel,
+			Users:     make(map[string]struct{}),
+			userModes: make(map[string]string),
+		}
 	}
 
 	state := t.channels[channel]
-	state.Users[user] = struct{}{}
+	t.addChannelUser(state, user)
+
+	userState := t.user(user)
+	userState.updatePrefix(msg.Prefix)
+
+	if len(msg.Params) == 3 {
+		userState.Account = msg.Params[1]
+		if userState.Account == "*" {
+			userState.Account = ""
+		}
+
+		userState.RealName = msg.Params[2]
+	}
+
+	t.queueEvent(TrackerEvent{
+		Type:    TrackerJoin,
+		Channel: channel,
+		Nick:    user,
+		Self:    user == t.currentNick,
+	})
 
 	return nil
 }
@@ -188,13 +377,21 @@ func (t *Tracker) handlePart(msg *Message) error {
 		return errors.New("received PART message for unknown channel")
 	}
 
+	t.queueEvent(TrackerEvent{
+		Type:    TrackerPart,
+		Channel: channel,
+		Nick:    user,
+		Message: msg.Param(1),
+		Self:    user == t.currentNick,
+	})
+
 	// If we left the channel, we can drop the whole thing, otherwise just drop
 	// this user from the channel.
 	if user == t.currentNick {
-		delete(t.channels, channel)
+		t.leaveChannel(channel)
 	} else {
-		state := t.channels[channel]
-		delete(state.Users, user)
+		t.removeChannelUser(t.channels[channel], user)
+		t.forgetUser(user)
 	}
 
 	return nil
@@ -207,7 +404,7 @@ func (t *Tracker) handleKick(msg *Message) error {
 
 	// user was kicked from channel by actor
 
-	// actor := msg.Prefix.Name
+	actor := msg.Prefix.Name
 	user := msg.Params[1]
 	channel := msg.Params[0]
 
@@ -218,13 +415,22 @@ func (t *Tracker) handleKick(msg *Message) error {
 		return errors.New("received KICK message for unknown channel")
 	}
 
+	t.queueEvent(TrackerEvent{
+		Type:    TrackerKick,
+		Channel: channel,
+		Nick:    user,
+		Actor:   actor,
+		Message: msg.Params[2],
+		Self:    user == t.currentNick,
+	})
+
 	// If we left the channel, we can drop the whole thing, otherwise just drop
 	// this user from the channel.
 	if user == t.currentNick {
-		delete(t.channels, channel)
+		t.leaveChannel(channel)
 	} else {
-		state := t.channels[channel]
-		delete(state.Users, user)
+		t.removeChannelUser(t.channels[channel], user)
+		t.forgetUser(user)
 	}
 
 	return nil
@@ -242,10 +448,24 @@ func (t *Tracker) handleQuit(msg *Message) error {
 	t.Lock()
 	defer t.Unlock()
 
-	for _, state := range t.channels {
-		delete(state.Users, user)
+	channels := t.channelsForUser(user)
+	for _, name := range channels {
+		t.removeChannelUser(t.channels[name], user)
 	}
 
+	t.queueEvent(TrackerEvent{
+		Type:     TrackerQuit,
+		Nick:     user,
+		Message:  msg.Params[0],
+		Channels: channels,
+		Self:     user == t.currentNick,
+	})
+
+	delete(t.bots, user)
+	delete(t.users, user)
+	delete(t.forced, user)
+	delete(t.metadata, user)
+
 	return nil
 }
 
@@ -259,21 +479,102 @@ func (t *Tracker) handleNick(msg *Message) error {
 	oldUser := msg.Prefix.Name
 	newUser := msg.Params[0]
 
+	collision := t.renameUser(oldUser, newUser)
+
+	t.RLock()
+	onNickCollision := t.onNickCollision
+	t.RUnlock()
+
+	if collision != nil && onNickCollision != nil {
+		onNickCollision(*collision)
+	}
+
+	return nil
+}
+
+// renameUser moves all state for oldUser over to newUser. If it looks like the
+// rename was caused by a nick collision, a NickCollision will be returned.
+func (t *Tracker) renameUser(oldUser, newUser string) *NickCollision {
 	t.Lock()
 	defer t.Unlock()
 
+	t.queueEvent(TrackerEvent{
+		Type:    TrackerNick,
+		Nick:    oldUser,
+		NewNick: newUser,
+		Self:    oldUser == t.currentNick,
+	})
+
 	if t.currentNick == oldUser {
 		t.currentNick = newUser
 	}
 
-	for _, state := range t.channels {
-		if _, ok := state.Users[oldUser]; ok {
-			delete(state.Users, oldUser)
-			state.Users[newUser] = struct{}{}
+	// Nicks are unique, so if we already know about someone with the new
+	// nick, they must have disappeared without us seeing it. This happens
+	// most often when the server resolves a collision, so we drop the ghost
+	// entry before moving the user over.
+	var ghost bool
+	if oldUser != newUser {
+		for _, name := range t.channelsForUser(newUser) {
+			t.removeChannelUser(t.channels[name], newUser)
+			ghost = true
 		}
+
+		delete(t.bots, newUser)
+		delete(t.users, newUser)
+		delete(t.forced, newUser)
+		delete(t.metadata, newUser)
 	}
 
-	return nil
+	for _, name := range t.channelsForUser(oldUser) {
+		state := t.channels[name]
+		t.addChannelUser(state, newUser)
+		if modes, ok := state.userModes[oldUser]; ok {
+			state.userModes[newUser] = modes
+		}
+		t.removeChannelUser(state, oldUser)
+	}
+
+	if _, ok := t.bots[oldUser]; ok {
+		delete(t.bots, oldUser)
+		t.bots[newUser] = struct{}{}
+	}
+
+	if user, ok := t.users[oldUser]; ok {
+		delete(t.users, oldUser)
+		user.Nick = newUser
+		t.users[newUser] = user
+	}
+
+	if data, ok := t.metadata[oldUser]; ok {
+		delete(t.metadata, oldUser)
+		t.metadata[newUser] = data
+	}
+
+	// Keep track of what forced nicks used to be so they can be looked up
+	// with PreviousNick. If the user was already on a forced nick, keep the
+	// original.
+	previous, wasForced := t.forced[oldUser]
+	delete(t.forced, oldUser)
+	if !wasForced {
+		previous = oldUser
+	}
+
+	forced := IsForcedNick(newUser) && !wasForced
+	if IsForcedNick(newUser) {
+		t.forced[newUser] = previous
+	}
+
+	if !forced && !ghost {
+		return nil
+	}
+
+	return &NickCollision{
+		OldNick: oldUser,
+		NewNick: newUser,
+		Forced:  forced,
+		Ghost:   ghost,
+	}
 }
 
 func (t *Tracker) handleRplNamReply(msg *Message) error {
@@ -282,38 +583,152 @@ func (t *Tracker) handleRplNamReply(msg *Message) error {
 	}
 
 	channel := msg.Params[2]
-	users := strings.Split(strings.TrimSpace(msg.Trailing()), " ")
+	users := strings.Fields(msg.Trailing())
 
 	prefixes, ok := t.isupport.GetPrefixMap()
 	if !ok {
 		return errors.New("ISupport missing prefix map")
 	}
 
+	ranks := t.isupport.getPrefixModes()
+
 	t.Lock()
 	defer t.Unlock()
 
-	if _, ok := t.channels[channel]; !ok {
+	state, ok := t.channels[channel]
+	if !ok {
 		return errors.New("received RPL_NAMREPLY message for untracked channel")
 	}
 
+	notPrefix := func(r rune) bool {
+		_, ok := prefixes[r]
+		return !ok
+	}
+
 	for _, user := range users {
-		i := strings.IndexFunc(user, func(r rune) bool {
-			_, ok := prefixes[r]
-			return !ok
-		})
+		// With multi-prefix, there may be more than one prefix.
+		var modes string
+		if i := strings.IndexFunc(user, notPrefix); i != -1 {
+			for _, symbol := range user[:i] {
+				modes = addUserMode(modes, prefixes[symbol], ranks)
+			}
 
-		if i != -1 {
 			user = user[i:]
 		}
 
+		state.setUserModes(user, modes)
+
 		// The bot user should be added via JOIN
 		if user == t.currentNick {
 			continue
 		}
 
-		state := t.channels[channel]
-		state.Users[user] = struct{}{}
+		t.addChannelUser(state, user)
+		t.user(user)
+	}
+
+	return nil
+}
+
+// handleBotTag marks the sender of any message with the bot tag as a bot.
+func (t *Tracker) handleBotTag(msg *Message) {
+	if msg.Prefix == nil || msg.Prefix.Name == "" {
+		return
+	}
+
+	if _, ok := msg.Tags["bot"]; !ok {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	// Only users we share a channel with are recorded, otherwise everyone who
+	// ever messaged us would stay in the map until they quit.
+	if _, ok := t.knownUser(msg.Prefix.Name); !ok {
+		return
+	}
+
+	t.bots[msg.Prefix.Name] = struct{}{}
+}
+
+func (t *Tracker) handleRplWhoReply(msg *Message) error {
+	if len(msg.Params) != 8 {
+		return errors.New("malformed RPL_WHOREPLY message")
 	}
 
+	// client channel user host server nick flags :hopcount realname
+
+	var realname string
+	if parts := strings.SplitN(msg.Params[7], " ", 2); len(parts) == 2 {
+		realname = parts[1]
+	}
+
+	t.updateFromWho(msg.Params[5], msg.Params[2], msg.Params[3], msg.Params[6], "", realname)
+
 	return nil
 }
+
+// whoxToken is used to recognize replies to the WHOX queries sent when
+// ClientConfig.WhoOnJoin is set.
+const whoxToken = "152"
+
+// whoxFields are the fields requested in WHOX queries. Replies always have
+// them in this order: token, channel, user, host, nick, flags, account, and
+// realname.
+const whoxFields = "%tcuhnfar," + whoxToken
+
+func (t *Tracker) handleRplWhoSpcRpl(msg *Message) error {
+	// Replies to other WHOX queries can have any fields, so we can only
+	// handle our own.
+	if len(msg.Params) < 2 || msg.Params[1] != whoxToken {
+		return nil
+	}
+
+	if len(msg.Params) != 9 {
+		return errors.New("malformed RPL_WHOSPCRPL message")
+	}
+
+	// client token channel user host nick flags account :realname
+
+	account := msg.Params[7]
+	if account == "0" {
+		account = "*"
+	}
+
+	t.updateFromWho(msg.Params[5], msg.Params[3], msg.Params[4], msg.Params[6], account, msg.Params[8])
+
+	return nil
+}
+
+// updateFromWho fills in what we know about a user from a WHO or WHOX reply.
+// Only users we already know about are updated. An empty account means it
+// wasn't included in the reply; * means the user isn't logged in.
+func (t *Tracker) updateFromWho(nick, username, host, flags, account, realname string) {
+	botMode, _ := t.isupport.GetRaw("BOT")
+
+	t.Lock()
+	defer t.Unlock()
+
+	user, ok := t.users[nick]
+	if !ok {
+		return
+	}
+
+	if botMode != "" && strings.Contains(flags, botMode) {
+		t.bots[nick] = struct{}{}
+	} else {
+		delete(t.bots, nick)
+	}
+
+	user.User = username
+	user.Host = host
+	user.RealName = realname
+	user.Away = strings.HasPrefix(flags, "G")
+
+	if account == "*" {
+		user.Account = ""
+	} else if account != "" {
+		user.Account = account
+	}
+}
@@ -0,0 +1,74 @@
+package irc
+
+import "errors"
+
+// handleAccountTag records the account of the sender of any message with the
+// account tag, which is sent when the account-tag cap is enabled.
+func (t *Tracker) handleAccountTag(msg *Message) {
+	if msg.Prefix == nil || msg.Prefix.Name == "" {
+		return
+	}
+
+	account, ok := msg.Tags["account"]
+	if !ok {
+		return
+	}
+
+	t.setAccount(msg.Prefix.Name, account)
+}
+
+// handleAccount handles ACCOUNT messages from account-notify. An account of *
+// means the user logged out.
+func (t *Tracker) handleAccount(msg *Message) error {
+	if len(msg.Params) != 1 {
+		return errors.New("malformed ACCOUNT message")
+	}
+
+	t.setAccount(msg.Prefix.Name, msg.Params[0])
+
+	return nil
+}
+
+func (t *Tracker) handleRplLoggedIn(msg *Message) error {
+	if len(msg.Params) != 4 {
+		return errors.New("malformed RPL_LOGGEDIN message")
+	}
+
+	// client prefix account :You are now logged in as account
+
+	// This is always about us, and may come before 001 or any JOIN, so
+	// the user is created if needed.
+	t.Lock()
+	t.user(msg.Params[0]).Account = msg.Params[2]
+	t.Unlock()
+
+	return nil
+}
+
+func (t *Tracker) handleRplLoggedOut(msg *Message) error {
+	if len(msg.Params) != 3 {
+		return errors.New("malformed RPL_LOGGEDOUT message")
+	}
+
+	t.Lock()
+	t.user(msg.Params[0]).Account = ""
+	t.Unlock()
+
+	return nil
+}
+
+func (t *Tracker) setAccount(nick, account string) {
+	t.Lock()
+	defer t.Unlock()
+
+	user, ok := t.knownUser(nick)
+	if !ok {
+		return
+	}
+
+	if account == "*" {
+		account = ""
+	}
+
+	user.Account = account
+}
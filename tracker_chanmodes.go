@@ -0,0 +1,320 @@
+package irc
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// modeChange is a single change from a MODE message.
+type modeChange struct {
+	adding bool
+	mode   rune
+	param  string
+	class  ChanModeType
+}
+
+// parseModeChanges splits a channel MODE string and its arguments into
+// individual changes, using PREFIX and CHANMODES to figure out which modes
+// take a parameter. Unknown modes are assumed to not take one.
+func parseModeChanges(isupport *ISupportTracker, modes string, args []string) ([]modeChange, error) {
+	ranks := isupport.getPrefixModes()
+	typeA, typeB, typeC, typeD := isupport.getChanModes()
+
+	var ret []modeChange
+	adding := true
+
+	for _, mode := range modes {
+		switch mode {
+		case '+':
+			adding = true
+			continue
+		case '-':
+			adding = false
+			continue
+		}
+
+		change := modeChange{adding: adding, mode: mode}
+		change.class = classifyChanMode(mode, ranks, typeA, typeB, typeC, typeD)
+		if change.class == ChanModeUnknown {
+			change.class = ChanModeD
+		}
+
+		if change.class.HasParam(adding) {
+			if len(args) == 0 {
+				return nil, errors.New("missing MODE parameter")
+			}
+
+			change.param = args[0]
+			args = args[1:]
+		}
+
+		ret = append(ret, change)
+	}
+
+	return ret, nil
+}
+
+// ChannelModes contains the modes set on a channel. Modes are keyed by their
+// mode letter.
+type ChannelModes struct {
+	// Flags contains modes which don't have a parameter, such as n or t.
+	Flags map[string]struct{} `json:"flags,omitempty"`
+
+	// Params contains modes which have a single parameter, such as k or l.
+	Params map[string]string `json:"params,omitempty"`
+
+	// Lists contains list modes, such as the ban list for b.
+	Lists map[string][]string `json:"lists,omitempty"`
+}
+
+// Has returns true if the given mode is set. List modes are considered set if
+// the list isn't empty.
+func (m ChannelModes) Has(mode rune) bool {
+	key := string(mode)
+
+	if _, ok := m.Flags[key]; ok {
+		return true
+	}
+
+	if _, ok := m.Params[key]; ok {
+		return true
+	}
+
+	return len(m.Lists[key]) > 0
+}
+
+// Param returns the parameter for a mode, such as the key for k.
+func (m ChannelModes) Param(mode rune) (string, bool) {
+	ret, ok := m.Params[string(mode)]
+	return ret, ok
+}
+
+// List returns a copy of the entries for a list mode.
+func (m ChannelModes) List(mode rune) []string {
+	list := m.Lists[string(mode)]
+	if len(list) == 0 {
+		return nil
+	}
+
+	ret := make([]string, len(list))
+	copy(ret, list)
+
+	return ret
+}
+
+// Key returns the channel key set with +k, if any.
+func (m ChannelModes) Key() string {
+	ret, _ := m.Param('k')
+	return ret
+}
+
+// Limit returns the user limit set with +l. The bool will be false if there is
+// no limit.
+func (m ChannelModes) Limit() (int, bool) {
+	data, ok := m.Param('l')
+	if !ok {
+		return 0, false
+	}
+
+	limit, err := strconv.Atoi(data)
+	if err != nil {
+		return 0, false
+	}
+
+	return limit, true
+}
+
+// Bans returns a copy of the ban list.
+func (m ChannelModes) Bans() []string {
+	return m.List('b')
+}
+
+// String returns the flags and parameter modes in the same format as a MODE
+// message, such as "+klnt key 10". List modes are not included.
+func (m ChannelModes) String() string {
+	modes := make([]string, 0, len(m.Flags)+len(m.Params))
+	for mode := range m.Flags {
+		modes = append(modes, mode)
+	}
+	for mode := range m.Params {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+
+	ret := "+" + strings.Join(modes, "")
+	for _, mode := range modes {
+		if param, ok := m.Params[mode]; ok {
+			ret += " " + param
+		}
+	}
+
+	return ret
+}
+
+// apply updates the modes with a single change. Prefix modes are ignored.
+func (m *ChannelModes) apply(change modeChange) {
+	key := string(change.mode)
+
+	switch change.class {
+	case ChanModeA:
+		m.applyList(key, change.param, change.adding)
+	case ChanModeB, ChanModeC:
+		if !change.adding {
+			delete(m.Params, key)
+			return
+		}
+
+		if m.Params == nil {
+			m.Params = make(map[string]string)
+		}
+		m.Params[key] = change.param
+	case ChanModeD:
+		if !change.adding {
+			delete(m.Flags, key)
+			return
+		}
+
+		if m.Flags == nil {
+			m.Flags = make(map[string]struct{})
+		}
+		m.Flags[key] = struct{}{}
+	}
+}
+
+func (m *ChannelModes) applyList(key, entry string, adding bool) {
+	list := m.Lists[key]
+
+	for i, existing := range list {
+		if existing != entry {
+			continue
+		}
+
+		if !adding {
+			m.Lists[key] = append(list[:i], list[i+1:]...)
+		}
+
+		return
+	}
+
+	if !adding {
+		return
+	}
+
+	if m.Lists == nil {
+		m.Lists = make(map[string][]string)
+	}
+	m.Lists[key] = append(list, entry)
+}
+
+// handleRplChannelModeIs handles the response to a MODE query for a channel,
+// which replaces all the non-list modes.
+func (t *Tracker) handleRplChannelModeIs(msg *Message) error {
+	if len(msg.Params) < 3 {
+		return errors.New("malformed RPL_CHANNELMODEIS message")
+	}
+
+	// client channel modestring args...
+
+	changes, err := parseModeChanges(t.isupport, msg.Params[2], msg.Params[3:])
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	state, ok := t.channels[msg.Params[1]]
+	if !ok {
+		return errors.New("received RPL_CHANNELMODEIS for unknown channel")
+	}
+
+	state.Modes.Flags = nil
+	state.Modes.Params = nil
+
+	for _, change := range changes {
+		if change.class != ChanModePrefix {
+			state.Modes.apply(change)
+		}
+	}
+
+	return nil
+}
+
+// handleRplCreationTime handles RPL_CREATIONTIME, which is usually sent along
+// with RPL_CHANNELMODEIS.
+func (t *Tracker) handleRplCreationTime(msg *Message) error {
+	if len(msg.Params) < 3 {
+		return errors.New("malformed RPL_CREATIONTIME message")
+	}
+
+	// client channel creationtime
+
+	ts, err := strconv.ParseInt(msg.Params[2], 10, 64)
+	if err != nil {
+		return errors.New("malformed RPL_CREATIONTIME message")
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	state, ok := t.channels[msg.Params[1]]
+	if !ok {
+		return errors.New("received RPL_CREATIONTIME for unknown channel")
+	}
+
+	state.CreatedAt = time.Unix(ts, 0)
+
+	return nil
+}
+
+// handleRplListEntry handles entries in ban, exception, and invite lists.
+func (t *Tracker) handleRplListEntry(msg *Message, mode string) error {
+	if len(msg.Params) < 3 {
+		return errors.New("malformed list mode message")
+	}
+
+	// client channel mask [setter time]
+
+	t.Lock()
+	defer t.Unlock()
+
+	state, ok := t.channels[msg.Params[1]]
+	if !ok {
+		return errors.New("received list mode entry for unknown channel")
+	}
+
+	state.Modes.applyList(mode, msg.Params[2], true)
+
+	return nil
+}
+
+// copy returns a deep copy of the modes.
+func (m ChannelModes) copy() ChannelModes {
+	var ret ChannelModes
+
+	if m.Flags != nil {
+		ret.Flags = make(map[string]struct{}, len(m.Flags))
+		for k := range m.Flags {
+			ret.Flags[k] = struct{}{}
+		}
+	}
+
+	if m.Params != nil {
+		ret.Params = make(map[string]string, len(m.Params))
+		for k, v := range m.Params {
+			ret.Params[k] = v
+		}
+	}
+
+	if m.Lists != nil {
+		ret.Lists = make(map[string][]string, len(m.Lists))
+		for k, v := range m.Lists {
+			ret.Lists[k] = append([]string(nil), v...)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,67 @@
+package irc
+
+import (
+	"regexp"
+)
+
+// NickCollision describes a nick change which the Tracker believes was caused
+// by the server resolving a nick collision rather than by the user.
+type NickCollision struct {
+	// OldNick is the nick the user had before the change.
+	OldNick string
+
+	// NewNick is the nick the user was changed to.
+	NewNick string
+
+	// Forced is true if NewNick looks like a nick assigned by the server,
+	// such as a UID or a Guest nick.
+	Forced bool
+
+	// Ghost is true if NewNick was already being tracked for another user,
+	// meaning that user must have left without us noticing. The stale entry
+	// is replaced.
+	Ghost bool
+}
+
+// forcedNickPatterns match nicks which are typically assigned by the server
+// when it needs to force a rename. TS6 networks use the user's UID, which is a
+// 3 character SID starting with a digit followed by 6 characters, and many
+// services packages use Guest followed by a number.
+var forcedNickPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^[0-9][0-9A-Z]{8}$`),
+	regexp.MustCompile(`^(?i:guest)[0-9]+$`),
+}
+
+// IsForcedNick returns true if the given nick looks like one assigned by the
+// server when resolving a nick collision.
+func IsForcedNick(nick string) bool {
+	for _, pattern := range forcedNickPatterns {
+		if pattern.MatchString(nick) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OnNickCollision sets a callback which will be called whenever the Tracker
+// sees a nick change which looks like the result of a nick collision. The
+// callback is called after the Tracker has been updated, without any locks
+// held.
+func (t *Tracker) OnNickCollision(f func(NickCollision)) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.onNickCollision = f
+}
+
+// PreviousNick returns the nick a user had before the server forced them onto
+// their current nick. This makes it possible to recognize users who were
+// renamed during a collision.
+func (t *Tracker) PreviousNick(nick string) (string, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	previous, ok := t.forced[nick]
+	return previous, ok
+}
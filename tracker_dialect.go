@@ -0,0 +1,184 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+)
+
+// TrackerDialect allows the Tracker to understand server specific extensions
+// without building them into the Tracker itself. Dialects are given every
+// message before the Tracker handles it.
+type TrackerDialect interface {
+	// HandleMessage is called for every message passed to Tracker.Handle. If
+	// it returns true or an error, the Tracker will not process the message
+	// any further.
+	HandleMessage(t *Tracker, msg *Message) (bool, error)
+}
+
+// AddDialect adds a dialect to the Tracker. Dialects added while messages are
+// being handled will see every message after the one being handled.
+func (t *Tracker) AddDialect(dialect TrackerDialect) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.dialects = append(t.dialects, dialect)
+}
+
+// GetMetadata returns a copy of all metadata known for a target, which may be
+// a nick or a channel. It will return nil if there is no metadata.
+func (t *Tracker) GetMetadata(target string) map[string]string {
+	t.RLock()
+	defer t.RUnlock()
+
+	data, ok := t.metadata[target]
+	if !ok {
+		return nil
+	}
+
+	ret := make(map[string]string, len(data))
+	for k, v := range data {
+		ret[k] = v
+	}
+
+	return ret
+}
+
+// SetMetadata stores a metadata value for a target. This is meant to be used by
+// TrackerDialect implementations.
+func (t *Tracker) SetMetadata(target, key, value string) {
+	t.Lock()
+	defer t.Unlock()
+
+	data, ok := t.metadata[target]
+	if !ok {
+		data = make(map[string]string)
+		t.metadata[target] = data
+	}
+
+	data[key] = value
+}
+
+// DeleteMetadata removes a metadata value for a target. This is meant to be
+// used by TrackerDialect implementations.
+func (t *Tracker) DeleteMetadata(target, key string) {
+	t.Lock()
+	defer t.Unlock()
+
+	data, ok := t.metadata[target]
+	if !ok {
+		return
+	}
+
+	delete(data, key)
+
+	if len(data) == 0 {
+		delete(t.metadata, target)
+	}
+}
+
+// MetadataDialect handles the METADATA command and RPL_KEYVALUE (761) as sent
+// by InspIRCd, UnrealIRCd, and other servers implementing the IRCv3 metadata
+// draft. Values are stored on the Tracker and can be read with GetMetadata.
+type MetadataDialect struct{}
+
+var _ TrackerDialect = MetadataDialect{}
+
+// HandleMessage implements TrackerDialect.
+func (MetadataDialect) HandleMessage(t *Tracker, msg *Message) (bool, error) {
+	switch msg.Command {
+	case "METADATA":
+		// METADATA <target> <key> <visibility> [:<value>]
+		if len(msg.Params) < 3 {
+			return true, errors.New("malformed METADATA message")
+		}
+
+		if len(msg.Params) == 3 {
+			t.DeleteMetadata(msg.Params[0], msg.Params[1])
+		} else {
+			t.SetMetadata(msg.Params[0], msg.Params[1], msg.Params[3])
+		}
+
+		return true, nil
+	case "761":
+		// RPL_KEYVALUE <client> <target> <key> <visibility> [:<value>]
+		if len(msg.Params) < 4 {
+			return true, errors.New("malformed RPL_KEYVALUE message")
+		}
+
+		if len(msg.Params) == 4 {
+			t.DeleteMetadata(msg.Params[1], msg.Params[2])
+		} else {
+			t.SetMetadata(msg.Params[1], msg.Params[2], msg.Params[4])
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// UnrealDialect handles the MD command UnrealIRCd uses to sync moddata, which
+// is seen by services and other clients linked as servers. Only client and
+// channel moddata is tracked. Values are stored on the Tracker and can be read
+// with GetMetadata.
+type UnrealDialect struct{}
+
+var _ TrackerDialect = UnrealDialect{}
+
+// HandleMessage implements TrackerDialect.
+func (UnrealDialect) HandleMessage(t *Tracker, msg *Message) (bool, error) {
+	if msg.Command != "MD" {
+		return false, nil
+	}
+
+	// MD <type> <target> <key> [:<value>]
+	if len(msg.Params) < 3 {
+		return true, errors.New("malformed MD message")
+	}
+
+	switch msg.Params[0] {
+	case "client", "channel":
+	default:
+		return true, nil
+	}
+
+	if len(msg.Params) == 3 {
+		t.DeleteMetadata(msg.Params[1], msg.Params[2])
+	} else {
+		t.SetMetadata(msg.Params[1], msg.Params[2], msg.Params[3])
+	}
+
+	return true, nil
+}
+
+// InspIRCdTagPrefix is the vendor prefix of the message tags InspIRCd uses
+// for its extensions.
+const InspIRCdTagPrefix = "inspircd.org/"
+
+// InspIRCdDialect records the InspIRCd vendor tags sent on TAGMSG as metadata
+// on the sender, with empty values removing the key. The message is still
+// passed on to the Tracker and any other handlers.
+type InspIRCdDialect struct{}
+
+var _ TrackerDialect = InspIRCdDialect{}
+
+// HandleMessage implements TrackerDialect.
+func (InspIRCdDialect) HandleMessage(t *Tracker, msg *Message) (bool, error) {
+	if msg.Command != "TAGMSG" || msg.Prefix == nil {
+		return false, nil
+	}
+
+	for key, value := range msg.Tags {
+		if !strings.HasPrefix(key, InspIRCdTagPrefix) {
+			continue
+		}
+
+		if value == "" {
+			t.DeleteMetadata(msg.Prefix.Name, key)
+		} else {
+			t.SetMetadata(msg.Prefix.Name, key, value)
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,94 @@
+package irc
+
+// TrackerEventType is the kind of change described by a TrackerEvent.
+type TrackerEventType int
+
+// These are the events the Tracker sends to callbacks registered with
+// OnEvent.
+const (
+	// TrackerJoin is sent when a user joins a channel.
+	TrackerJoin TrackerEventType = iota
+
+	// TrackerPart is sent when a user leaves a channel. Message is the part
+	// reason, if any.
+	TrackerPart
+
+	// TrackerKick is sent when a user is kicked from a channel. Actor is who
+	// kicked them and Message is the reason.
+	TrackerKick
+
+	// TrackerQuit is sent when a user disconnects. Channels are the channels
+	// they were in and Message is the quit reason.
+	TrackerQuit
+
+	// TrackerNick is sent when a user changes their nick to NewNick.
+	TrackerNick
+
+	// TrackerTopic is sent when the topic of a channel is changed. Actor is
+	// who changed it and Message is the new topic.
+	TrackerTopic
+)
+
+// TrackerEvent describes a change the Tracker has seen. Fields which don't
+// apply to the event's Type will be empty.
+type TrackerEvent struct {
+	Type TrackerEventType
+
+	// Channel is the channel the event happened in.
+	Channel string
+
+	// Nick is the user the event is about. For TrackerNick, it is the old
+	// nick.
+	Nick string
+
+	// NewNick is the new nick for TrackerNick.
+	NewNick string
+
+	// Actor is the user who caused the event, for kicks and topic changes.
+	Actor string
+
+	// Message is the reason or topic, depending on the event.
+	Message string
+
+	// Channels are the channels a user was in for TrackerQuit.
+	Channels []string
+
+	// Self is true if Nick (or Actor for TrackerTopic) is the current nick,
+	// such as when we are kicked from a channel.
+	Self bool
+}
+
+// OnEvent registers a callback which will be called for every TrackerEvent.
+// Callbacks are called after the Tracker has been updated, without any locks
+// held, so they can safely query the Tracker.
+func (t *Tracker) OnEvent(f func(TrackerEvent)) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.onEvent = append(t.onEvent, f)
+}
+
+// queueEvent adds an event to be sent once the current message is done being
+// handled. The lock must be held when calling this.
+func (t *Tracker) queueEvent(event TrackerEvent) {
+	if len(t.onEvent) == 0 {
+		return
+	}
+
+	t.pending = append(t.pending, event)
+}
+
+// flushEvents sends all queued events to the registered callbacks.
+func (t *Tracker) flushEvents() {
+	t.Lock()
+	events := t.pending
+	callbacks := t.onEvent
+	t.pending = nil
+	t.Unlock()
+
+	for _, event := range events {
+		for _, f := range callbacks {
+			f(event)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package irc
+
+import (
+	"errors"
+	"strings"
+)
+
+// UserModes returns the prefix modes a user has in a channel, such as "o" for
+// an op or "v" for a voiced user, ordered from highest to lowest rank. The
+// bool will be false if the channel or user isn't known. Note that unless the
+// multi-prefix cap is enabled, only the highest mode will be known for users
+// from NAMES replies.
+func (t *Tracker) UserModes(channel, nick string) (string, bool) {
+	t.RLock()
+	defer t.RUnlock()
+
+	state, ok := t.channels[channel]
+	if !ok {
+		return "", false
+	}
+
+	if _, ok := state.Users[nick]; !ok {
+		return "", false
+	}
+
+	return state.userModes[nick], true
+}
+
+// removeUser drops a user and their modes from the channel.
+func (s *ChannelState) removeUser(nick string) {
+	delete(s.Users, nick)
+	delete(s.userModes, nick)
+}
+
+// setUserModes replaces the modes for a user in the channel.
+func (s *ChannelState) setUserModes(nick, modes string) {
+	if s.userModes == nil {
+		s.userModes = make(map[string]string)
+	}
+
+	if modes == "" {
+		delete(s.userModes, nick)
+		return
+	}
+
+	s.userModes[nick] = modes
+}
+
+// addUserMode adds a mode to a set of modes, keeping them in the order given
+// by ranks.
+func addUserMode(modes string, mode rune, ranks string) string {
+	if strings.ContainsRune(modes, mode) {
+		return modes
+	}
+
+	modes += string(mode)
+
+	var ret strings.Builder
+	for _, r := range ranks {
+		if strings.ContainsRune(modes, r) {
+			ret.WriteRune(r)
+		}
+	}
+
+	return ret.String()
+}
+
+// removeUserMode removes a mode from a set of modes.
+func removeUserMode(modes string, mode rune) string {
+	return strings.Replace(modes, string(mode), "", -1)
+}
+
+// handleMode applies mode changes in channels, both for the channel itself
+// and prefix modes for users, such as +o or -v.
+func (t *Tracker) handleMode(msg *Message) error {
+	if len(msg.Params) < 2 {
+		return errors.New("malformed MODE message")
+	}
+
+	channel := msg.Params[0]
+	ranks := t.isupport.getPrefixModes()
+
+	changes, err := parseModeChanges(t.isupport, msg.Params[1], msg.Params[2:])
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	state, ok := t.channels[channel]
+	if !ok {
+		// This is either a user mode or a channel we aren't in.
+		return nil
+	}
+
+	for _, change := range changes {
+		if change.class != ChanModePrefix {
+			state.Modes.apply(change)
+			continue
+		}
+
+		nick := change.param
+		if _, ok := state.Users[nick]; !ok {
+			continue
+		}
+
+		if change.adding {
+			state.setUserModes(nick, addUserMode(state.userModes[nick], change.mode, ranks))
+		} else {
+			state.setUserModes(nick, removeUserMode(state.userModes[nick], change.mode))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package irc
+
+import (
+	"sort"
+	"time"
+)
+
+// TrackerSnapshot is a copy of everything the Tracker knows at a point in time.
+// It doesn't share any data with the Tracker, so it is safe to use from any
+// goroutine, and it can be marshaled to JSON.
+type TrackerSnapshot struct {
+	CurrentNick string                       `json:"current_nick"`
+	Channels    map[string]ChannelSnapshot   `json:"channels"`
+	Users       map[string]UserState         `json:"users"`
+	Bots        []string                     `json:"bots,omitempty"`
+	Forced      map[string]string            `json:"forced,omitempty"`
+	Metadata    map[string]map[string]string `json:"metadata,omitempty"`
+}
+
+// ChannelSnapshot is a copy of a single channel's state.
+type ChannelSnapshot struct {
+	Name       string       `json:"name"`
+	Topic      string       `json:"topic,omitempty"`
+	TopicSetBy string       `json:"topic_set_by,omitempty"`
+	TopicSetAt time.Time    `json:"topic_set_at"`
+	CreatedAt  time.Time    `json:"created_at"`
+	Modes      ChannelModes `json:"modes"`
+
+	// Users maps each nick in the channel to their prefix modes, which will
+	// be empty for users without any.
+	Users map[string]string `json:"users"`
+}
+
+// Snapshot returns a deep copy of the Tracker's state.
+func (t *Tracker) Snapshot() *TrackerSnapshot {
+	t.RLock()
+	defer t.RUnlock()
+
+	ret := &TrackerSnapshot{
+		CurrentNick: t.currentNick,
+		Channels:    make(map[string]ChannelSnapshot, len(t.channels)),
+		Users:       make(map[string]UserState, len(t.users)),
+		Forced:      make(map[string]string, len(t.forced)),
+		Metadata:    make(map[string]map[string]string, len(t.metadata)),
+	}
+
+	for name, state := range t.channels {
+		channel := ChannelSnapshot{
+			Name:       state.Name,
+			Topic:      state.Topic,
+			TopicSetBy: state.TopicSetBy,
+			TopicSetAt: state.TopicSetAt,
+			CreatedAt:  state.CreatedAt,
+			Modes:      state.Modes.copy(),
+			Users:      make(map[string]string, len(state.Users)),
+		}
+
+		for nick := range state.Users {
+			channel.Users[nick] = state.userModes[nick]
+		}
+
+		ret.Channels[name] = channel
+	}
+
+	for nick, user := range t.users {
+		ret.Users[nick] = *user
+	}
+
+	for nick := range t.bots {
+		ret.Bots = append(ret.Bots, nick)
+	}
+	sort.Strings(ret.Bots)
+
+	for nick, previous := range t.forced {
+		ret.Forced[nick] = previous
+	}
+
+	for target, data := range t.metadata {
+		values := make(map[string]string, len(data))
+		for k, v := range data {
+			values[k] = v
+		}
+		ret.Metadata[target] = values
+	}
+
+	return ret
+}
+
+// RestoreSnapshot replaces the Tracker's state with a copy of the given
+// snapshot. This is meant for restoring state from before a restart; the
+// restored state may be out of date until the server sends updates.
+func (t *Tracker) RestoreSnapshot(snapshot *TrackerSnapshot) {
+	channels := make(map[string]*ChannelState, len(snapshot.Channels))
+	userChannels := make(map[string]map[string]struct{})
+	for name, channel := range snapshot.Channels {
+		state := &ChannelState{
+			Name:       channel.Name,
+			Topic:      channel.Topic,
+			TopicSetBy: channel.TopicSetBy,
+			TopicSetAt: channel.TopicSetAt,
+			CreatedAt:  channel.CreatedAt,
+			Modes:      channel.Modes.copy(),
+			Users:      make(map[string]struct{}, len(channel.Users)),
+			userModes:  make(map[string]string),
+		}
+
+		for nick, modes := range channel.Users {
+			state.Users[nick] = struct{}{}
+			state.setUserModes(nick, modes)
+
+			if _, ok := userChannels[nick]; !ok {
+				userChannels[nick] = make(map[string]struct{})
+			}
+			userChannels[nick][name] = struct{}{}
+		}
+
+		channels[name] = state
+	}
+
+	users := make(map[string]*UserState, len(snapshot.Users))
+	for nick, user := range snapshot.Users {
+		user := user
+		users[nick] = &user
+	}
+
+	bots := make(map[string]struct{}, len(snapshot.Bots))
+	for _, nick := range snapshot.Bots {
+		bots[nick] = struct{}{}
+	}
+
+	forced := make(map[string]string, len(snapshot.Forced))
+	for nick, previous := range snapshot.Forced {
+		forced[nick] = previous
+	}
+
+	metadata := make(map[string]map[string]string, len(snapshot.Metadata))
+	for target, data := range snapshot.Metadata {
+		values := make(map[string]string, len(data))
+		for k, v := range data {
+			values[k] = v
+		}
+		metadata[target] = values
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.currentNick = snapshot.CurrentNick
+	t.channels = channels
+	t.userChannels = userChannels
+	t.users = users
+	t.bots = bots
+	t.forced = forced
+	t.metadata = metadata
+}
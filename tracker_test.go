@@ -0,0 +1,740 @@
+package irc_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+func newTestTracker(t *testing.T, lines ...string) *irc.Tracker {
+	t.Helper()
+
+	isupport := irc.NewISupportTracker()
+	tracker := irc.NewTracker(isupport)
+
+	feedTracker(t, tracker, lines...)
+
+	return tracker
+}
+
+func feedTracker(t *testing.T, tracker *irc.Tracker, lines ...string) {
+	t.Helper()
+
+	for _, line := range lines {
+		require.NoError(t, tracker.Handle(irc.MustParseMessage(line)), line)
+	}
+}
+
+func TestTrackerBots(t *testing.T) {
+	t.Parallel()
+
+	isupport := irc.NewISupportTracker()
+	require.NoError(t, isupport.Handle(irc.MustParseMessage("005 test_nick BOT=B :are supported by this server")))
+
+	tracker := irc.NewTracker(isupport)
+	feedTracker(t, tracker,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		"353 test_nick = #chan :test_nick a_bot a_user other_bot other_user",
+		"366 test_nick #chan :End of /NAMES list",
+		"@bot :a_bot!user@host PRIVMSG #chan :hello",
+		":a_user!user@host PRIVMSG #chan :hello",
+		"352 test_nick #chan user host server other_bot H@B :0 Real Name",
+		"352 test_nick #chan user host server other_user H :0 Real Name",
+		"@bot :stranger_bot!user@host PRIVMSG test_nick :hello",
+		"352 test_nick * user host server stranger_who_bot H@B :0 Real Name",
+	)
+
+	assert.True(t, tracker.IsBot("a_bot"))
+	assert.True(t, tracker.IsBot("other_bot"))
+	assert.False(t, tracker.IsBot("a_user"))
+	assert.False(t, tracker.IsBot("other_user"))
+
+	// Users we don't share a channel with aren't tracked.
+	assert.False(t, tracker.IsBot("stranger_bot"))
+	assert.False(t, tracker.IsBot("stranger_who_bot"))
+
+	feedTracker(t, tracker,
+		":a_bot!user@host NICK new_bot",
+		":other_bot!user@host QUIT :bye",
+	)
+
+	assert.False(t, tracker.IsBot("a_bot"))
+	assert.True(t, tracker.IsBot("new_bot"))
+	assert.False(t, tracker.IsBot("other_bot"))
+
+	// A WHO reply without the flag clears it
+	feedTracker(t, tracker, "352 test_nick #chan user host server new_bot H :0 Real Name")
+	assert.False(t, tracker.IsBot("new_bot"))
+
+	// So does leaving the last channel we share.
+	feedTracker(t, tracker,
+		"@bot :a_user!user@host PRIVMSG #chan :hello",
+		":a_user!user@host PART #chan",
+	)
+	assert.False(t, tracker.IsBot("a_user"))
+}
+
+func TestTrackerChannelsForUser(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":test_nick!user@host JOIN #other",
+		":irc.example.com 353 test_nick = #chan :test_nick a_user @b_user",
+		":b_user!user@host JOIN #other",
+	)
+
+	assert.ElementsMatch(t, []string{"test_nick", "a_user", "b_user"}, tracker.ListUsers())
+	assert.Equal(t, []string{"#chan", "#other"}, tracker.ChannelsForUser("test_nick"))
+	assert.Equal(t, []string{"#chan"}, tracker.ChannelsForUser("a_user"))
+	assert.Equal(t, []string{"#chan", "#other"}, tracker.ChannelsForUser("b_user"))
+	assert.Nil(t, tracker.ChannelsForUser("c_user"))
+
+	feedTracker(t, tracker,
+		":b_user!user@host NICK c_user",
+		":c_user!user@host PART #chan",
+		":test_nick!user@host KICK #chan a_user :bye",
+	)
+
+	assert.Nil(t, tracker.ChannelsForUser("b_user"))
+	assert.Nil(t, tracker.ChannelsForUser("a_user"))
+	assert.Equal(t, []string{"#other"}, tracker.ChannelsForUser("c_user"))
+
+	feedTracker(t, tracker, ":test_nick!user@host PART #other")
+	assert.Nil(t, tracker.ChannelsForUser("c_user"))
+	assert.Equal(t, []string{"#chan"}, tracker.ChannelsForUser("test_nick"))
+
+	feedTracker(t, tracker, ":test_nick!user@host QUIT :bye")
+	assert.Empty(t, tracker.ListUsers())
+}
+
+func TestTrackerWho(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":irc.example.com 353 test_nick = #chan :test_nick a_user b_user",
+		":irc.example.com 352 test_nick #chan a_ident a.host server a_user G :0 A User",
+		":irc.example.com 354 test_nick 152 #chan b_ident b.host b_user H b_account :B User",
+		// Users we don't share a channel with and replies to other WHOX
+		// queries should be ignored.
+		":irc.example.com 352 test_nick #other c_ident c.host server c_user H :0 C User",
+		":irc.example.com 354 test_nick 1 #chan b_user",
+	)
+
+	assert.Equal(t, &irc.UserState{
+		Nick:     "a_user",
+		User:     "a_ident",
+		Host:     "a.host",
+		RealName: "A User",
+		Away:     true,
+	}, tracker.GetUser("a_user"))
+	assert.Equal(t, &irc.UserState{
+		Nick:     "b_user",
+		User:     "b_ident",
+		Host:     "b.host",
+		Account:  "b_account",
+		RealName: "B User",
+	}, tracker.GetUser("b_user"))
+	assert.Nil(t, tracker.GetUser("c_user"))
+
+	// An account of 0 means the user logged out.
+	feedTracker(t, tracker, ":irc.example.com 354 test_nick 152 #chan b_ident b.host b_user G 0 :B User")
+	assert.Equal(t, "", tracker.GetUser("b_user").Account)
+	assert.True(t, tracker.GetUser("b_user").Away)
+}
+
+func TestTrackerMetadataDialect(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t)
+	tracker.AddDialect(irc.MetadataDialect{})
+
+	feedTracker(t, tracker,
+		"001 test_nick :Welcome",
+		":irc.example.com METADATA other_nick avatar * :https://example.com/a.png",
+		":irc.example.com METADATA #chan url * :https://example.com",
+		"761 test_nick other_nick homepage * :https://example.com/other",
+	)
+
+	assert.Equal(t, map[string]string{
+		"avatar":   "https://example.com/a.png",
+		"homepage": "https://example.com/other",
+	}, tracker.GetMetadata("other_nick"))
+	assert.Equal(t, map[string]string{"url": "https://example.com"}, tracker.GetMetadata("#chan"))
+
+	feedTracker(t, tracker,
+		":irc.example.com METADATA other_nick avatar *",
+		"761 test_nick #chan url *",
+	)
+
+	assert.Equal(t, map[string]string{"homepage": "https://example.com/other"}, tracker.GetMetadata("other_nick"))
+	assert.Nil(t, tracker.GetMetadata("#chan"))
+
+	assert.Error(t, tracker.Handle(irc.MustParseMessage("METADATA other_nick")))
+	assert.Error(t, tracker.Handle(irc.MustParseMessage("761 test_nick other_nick")))
+}
+
+func TestTrackerMetadataCleanup(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":a_user!user@host JOIN #chan",
+		":b_user!user@host JOIN #chan",
+	)
+	tracker.AddDialect(irc.MetadataDialect{})
+
+	feedTracker(t, tracker,
+		":irc.example.com METADATA a_user avatar * :a.png",
+		":irc.example.com METADATA b_user avatar * :b.png",
+		":irc.example.com METADATA #chan url * :https://example.com",
+	)
+
+	// Metadata follows nick changes.
+	feedTracker(t, tracker, ":a_user!user@host NICK c_user")
+	assert.Nil(t, tracker.GetMetadata("a_user"))
+	assert.Equal(t, map[string]string{"avatar": "a.png"}, tracker.GetMetadata("c_user"))
+
+	// And is dropped when we stop seeing the user or channel.
+	feedTracker(t, tracker, ":c_user!user@host QUIT :Bye")
+	assert.Nil(t, tracker.GetMetadata("c_user"))
+
+	feedTracker(t, tracker, ":test_nick!user@host PART #chan")
+	assert.Nil(t, tracker.GetMetadata("b_user"))
+	assert.Nil(t, tracker.GetMetadata("#chan"))
+}
+
+func TestTrackerUnrealDialect(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t)
+	tracker.AddDialect(irc.UnrealDialect{})
+
+	feedTracker(t, tracker,
+		"001 test_nick :Welcome",
+		":irc.example.com MD client other_nick certfp :abcdef",
+		":irc.example.com MD channel #chan floodprot :[5j]:15",
+		":irc.example.com MD member other_nick:#chan key :value",
+	)
+
+	assert.Equal(t, map[string]string{"certfp": "abcdef"}, tracker.GetMetadata("other_nick"))
+	assert.Equal(t, map[string]string{"floodprot": "[5j]:15"}, tracker.GetMetadata("#chan"))
+	assert.Nil(t, tracker.GetMetadata("other_nick:#chan"))
+
+	feedTracker(t, tracker, ":irc.example.com MD client other_nick certfp")
+	assert.Nil(t, tracker.GetMetadata("other_nick"))
+
+	assert.Error(t, tracker.Handle(irc.MustParseMessage("MD client other_nick")))
+}
+
+func TestTrackerInspIRCdDialect(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t)
+	tracker.AddDialect(irc.InspIRCdDialect{})
+
+	feedTracker(t, tracker,
+		"001 test_nick :Welcome",
+		"@inspircd.org/service=ChanServ;+typing=active :other_nick!user@host TAGMSG #chan",
+	)
+
+	assert.Equal(t, map[string]string{"inspircd.org/service": "ChanServ"}, tracker.GetMetadata("other_nick"))
+
+	feedTracker(t, tracker, "@inspircd.org/service= :other_nick!user@host TAGMSG #chan")
+	assert.Nil(t, tracker.GetMetadata("other_nick"))
+}
+
+func TestTrackerNickCollision(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":test_nick!user@host JOIN #other",
+		":a_user!user@host JOIN #chan",
+		":ghost!user@host JOIN #other",
+	)
+
+	var collisions []irc.NickCollision
+	tracker.OnNickCollision(func(c irc.NickCollision) {
+		collisions = append(collisions, c)
+	})
+
+	// Regular nick changes shouldn't be reported.
+	feedTracker(t, tracker, ":a_user!user@host NICK b_user")
+	assert.Empty(t, collisions)
+
+	// Being forced onto a UID should be reported and remembered.
+	feedTracker(t, tracker, ":b_user!user@host NICK 42XAAAAAB")
+	assert.Equal(t, []irc.NickCollision{
+		{OldNick: "b_user", NewNick: "42XAAAAAB", Forced: true},
+	}, collisions)
+
+	previous, ok := tracker.PreviousNick("42XAAAAAB")
+	assert.True(t, ok)
+	assert.Equal(t, "b_user", previous)
+
+	// Taking a nick we still think someone else has should drop the ghost.
+	collisions = nil
+	feedTracker(t, tracker, ":42XAAAAAB!user@host NICK ghost")
+	assert.Equal(t, []irc.NickCollision{
+		{OldNick: "42XAAAAAB", NewNick: "ghost", Ghost: true},
+	}, collisions)
+
+	assert.Equal(t, map[string]struct{}{
+		"test_nick": {},
+		"ghost":     {},
+	}, tracker.GetChannel("#chan").Users)
+	assert.Equal(t, map[string]struct{}{
+		"test_nick": {},
+	}, tracker.GetChannel("#other").Users)
+
+	_, ok = tracker.PreviousNick("42XAAAAAB")
+	assert.False(t, ok)
+
+	// Guest nicks are also considered forced.
+	assert.True(t, irc.IsForcedNick("Guest12345"))
+	assert.False(t, irc.IsForcedNick("guest_user"))
+}
+
+func BenchmarkTrackerNames(b *testing.B) {
+	messages := []*irc.Message{
+		irc.MustParseMessage("001 test_nick :Welcome"),
+		irc.MustParseMessage(":test_nick JOIN #chan"),
+	}
+
+	// 50k users, 100 per RPL_NAMREPLY line.
+	for i := 0; i < 500; i++ {
+		var users []string
+		for j := 0; j < 100; j++ {
+			prefix := ""
+			if j%10 == 0 {
+				prefix = "@"
+			}
+			users = append(users, fmt.Sprintf("%suser_%d_%d", prefix, i, j))
+		}
+
+		messages = append(messages, irc.MustParseMessage(
+			":irc.example.com 353 test_nick = #chan :"+strings.Join(users, " "),
+		))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		tracker := irc.NewTracker(irc.NewISupportTracker())
+		for _, m := range messages {
+			if err := tracker.Handle(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestTrackerAccounts(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":irc.example.com 900 test_nick test_nick!user@host test_account :You are now logged in as test_account",
+		":test_nick!user@host JOIN #chan",
+		":a_user!user@host JOIN #chan",
+		":b_user!user@host JOIN #chan",
+		"@account=a_account :b_user!user@host PRIVMSG #chan :hello",
+	)
+
+	account, ok := tracker.AccountFor("test_nick")
+	assert.True(t, ok)
+	assert.Equal(t, "test_account", account)
+
+	account, ok = tracker.AccountFor("b_user")
+	assert.True(t, ok)
+	assert.Equal(t, "a_account", account)
+
+	_, ok = tracker.AccountFor("a_user")
+	assert.False(t, ok)
+
+	// account-notify
+	feedTracker(t, tracker, ":a_user!user@host ACCOUNT other_account")
+	account, _ = tracker.AccountFor("a_user")
+	assert.Equal(t, "other_account", account)
+
+	// Accounts should follow nick changes.
+	feedTracker(t, tracker, ":a_user!user@host NICK c_user")
+	_, ok = tracker.AccountFor("a_user")
+	assert.False(t, ok)
+	account, _ = tracker.AccountFor("c_user")
+	assert.Equal(t, "other_account", account)
+
+	// Logging out or quitting should forget the account.
+	feedTracker(t, tracker,
+		":c_user!user@host ACCOUNT *",
+		":b_user!user@host QUIT :bye",
+		":irc.example.com 901 test_nick test_nick!user@host :You are now logged out",
+	)
+
+	for _, nick := range []string{"c_user", "b_user", "test_nick"} {
+		_, ok = tracker.AccountFor(nick)
+		assert.False(t, ok, nick)
+	}
+
+	// Users we don't share a channel with aren't tracked.
+	feedTracker(t, tracker,
+		"@account=s_account :stranger!user@host PRIVMSG test_nick :hello",
+		":stranger!user@host ACCOUNT s_account",
+		":stranger!user@host CHGHOST ident host",
+		":stranger!user@host SETNAME :Stranger",
+	)
+	assert.Nil(t, tracker.GetUser("stranger"))
+	assert.ElementsMatch(t, []string{"test_nick", "c_user"}, tracker.ListUsers())
+}
+
+func TestTrackerUsers(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan * :Test Name",
+		":test_nick!user@host JOIN #other * :Test Name",
+		":a_user!a_ident@a.host JOIN #chan a_account :A User",
+		":b_user!b_ident@b.host JOIN #chan * :B User",
+		":b_user!b_ident@b.host JOIN #other",
+		":irc.example.com 353 test_nick = #chan :test_nick a_user b_user @c_user",
+	)
+
+	assert.Equal(t, &irc.UserState{
+		Nick:     "a_user",
+		User:     "a_ident",
+		Host:     "a.host",
+		Account:  "a_account",
+		RealName: "A User",
+	}, tracker.GetUser("a_user"))
+
+	_, ok := tracker.AccountFor("b_user")
+	assert.False(t, ok)
+	assert.Equal(t, "B User", tracker.GetUser("b_user").RealName)
+
+	// Users from NAMES are known, even if we don't know much about them.
+	assert.Equal(t, &irc.UserState{Nick: "c_user"}, tracker.GetUser("c_user"))
+
+	// Renaming keeps everything we know.
+	feedTracker(t, tracker, ":a_user!a_ident@a.host NICK d_user")
+	assert.Nil(t, tracker.GetUser("a_user"))
+	assert.Equal(t, "A User", tracker.GetUser("d_user").RealName)
+
+	// Users are forgotten once we don't share any channels.
+	feedTracker(t, tracker, ":test_nick!user@host PART #chan")
+	assert.Nil(t, tracker.GetUser("d_user"))
+	assert.Nil(t, tracker.GetUser("c_user"))
+	assert.NotNil(t, tracker.GetUser("b_user"))
+	assert.NotNil(t, tracker.GetUser("test_nick"))
+
+	feedTracker(t, tracker, ":b_user!b_ident@b.host PART #other")
+	assert.Nil(t, tracker.GetUser("b_user"))
+
+	// Modifying the returned state shouldn't affect the tracker.
+	tracker.GetUser("test_nick").RealName = "changed"
+	assert.Equal(t, "Test Name", tracker.GetUser("test_nick").RealName)
+}
+
+func TestTrackerUserChanges(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":a_user!a_ident@a.host JOIN #chan a_account :A User",
+	)
+
+	var changes [][2]irc.UserState
+	tracker.OnUserChange(func(old, new irc.UserState) {
+		changes = append(changes, [2]irc.UserState{old, new})
+	})
+
+	feedTracker(t, tracker,
+		":a_user!a_ident@a.host CHGHOST new_ident new.host",
+		":a_user!new_ident@new.host SETNAME :New Name",
+		// Changes which don't change anything shouldn't be reported.
+		":a_user!new_ident@new.host SETNAME :New Name",
+	)
+
+	original := irc.UserState{Nick: "a_user", User: "a_ident", Host: "a.host", Account: "a_account", RealName: "A User"}
+	chghost := irc.UserState{Nick: "a_user", User: "new_ident", Host: "new.host", Account: "a_account", RealName: "A User"}
+	setname := irc.UserState{Nick: "a_user", User: "new_ident", Host: "new.host", Account: "a_account", RealName: "New Name"}
+
+	assert.Equal(t, [][2]irc.UserState{
+		{original, chghost},
+		{chghost, setname},
+	}, changes)
+	assert.Equal(t, &setname, tracker.GetUser("a_user"))
+}
+
+func TestTrackerUserModes(t *testing.T) {
+	t.Parallel()
+
+	isupport := irc.NewISupportTracker()
+	require.NoError(t, isupport.Handle(irc.MustParseMessage("005 test_nick PREFIX=(qaohv)~&@%+ CHANMODES=beI,k,l,imnpst :are supported by this server")))
+
+	tracker := irc.NewTracker(isupport)
+	feedTracker(t, tracker,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":irc.example.com 353 test_nick = #chan :@test_nick ~@a_user +b_user c_user",
+	)
+
+	modes := func(nick string) string {
+		t.Helper()
+
+		ret, ok := tracker.UserModes("#chan", nick)
+		assert.True(t, ok, nick)
+		return ret
+	}
+
+	assert.Equal(t, "o", modes("test_nick"))
+	assert.Equal(t, "qo", modes("a_user"))
+	assert.Equal(t, "v", modes("b_user"))
+	assert.Equal(t, "", modes("c_user"))
+
+	_, ok := tracker.UserModes("#chan", "unknown")
+	assert.False(t, ok)
+	_, ok = tracker.UserModes("#other", "a_user")
+	assert.False(t, ok)
+
+	// Parameters for other modes need to be skipped properly. +l takes a
+	// parameter when set but not when unset.
+	feedTracker(t, tracker,
+		":a_user!user@host MODE #chan +vbkl-o c_user *!*@spam key 10 a_user",
+		":a_user!user@host MODE #chan -lv+h b_user b_user",
+	)
+
+	assert.Equal(t, "q", modes("a_user"))
+	assert.Equal(t, "h", modes("b_user"))
+	assert.Equal(t, "v", modes("c_user"))
+
+	// Modes should follow nick changes and be dropped when users leave.
+	feedTracker(t, tracker,
+		":c_user!user@host NICK d_user",
+		":b_user!user@host PART #chan",
+		":b_user!user@host JOIN #chan",
+	)
+
+	assert.Equal(t, "v", modes("d_user"))
+	assert.Equal(t, "", modes("b_user"))
+}
+
+func TestTrackerChannelModes(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":irc.example.com 324 test_nick #chan +ntkl key 10",
+		":irc.example.com 329 test_nick #chan 1600000000",
+		":irc.example.com 367 test_nick #chan *!*@spam.example.com op 1600000000",
+		":irc.example.com 368 test_nick #chan :End of channel ban list",
+	)
+
+	modes := tracker.GetChannel("#chan").Modes
+	assert.True(t, modes.Has('n'))
+	assert.True(t, modes.Has('b'))
+	assert.False(t, modes.Has('m'))
+	assert.Equal(t, "key", modes.Key())
+	limit, ok := modes.Limit()
+	assert.True(t, ok)
+	assert.Equal(t, 10, limit)
+	assert.Equal(t, []string{"*!*@spam.example.com"}, modes.Bans())
+	assert.Equal(t, "+klnt key 10", modes.String())
+	assert.Equal(t, int64(1600000000), tracker.GetChannel("#chan").CreatedAt.Unix())
+
+	feedTracker(t, tracker,
+		":op!user@host MODE #chan +mb-lk *!*@other.example.com key",
+		":op!user@host MODE #chan -b+o *!*@spam.example.com test_nick",
+	)
+
+	modes = tracker.GetChannel("#chan").Modes
+	assert.Equal(t, "+mnt", modes.String())
+	_, ok = modes.Limit()
+	assert.False(t, ok)
+	assert.Equal(t, []string{"*!*@other.example.com"}, modes.Bans())
+
+	// A fresh RPL_CHANNELMODEIS replaces everything but the lists.
+	feedTracker(t, tracker, ":irc.example.com 324 test_nick #chan +s")
+	modes = tracker.GetChannel("#chan").Modes
+	assert.Equal(t, "+s", modes.String())
+	assert.Equal(t, []string{"*!*@other.example.com"}, modes.Bans())
+}
+
+func TestTrackerTopic(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":irc.example.com 332 test_nick #chan :Old topic",
+		":irc.example.com 333 test_nick #chan setter!user@host 1600000000",
+	)
+
+	state := tracker.GetChannel("#chan")
+	assert.Equal(t, "Old topic", state.Topic)
+	assert.Equal(t, "setter!user@host", state.TopicSetBy)
+	assert.Equal(t, int64(1600000000), state.TopicSetAt.Unix())
+
+	feedTracker(t, tracker, "@time=2020-01-01T00:00:00.000Z :other!user@host TOPIC #chan :New topic")
+
+	state = tracker.GetChannel("#chan")
+	assert.Equal(t, "New topic", state.Topic)
+	assert.Equal(t, "other", state.TopicSetBy)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), state.TopicSetAt)
+
+	// Without server-time, the current time is used.
+	before := time.Now()
+	feedTracker(t, tracker, ":other!user@host TOPIC #chan :Newer topic")
+	assert.False(t, tracker.GetChannel("#chan").TopicSetAt.Before(before))
+}
+
+func TestTrackerEvents(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t, "001 test_nick :Welcome")
+
+	var events []irc.TrackerEvent
+	tracker.OnEvent(func(event irc.TrackerEvent) {
+		// The tracker should already be updated and unlocked.
+		if event.Type == irc.TrackerJoin {
+			assert.NotNil(t, tracker.GetChannel(event.Channel))
+		}
+
+		events = append(events, event)
+	})
+
+	feedTracker(t, tracker,
+		":test_nick!user@host JOIN #chan",
+		":test_nick!user@host JOIN #other",
+		":a_user!user@host JOIN #chan",
+		":a_user!user@host JOIN #other",
+		":a_user!user@host TOPIC #chan :New topic",
+		":a_user!user@host NICK b_user",
+		":b_user!user@host PART #other :bye",
+		":b_user!user@host QUIT :Quit: leaving",
+		":op!user@host KICK #chan test_nick :go away",
+	)
+
+	assert.Equal(t, []irc.TrackerEvent{
+		{Type: irc.TrackerJoin, Channel: "#chan", Nick: "test_nick", Self: true},
+		{Type: irc.TrackerJoin, Channel: "#other", Nick: "test_nick", Self: true},
+		{Type: irc.TrackerJoin, Channel: "#chan", Nick: "a_user"},
+		{Type: irc.TrackerJoin, Channel: "#other", Nick: "a_user"},
+		{Type: irc.TrackerTopic, Channel: "#chan", Actor: "a_user", Message: "New topic"},
+		{Type: irc.TrackerNick, Nick: "a_user", NewNick: "b_user"},
+		{Type: irc.TrackerPart, Channel: "#other", Nick: "b_user", Message: "bye"},
+		{Type: irc.TrackerQuit, Nick: "b_user", Message: "Quit: leaving", Channels: []string{"#chan"}},
+		{Type: irc.TrackerKick, Channel: "#chan", Nick: "test_nick", Actor: "op", Message: "go away", Self: true},
+	}, events)
+}
+
+func TestTrackerCallbacksWhileHandling(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+	)
+
+	// Registering callbacks while messages are being handled shouldn't race
+	// with the Tracker using them.
+	register := []func(){
+		func() { tracker.OnEvent(func(irc.TrackerEvent) {}) },
+		func() { tracker.OnNickCollision(func(irc.NickCollision) {}) },
+		func() { tracker.OnUserChange(func(old, new irc.UserState) {}) },
+		func() { tracker.AddDialect(irc.InspIRCdDialect{}) },
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			for _, f := range register {
+				f()
+			}
+			runtime.Gosched()
+		}
+	}()
+
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		feedTracker(t, tracker,
+			fmt.Sprintf(":user_%d!user@host JOIN #chan", i),
+			fmt.Sprintf(":user_%d!user@host NICK 42X%06d", i, i),
+			fmt.Sprintf(":42X%06d!user@host CHGHOST new_user new.host", i),
+			fmt.Sprintf(":42X%06d!user@host QUIT :bye", i),
+		)
+		runtime.Gosched()
+	}
+}
+
+func TestTrackerSnapshot(t *testing.T) {
+	t.Parallel()
+
+	tracker := newTestTracker(t,
+		"001 test_nick :Welcome",
+		":test_nick!user@host JOIN #chan",
+		":a_user!a_ident@a.host JOIN #chan a_account :A User",
+		":irc.example.com 353 test_nick = #chan :@test_nick +a_user",
+		":irc.example.com 324 test_nick #chan +ntk key",
+		":irc.example.com 367 test_nick #chan *!*@spam",
+		"@bot :a_user!a_ident@a.host PRIVMSG #chan :hello",
+		"@time=2020-01-01T00:00:00.000Z :a_user!a_ident@a.host TOPIC #chan :Topic",
+	)
+
+	snapshot := tracker.Snapshot()
+	assert.Equal(t, "test_nick", snapshot.CurrentNick)
+	assert.Equal(t, map[string]string{"test_nick": "o", "a_user": "v"}, snapshot.Channels["#chan"].Users)
+	assert.Equal(t, []string{"a_user"}, snapshot.Bots)
+	assert.Equal(t, "A User", snapshot.Users["a_user"].RealName)
+
+	// The snapshot shouldn't share anything with the tracker.
+	feedTracker(t, tracker,
+		":op!user@host MODE #chan +b *!*@other",
+		":a_user!a_ident@a.host PART #chan",
+	)
+	assert.Equal(t, []string{"*!*@spam"}, snapshot.Channels["#chan"].Modes.Lists["b"])
+	assert.Contains(t, snapshot.Channels["#chan"].Users, "a_user")
+
+	// Round trip through JSON and restore into a new tracker.
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+
+	var decoded irc.TrackerSnapshot
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	restored := irc.NewTracker(irc.NewISupportTracker())
+	restored.RestoreSnapshot(&decoded)
+
+	assert.Equal(t, snapshot, restored.Snapshot())
+
+	modes, ok := restored.UserModes("#chan", "a_user")
+	assert.True(t, ok)
+	assert.Equal(t, "v", modes)
+	assert.True(t, restored.IsBot("a_user"))
+	assert.Equal(t, "key", restored.GetChannel("#chan").Modes.Key())
+	assert.Equal(t, []string{"#chan"}, restored.ChannelsForUser("a_user"))
+}
@@ -0,0 +1,235 @@
+package irc
+
+import (
+	"errors"
+	"sort"
+)
+
+// UserState represents everything the Tracker knows about a single user.
+// Fields which haven't been seen yet will be empty.
+type UserState struct {
+	Nick     string `json:"nick"`
+	User     string `json:"user,omitempty"`
+	Host     string `json:"host,omitempty"`
+	Account  string `json:"account,omitempty"`
+	RealName string `json:"realname,omitempty"`
+
+	// Away is true if the user was marked as away in a WHO reply.
+	Away bool `json:"away,omitempty"`
+}
+
+// GetUser returns a copy of the UserState for a given nick. It will return nil
+// if the user is unknown.
+func (t *Tracker) GetUser(nick string) *UserState {
+	t.RLock()
+	defer t.RUnlock()
+
+	user, ok := t.users[nick]
+	if !ok {
+		return nil
+	}
+
+	ret := *user
+	return &ret
+}
+
+// ListUsers returns the nicks of all users in any known channel, including
+// the client itself.
+func (t *Tracker) ListUsers() []string {
+	t.RLock()
+	defer t.RUnlock()
+
+	ret := make([]string, 0, len(t.userChannels))
+	for nick := range t.userChannels {
+		ret = append(ret, nick)
+	}
+
+	return ret
+}
+
+// ChannelsForUser returns the sorted names of all known channels the given
+// user is in. It will return nil if we don't share any channels with them.
+func (t *Tracker) ChannelsForUser(nick string) []string {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.channelsForUser(nick)
+}
+
+// channelsForUser is ChannelsForUser without locking. The lock must be held
+// when calling this.
+func (t *Tracker) channelsForUser(nick string) []string {
+	channels, ok := t.userChannels[nick]
+	if !ok {
+		return nil
+	}
+
+	ret := make([]string, 0, len(channels))
+	for channel := range channels {
+		ret = append(ret, channel)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// addChannelUser adds a user to a channel, keeping the reverse index up to
+// date. The lock must be held when calling this.
+func (t *Tracker) addChannelUser(state *ChannelState, nick string) {
+	state.Users[nick] = struct{}{}
+
+	channels, ok := t.userChannels[nick]
+	if !ok {
+		channels = make(map[string]struct{})
+		t.userChannels[nick] = channels
+	}
+
+	channels[state.Name] = struct{}{}
+}
+
+// removeChannelUser removes a user and their modes from a channel, keeping
+// the reverse index up to date. The lock must be held when calling this.
+func (t *Tracker) removeChannelUser(state *ChannelState, nick string) {
+	state.removeUser(nick)
+
+	channels, ok := t.userChannels[nick]
+	if !ok {
+		return
+	}
+
+	delete(channels, state.Name)
+	if len(channels) == 0 {
+		delete(t.userChannels, nick)
+	}
+}
+
+// user returns the UserState for the given nick, creating it if needed. The
+// lock must be held when calling this.
+func (t *Tracker) user(nick string) *UserState {
+	user, ok := t.users[nick]
+	if !ok {
+		user = &UserState{Nick: nick}
+		t.users[nick] = user
+	}
+
+	return user
+}
+
+// knownUser returns the UserState for ourselves or a user we share a channel
+// with. Other users aren't tracked, as nothing would remove them once they
+// stop talking to us. The lock must be held.
+func (t *Tracker) knownUser(nick string) (*UserState, bool) {
+	if nick == t.currentNick {
+		return t.user(nick), true
+	}
+
+	user, ok := t.users[nick]
+	return user, ok
+}
+
+// updatePrefix fills in the user and host from a message prefix.
+func (u *UserState) updatePrefix(prefix *Prefix) {
+	if prefix.User != "" {
+		u.User = prefix.User
+	}
+
+	if prefix.Host != "" {
+		u.Host = prefix.Host
+	}
+}
+
+// leaveChannel drops a channel we are no longer in, along with any users we
+// no longer share a channel with. The lock must be held when calling this.
+func (t *Tracker) leaveChannel(channel string) {
+	state, ok := t.channels[channel]
+	if !ok {
+		return
+	}
+
+	delete(t.channels, channel)
+	delete(t.metadata, channel)
+
+	for user := range state.Users {
+		t.removeChannelUser(state, user)
+		t.forgetUser(user)
+	}
+}
+
+// forgetUser drops the UserState for a nick if they aren't in any channel we
+// know about. We always keep our own state. The lock must be held when
+// calling this.
+func (t *Tracker) forgetUser(nick string) {
+	if nick == t.currentNick {
+		return
+	}
+
+	if _, ok := t.userChannels[nick]; ok {
+		return
+	}
+
+	delete(t.users, nick)
+	delete(t.bots, nick)
+	delete(t.metadata, nick)
+}
+
+// OnUserChange sets a callback which will be called whenever a user's
+// username, host, or realname changes because of CHGHOST or SETNAME. The
+// callback is called after the Tracker has been updated, without any locks
+// held.
+func (t *Tracker) OnUserChange(f func(old, new UserState)) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.onUserChange = f
+}
+
+// updateUser applies a change to the UserState for the given nick and calls
+// the OnUserChange callback. Unknown users are ignored.
+func (t *Tracker) updateUser(nick string, update func(*UserState)) {
+	t.Lock()
+	user, ok := t.knownUser(nick)
+	if !ok {
+		t.Unlock()
+		return
+	}
+
+	old := *user
+	update(user)
+	updated := *user
+	onUserChange := t.onUserChange
+	t.Unlock()
+
+	if onUserChange != nil && old != updated {
+		onUserChange(old, updated)
+	}
+}
+
+// handleChghost handles CHGHOST messages, sent with the chghost cap when a
+// user's username or host changes.
+func (t *Tracker) handleChghost(msg *Message) error {
+	if len(msg.Params) != 2 {
+		return errors.New("malformed CHGHOST message")
+	}
+
+	t.updateUser(msg.Prefix.Name, func(user *UserState) {
+		user.User = msg.Params[0]
+		user.Host = msg.Params[1]
+	})
+
+	return nil
+}
+
+// handleSetname handles SETNAME messages, sent with the setname cap when a
+// user's realname changes.
+func (t *Tracker) handleSetname(msg *Message) error {
+	if len(msg.Params) != 1 {
+		return errors.New("malformed SETNAME message")
+	}
+
+	t.updateUser(msg.Prefix.Name, func(user *UserState) {
+		user.RealName = msg.Params[0]
+	})
+
+	return nil
+}
@@ -3,6 +3,7 @@ package irc
 import (
 	"bytes"
 	"regexp"
+	"unicode/utf8"
 )
 
 var maskTranslations = map[byte]string{
@@ -48,3 +49,21 @@ func MaskToRegex(rawMask string) (*regexp.Regexp, error) {
 
 	return regexp.Compile(output.String())
 }
+
+// truncateUTF8 shortens s to at most n bytes without splitting a multi-byte
+// UTF-8 sequence.
+func truncateUTF8(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
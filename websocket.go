@@ -0,0 +1,151 @@
+package irc
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// Subprotocols defined by the IRCv3 WebSocket spec. One of these should be
+// requested when opening the WebSocket connection. With the text subprotocol,
+// all lines must be valid UTF-8.
+const (
+	WebSocketTextSubprotocol   = "text.ircv3.net"
+	WebSocketBinarySubprotocol = "binary.ircv3.net"
+)
+
+// Frame types, matching the values in RFC 6455 and used by most Go WebSocket
+// packages.
+const (
+	webSocketTextFrame   = 1
+	webSocketBinaryFrame = 2
+)
+
+// WebSocketConn is the subset of a WebSocket connection needed by
+// WebSocketAdapter. It matches the methods on *websocket.Conn from
+// github.com/gorilla/websocket, and is simple to implement on top of other
+// WebSocket packages. The message types are the frame opcodes from RFC 6455,
+// so 1 is text and 2 is binary.
+type WebSocketConn interface {
+	ReadMessage() (messageType int, data []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// WebSocketAdapter speaks the IRCv3 WebSocket binding, where each message is
+// sent in its own frame without the trailing \r\n, over a WebSocketConn. It
+// implements io.ReadWriteCloser so it can be passed to NewClient or NewConn.
+type WebSocketAdapter struct {
+	// Binary sends binary frames rather than text frames. This should be set
+	// if the binary.ircv3.net subprotocol was negotiated. Either kind of
+	// frame is accepted when reading.
+	Binary bool
+
+	conn WebSocketConn
+
+	readLock sync.Mutex
+	readBuf  []byte
+
+	writeLock sync.Mutex
+	writeBuf  []byte
+}
+
+// NewWebSocketAdapter wraps an open WebSocket connection.
+func NewWebSocketAdapter(conn WebSocketConn) *WebSocketAdapter {
+	return &WebSocketAdapter{ //nolint:exhaustruct
+		conn: conn,
+	}
+}
+
+// Read reads messages from the WebSocket, adding the \r\n after each one.
+func (a *WebSocketAdapter) Read(p []byte) (int, error) {
+	a.readLock.Lock()
+	defer a.readLock.Unlock()
+
+	for len(a.readBuf) == 0 {
+		messageType, data, err := a.conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+
+		// Anything other than text and binary, such as pings, should
+		// have been handled by the WebSocket package.
+		if messageType != webSocketTextFrame && messageType != webSocketBinaryFrame {
+			continue
+		}
+
+		data = bytes.TrimRight(data, "\r\n")
+		if len(data) == 0 {
+			continue
+		}
+
+		a.readBuf = append(append(a.readBuf[:0], data...), '\r', '\n')
+	}
+
+	n := copy(p, a.readBuf)
+	a.readBuf = a.readBuf[n:]
+
+	return n, nil
+}
+
+// Write sends each complete line as a separate frame, without the \r\n. Any
+// partial line is held until the rest of it is written.
+func (a *WebSocketAdapter) Write(p []byte) (int, error) {
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+
+	a.writeBuf = append(a.writeBuf, p...)
+
+	frameType := webSocketTextFrame
+	if a.Binary {
+		frameType = webSocketBinaryFrame
+	}
+
+	for {
+		i := bytes.IndexByte(a.writeBuf, '\n')
+		if i == -1 {
+			break
+		}
+
+		line := bytes.TrimRight(a.writeBuf[:i], "\r")
+		a.writeBuf = a.writeBuf[i+1:]
+
+		if len(line) == 0 {
+			continue
+		}
+
+		err := a.conn.WriteMessage(frameType, line)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close closes the WebSocket connection.
+func (a *WebSocketAdapter) Close() error {
+	return a.conn.Close()
+}
+
+// SetReadDeadline sets the read deadline on the WebSocket connection if it
+// supports deadlines, otherwise ErrDeadlineUnsupported is returned.
+func (a *WebSocketAdapter) SetReadDeadline(t time.Time) error {
+	d, ok := a.conn.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return ErrDeadlineUnsupported
+	}
+
+	return d.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline on the WebSocket connection if it
+// supports deadlines, otherwise ErrDeadlineUnsupported is returned.
+func (a *WebSocketAdapter) SetWriteDeadline(t time.Time) error {
+	d, ok := a.conn.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok {
+		return ErrDeadlineUnsupported
+	}
+
+	return d.SetWriteDeadline(t)
+}
@@ -0,0 +1,100 @@
+package irc_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gopkg.in/irc.v4"
+)
+
+type testWebSocketFrame struct {
+	Type int
+	Data string
+}
+
+// testWebSocket is a fake WebSocket connection which reads from a list of
+// frames and records everything written.
+type testWebSocket struct {
+	incoming []testWebSocketFrame
+	written  []testWebSocketFrame
+	closed   bool
+}
+
+func (ws *testWebSocket) ReadMessage() (int, []byte, error) {
+	if len(ws.incoming) == 0 {
+		return 0, nil, io.EOF
+	}
+
+	frame := ws.incoming[0]
+	ws.incoming = ws.incoming[1:]
+
+	return frame.Type, []byte(frame.Data), nil
+}
+
+func (ws *testWebSocket) WriteMessage(messageType int, data []byte) error {
+	ws.written = append(ws.written, testWebSocketFrame{messageType, string(data)})
+	return nil
+}
+
+func (ws *testWebSocket) Close() error {
+	ws.closed = true
+	return nil
+}
+
+func TestWebSocketAdapter(t *testing.T) {
+	t.Parallel()
+
+	ws := &testWebSocket{
+		incoming: []testWebSocketFrame{
+			{1, "PING :hello"},
+			{9, "ignored"},
+			{1, ""},
+			{2, ":server 001 test_nick :Welcome\r\n"},
+		},
+	}
+
+	adapter := irc.NewWebSocketAdapter(ws)
+	c := irc.NewConn(adapter)
+
+	m, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, irc.MustParseMessage("PING :hello"), m)
+
+	m, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, irc.MustParseMessage(":server 001 test_nick :Welcome"), m)
+
+	_, err = c.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+
+	// Each line should be sent in its own frame, even when they're written
+	// together or split across writes.
+	require.NoError(t, c.Write("PONG :hello"))
+	_, err = adapter.Write([]byte("NICK a\r\nNICK "))
+	require.NoError(t, err)
+	_, err = adapter.Write([]byte("b\r\n"))
+	require.NoError(t, err)
+
+	adapter.Binary = true
+	require.NoError(t, c.Write("QUIT"))
+
+	assert.Equal(t, []testWebSocketFrame{
+		{1, "PONG :hello"},
+		{1, "NICK a"},
+		{1, "NICK b"},
+		{2, "QUIT"},
+	}, ws.written)
+
+	// The fake doesn't support deadlines, which shouldn't stop writes from
+	// working with a timeout set.
+	assert.Equal(t, irc.ErrDeadlineUnsupported, c.SetReadDeadline(time.Time{}))
+	c.WriteTimeout = time.Second
+	require.NoError(t, c.Write("PING :again"))
+
+	require.NoError(t, adapter.Close())
+	assert.True(t, ws.closed)
+}